@@ -0,0 +1,19 @@
+// Package compresschi 为 chi 路由提供 compress 中间件, 例如:
+//
+//	m := compress.New(compress.DefaultCompressionConfig())
+//	r := chi.NewRouter()
+//	r.Use(compresschi.New(m))
+package compresschi
+
+import (
+	"net/http"
+
+	"github.com/fenthope/compress"
+)
+
+// New 返回以 m 压缩响应的 chi 中间件。
+// chi 的中间件就是标准的 func(http.Handler) http.Handler, 因此它等同于 m.HTTPHandler;
+// chi 在构建路由时为每条处理链调用一次, 请求 (包括 chi 的路由参数) 原样传给后续处理器。
+func New(m *compress.Middleware) func(http.Handler) http.Handler {
+	return m.HTTPHandler
+}
@@ -0,0 +1,39 @@
+package compresschi
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fenthope/compress"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestNew(t *testing.T) {
+	m := compress.New(compress.DefaultCompressionConfig())
+	r := chi.NewRouter()
+	r.Use(New(m))
+	r.Get("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, strings.Repeat(chi.URLParam(r, "id")+" ", 500))
+	})
+
+	req := httptest.NewRequest("GET", "/items/42", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Header().Get("Content-Encoding") != compress.EncodingGzip {
+		t.Fatalf("Expected gzip, got %v", w.Header())
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// chi 的路由参数经过中间件后仍然可用
+	if got, _ := io.ReadAll(gr); string(got) != strings.Repeat("42 ", 500) {
+		t.Errorf("Unexpected body %.20q", got)
+	}
+}
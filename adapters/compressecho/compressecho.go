@@ -0,0 +1,49 @@
+// Package compressecho 为 echo 提供 compress 中间件, 例如:
+//
+//	m := compress.New(compress.DefaultCompressionConfig())
+//	e := echo.New()
+//	e.Use(compressecho.New(m))
+package compressecho
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/fenthope/compress"
+	"github.com/labstack/echo/v4"
+)
+
+// callKey 是把 echo 的调用交给内部 handler 时使用的请求 context 键
+type callKey struct{}
+
+// call 是一次经过压缩中间件的 echo 调用
+type call struct {
+	c    echo.Context
+	next echo.HandlerFunc
+}
+
+// New 返回以 m 压缩响应的 echo 中间件。
+// 与 echo.WrapMiddleware(m.HTTPHandler) 不同, 被包装的 handler 只创建一次, 而不是每个请求一次。
+// 后续处理器返回的错误在压缩响应结束前交给 c.Error, 使错误响应同样经过压缩。
+func New(m *compress.Middleware) echo.MiddlewareFunc {
+	h := m.HTTPHandler(http.HandlerFunc(serve))
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			h.ServeHTTP(c.Response().Writer, req.WithContext(context.WithValue(req.Context(), callKey{}, &call{c: c, next: next})))
+			return nil
+		}
+	}
+}
+
+// serve 在压缩中间件内运行: 把 echo 响应的底层 writer 换成 w, 调用后续处理器后换回
+func serve(w http.ResponseWriter, r *http.Request) {
+	call := r.Context().Value(callKey{}).(*call)
+	res := call.c.Response()
+	orig := res.Writer
+	res.Writer = w
+	defer func() { res.Writer = orig }()
+	if err := call.next(call.c); err != nil {
+		call.c.Error(err)
+	}
+}
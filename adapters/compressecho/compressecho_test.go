@@ -0,0 +1,56 @@
+package compressecho
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fenthope/compress"
+	"github.com/labstack/echo/v4"
+)
+
+func TestNew(t *testing.T) {
+	body := strings.Repeat("echo ", 500)
+	m := compress.New(compress.DefaultCompressionConfig())
+	e := echo.New()
+	e.Use(New(m))
+	e.GET("/", func(c echo.Context) error { return c.String(http.StatusOK, body) })
+	e.GET("/error", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusTeapot, strings.Repeat("failure ", 100))
+	})
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		return w
+	}
+	decode := func(t *testing.T, w *httptest.ResponseRecorder) string {
+		t.Helper()
+		if w.Header().Get("Content-Encoding") != compress.EncodingGzip {
+			t.Fatalf("Expected gzip, got %v", w.Header())
+		}
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(gr)
+		return string(got)
+	}
+
+	if got := decode(t, serve("/")); got != body {
+		t.Errorf("Unexpected body %.20q", got)
+	}
+	// 处理器返回的错误由 echo 的错误处理写入压缩响应
+	w := serve("/error")
+	if w.Code != http.StatusTeapot {
+		t.Errorf("Expected 418, got %d", w.Code)
+	}
+	if got := decode(t, w); !strings.Contains(got, "failure") {
+		t.Errorf("Unexpected error body %.40q", got)
+	}
+}
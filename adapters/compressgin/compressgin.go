@@ -0,0 +1,103 @@
+// Package compressgin 为 gin 提供 compress 中间件, 例如:
+//
+//	m := compress.New(compress.DefaultCompressionConfig())
+//	r := gin.New()
+//	r.Use(compressgin.New(m))
+package compressgin
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/fenthope/compress"
+	"github.com/gin-gonic/gin"
+	"github.com/infinite-iroha/touka"
+)
+
+// ctxKey 是把 gin.Context 交给内部 handler 时使用的请求 context 键
+type ctxKey struct{}
+
+// New 返回以 m 压缩响应的 gin 中间件。
+// 被包装的 handler 只创建一次; 每个请求中 c.Writer 在后续处理器运行期间换成压缩响应, 结束后换回。
+func New(m *compress.Middleware) gin.HandlerFunc {
+	h := m.HTTPHandler(http.HandlerFunc(serve))
+	return func(c *gin.Context) {
+		req := c.Request
+		h.ServeHTTP(c.Writer, req.WithContext(context.WithValue(req.Context(), ctxKey{}, c)))
+	}
+}
+
+// serve 在压缩中间件内运行: 把 c.Writer 换成压缩响应, 运行后续处理器后换回
+func serve(w http.ResponseWriter, r *http.Request) {
+	c := r.Context().Value(ctxKey{}).(*gin.Context)
+	orig := c.Writer
+	c.Writer = &responseWriter{orig: orig, w: w.(touka.ResponseWriter), status: http.StatusOK}
+	defer func() { c.Writer = orig }()
+	c.Next()
+	c.Writer.WriteHeaderNow() // 没有写入数据的响应也在压缩器关闭前写出头部
+}
+
+// responseWriter 以 gin 的语义包装压缩响应: WriteHeader 只记录状态码,
+// 头部在第一次写入数据或 WriteHeaderNow 时写出, 使 gin 的渲染器可以在 c.Status 之后设置 Content-Type。
+type responseWriter struct {
+	orig    gin.ResponseWriter   // gin 原来的 writer, 用于 CloseNotify 与 Pusher
+	w       touka.ResponseWriter // 压缩响应
+	status  int
+	written bool
+}
+
+var _ gin.ResponseWriter = (*responseWriter)(nil)
+
+func (rw *responseWriter) Header() http.Header { return rw.w.Header() }
+
+func (rw *responseWriter) WriteHeader(code int) {
+	if code > 0 && !rw.written {
+		rw.status = code
+	}
+}
+
+func (rw *responseWriter) WriteHeaderNow() {
+	if !rw.written {
+		rw.written = true
+		rw.w.WriteHeader(rw.status)
+	}
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	rw.WriteHeaderNow()
+	return rw.w.Write(p)
+}
+
+func (rw *responseWriter) WriteString(s string) (int, error) {
+	rw.WriteHeaderNow()
+	return io.WriteString(rw.w, s)
+}
+
+func (rw *responseWriter) Status() int { return rw.status }
+
+// Size 与 gin 一致, 在写出头部之前返回 -1
+func (rw *responseWriter) Size() int {
+	if !rw.written {
+		return -1
+	}
+	return rw.w.Size()
+}
+
+func (rw *responseWriter) Written() bool { return rw.written }
+
+func (rw *responseWriter) Flush() {
+	rw.WriteHeaderNow()
+	rw.w.Flush()
+}
+
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	rw.written = true
+	return rw.w.Hijack()
+}
+
+func (rw *responseWriter) CloseNotify() <-chan bool { return rw.orig.CloseNotify() }
+
+func (rw *responseWriter) Pusher() http.Pusher { return rw.orig.Pusher() }
@@ -0,0 +1,54 @@
+package compressgin
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fenthope/compress"
+	"github.com/gin-gonic/gin"
+)
+
+func TestNew(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	items := make([]string, 200)
+	for i := range items {
+		items[i] = "item"
+	}
+	m := compress.New(compress.DefaultCompressionConfig())
+	r := gin.New()
+	r.Use(New(m))
+	r.GET("/json", func(c *gin.Context) { c.JSON(http.StatusCreated, items) })
+	r.GET("/empty", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	req := httptest.NewRequest("GET", "/json", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	// gin 在 c.Status 之后才设置 Content-Type, 压缩仍按它判断
+	if w.Code != http.StatusCreated || w.Header().Get("Content-Encoding") != compress.EncodingGzip {
+		t.Fatalf("Expected compressed 201, got %d %v", w.Code, w.Header())
+	}
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+		t.Errorf("Expected JSON content type, got %q", got)
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []string
+	if err := json.NewDecoder(gr).Decode(&decoded); err != nil || len(decoded) != len(items) {
+		t.Errorf("Decoded %d items, err %v", len(decoded), err)
+	}
+
+	req = httptest.NewRequest("GET", "/empty", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Expected empty 204, got %d %v %q", w.Code, w.Header(), w.Body.String())
+	}
+}
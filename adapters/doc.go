@@ -0,0 +1,12 @@
+// Package adapters 是 compress 中间件在其他路由框架上的适配器所在的模块。
+//
+// 适配器位于独立的 Go 模块 (github.com/fenthope/compress/adapters) 中,
+// 使核心模块不依赖 gin、echo 或 chi; 每个框架一个子包:
+//
+//   - compressgin: gin.HandlerFunc
+//   - compressecho: echo.MiddlewareFunc
+//   - compresschi: chi 使用的 func(http.Handler) http.Handler
+//
+// 所有适配器都经 Middleware.HTTPHandler 复用核心的协商、对象池与响应包装,
+// 只负责把框架的 ResponseWriter 换成压缩响应, 处理器结束后换回。
+package adapters
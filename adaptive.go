@@ -0,0 +1,235 @@
+package compress
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AdaptiveSignals 是一次压缩响应完成后提供给 LevelPolicy 的信号
+type AdaptiveSignals struct {
+	Level      int           // 本次使用的级别
+	BytesIn    int64         // 压缩前的字节数
+	BytesOut   int64         // 压缩后的字节数
+	EncodeTime time.Duration // 压缩器自身的耗时 (不含写往连接的时间)
+	WriteTime  time.Duration // 压缩数据写往底层连接的累计耗时
+}
+
+// LevelPolicy 在每个压缩响应开始时决定使用的级别, 并在响应结束后接收信号。
+// 压缩器无法在流中途改变级别, 因此调整作用于后续的响应。实现必须可并发调用。
+type LevelPolicy interface {
+	// Level 返回本次响应使用的级别, configured 为配置的级别
+	Level(encoding string, configured int) int
+	// Observe 接收一次已完成响应的信号
+	Observe(encoding string, s AdaptiveSignals)
+}
+
+// BackpressurePolicy 根据写往连接与压缩本身的耗时比例调整级别:
+// 写入明显慢于压缩 (客户端网络差) 时提高级别以减少传输量,
+// 压缩明显慢于写入 (CPU 成为瓶颈) 时降低级别。
+type BackpressurePolicy struct {
+	// Threshold 是触发调整的耗时比例, 默认 2
+	Threshold float64
+	// MaxOffset 是相对配置级别的最大偏移, 默认 3
+	MaxOffset int
+
+	states map[string]*backpressureState // 在创建时固定, 之后只读
+}
+
+type backpressureState struct {
+	ewmaBits atomic.Uint64 // 写入耗时 / 压缩耗时 的指数移动平均
+	offset   atomic.Int32
+}
+
+// NewBackpressurePolicy 创建一个使用默认参数的 BackpressurePolicy
+func NewBackpressurePolicy() *BackpressurePolicy {
+	p := &BackpressurePolicy{Threshold: 2, MaxOffset: 3, states: make(map[string]*backpressureState, len(codecTable))}
+	for _, c := range codecTable {
+		st := &backpressureState{}
+		st.ewmaBits.Store(math.Float64bits(1))
+		p.states[c.Encoding] = st
+	}
+	return p
+}
+
+// Level 实现 LevelPolicy
+func (p *BackpressurePolicy) Level(encoding string, configured int) int {
+	st, ok := p.states[encoding]
+	if !ok {
+		return configured
+	}
+	offset := int(st.offset.Load())
+	if offset == 0 {
+		return configured
+	}
+	lo, hi, def := levelRange(encoding)
+	level := configured
+	if level < lo {
+		level = def
+	}
+	return min(max(level+offset, lo), hi)
+}
+
+// Observe 实现 LevelPolicy
+func (p *BackpressurePolicy) Observe(encoding string, s AdaptiveSignals) {
+	st, ok := p.states[encoding]
+	if !ok || s.EncodeTime <= 0 || s.WriteTime <= 0 {
+		return
+	}
+	r := float64(s.WriteTime) / float64(s.EncodeTime)
+	var ewma float64
+	for {
+		old := st.ewmaBits.Load()
+		ewma = 0.8*math.Float64frombits(old) + 0.2*r
+		if st.ewmaBits.CompareAndSwap(old, math.Float64bits(ewma)) {
+			break
+		}
+	}
+
+	threshold := p.Threshold
+	if threshold <= 1 {
+		threshold = 2
+	}
+	limit := int32(p.MaxOffset)
+	switch offset := st.offset.Load(); {
+	case ewma > threshold && offset < limit:
+		st.offset.CompareAndSwap(offset, offset+1)
+	case ewma < 1/threshold && offset > -limit:
+		st.offset.CompareAndSwap(offset, offset-1)
+	}
+}
+
+// EncodingChoice 是编码与级别的组合
+type EncodingChoice struct {
+	Encoding string
+	Level    int
+}
+
+// EncodingPolicy 在响应的媒体类型确定后, 从客户端接受的编码中选择本次使用的编码与级别, 并在响应结束后接收信号。
+// 实现必须可并发调用。
+type EncodingPolicy interface {
+	// Choose 返回本次响应使用的编码与级别; candidates 是客户端接受且已配置的编码 (按优先级) 及其配置的级别,
+	// 返回的编码必须取自其中, 否则使用协商的结果。mediaType 为小写且不含参数。
+	Choose(mediaType string, candidates []EncodingChoice) EncodingChoice
+	// Observe 接收一次已完成响应的信号
+	Observe(mediaType, encoding string, s AdaptiveSignals)
+}
+
+// HistoryPolicy 按内容类型 (html、json 等, 与统计中的分组相同) 记录各编码与级别实际达到的压缩率与每字节的压缩耗时,
+// 选择历史表现最好的组合。样本不足的候选会先被选中以积累数据, 之后仍以 ExploreRate 的概率随机选择, 使历史保持更新。
+type HistoryPolicy struct {
+	// MinSamples 是参与比较前需要的响应数, 默认 20
+	MinSamples int
+	// ExploreRate 是随机选择候选的概率, 默认 0.05
+	ExploreRate float64
+	// CPUWeight 是每字节压缩耗时 (纳秒) 折算为压缩率的权重, 分数 = 压缩率 + CPUWeight × 耗时, 越低越好; 默认 0.01
+	CPUWeight float64
+
+	mu      sync.RWMutex
+	history map[historyKey]*historyStats
+}
+
+type historyKey struct {
+	family   string
+	encoding string
+	level    int
+}
+
+type historyStats struct {
+	samples   atomic.Uint64
+	ratioBits atomic.Uint64 // 压缩后 / 压缩前 的指数移动平均
+	nsBits    atomic.Uint64 // 每字节压缩耗时 (纳秒) 的指数移动平均
+}
+
+// NewHistoryPolicy 创建一个使用默认参数的 HistoryPolicy
+func NewHistoryPolicy() *HistoryPolicy {
+	return &HistoryPolicy{MinSamples: 20, ExploreRate: 0.05, CPUWeight: 0.01, history: make(map[historyKey]*historyStats)}
+}
+
+// Choose 实现 EncodingPolicy
+func (p *HistoryPolicy) Choose(mediaType string, candidates []EncodingChoice) EncodingChoice {
+	if p.ExploreRate > 0 && rand.Float64() < p.ExploreRate {
+		return candidates[rand.IntN(len(candidates))]
+	}
+	family := contentTypeFamily(mediaType)
+	minSamples := uint64(max(p.MinSamples, 1))
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, c := range candidates {
+		if st := p.history[historyKey{family, c.Encoding, c.Level}]; st == nil || st.samples.Load() < minSamples {
+			return c // 先积累样本
+		}
+	}
+	// 也比较候选编码在其他级别 (如 AdaptiveLevel 调整后) 的历史
+	best, bestScore := candidates[0], math.Inf(1)
+	for k, st := range p.history {
+		if k.family != family || st.samples.Load() < minSamples || !hasEncoding(candidates, k.encoding) {
+			continue
+		}
+		score := math.Float64frombits(st.ratioBits.Load()) + p.CPUWeight*math.Float64frombits(st.nsBits.Load())
+		if score < bestScore {
+			best, bestScore = EncodingChoice{Encoding: k.encoding, Level: k.level}, score
+		}
+	}
+	return best
+}
+
+// Observe 实现 EncodingPolicy
+func (p *HistoryPolicy) Observe(mediaType, encoding string, s AdaptiveSignals) {
+	if s.BytesIn <= 0 {
+		return
+	}
+	k := historyKey{contentTypeFamily(mediaType), encoding, s.Level}
+	p.mu.RLock()
+	st := p.history[k]
+	p.mu.RUnlock()
+	if st == nil {
+		p.mu.Lock()
+		if p.history == nil {
+			p.history = make(map[historyKey]*historyStats)
+		}
+		if st = p.history[k]; st == nil {
+			st = &historyStats{}
+			p.history[k] = st
+		}
+		p.mu.Unlock()
+	}
+	first := st.samples.Add(1) == 1
+	updateEWMA(&st.ratioBits, float64(s.BytesOut)/float64(s.BytesIn), first)
+	updateEWMA(&st.nsBits, float64(s.EncodeTime)/float64(s.BytesIn), first)
+}
+
+// hasEncoding 报告 candidates 中是否有 encoding
+func hasEncoding(candidates []EncodingChoice, encoding string) bool {
+	for _, c := range candidates {
+		if c.Encoding == encoding {
+			return true
+		}
+	}
+	return false
+}
+
+// updateEWMA 把 v 计入 bits 保存的指数移动平均, first 为 true 时直接取 v
+func updateEWMA(bits *atomic.Uint64, v float64, first bool) {
+	for {
+		old := bits.Load()
+		ewma := v
+		if !first {
+			ewma = 0.8*math.Float64frombits(old) + 0.2*v
+		}
+		if bits.CompareAndSwap(old, math.Float64bits(ewma)) {
+			return
+		}
+	}
+}
+
+// levelRange 返回编码可用的正数级别范围与默认级别对应的数值
+func levelRange(encoding string) (lo, hi, def int) {
+	if encoding == EncodingZstd {
+		return 1, 22, zstdDefaultLevel
+	}
+	return 1, 9, 6
+}
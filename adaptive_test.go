@@ -0,0 +1,176 @@
+package compress
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestBackpressurePolicy(t *testing.T) {
+	p := NewBackpressurePolicy()
+	slowNetwork := AdaptiveSignals{EncodeTime: time.Millisecond, WriteTime: 20 * time.Millisecond}
+	for i := 0; i < 20; i++ {
+		p.Observe(EncodingGzip, slowNetwork)
+	}
+	if got := p.Level(EncodingGzip, -1); got != 9 {
+		t.Errorf("Expected level 9 on a slow network, got %d", got)
+	}
+
+	busyCPU := AdaptiveSignals{EncodeTime: 20 * time.Millisecond, WriteTime: time.Millisecond}
+	for i := 0; i < 40; i++ {
+		p.Observe(EncodingGzip, busyCPU)
+	}
+	if got := p.Level(EncodingGzip, 2); got != 1 {
+		t.Errorf("Expected level clamped to 1 when CPU bound, got %d", got)
+	}
+	if got := p.Level(EncodingZstd, 3); got != 3 {
+		t.Errorf("Expected zstd level untouched, got %d", got)
+	}
+}
+
+type fixedPolicy struct {
+	level    int
+	observed atomic.Int32
+}
+
+func (p *fixedPolicy) Level(string, int) int { return p.level }
+func (p *fixedPolicy) Observe(_ string, s AdaptiveSignals) {
+	if s.Level == p.level && s.BytesIn > 0 {
+		p.observed.Add(1)
+	}
+}
+
+func TestAdaptiveLevelOption(t *testing.T) {
+	policy := &fixedPolicy{level: 2}
+	r := touka.New()
+	r.Use(Compression(CompressOptions{AdaptiveLevel: policy, DebugHeader: true}))
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", strings.Repeat("adaptive ", 100))
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if info := w.Header().Get("X-Compression-Info"); !strings.Contains(info, "level=2") {
+		t.Errorf("Expected policy level in %q", info)
+	}
+	if policy.observed.Load() != 1 {
+		t.Errorf("Expected 1 observation, got %d", policy.observed.Load())
+	}
+}
+
+func TestHistoryPolicy(t *testing.T) {
+	p := NewHistoryPolicy()
+	p.ExploreRate = 0
+	candidates := []EncodingChoice{{EncodingZstd, 3}, {EncodingGzip, 6}}
+
+	// 样本不足时依次选择各候选
+	if got := p.Choose("application/json", candidates); got != candidates[0] {
+		t.Errorf("Expected first undersampled candidate, got %v", got)
+	}
+	for range p.MinSamples {
+		p.Observe("application/json", EncodingZstd, AdaptiveSignals{Level: 3, BytesIn: 1000, BytesOut: 300, EncodeTime: 2 * time.Microsecond})
+	}
+	if got := p.Choose("application/json", candidates); got != candidates[1] {
+		t.Errorf("Expected gzip to be sampled next, got %v", got)
+	}
+	for range p.MinSamples {
+		p.Observe("application/json", EncodingGzip, AdaptiveSignals{Level: 6, BytesIn: 1000, BytesOut: 250, EncodeTime: 20 * time.Microsecond})
+		// gzip 在 1 级别的历史, 由 AdaptiveLevel 等调整产生
+		p.Observe("application/json", EncodingGzip, AdaptiveSignals{Level: 1, BytesIn: 1000, BytesOut: 300, EncodeTime: 3 * time.Microsecond})
+	}
+	// 分数: zstd 0.30+0.02, gzip-6 0.25+0.20, gzip-1 0.30+0.03
+	if got := p.Choose("application/json", candidates); got != candidates[0] {
+		t.Errorf("Expected zstd to score best, got %v", got)
+	}
+	if got := p.Choose("application/json", candidates[1:]); got != (EncodingChoice{EncodingGzip, 1}) {
+		t.Errorf("Expected gzip level 1 when zstd is not accepted, got %v", got)
+	}
+	// 其他内容类型的历史相互独立
+	if got := p.Choose("text/html", candidates[1:]); got != candidates[1] {
+		t.Errorf("Expected html history to start empty, got %v", got)
+	}
+}
+
+// gzipPolicy 总是选择 gzip 1 级
+type gzipPolicy struct {
+	observed atomic.Int32
+}
+
+func (p *gzipPolicy) Choose(_ string, candidates []EncodingChoice) EncodingChoice {
+	return EncodingChoice{EncodingGzip, 1}
+}
+
+func (p *gzipPolicy) Observe(mediaType, encoding string, s AdaptiveSignals) {
+	if mediaType == "text/plain" && encoding == EncodingGzip && s.Level == 1 {
+		p.observed.Add(1)
+	}
+}
+
+func TestEncodingPolicyOption(t *testing.T) {
+	skipWithoutZstd(t)
+	policy := &gzipPolicy{}
+	r := touka.New()
+	r.Use(Compression(CompressOptions{
+		Algorithms: map[string]AlgorithmConfig{
+			EncodingZstd: {Level: zstdDefaultLevel},
+			EncodingGzip: {Level: 6},
+		},
+		EncodingPolicy: policy,
+		DebugHeader:    true,
+	}))
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "Text/Plain; charset=utf-8")
+		c.String(http.StatusOK, "%s", strings.Repeat("policy ", 100))
+	})
+
+	for accept, want := range map[string]string{"zstd, gzip": EncodingGzip, "zstd": EncodingZstd} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", accept)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if got := w.Header().Get("Content-Encoding"); got != want {
+			t.Errorf("%q: expected %s, got %q (%s)", accept, want, got, w.Header().Get("X-Compression-Info"))
+		}
+	}
+	if policy.observed.Load() != 1 {
+		t.Errorf("Expected 1 observation of gzip level 1, got %d", policy.observed.Load())
+	}
+}
+
+func TestEncodingPolicyMinContentLength(t *testing.T) {
+	skipWithoutZstd(t)
+	r := touka.New()
+	r.Use(Compression(CompressOptions{
+		Algorithms: map[string]AlgorithmConfig{
+			EncodingZstd: {Level: zstdDefaultLevel},
+			EncodingGzip: {Level: 6, MinContentLength: 4096},
+		},
+		EncodingPolicy: &gzipPolicy{},
+	}))
+	r.GET("/", func(c *touka.Context) {
+		n, _ := strconv.Atoi(c.Query("n"))
+		c.Header("Content-Type", "text/plain")
+		c.Header("Content-Length", strconv.Itoa(n))
+		c.String(http.StatusOK, "%s", strings.Repeat("p", n))
+	})
+
+	// policy 选择的 gzip 未达到自身的最小长度时保持协商的编码
+	for target, want := range map[string]string{"/?n=700": EncodingZstd, "/?n=5000": EncodingGzip} {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Accept-Encoding", "zstd, gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if got := w.Header().Get("Content-Encoding"); got != want {
+			t.Errorf("%s: expected %s, got %q", target, want, got)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/infinite-iroha/touka"
+)
+
+// maxAdminBody 是 AdminHandler 接受的请求体上限
+const maxAdminBody = 64 << 10
+
+// adminPatch 是 AdminHandler 接受的更新, 未出现的字段保持不变
+type adminPatch struct {
+	Enabled          *bool                      `json:"enabled"`
+	MinContentLength *int64                     `json:"min_content_length"`
+	Algorithms       map[string]json.RawMessage `json:"algorithms"`
+}
+
+// AdminHandler 返回在运行时修改配置的处理函数, 基于 UpdateOptions。GET 返回生效的配置 (格式同 DebugInfo 的 config),
+// PATCH 接受 JSON 形式的局部更新, 成功后返回更新后的配置, 例如:
+//
+//	admin.Use(authMiddleware)
+//	admin.GET("/compress", m.AdminHandler())
+//	admin.PATCH("/compress", m.AdminHandler())
+//
+//	PATCH {"algorithms": {"gzip": 9, "zstd": false}, "min_content_length": 2048}
+//
+// algorithms 中的值可以是级别 (数字或名称, 与 LoadOptions 相同, 只改级别并启用该编码)、完整的对象 (替换该编码的配置)、
+// true (以现有或默认配置启用) 或 false/null (停用, 即从 EncodingPriority 中移除); 不能停用全部编码, 整体关闭使用 enabled。
+// 更新作为一个整体生效, 有错误时不做任何更改并返回 400。处理函数本身不做鉴权, 必须挂载在调用方的鉴权中间件之后。
+func (m *Middleware) AdminHandler() touka.HandlerFunc {
+	var mu sync.Mutex // 串行化读取-修改-写入, 避免并发的更新相互覆盖
+	return func(c *touka.Context) {
+		c.Header(headerCacheControl, "no-store")
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPatch:
+			var patch adminPatch
+			dec := json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, maxAdminBody))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&patch); err != nil {
+				c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("compress: parsing update: %s", err)})
+				return
+			}
+			mu.Lock()
+			err := m.applyPatch(&patch)
+			mu.Unlock()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+		default:
+			c.Header("Allow", "GET, HEAD, PATCH")
+			c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "compress: method not allowed"})
+			return
+		}
+		c.JSON(http.StatusOK, m.DebugInfo().Config)
+	}
+}
+
+// applyPatch 把 patch 应用到当前配置的副本上再经 UpdateOptions 替换
+func (m *Middleware) applyPatch(patch *adminPatch) error {
+	opts := m.Options()
+	// Options 的映射与切片与生效的配置共享, 修改前先复制
+	opts.Algorithms = maps.Clone(opts.Algorithms)
+	opts.EncodingPriority = slices.Clone(opts.EncodingPriority)
+
+	// 按名称排序, 同时启用多个编码时追加到 EncodingPriority 的顺序固定
+	for _, name := range slices.Sorted(maps.Keys(patch.Algorithms)) {
+		raw := patch.Algorithms[name]
+		if _, ok := knownCodec(name); !ok {
+			return fmt.Errorf("compress: unknown encoding %q in algorithms", name)
+		}
+		switch trimmed := string(bytes.TrimSpace(raw)); trimmed {
+		case "null", "false":
+			opts.EncodingPriority = slices.DeleteFunc(opts.EncodingPriority, func(s string) bool { return s == name })
+			continue
+		case "true":
+			if _, ok := opts.Algorithms[name]; !ok {
+				c, _ := knownCodec(name)
+				opts.Algorithms[name] = AlgorithmConfig{Level: c.DefaultLevel, PoolEnabled: hasPool(name, c.DefaultLevel)}
+			}
+		default:
+			ac, ok := opts.Algorithms[name]
+			if !ok || strings.HasPrefix(trimmed, "{") {
+				var err error
+				if ac, err = parseAlgorithm(name, raw); err != nil {
+					return err
+				}
+			} else {
+				level, err := parseLevel(name, raw)
+				if err != nil {
+					return err
+				}
+				ac.Level = level
+				ac.PoolEnabled = hasPool(name, level)
+			}
+			opts.Algorithms[name] = ac
+		}
+		if !slices.Contains(opts.EncodingPriority, name) {
+			opts.EncodingPriority = append(opts.EncodingPriority, name)
+		}
+	}
+	if len(opts.EncodingPriority) == 0 {
+		return errors.New("compress: cannot disable every encoding, set enabled to false instead")
+	}
+	set(&opts.MinContentLength, patch.MinContentLength)
+
+	if len(patch.Algorithms) > 0 || patch.MinContentLength != nil {
+		if err := m.UpdateOptions(opts); err != nil {
+			return err
+		}
+	}
+	if patch.Enabled != nil {
+		m.SetEnabled(*patch.Enabled)
+	}
+	return nil
+}
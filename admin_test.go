@@ -0,0 +1,81 @@
+package compress
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestAdminHandler(t *testing.T) {
+	m := New(CompressOptions{Algorithms: map[string]AlgorithmConfig{
+		EncodingZstd: {Level: zstdDefaultLevel},
+		EncodingGzip: {Level: 6, MaxIdle: 4},
+	}})
+	r := touka.New()
+	r.GET("/admin", m.AdminHandler())
+	r.PATCH("/admin", m.AdminHandler())
+
+	patch := func(body string) (*httptest.ResponseRecorder, DebugConfig) {
+		req := httptest.NewRequest(http.MethodPatch, "/admin", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var cfg DebugConfig
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &cfg); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w, cfg
+	}
+
+	w, cfg := patch(`{"algorithms": {"gzip": "best", "zstd": false}, "min_content_length": 2048}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	opts := m.Options()
+	if gz := opts.Algorithms[EncodingGzip]; gz.Level != 9 || gz.MaxIdle != 4 {
+		t.Errorf("Expected only the gzip level to change, got %+v", gz)
+	}
+	if slices.Contains(opts.EncodingPriority, EncodingZstd) || opts.MinContentLength != 2048 {
+		t.Errorf("Unexpected options after update: %v, %d", opts.EncodingPriority, opts.MinContentLength)
+	}
+	if cfg.MinContentLength != 2048 || slices.Contains(cfg.EncodingPriority, EncodingZstd) {
+		t.Errorf("Expected the response to show the updated config, got %+v", cfg)
+	}
+
+	// 重新启用 zstd, 并整体关闭中间件
+	if w, _ := patch(`{"algorithms": {"zstd": true}, "enabled": false}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if !slices.Contains(m.Options().EncodingPriority, EncodingZstd) || m.Enabled() {
+		t.Errorf("Expected zstd enabled and the middleware disabled")
+	}
+
+	// 有错误时整体不生效
+	for _, body := range []string{
+		`{"algorithms": {"gzip": 1, "br": 5}}`,
+		`{"algorithms": {"gzip": 42}}`,
+		`{"algorithms": {"gzip": null, "deflate": null, "zstd": null}}`,
+		`{"min_content_length": -1}`,
+		`{"level": 3}`,
+		`not json`,
+	} {
+		if w, _ := patch(body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+	if opts := m.Options(); opts.Algorithms[EncodingGzip].Level != 9 || opts.MinContentLength != 2048 {
+		t.Errorf("Expected rejected updates to leave options unchanged, got %+v", opts)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"min_content_length":2048`) {
+		t.Errorf("Expected GET to return the config, got %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,253 @@
+package compress
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/infinite-iroha/touka"
+)
+
+// ArchiveEntry 是归档响应中的一个文件
+type ArchiveEntry struct {
+	Name    string      // 归档内的路径, 使用 "/" 分隔
+	Size    int64       // 文件大小; tar 需要预先知道大小, 小于 0 时先把内容读入内存
+	Mode    fs.FileMode // 为 0 时使用 0644
+	ModTime time.Time   // 为零值时使用当前时间
+
+	// Open 在写入该文件时调用, 返回的 ReadCloser 读完后关闭; 文件按需逐个打开
+	Open func() (io.ReadCloser, error)
+}
+
+// ReaderEntry 返回内容来自 r 的归档文件, size 未知时传 -1
+func ReaderEntry(name string, r io.Reader, size int64) ArchiveEntry {
+	return ArchiveEntry{Name: name, Size: size, Open: func() (io.ReadCloser, error) { return io.NopCloser(r), nil }}
+}
+
+// FSEntries 返回 fsys 中 root 目录下 (含子目录) 的全部普通文件, 归档内的路径相对于 root
+func FSEntries(fsys fs.FS, root string) ([]ArchiveEntry, error) {
+	var entries []ArchiveEntry
+	err := fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		name := p
+		if root != "." {
+			name = p[len(root)+1:]
+		}
+		entries = append(entries, ArchiveEntry{
+			Name:    name,
+			Size:    info.Size(),
+			Mode:    info.Mode().Perm(),
+			ModTime: info.ModTime(),
+			Open:    func() (io.ReadCloser, error) { return fsys.Open(p) },
+		})
+		return nil
+	})
+	return entries, err
+}
+
+// ArchiveOptions 控制归档响应的压缩
+type ArchiveOptions struct {
+	// Level 是 gzip (tar.gz) 或 deflate (zip) 的压缩级别, 为 0 时使用默认级别
+	Level int
+	// FlushEachEntry 为 true 时每写完一个文件即刷新压缩器与连接, 客户端可以看到下载进度
+	FlushEachEntry bool
+}
+
+// level 返回生效的压缩级别; gzip 与 deflate 的默认级别取值相同
+func (o ArchiveOptions) level() int {
+	if o.Level == 0 {
+		return gzip.DefaultCompression
+	}
+	return o.Level
+}
+
+// TarGz 以 tar.gz 格式把 entries 流式写入响应, 文件名为 filename。
+// gzip 压缩器取自包内的对象池; 响应本身已压缩, 外层的压缩中间件不会再次压缩 (跳过原因 SkipPreEncoded)。
+//
+// 响应头部在写入第一个文件前发出, 之后出错时无法再改变状态码: 返回的错误应记录下来,
+// 归档缺少结尾, 客户端解压时可以发现传输不完整。
+func TarGz(c *touka.Context, filename string, entries []ArchiveEntry, opts ArchiveOptions) error {
+	level := opts.level()
+	if !validLevel(EncodingGzip, level) {
+		return fmt.Errorf("compress: gzip level %d out of range", level)
+	}
+	writeArchiveHeader(c, filename, "application/gzip")
+	gz, pool := getCompressor(EncodingGzip, level, c.Writer, true)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		if err := writeTarEntry(tw, e); err != nil {
+			pool.discard()
+			return err
+		}
+		if opts.FlushEachEntry {
+			flushArchive(c, tw.Flush, gz.Flush)
+		}
+	}
+	err := tw.Close()
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		pool.discard()
+		return fmt.Errorf("compress: finishing tar.gz: %w", err)
+	}
+	pool.put(gz)
+	return nil
+}
+
+func writeTarEntry(tw *tar.Writer, e ArchiveEntry) error {
+	rc, err := e.Open()
+	if err != nil {
+		return fmt.Errorf("compress: opening %s: %w", e.Name, err)
+	}
+	defer rc.Close()
+	var r io.Reader = rc
+	size := e.Size
+	if size < 0 {
+		var buf bytes.Buffer
+		if _, err := buf.ReadFrom(rc); err != nil {
+			return fmt.Errorf("compress: reading %s: %w", e.Name, err)
+		}
+		r, size = &buf, int64(buf.Len())
+	}
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     e.Name,
+		Size:     size,
+		Mode:     int64(entryMode(e)),
+		ModTime:  entryTime(e),
+		Format:   tar.FormatPAX,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("compress: writing %s: %w", e.Name, err)
+	}
+	if err := copyBuffered(tw, r); err != nil {
+		return fmt.Errorf("compress: writing %s: %w", e.Name, err)
+	}
+	return nil
+}
+
+// Zip 以 zip 格式把 entries 流式写入响应, 文件名为 filename。
+// 各文件使用包内对象池中的 deflate 压缩器; 错误处理与 TarGz 相同。
+func Zip(c *touka.Context, filename string, entries []ArchiveEntry, opts ArchiveOptions) error {
+	level := opts.level()
+	if !validLevel(EncodingDeflate, level) {
+		return fmt.Errorf("compress: deflate level %d out of range", level)
+	}
+	writeArchiveHeader(c, filename, "application/zip")
+	zw := zip.NewWriter(c.Writer)
+	zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		cw, pool := getCompressor(EncodingDeflate, level, w, true)
+		return &pooledDeflate{cw: cw, pool: pool}, nil
+	})
+	for _, e := range entries {
+		rc, err := e.Open()
+		if err != nil {
+			return fmt.Errorf("compress: opening %s: %w", e.Name, err)
+		}
+		hdr := &zip.FileHeader{Name: e.Name, Method: zip.Deflate, Modified: entryTime(e)}
+		hdr.SetMode(entryMode(e))
+		w, err := zw.CreateHeader(hdr)
+		if err == nil {
+			err = copyBuffered(w, rc)
+		}
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("compress: writing %s: %w", e.Name, err)
+		}
+		if opts.FlushEachEntry {
+			flushArchive(c, zw.Flush)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("compress: finishing zip: %w", err)
+	}
+	return nil
+}
+
+// pooledDeflate 把对象池中的 deflate 压缩器交给 archive/zip 使用, Close 时归还
+type pooledDeflate struct {
+	cw     compressWriter
+	pool   *encoderPool
+	failed bool
+}
+
+func (p *pooledDeflate) Write(b []byte) (int, error) {
+	n, err := p.cw.Write(b)
+	if err != nil {
+		p.failed = true
+	}
+	return n, err
+}
+
+func (p *pooledDeflate) Close() error {
+	err := p.cw.Close()
+	if err != nil || p.failed {
+		p.pool.discard()
+		return err
+	}
+	p.pool.put(p.cw)
+	return nil
+}
+
+// writeArchiveHeader 设置归档响应的头部并写出状态码
+func writeArchiveHeader(c *touka.Context, filename, contentType string) {
+	h := c.Writer.Header()
+	h.Set(headerContentType, contentType)
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(filename)}))
+	h.Del(headerContentLength)
+	if crw, ok := c.Writer.(*compressResponseWriter); ok && !crw.wroteHeader {
+		// 归档已经压缩, 不论可压缩类型如何配置都原样发送
+		crw.wroteHeader = true
+		crw.statusCode = http.StatusOK
+		crw.skipWith(SkipPreEncoded, http.StatusOK)
+		return
+	}
+	c.Writer.WriteHeader(http.StatusOK)
+}
+
+// flushArchive 依次刷新归档格式与压缩器的缓冲, 再刷新连接
+func flushArchive(c *touka.Context, flushers ...func() error) {
+	for _, f := range flushers {
+		if f() != nil {
+			return
+		}
+	}
+	c.Writer.Flush()
+}
+
+// copyBuffered 使用共享缓冲区复制数据
+func copyBuffered(dst io.Writer, src io.Reader) error {
+	buf := getBuffer()
+	_, err := io.CopyBuffer(dst, src, *buf)
+	putBuffer(buf)
+	return err
+}
+
+func entryMode(e ArchiveEntry) fs.FileMode {
+	if e.Mode == 0 {
+		return 0o644
+	}
+	return e.Mode
+}
+
+func entryTime(e ArchiveEntry) time.Time {
+	if e.ModTime.IsZero() {
+		return time.Now()
+	}
+	return e.ModTime
+}
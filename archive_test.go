@@ -0,0 +1,129 @@
+package compress
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestArchives(t *testing.T) {
+	fsys := fstest.MapFS{
+		"assets/app.js":        {Data: []byte(strings.Repeat("console.log(1);", 100))},
+		"assets/css/style.css": {Data: []byte("body{}")},
+		"other.txt":            {Data: []byte("not included")},
+	}
+	want := map[string]string{
+		"app.js":        string(fsys["assets/app.js"].Data),
+		"css/style.css": "body{}",
+		"notes.txt":     "streamed with unknown size",
+	}
+	entries := func(t *testing.T) []ArchiveEntry {
+		e, err := FSEntries(fsys, "assets")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(e, ReaderEntry("notes.txt", strings.NewReader(want["notes.txt"]), -1))
+	}
+
+	r := touka.New()
+	r.Use(Compression(CompressOptions{
+		Algorithms:        map[string]AlgorithmConfig{EncodingGzip: {Level: 6}},
+		CompressibleTypes: []string{"application/"},
+		DebugHeader:       true,
+	}))
+	r.GET("/bundle.tar.gz", func(c *touka.Context) {
+		if err := TarGz(c, "bundle.tar.gz", entries(t), ArchiveOptions{FlushEachEntry: true}); err != nil {
+			t.Error(err)
+		}
+	})
+	r.GET("/bundle.zip", func(c *touka.Context) {
+		if err := Zip(c, "bundle.zip", entries(t), ArchiveOptions{Level: 9}); err != nil {
+			t.Error(err)
+		}
+	})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "" {
+			t.Fatalf("%s: expected 200 without Content-Encoding, got %d %q", path, w.Code, w.Header().Get("Content-Encoding"))
+		}
+		if got := w.Header().Get("X-Compression-Info"); got != "skipped=pre_encoded" {
+			t.Errorf("%s: expected pre_encoded skip, got %q", path, got)
+		}
+		if got := w.Header().Get("Content-Disposition"); !strings.Contains(got, "attachment") || !strings.Contains(got, path[1:]) {
+			t.Errorf("%s: unexpected Content-Disposition %q", path, got)
+		}
+		return w
+	}
+
+	w := get("/bundle.tar.gz")
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(tr)
+		got[hdr.Name] = string(b)
+	}
+	checkArchive(t, "tar.gz", got, want)
+
+	w = get("/bundle.zip")
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		got[f.Name] = string(b)
+	}
+	checkArchive(t, "zip", got, want)
+}
+
+func checkArchive(t *testing.T, format string, got, want map[string]string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: expected %d files, got %d", format, len(want), len(got))
+	}
+	for name, data := range want {
+		if got[name] != data {
+			t.Errorf("%s: %s = %q, want %q", format, name, got[name], data)
+		}
+	}
+}
+
+func TestArchiveInvalidLevel(t *testing.T) {
+	c, _ := touka.CreateTestContext(httptest.NewRecorder())
+	if err := TarGz(c, "a.tar.gz", nil, ArchiveOptions{Level: 42}); err == nil {
+		t.Error("Expected error for invalid gzip level")
+	}
+	if err := Zip(c, "a.zip", nil, ArchiveOptions{Level: 42}); err == nil {
+		t.Error("Expected error for invalid deflate level")
+	}
+}
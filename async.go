@@ -0,0 +1,199 @@
+package compress
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+var errEncoderUnavailable = errors.New("compress: encoder unavailable")
+
+// asyncOp 是发给压缩 worker 的消息类型
+type asyncOp uint8
+
+const (
+	asyncStart asyncOp = iota
+	asyncWrite
+	asyncFlush
+	asyncDrain
+	asyncClose
+	asyncAbort
+)
+
+type asyncMsg struct {
+	op   asyncOp
+	ac   *asyncCompressor // 仅用于 asyncStart: 本次响应的压缩器
+	data []byte
+	buf  *[]byte    // data 所在的共享缓冲区, 写入后归还
+	ack  chan error // flush/drain/close 完成后回复
+}
+
+// asyncWorker 在独立的 goroutine 中为一个响应执行压缩。
+// 压缩器由处理器所在的 goroutine 在写出头部之前取得 (与同步压缩一样经过对象池与内存预算),
+// worker 只负责调用它, 响应结束后由处理器归还。
+type asyncWorker struct {
+	m    *Middleware
+	msgs chan asyncMsg
+	ack  chan error
+}
+
+// startAsyncWorkers 启动 n 个压缩 worker; worker 空闲时登记在 m.idleWorkers 中
+func (m *Middleware) startAsyncWorkers(n, queue int) {
+	if queue <= 0 {
+		queue = 16
+	}
+	m.idleWorkers = make(chan *asyncWorker, n)
+	for i := 0; i < n; i++ {
+		w := &asyncWorker{
+			m:    m,
+			msgs: make(chan asyncMsg, queue),
+			ack:  make(chan error, 1),
+		}
+		m.idleWorkers <- w
+		go w.run()
+	}
+}
+
+// acquireAsyncCompressor 在有空闲 worker 时返回一个由其调用 cw 的压缩器, 否则返回 nil (由调用方同步压缩)。
+// 进程内缓冲的数据已达 MaxTotalBufferedBytes 时也返回 nil。
+func (m *Middleware) acquireAsyncCompressor(cw compressWriter, cfg *config) *asyncCompressor {
+	if overBufferBudget(cfg.opts.MaxTotalBufferedBytes) {
+		return nil
+	}
+	select {
+	case wk := <-m.idleWorkers:
+		ac := &asyncCompressor{worker: wk, cw: cw, limit: cfg.bufferLimit(), total: cfg.opts.MaxTotalBufferedBytes}
+		wk.msgs <- asyncMsg{op: asyncStart, ac: ac}
+		return ac
+	default:
+		return nil
+	}
+}
+
+func (wk *asyncWorker) run() {
+	var ac *asyncCompressor
+	for msg := range wk.msgs {
+		switch msg.op {
+		case asyncStart:
+			ac = msg.ac
+		case asyncWrite:
+			ac.mu.Lock()
+			if ac.err == nil {
+				_, ac.err = ac.cw.Write(msg.data)
+			}
+			ac.mu.Unlock()
+			n := int64(len(msg.data))
+			ac.queued.Add(-n)
+			releaseBuffered(n)
+			putBuffer(msg.buf)
+		case asyncFlush:
+			ac.mu.Lock()
+			if ac.err == nil {
+				ac.err = ac.cw.Flush()
+			}
+			err := ac.err
+			ac.mu.Unlock()
+			msg.ack <- err
+		case asyncDrain:
+			msg.ack <- nil // 之前排队的写入都已处理
+		case asyncClose:
+			ac.mu.Lock()
+			if closeErr := ac.cw.Close(); ac.err == nil {
+				ac.err = closeErr
+			}
+			err := ac.err
+			ac.mu.Unlock()
+			msg.ack <- err
+			ac = nil
+			wk.m.idleWorkers <- wk
+		case asyncAbort:
+			// 响应异常结束, 不写出剩余数据; 压缩器由处理器丢弃
+			msg.ack <- nil
+			ac = nil
+			wk.m.idleWorkers <- wk
+		}
+	}
+}
+
+// asyncCompressor 将写入转交给 worker, 使处理器不必等待压缩完成。
+// 写入错误会在之后的 Flush 或 Close 返回。
+type asyncCompressor struct {
+	worker *asyncWorker
+	cw     compressWriter // 实际的压缩器, 由 worker 调用; 只在 worker 处理完已排队的数据后由处理器直接调用
+	limit  int64          // 排队数据的上限, 见 MaxBufferedBytes
+	total  int64          // MaxTotalBufferedBytes
+	queued atomic.Int64   // 已排队尚未压缩的字节数
+
+	// mu 在调用 cw (进而写入底层 ResponseWriter) 期间持有,
+	// 处理器读取 Size 等底层 writer 的状态时先取得它, 见 compressResponseWriter.Size
+	mu  sync.Mutex
+	err error // 第一个压缩错误, 由 mu 保护
+}
+
+// Write 把 p 复制到共享缓冲区后排队 (调用方可能复用 p)。超过缓冲区大小的写入拆成多条消息,
+// 因此每个 worker 排队的数据不超过 AsyncQueue 个缓冲区, 队列满时处理器等待 worker。
+// 排队的数据将超过 MaxBufferedBytes 时先等待 worker 处理完已排队的数据;
+// 进程内缓冲已达 MaxTotalBufferedBytes 时等待后在本 goroutine 直接压缩, 不再排队。
+func (ac *asyncCompressor) Write(p []byte) (int, error) {
+	n := len(p)
+	chunk := int(min(bufferSize, ac.limit))
+	for len(p) > 0 {
+		k := min(len(p), chunk)
+		if ac.queued.Load()+int64(k) > ac.limit {
+			ac.drain()
+		}
+		if !reserveBuffered(ac.total, int64(k)) {
+			ac.drain()
+			ac.mu.Lock()
+			if ac.err == nil {
+				_, ac.err = ac.cw.Write(p[:k])
+			}
+			ac.mu.Unlock()
+			p = p[k:]
+			continue
+		}
+		ac.queued.Add(int64(k))
+		buf := getBuffer()
+		copy(*buf, p[:k])
+		ac.worker.msgs <- asyncMsg{op: asyncWrite, data: (*buf)[:k], buf: buf}
+		p = p[k:]
+	}
+	return n, nil
+}
+
+// drain 等待 worker 处理完已排队的写入, 不改变压缩输出
+func (ac *asyncCompressor) drain() {
+	ac.worker.msgs <- asyncMsg{op: asyncDrain, ack: ac.worker.ack}
+	<-ac.worker.ack
+}
+
+func (ac *asyncCompressor) Flush() error {
+	ac.worker.msgs <- asyncMsg{op: asyncFlush, ack: ac.worker.ack}
+	return <-ac.worker.ack
+}
+
+// Close 等待 worker 写完所有数据, 之后 worker 可被其他响应使用, ac.cw 可以归还到对象池
+func (ac *asyncCompressor) Close() error {
+	ac.worker.msgs <- asyncMsg{op: asyncClose, ack: ac.worker.ack}
+	return <-ac.worker.ack
+}
+
+// abort 让 worker 放弃当前响应而不写出剩余数据, 之后 worker 可被其他响应使用。
+// ac.cw 可能停在写了一半的状态, 调用方应丢弃而不是归还它。
+func (ac *asyncCompressor) abort() {
+	ac.worker.msgs <- asyncMsg{op: asyncAbort, ack: ac.worker.ack}
+	<-ac.worker.ack
+}
+
+func (ac *asyncCompressor) Reset(io.Writer) {}
+
+// syncAsync 在异步压缩时取得 worker 的锁并返回解锁函数, 使处理器读取底层 writer 的状态时不与 worker 竞争;
+// 同步压缩时什么也不做
+func (crw *compressResponseWriter) syncAsync() func() {
+	if ac, ok := crw.compressor.(*asyncCompressor); ok {
+		ac.mu.Lock()
+		return ac.mu.Unlock
+	}
+	return func() {}
+}
@@ -0,0 +1,312 @@
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/infinite-iroha/touka"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestAsyncWorkers(t *testing.T) {
+	skipWithoutZstd(t)
+	m := New(CompressOptions{
+		Algorithms: map[string]AlgorithmConfig{
+			EncodingGzip: {Level: gzip.BestSpeed, PoolEnabled: true},
+			EncodingZstd: {Level: zstdDefaultLevel, PoolEnabled: true},
+		},
+		AsyncWorkers: 2,
+		AsyncQueue:   2,
+	})
+	chunk := strings.Repeat("async chunk ", 50)
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		buf := []byte(chunk)
+		for i := 0; i < 20; i++ {
+			c.Writer.Write(buf) // 复用同一缓冲区, worker 必须拷贝
+			if i == 10 {
+				c.Writer.Flush()
+			}
+		}
+	})
+
+	want := strings.Repeat(chunk, 20)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ { // 多于 worker 数, 部分请求回退为同步压缩
+		wg.Add(1)
+		go func(ae string) {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", ae)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			var rd io.Reader
+			switch w.Header().Get("Content-Encoding") {
+			case EncodingGzip:
+				gr, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				rd = gr
+			case EncodingZstd:
+				zr, err := zstd.NewReader(w.Body)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer zr.Close()
+				rd = zr
+			default:
+				t.Errorf("Unexpected Content-Encoding %q", w.Header().Get("Content-Encoding"))
+				return
+			}
+			got, _ := io.ReadAll(rd)
+			if string(got) != want {
+				t.Errorf("%s: body mismatch (%d bytes)", ae, len(got))
+			}
+		}([]string{"gzip", "zstd"}[i%2])
+	}
+	wg.Wait()
+
+	if got := m.Stats().Snapshot().Total.Responses; got != 8 {
+		t.Errorf("Expected 8 compressed responses, got %d", got)
+	}
+	// worker 在回复 Close 之后才重新登记为空闲
+	deadline := time.Now().Add(time.Second)
+	for len(m.idleWorkers) != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if len(m.idleWorkers) != 2 {
+		t.Errorf("Expected all workers to be idle again, got %d", len(m.idleWorkers))
+	}
+}
+
+func TestAsyncFlushSize(t *testing.T) {
+	// worker 写出压缩数据的同时处理器反复 Flush; 需在 -race 下运行
+	const events = 200
+	m := New(CompressOptions{
+		Algorithms:        map[string]AlgorithmConfig{EncodingGzip: {Level: 6, PoolEnabled: true}},
+		CompressibleTypes: []string{"text/"},
+		AsyncWorkers:      1,
+		FlushSize:         1400,
+	})
+	var want strings.Builder
+	for i := range events {
+		want.WriteString(strings.Repeat("async flush event ", i%7+1) + "\n")
+	}
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		for i := range events {
+			io.WriteString(c.Writer, strings.Repeat("async flush event ", i%7+1)+"\n")
+			c.Writer.Flush()
+		}
+	})
+
+	w := &flushCounter{ResponseRecorder: httptest.NewRecorder()}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	r.ServeHTTP(w, req)
+
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(gr)
+	if string(got) != want.String() {
+		t.Fatalf("Body mismatch (%d bytes)", len(got))
+	}
+	// 异步压缩时不合并刷新, 每次 Flush 都交给 worker 并送达客户端
+	if w.flushes < events {
+		t.Errorf("Expected every Flush to be forwarded with async workers, got %d of %d", w.flushes, events)
+	}
+}
+
+func TestAsyncLargeWrite(t *testing.T) {
+	// 超过共享缓冲区的写入被拆分排队, 队列只有一条消息时也不会丢失或错序
+	m := New(CompressOptions{
+		Algorithms:   map[string]AlgorithmConfig{EncodingGzip: {Level: 1}},
+		AsyncWorkers: 1,
+		AsyncQueue:   1,
+	})
+	var want strings.Builder
+	for i := 0; want.Len() < 3*bufferSize+100; i++ {
+		fmt.Fprintf(&want, "line %d\n", i)
+	}
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		buf := []byte(want.String())
+		c.Writer.Write(buf)
+		clear(buf) // Write 返回后调用方可以复用缓冲区
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(gr); string(got) != want.String() {
+		t.Errorf("Body mismatch (%d of %d bytes)", len(got), want.Len())
+	}
+}
+
+// fixedLevel 总是返回同一级别的 LevelPolicy
+type fixedLevel int
+
+func (l fixedLevel) Level(string, int) int         { return int(l) }
+func (fixedLevel) Observe(string, AdaptiveSignals) {}
+
+func TestAsyncEncoderUnavailable(t *testing.T) {
+	// 压缩器在写出头部前取得: 无法创建时以 identity 发送完整的响应体, 而不是带 Content-Encoding 的空响应
+	m := New(CompressOptions{
+		Algorithms:    map[string]AlgorithmConfig{EncodingGzip: {Level: 6}},
+		AsyncWorkers:  1,
+		AdaptiveLevel: fixedLevel(42),
+		DebugHeader:   true,
+	})
+	body := strings.Repeat("no encoder ", 100)
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		io.WriteString(c.Writer, body)
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != body {
+		t.Errorf("Expected identity body, got %q with %d bytes", w.Header().Get("Content-Encoding"), w.Body.Len())
+	}
+	if got := w.Header().Get("X-Compression-Info"); got != "skipped=encoder_unavailable" {
+		t.Errorf("Unexpected skip reason %q", got)
+	}
+}
+
+func TestAsyncSharedPool(t *testing.T) {
+	m := New(CompressOptions{
+		Algorithms:   map[string]AlgorithmConfig{EncodingGzip: {Level: 4, PoolEnabled: true}},
+		AsyncWorkers: 1,
+	})
+	body := strings.Repeat("shared pool ", 100)
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		io.WriteString(c.Writer, body)
+		// 与 worker 的写出并发读取底层 writer 的状态; 需在 -race 下运行
+		_, _ = c.Writer.Size(), c.Writer.Written()
+		if bw, ok := c.Writer.(interface{ BytesOut() int64 }); ok {
+			bw.BytesOut()
+		}
+		c.Writer.Flush()
+		_ = c.Writer.Size()
+	})
+	pool := poolFor(EncodingGzip, 4)
+	gets, puts := pool.gets.Load(), pool.puts.Load()
+	for range 4 {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Header().Get("Content-Encoding") != EncodingGzip {
+			t.Fatalf("Expected gzip, got %v", w.Header())
+		}
+	}
+	// 异步压缩的压缩器经过共享的对象池, 计入池统计
+	if g, p := pool.gets.Load()-gets, pool.puts.Load()-puts; g != 4 || p != 4 {
+		t.Errorf("Expected 4 pool gets and puts, got %d and %d", g, p)
+	}
+}
+
+func TestAsyncBufferLimit(t *testing.T) {
+	var want strings.Builder
+	for i := 0; want.Len() < 64<<10; i++ {
+		fmt.Fprintf(&want, "line %d\n", i)
+	}
+	check := func(t *testing.T, opts CompressOptions, handler func(*testing.T, *asyncCompressor)) {
+		t.Helper()
+		opts.Algorithms = map[string]AlgorithmConfig{EncodingGzip: {Level: 1}}
+		opts.AsyncWorkers = 1
+		r := touka.New()
+		r.Use(Compression(opts))
+		r.GET("/", func(c *touka.Context) {
+			c.Header("Content-Type", "text/plain")
+			ac, ok := c.Writer.(*compressResponseWriter).compressor.(*asyncCompressor)
+			if !ok {
+				// 首次写入前还没有选定压缩器
+				c.Writer.WriteHeader(200)
+				ac, ok = c.Writer.(*compressResponseWriter).compressor.(*asyncCompressor)
+			}
+			if ok {
+				handler(t, ac)
+			}
+			for s := want.String(); len(s) > 0; {
+				k := min(len(s), 1000)
+				io.WriteString(c.Writer, s[:k])
+				s = s[k:]
+				if ac != nil && ac.queued.Load() > ac.limit {
+					t.Errorf("Queued %d bytes over the %d limit", ac.queued.Load(), ac.limit)
+				}
+			}
+		})
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := io.ReadAll(gr); string(got) != want.String() {
+			t.Errorf("Body mismatch (%d of %d bytes)", len(got), want.Len())
+		}
+	}
+
+	t.Run("per-response", func(t *testing.T) {
+		async := false
+		check(t, CompressOptions{MaxBufferedBytes: 4096}, func(t *testing.T, ac *asyncCompressor) {
+			async = true
+			if ac.limit != 4096 {
+				t.Errorf("Expected limit 4096, got %d", ac.limit)
+			}
+		})
+		if !async {
+			t.Error("Expected asynchronous compression")
+		}
+	})
+	t.Run("global exhausted mid-response", func(t *testing.T) {
+		// 响应开始后进程内预算耗尽, 之后的写入在处理器的 goroutine 中直接压缩
+		before := bufferedBytes.Load()
+		check(t, CompressOptions{MaxTotalBufferedBytes: 1 << 20}, func(t *testing.T, ac *asyncCompressor) {
+			bufferedBytes.Add(1 << 20)
+			t.Cleanup(func() { bufferedBytes.Add(-1 << 20) })
+		})
+		if got := bufferedBytes.Load() - 1<<20; got != before {
+			t.Errorf("Expected buffered bytes to return to %d, got %d", before, got)
+		}
+	})
+	t.Run("global exhausted", func(t *testing.T) {
+		bufferedBytes.Add(1 << 20)
+		defer bufferedBytes.Add(-1 << 20)
+		check(t, CompressOptions{MaxTotalBufferedBytes: 1 << 20}, func(t *testing.T, ac *asyncCompressor) {
+			t.Error("Expected synchronous compression once the process-wide budget is used up")
+		})
+	})
+}
@@ -0,0 +1,139 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fenthope/compress"
+	"github.com/infinite-iroha/touka"
+)
+
+// target 是参与基准的编码与级别
+type target struct {
+	encoding string
+	level    int
+}
+
+var targets = []target{
+	{compress.EncodingGzip, 1},
+	{compress.EncodingGzip, 6},
+	{compress.EncodingGzip, 9},
+	{compress.EncodingDeflate, 6},
+	{compress.EncodingZstd, 1},
+	{compress.EncodingZstd, 3},
+	{compress.EncodingZstd, 9},
+}
+
+// parallelism 是 b.SetParallelism 的取值, 实际 goroutine 数为其与 GOMAXPROCS 之积
+var parallelism = []int{1, 8, 64}
+
+// discardWriter 是丢弃响应体的 http.ResponseWriter, 避免记录器本身的开销干扰结果
+type discardWriter struct {
+	header http.Header
+	n      int64
+}
+
+func (w *discardWriter) Header() http.Header { return w.header }
+func (w *discardWriter) WriteHeader(int)     {}
+func (w *discardWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+func newEngine(t target, corpus Corpus) *touka.Engine {
+	r := touka.New()
+	r.Use(compress.Compression(compress.CompressOptions{
+		Algorithms:       map[string]compress.AlgorithmConfig{t.encoding: {Level: t.level, PoolEnabled: true}},
+		EncodingPriority: []string{t.encoding},
+	}))
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", corpus.ContentType)
+		c.Writer.Write(corpus.Data)
+	})
+	return r
+}
+
+// serve 以 encoding 请求一次, 返回压缩后的字节数与 Content-Encoding
+func serve(r *touka.Engine, encoding string) (int64, string) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", encoding)
+	w := &discardWriter{header: make(http.Header)}
+	r.ServeHTTP(w, req)
+	return w.n, w.header.Get("Content-Encoding")
+}
+
+// BenchmarkMiddleware 衡量经过中间件的完整请求, 报告吞吐 (按未压缩字节) 与压缩比
+func BenchmarkMiddleware(b *testing.B) {
+	for _, t := range targets {
+		for _, corpus := range Corpora() {
+			r := newEngine(t, corpus)
+			out, enc := serve(r, t.encoding)
+			if enc != t.encoding {
+				b.Fatalf("Expected %s response, got %q", t.encoding, enc)
+			}
+			ratio := float64(len(corpus.Data)) / float64(out)
+			for _, par := range parallelism {
+				b.Run(fmt.Sprintf("%s-%d/%s/par-%d", t.encoding, t.level, corpus.Name, par), func(b *testing.B) {
+					b.SetBytes(int64(len(corpus.Data)))
+					b.SetParallelism(par)
+					b.ReportAllocs()
+					b.RunParallel(func(pb *testing.PB) {
+						req := httptest.NewRequest("GET", "/", nil)
+						req.Header.Set("Accept-Encoding", t.encoding)
+						w := &discardWriter{header: make(http.Header)}
+						for pb.Next() {
+							clear(w.header)
+							r.ServeHTTP(w, req)
+						}
+					})
+					b.ReportMetric(ratio, "ratio")
+				})
+			}
+		}
+	}
+}
+
+// BenchmarkEncoder 衡量不经中间件与对象池的压缩器本身, 作为中间件开销的基线
+func BenchmarkEncoder(b *testing.B) {
+	for _, t := range targets {
+		for _, corpus := range Corpora() {
+			b.Run(fmt.Sprintf("%s-%d/%s", t.encoding, t.level, corpus.Name), func(b *testing.B) {
+				b.SetBytes(int64(len(corpus.Data)))
+				b.ReportAllocs()
+				for b.Loop() {
+					w, err := compress.NewEncoder(t.encoding, t.level, io.Discard)
+					if err != nil {
+						b.Fatal(err)
+					}
+					w.Write(corpus.Data)
+					w.Close()
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkSkipped 衡量中间件在不压缩的请求上的开销
+func BenchmarkSkipped(b *testing.B) {
+	r := touka.New()
+	r.Use(compress.Compression(compress.DefaultCompressionConfig()))
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "image/png")
+		c.Writer.Write([]byte("png"))
+	})
+	for _, ae := range []string{"", "gzip"} {
+		b.Run("accept-"+ae, func(b *testing.B) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", ae)
+			w := &discardWriter{header: make(http.Header)}
+			b.ReportAllocs()
+			for b.Loop() {
+				clear(w.header)
+				r.ServeHTTP(w, req)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package bench
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCorporaDeterministic(t *testing.T) {
+	a, b := Corpora(), Corpora()
+	for i := range a {
+		if a[i].Name != b[i].Name || !bytes.Equal(a[i].Data, b[i].Data) {
+			t.Errorf("Corpus %s is not reproducible", a[i].Name)
+		}
+		if len(a[i].Data) < 2<<10 {
+			t.Errorf("Corpus %s is only %d bytes", a[i].Name, len(a[i].Data))
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package compress
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// bufferSize 是共享缓冲区的大小, 与 io.Copy 的默认缓冲区一致
+const bufferSize = 32 << 10
+
+// bufferPool 是包内各处复制数据时共用的临时缓冲区池, 避免各功能各自分配
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, bufferSize)
+		return &b
+	},
+}
+
+// getBuffer 从池中取出一个长度为 bufferSize 的缓冲区, 用完后应调用 putBuffer 归还
+func getBuffer() *[]byte {
+	return bufferPool.Get().(*[]byte)
+}
+
+// putBuffer 归还 getBuffer 取得的缓冲区; 容量不符的缓冲区会被丢弃
+func putBuffer(b *[]byte) {
+	if b == nil || cap(*b) != bufferSize {
+		return
+	}
+	*b = (*b)[:bufferSize]
+	bufferPool.Put(b)
+}
+
+// defaultMaxBufferedBytes 是 MaxBufferedBytes 未设置时单个响应的缓冲上限
+const defaultMaxBufferedBytes = 1 << 20
+
+// bufferedBytes 是进程内所有响应当前缓冲的数据量, 与 MaxTotalBufferedBytes 比较
+var bufferedBytes atomic.Int64
+
+// bufferLimit 返回生效的单个响应缓冲上限
+func (cfg *config) bufferLimit() int64 {
+	if cfg.opts.MaxBufferedBytes > 0 {
+		return cfg.opts.MaxBufferedBytes
+	}
+	return defaultMaxBufferedBytes
+}
+
+// overBufferBudget 报告进程内缓冲的数据是否已达到 total (为 0 时不限制)
+func overBufferBudget(total int64) bool {
+	return total > 0 && bufferedBytes.Load() >= total
+}
+
+// reserveBuffered 在不超过 total (为 0 时不限制) 的前提下把 n 计入 bufferedBytes, 超出时不计入并返回 false。
+// 计入的字节在数据写出后由 releaseBuffered 扣除。
+func reserveBuffered(total, n int64) bool {
+	if after := bufferedBytes.Add(n); total > 0 && after > total {
+		bufferedBytes.Add(-n)
+		return false
+	}
+	return true
+}
+
+// releaseBuffered 扣除 reserveBuffered 计入的 n 字节
+func releaseBuffered(n int64) { bufferedBytes.Add(-n) }
@@ -0,0 +1,20 @@
+package compress
+
+import "testing"
+
+func TestBufferPool(t *testing.T) {
+	b := getBuffer()
+	if len(*b) != bufferSize {
+		t.Fatalf("Expected buffer of %d bytes, got %d", bufferSize, len(*b))
+	}
+	*b = (*b)[:10]
+	putBuffer(b)
+	if b := getBuffer(); len(*b) != bufferSize {
+		t.Errorf("Expected returned buffer to be restored to %d bytes, got %d", bufferSize, len(*b))
+	}
+
+	// 容量不符与 nil 的缓冲区不应进入池中
+	small := make([]byte, 10)
+	putBuffer(&small)
+	putBuffer(nil)
+}
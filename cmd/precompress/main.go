@@ -0,0 +1,180 @@
+// precompress 在构建期遍历静态资源目录, 以最高压缩比为每个文件生成 .zst/.gz 等预压缩副本。
+//
+// 用法:
+//
+//	precompress [-enc zstd,gzip] [-min 256] [-ext .html,.css,.js] [-force] <dir>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fenthope/compress"
+)
+
+// defaultExtensions 是默认参与预压缩的文件扩展名
+var defaultExtensions = []string{
+	".html", ".htm", ".css", ".js", ".mjs", ".json", ".xml", ".svg", ".txt", ".map", ".wasm",
+}
+
+type config struct {
+	root       string
+	codecs     []compress.Codec
+	minSize    int64
+	extensions map[string]bool
+	force      bool
+	verbose    bool
+}
+
+func main() {
+	encFlag := flag.String("enc", "zstd,gzip", "要生成的编码, 逗号分隔")
+	minFlag := flag.Int64("min", 256, "小于此字节数的文件不压缩")
+	extFlag := flag.String("ext", strings.Join(defaultExtensions, ","), "参与压缩的文件扩展名, 逗号分隔")
+	forceFlag := flag.Bool("force", false, "即使预压缩文件已是最新也重新生成")
+	verboseFlag := flag.Bool("v", false, "输出每个文件的处理结果")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: precompress [flags] <dir>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := config{
+		root:       flag.Arg(0),
+		minSize:    *minFlag,
+		extensions: make(map[string]bool),
+		force:      *forceFlag,
+		verbose:    *verboseFlag,
+	}
+	for _, ext := range strings.Split(*extFlag, ",") {
+		if ext = strings.TrimSpace(ext); ext != "" {
+			cfg.extensions[strings.ToLower(ext)] = true
+		}
+	}
+	for _, enc := range strings.Split(*encFlag, ",") {
+		enc = strings.TrimSpace(enc)
+		codec, ok := compress.LookupCodec(enc)
+		if !ok || codec.Extension == "" {
+			fmt.Fprintf(os.Stderr, "precompress: unsupported encoding %q\n", enc)
+			os.Exit(2)
+		}
+		cfg.codecs = append(cfg.codecs, codec)
+	}
+
+	if err := run(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "precompress: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run 遍历目录并为符合条件的文件生成预压缩副本
+func run(cfg config) error {
+	sidecarExts := make(map[string]bool, len(cfg.codecs))
+	for _, codec := range compress.Codecs() {
+		if codec.Extension != "" {
+			sidecarExts[codec.Extension] = true
+		}
+	}
+
+	return filepath.WalkDir(cfg.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if sidecarExts[ext] || !cfg.extensions[ext] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() < cfg.minSize {
+			return nil
+		}
+		for _, codec := range cfg.codecs {
+			if err := compressFile(cfg, path, info, codec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// compressFile 为单个文件生成指定编码的副本。
+// 若压缩结果不小于原文件, 则不保留副本, 避免运行时提供更大的变体。
+func compressFile(cfg config, path string, info fs.FileInfo, codec compress.Codec) error {
+	dst := path + codec.Extension
+	if !cfg.force {
+		if st, err := os.Stat(dst); err == nil && !st.ModTime().Before(info.ModTime()) {
+			return nil
+		}
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".precompress-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // 成功重命名后删除会失败, 可忽略
+
+	enc, err := compress.NewEncoder(codec.Encoding, codec.BestLevel, tmp)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := io.Copy(enc, src); err != nil {
+		enc.Close()
+		tmp.Close()
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := enc.Close(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	st, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if st.Size() >= info.Size() {
+		if cfg.verbose {
+			fmt.Printf("skip %s (%s not smaller)\n", path, codec.Encoding)
+		}
+		return nil
+	}
+	if err := os.Chmod(tmpName, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, dst); err != nil {
+		return err
+	}
+	if err := os.Chtimes(dst, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	if cfg.verbose {
+		fmt.Printf("%s %d -> %d\n", dst, info.Size(), st.Size())
+	}
+	return nil
+}
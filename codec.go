@@ -0,0 +1,77 @@
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/flate"
+)
+
+// Codec 描述包内支持的一种压缩编码
+type Codec struct {
+	Encoding     string // 编码名称, 如 "gzip"
+	Extension    string // 预压缩文件使用的扩展名, 如 ".gz"; 为空表示该编码通常不用于预压缩文件
+	DefaultLevel int    // 默认压缩级别
+	BestLevel    int    // 最高压缩比级别
+}
+
+// knownCodecs 是包认识的全部编码, 包括以构建标签排除的
+var knownCodecs = []Codec{
+	{Encoding: EncodingZstd, Extension: ".zst", DefaultLevel: zstdDefaultLevel, BestLevel: 22},
+	{Encoding: EncodingGzip, Extension: ".gz", DefaultLevel: gzip.DefaultCompression, BestLevel: gzip.BestCompression},
+	{Encoding: EncodingDeflate, DefaultLevel: flate.DefaultCompression, BestLevel: flate.BestCompression},
+}
+
+// codecTable 是编译进二进制的编码表, 顺序即默认的预压缩顺序; 以 compress_no_zstd 构建时不含 zstd
+var codecTable = func() []Codec {
+	var t []Codec
+	for _, c := range knownCodecs {
+		if c.Encoding != EncodingZstd || zstdAvailable {
+			t = append(t, c)
+		}
+	}
+	return t
+}()
+
+// Codecs 返回包内支持的编码表的副本
+func Codecs() []Codec {
+	out := make([]Codec, len(codecTable))
+	copy(out, codecTable)
+	return out
+}
+
+// knownCodec 按编码名称查找包认识的编码, 包括未编译进二进制的。
+// 配置的校验与解析使用它, 使同一份配置适用于各种构建; 未编译进二进制的编码在运行时被忽略。
+func knownCodec(encoding string) (Codec, bool) {
+	for _, c := range knownCodecs {
+		if c.Encoding == encoding {
+			return c, true
+		}
+	}
+	return Codec{}, false
+}
+
+// LookupCodec 按编码名称查找编码表项
+func LookupCodec(encoding string) (Codec, bool) {
+	for _, c := range codecTable {
+		if c.Encoding == encoding {
+			return c, true
+		}
+	}
+	return Codec{}, false
+}
+
+// NewEncoder 创建一个不经过对象池的压缩写入器, 写入的数据会以 encoding 编码后写入 w。
+// 适用于中间件之外的场景, 如构建期预压缩静态资源。调用方必须 Close 返回的写入器。
+func NewEncoder(encoding string, level int, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case EncodingGzip:
+		return gzip.NewWriterLevel(w, level)
+	case EncodingDeflate:
+		return flate.NewWriter(w, level)
+	case EncodingZstd:
+		return newZstdEncoder(level, w)
+	}
+	return nil, fmt.Errorf("compress: unsupported encoding %q", encoding)
+}
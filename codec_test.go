@@ -0,0 +1,132 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zstd"
+)
+
+// skipWithoutZstd 在以 compress_no_zstd 构建时跳过依赖 zstd 的测试
+func skipWithoutZstd(t testing.TB) {
+	t.Helper()
+	if !zstdAvailable {
+		t.Skip("zstd is excluded by the compress_no_zstd build tag")
+	}
+}
+
+func TestNewEncoderRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte("precompressed asset content "), 64)
+
+	for _, codec := range Codecs() {
+		t.Run(codec.Encoding, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewEncoder(codec.Encoding, codec.BestLevel, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(payload); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			var r io.Reader
+			switch codec.Encoding {
+			case EncodingGzip:
+				gr, err := gzip.NewReader(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r = gr
+			case EncodingDeflate:
+				r = flate.NewReader(&buf)
+			case EncodingZstd:
+				zr, err := zstd.NewReader(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer zr.Close()
+				r = zr
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Errorf("round trip mismatch for %s", codec.Encoding)
+			}
+		})
+	}
+}
+
+func TestNewEncoderUnsupported(t *testing.T) {
+	if _, err := NewEncoder("br", 0, io.Discard); err == nil {
+		t.Error("Expected error for unsupported encoding")
+	}
+	if _, ok := LookupCodec("br"); ok {
+		t.Error("Expected br to be absent from codec table")
+	}
+}
+
+func TestZstdDefaultLevel(t *testing.T) {
+	skipWithoutZstd(t)
+	codec, ok := LookupCodec(EncodingZstd)
+	if !ok {
+		t.Fatal("Expected zstd in codec table")
+	}
+	if got := zstd.EncoderLevelFromZstd(codec.DefaultLevel); got != zstd.SpeedDefault {
+		t.Errorf("zstd DefaultLevel %d maps to %v, want %v", codec.DefaultLevel, got, zstd.SpeedDefault)
+	}
+	if !hasPool(EncodingZstd, codec.DefaultLevel) {
+		t.Errorf("Expected pooled encoder for zstd DefaultLevel %d", codec.DefaultLevel)
+	}
+}
+
+func TestBestLevelSmallerThanDefault(t *testing.T) {
+	// 低冗余的文本, 使较高级别的匹配搜索有机会得到更小的输出
+	var payload bytes.Buffer
+	for i := 0; i < 4096; i++ {
+		fmt.Fprintf(&payload, "%d:%x;", i, i*i*2654435761)
+	}
+
+	encode := func(encoding string, level int) int {
+		var buf bytes.Buffer
+		w, err := NewEncoder(encoding, level, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(payload.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Len()
+	}
+
+	for _, codec := range Codecs() {
+		t.Run(codec.Encoding, func(t *testing.T) {
+			best, def := encode(codec.Encoding, codec.BestLevel), encode(codec.Encoding, codec.DefaultLevel)
+			if best >= def {
+				t.Errorf("BestLevel %d output %d bytes, DefaultLevel %d output %d bytes", codec.BestLevel, best, codec.DefaultLevel, def)
+			}
+		})
+	}
+}
+
+func TestDefaultLevelPools(t *testing.T) {
+	for _, codec := range Codecs() {
+		if !hasPool(codec.Encoding, codec.DefaultLevel) {
+			t.Errorf("Expected pooled encoder for %s DefaultLevel %d", codec.Encoding, codec.DefaultLevel)
+		}
+	}
+	if !hasPool(EncodingGzip, gzip.NoCompression) {
+		t.Error("Expected pooled encoder for gzip NoCompression")
+	}
+}
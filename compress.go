@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"compress/gzip" // Gzip
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
@@ -11,22 +12,79 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/klauspost/compress/flate" // Deflate
 
 	"github.com/infinite-iroha/touka"
-	"github.com/klauspost/compress/zstd" // Zstandard
 )
 
 // HTTP 头部常量
 const (
-	headerAcceptEncoding  = "Accept-Encoding"  // 客户端接受的编码
-	headerContentEncoding = "Content-Encoding" // 响应使用的编码
-	headerContentLength   = "Content-Length"   // 内容长度
-	headerContentType     = "Content-Type"     // 内容类型
-	headerVary            = "Vary"             // 缓存控制
+	headerAcceptEncoding  = "Accept-Encoding"       // 客户端接受的编码
+	headerContentEncoding = "Content-Encoding"      // 响应使用的编码
+	headerContentLength   = "Content-Length"        // 内容长度
+	headerContentType     = "Content-Type"          // 内容类型
+	headerVary            = "Vary"                  // 缓存控制
+	headerCacheControl    = "Cache-Control"         // 缓存指令 (no-transform)
+	headerContentMD5      = "Content-MD5"           // 内容摘要 (已废弃, 但仍有处理器设置)
+	headerDigest          = "Digest"                // RFC 3230 实例摘要
+	headerReprDigest      = "Repr-Digest"           // RFC 9530 表示摘要
+	headerServerTiming    = "Server-Timing"         // 服务端耗时
+	headerCompressionInfo = "X-Compression-Info"    // 压缩决策的调试信息
+	headerAcceptRanges    = "Accept-Ranges"         // 是否支持范围请求
+	headerPadding         = "X-Compression-Padding" // 随机长度的填充, 见 PaddingMode
+	headerETag            = "ETag"                  // 实体标签
+
+	headerCompressedLength = "Compressed-Length" // trailer: 压缩后的字节数, 见 CompressionTrailers
+	headerCompressionRatio = "Compression-Ratio" // trailer: 未压缩与压缩后字节数之比
 )
 
+// AcceptRangesPolicy 决定压缩响应如何处理 Accept-Ranges 头部
+type AcceptRangesPolicy uint8
+
+const (
+	AcceptRangesRemove AcceptRangesPolicy = iota // 移除 Accept-Ranges (默认)
+	AcceptRangesNone                             // 设为 Accept-Ranges: none, 明确告知客户端不支持范围请求
+	AcceptRangesKeep                             // 保留处理器设置的值
+)
+
+var acceptRangesPolicyNames = [...]string{"remove", "none", "keep"}
+
+func (p AcceptRangesPolicy) String() string {
+	if int(p) < len(acceptRangesPolicyNames) {
+		return acceptRangesPolicyNames[p]
+	}
+	return "unknown"
+}
+
+// ErrorPolicy 决定 4xx 与 5xx 响应的响应体是否压缩
+type ErrorPolicy uint8
+
+const (
+	CompressErrors ErrorPolicy = iota // 与其他响应一样压缩 (默认)
+	SkipErrors                        // 以 identity 发送, 便于直接查看错误内容 (跳过原因 SkipStatusCode)
+)
+
+var errorPolicyNames = [...]string{"compress", "skip"}
+
+func (p ErrorPolicy) String() string {
+	if int(p) < len(errorPolicyNames) {
+		return errorPolicyNames[p]
+	}
+	return "unknown"
+}
+
+// integrityHeaders 是描述响应体摘要的头部。
+// 压缩会改变响应体, 处理器计算的摘要将不再匹配, 因此需要在压缩时移除。
+// 使用规范化的键, 删除时无需再规范化 (Content-MD5 的规范形式为 Content-Md5)。
+var integrityHeaders = []string{
+	http.CanonicalHeaderKey(headerContentMD5),
+	http.CanonicalHeaderKey(headerDigest),
+	http.CanonicalHeaderKey(headerReprDigest),
+}
+
 // 支持的压缩编码名称
 const (
 	EncodingGzip     = "gzip"
@@ -56,11 +114,79 @@ type AlgorithmConfig struct {
 	// Level 是压缩级别。具体含义取决于算法：
 	// - Gzip: gzip.BestSpeed (-2) 到 gzip.BestCompression (9), gzip.DefaultCompression (-1)
 	// - Deflate: flate.BestSpeed (-2) 到 flate.BestCompression (9), flate.DefaultCompression (-1)
-	// - Zstd: zstd.SpeedFastest (1) 到 zstd.SpeedBestCompression (22 approx), zstd.SpeedDefault (3)
+	// - Zstd: 与 zstd 命令行一致的数值级别 1 到 22, 默认 3 (经 zstd.EncoderLevelFromZstd 映射)
 	Level int
 	// PoolEnabled 指示是否为此算法和级别启用对象池。
 	// 对于不常用的级别或算法，可以禁用池以减少内存占用。
 	PoolEnabled bool
+
+	// Concurrency 仅用于 zstd, 是每个压缩器使用的 goroutine 数。
+	// klauspost/zstd 默认按 GOMAXPROCS 启动 goroutine, 大量并发响应下开销很大,
+	// 因此默认 (0) 按 GOMAXPROCS 每 16 个增加一个, 最多 4 个, 小容器上为单 goroutine。
+	// 设为大于 1 的值时压缩器不使用对象池。
+	Concurrency int
+
+	// LowMemory 仅用于 zstd, 对应 zstd.WithLowerEncoderMem:
+	// 以略低的压缩比换取显著更小的单个压缩器内存占用, 适合内存受限的小容器。
+	// 启用后压缩器不使用对象池。
+	LowMemory bool
+
+	// PrewarmPoolSize 为 N 时, New 会预先创建 N 个压缩器放入对象池,
+	// 避免部署后第一波流量承担压缩器的创建开销 (zstd 压缩器每个需要数毫秒)。
+	// 仅在该配置经过对象池时生效。注意 sync.Pool 会在 GC 时丢弃空闲对象, 需要持久的预热时使用 PoolBounded。
+	// 设为 Auto 时按 GOMAXPROCS 每个 P 预热一个, 最多 16 个。
+	PrewarmPoolSize int
+
+	// PoolType 选择对象池的实现, 默认 PoolSync。
+	// PoolBounded 使用有容量上限的池, 空闲压缩器在 GC 后仍会保留, 内存占用可预期。
+	PoolType PoolType
+	// MaxIdle 是 PoolBounded 池最多保留的空闲压缩器数, 默认 GOMAXPROCS 的 4 倍, 介于 16 与 256 之间
+	MaxIdle int
+
+	// MinContentLength 大于 0 时代替 CompressOptions.MinContentLength 作为选中此编码时的最小压缩长度,
+	// 例如 zstd 在比 gzip 9 更小的响应上就值得压缩
+	MinContentLength int64
+
+	// SizeTiers 非空时按响应声明的 Content-Length 选择级别, 取第一个 MaxLength 不小于该长度的档位,
+	// 例如 [{8 << 10, 1}, {256 << 10, 5}, {0, 3}]; 未声明长度或没有匹配的档位时使用 Level。
+	// 档位按 MaxLength 升序排列, 只有最后一个可以为 0 (不限长度)。EncodingPolicy 选择了级别时不再按档位选择。
+	SizeTiers []SizeTier
+	// StreamingLevel 非 0 时用于未声明 Content-Length 的 (流式) 响应。流式响应通常对延迟敏感且频繁刷新,
+	// 压缩比本就受影响, 可以使用比 Level 更快的级别。EncodingPolicy 选择了级别时不生效。
+	StreamingLevel int
+	// PreferAbove 大于 0 时, 只有声明的 Content-Length 超过此值的响应才优先使用此编码,
+	// 更小的响应改用客户端接受的下一个编码 (按优先级), 例如 zstd 设为 32 << 10 时小响应改用 gzip,
+	// 免去 zstd 压缩器的初始化开销。未声明长度的响应仍按优先级协商; EncodingPolicy 非 nil 时不生效。
+	PreferAbove int64
+}
+
+// SizeTier 是 AlgorithmConfig.SizeTiers 中的一个档位
+type SizeTier struct {
+	MaxLength int64 `json:"max_length"` // 档位包含的最大 Content-Length, 0 表示不限
+	Level     int   `json:"level"`
+}
+
+// tierLevel 返回长度为 n 的响应按 SizeTiers 应使用的级别
+func (a AlgorithmConfig) tierLevel(n int64) (int, bool) {
+	for _, t := range a.SizeTiers {
+		if t.MaxLength == 0 || n <= t.MaxLength {
+			return t.Level, true
+		}
+	}
+	return 0, false
+}
+
+// zstdCustom 报告配置是否包含需要专门创建 zstd 压缩器的选项 (此类压缩器不经对象池)
+func (a AlgorithmConfig) zstdCustom() bool {
+	return a.Concurrency > 1 || a.LowMemory
+}
+
+// pooled 报告按此配置为 encoding 获取的压缩器是否会经过对象池
+func (a AlgorithmConfig) pooled(encoding string) bool {
+	if !a.PoolEnabled || (encoding == EncodingZstd && a.zstdCustom()) {
+		return false
+	}
+	return hasPool(encoding, a.Level)
 }
 
 // CompressOptions 用于配置压缩中间件
@@ -72,18 +198,216 @@ type CompressOptions struct {
 	// 用户可以通过此配置禁用某些算法或设置其级别。
 	Algorithms map[string]AlgorithmConfig
 
+	// Methods 非空时只压缩这些请求方法 (如 GET、HEAD) 的响应, 其他方法的请求完全跳过中间件,
+	// 不做协商也不包装 ResponseWriter (跳过原因 SkipMethod)。HEAD 通常应与 GET 一同列出, 使两者的响应头部一致。
+	Methods []string
+	// MethodFilter 非 nil 时对请求方法调用, 返回 false 的请求同样跳过中间件; 与 Methods 同时设置时两者都需通过
+	MethodFilter func(method string) bool
+
 	// MinContentLength 是应用压缩的最小内容长度 (字节)。
 	// 如果响应的 Content-Length 小于此值，则不应用压缩。默认为 0 (无最小限制)。
 	MinContentLength int64
 
+	// MaxContentLength 大于 0 时, Content-Length 超过此值的响应 (如代理的大文件) 原样发送 (跳过原因 SkipTooLarge),
+	// 避免长时间占用 CPU, 也不妨碍 sendfile 等零拷贝发送。未声明长度的响应不受限制;
+	// 个别路由确需压缩大响应时使用 AllowLargeResponses。
+	MaxContentLength int64
+
 	// CompressibleTypes 是要压缩的 MIME 类型列表。
 	// 如果为空，将使用 defaultCompressibleTypes。
 	CompressibleTypes []string
+	// RsyncableTypes 列出以 "rsyncable" 方式压缩的 MIME 类型前缀 (如 "application/x-tar"), 只对 gzip 与 deflate 生效。
+	// 匹配的响应在由内容决定的边界处 (至少间隔 2 KiB, 平均约 10 KiB) 同步刷新压缩器, 类似 gzip --rsyncable:
+	// 输入的局部改动只影响附近的压缩输出, 便于下游做增量同步或去重, 代价是压缩比略有下降。
+	RsyncableTypes []string
 
 	// EncodingPriority 是一个有序的编码名称切片，用于在客户端支持多种可用算法时决定优先级。
 	// 例如：[]string{"zstd", "gzip", "deflate"}。
 	// 如果为空，默认优先级为：zstd (如果已配置), gzip, deflate。
 	EncodingPriority []string
+
+	// EncodingWeights 为编码设置大于 0 的权重后, 客户端接受 (q>0) 的带权重编码之间按权重随机选择, 不再按 EncodingPriority 排序,
+	// 例如 {"zstd": 80, "gzip": 20} 让同时支持两者的客户端 80% 的时间收到 zstd, 以分散 CPU 开销。
+	// 客户端未明确列出任何带权重的编码时 (包括只发送 "*"), 仍按 EncodingPriority 协商。默认为空, 不随机。
+	EncodingWeights map[string]int
+
+	// Profiles 是具名的路由配置 (如 "api"、"assets"、"streaming"), 路由通过 Middleware.Profile 按名称使用。
+	// 各配置在创建与 UpdateOptions 时编译一次, 与主配置共享对象池、并发名额与统计。
+	Profiles map[string]PartialOptions
+	// ProfileHeader 非空时, 来自 TrustedProxies 的请求可以用该请求头部 (如 X-Compress-Profile) 按名称选择 Profiles 中的配置,
+	// 使边缘节点或 CDN 无需后端重新部署即可决定策略。其他来源的请求与未知的名称忽略该头部; 路由上的 Profile 与 Override 优先。
+	ProfileHeader string
+	// TrustedProxies 是允许使用 ProfileHeader 的直接对端, 每项为 IP 或 CIDR (如 "10.0.0.0/8")。
+	// 按 TCP 连接的对端地址 (Request.RemoteAddr) 判断, 不参考 X-Forwarded-For。
+	TrustedProxies []string
+
+	// ExpvarName 非空时, 统计快照会以此名称发布到 expvar (可在 /debug/vars 查看)。
+	// 多个实例使用同一名称时, 以最后创建的实例为准。
+	ExpvarName string
+
+	// ServerTiming 为 true 时, 压缩响应会附带 Server-Timing 指标,
+	// 例如 `compress;dur=0.412;desc="zstd l3 ratio=4.20"`。
+	// 耗时与压缩比在响应体写完后才能确定, 因此以 HTTP trailer 的形式发送。
+	ServerTiming bool
+
+	// OriginalLengthHeader 非空时 (如 "X-Original-Content-Length"), 压缩响应以该名称的头部
+	// 保留处理器设置的 Content-Length, 压缩移除 Content-Length 后客户端与日志系统仍能看到未压缩的大小。
+	OriginalLengthHeader string
+	// OriginalLengthTrailer 为 true 时, 处理器未设置 Content-Length 的压缩响应在结束后
+	// 以同名 trailer 发送实际写入的未压缩字节数; 需要同时设置 OriginalLengthHeader
+	OriginalLengthTrailer bool
+	// CompressionTrailers 为 true 时, 压缩响应声明并在结束后发送 Compressed-Length (压缩后的字节数)
+	// 与 Compression-Ratio (未压缩与压缩后字节数之比, 保留三位小数) 两个 trailer, 供客户端与记录日志的代理统计每个响应的节省。
+	// 只有分块传输 (HTTP/1.1) 或 HTTP/2 的响应能携带 trailer, 不支持 trailer 的客户端会忽略它们。
+	CompressionTrailers bool
+
+	// DebugHeader 为 true 时, 压缩响应会附带 X-Compression-Info 头部,
+	// 例如 `encoding=gzip; level=6; pooled=true`, 便于在预发环境验证 CDN/边缘节点的行为。
+	DebugHeader bool
+
+	// GzipHeader 非 nil 时在每个 gzip 压缩响应开始写入前调用, 可以设置 gzip 头部的 Name、ModTime、Comment 与 Extra,
+	// 例如为以 Content-Disposition 下载、客户端解压后保存的文件写入原始文件名。h 预先填入默认值 (OS 为 255)。
+	// Name 与 Comment 只能包含 Latin-1 字符, 否则写入时失败, 按压缩器错误处理。
+	GzipHeader func(c *touka.Context, h *gzip.Header)
+
+	// OnCompress 在一次压缩响应完成 (压缩器关闭) 后调用
+	OnCompress func(info CompressInfo)
+
+	// OnSkip 在一次响应未被压缩时于请求结束后调用
+	OnSkip func(reason SkipReason, c *touka.Context)
+
+	// ErrorHandler 在压缩器创建、写入、刷新或结束失败时调用, err 为 *EncoderError, 每个响应最多调用一次。
+	// 默认 (nil) 以 Error 级别记录日志。无论是否设置, 创建失败 (OpInit) 时响应都会以 identity 发送;
+	// 其余错误发生时响应头已经发出, 客户端收到的压缩流可能被截断。
+	ErrorHandler func(c *touka.Context, err error)
+
+	// AcceptRanges 决定压缩响应如何处理处理器设置的 Accept-Ranges。
+	// 实时压缩的响应无法按原始长度响应范围请求, 默认 (AcceptRangesRemove) 移除该头部;
+	// 依赖预压缩副本等自行处理范围请求的部署可设为 AcceptRangesKeep。
+	AcceptRanges AcceptRangesPolicy
+
+	// SkipOnVaryWildcard 为 true 时, 处理器设置了 Vary: * 的响应不压缩 (跳过原因 SkipVaryWildcard)。
+	// 默认照常压缩, 并保留 Vary: * 而不追加 Accept-Encoding (* 已涵盖所有请求头部)。
+	SkipOnVaryWildcard bool
+
+	// Errors 决定 4xx 与 5xx 错误响应是否压缩。较大的 HTML 错误页与 JSON problem details 压缩收益明显,
+	// 默认 (CompressErrors) 照常压缩; 希望错误响应保持原样以便调试时设为 SkipErrors。
+	Errors ErrorPolicy
+
+	// SensitiveResponse 非 nil 时在其他检查都通过、即将开始压缩时调用, 返回 true 则该响应以 identity 发送
+	// (跳过原因 SkipSensitive)。用于按自己的规则 (如响应含 CSRF 令牌、设置了 Set-Cookie、认证相关路由)
+	// 避免压缩同时包含机密与用户输入的响应。调用时响应体尚未写出, header 为处理器已设置的响应头部。
+	SensitiveResponse func(c *touka.Context, header http.Header) bool
+
+	// MaxOutputBytes 大于 0 时限制单个响应压缩后写出的字节数, 防止压缩器异常或无休止的流式响应。
+	// 超出时不再写出任何数据, 以包装 ErrOutputLimit 的错误调用 ErrorHandler, 客户端收到的响应被截断。
+	MaxOutputBytes int64
+
+	// WriteTimeout 大于 0 时, 每次向客户端写出压缩数据前经 http.ResponseController 把写超时设为此时长之后,
+	// 请求的 context 带有更早的截止时间时以其为准; 停止读取的客户端不会无限期占用压缩器, 写出超时后压缩器被丢弃。
+	// 响应结束时清除写超时。底层 ResponseWriter 不支持 SetWriteDeadline (http.ErrNotSupported) 时不生效。
+	WriteTimeout time.Duration
+
+	// FlushSize 大于 0 时合并压缩器的刷新, 使每次写出的块接近此大小 (如 1400 或其倍数), 减少逐事件刷新的流式响应产生的小包。
+	// 按已写出部分的压缩率估算, 自上次刷新以来的数据压缩后不足 FlushSize 字节时推迟 Flush; 第一次 Flush 总是立即生效。
+	// 推迟的数据在之后的 Flush 或响应结束时写出, 处理器在两次 Flush 之间长时间等待时会随之延迟, 可用 FlushMaxDelay 限制。
+	// 启用 AsyncWorkers 且响应由 worker 压缩时不合并刷新。
+	FlushSize int
+	// FlushMaxDelay 大于 0 时, 距上次刷新超过此时长的 Flush 不再推迟 (需在 Flush 调用时检查, 不会主动刷新)
+	FlushMaxDelay time.Duration
+
+	// Padding 非 PaddingOff 时为每个压缩响应加入随机长度的填充, 作为 BREACH 的辅助缓解措施, 见 PaddingMode
+	Padding PaddingMode
+	// PaddingMax 是填充内容的最大长度 (字节), 默认 32, 最大 4096
+	PaddingMax int
+
+	// LogLevel 控制通过 touka 引擎日志记录的内容, 默认只记录压缩器错误。
+	// 设为 LogLevelOff 可完全关闭。
+	LogLevel LogLevel
+
+	// LogSampleRate 是记录压缩决策 (编码、大小、压缩比、耗时或跳过原因) 的抽样比例,
+	// 取值 0 到 1, 例如 0.01 表示约每 100 个请求记录一次。默认 0 不记录。
+	// 抽样日志以 Info 级别输出, 不受 LogLevel 限制 (LogLevelOff 除外)。
+	LogSampleRate float64
+
+	// Tee 非 nil 时, 在决定压缩某个响应后调用, 返回的 Writer 会同步收到未压缩的响应体,
+	// 客户端仍收到压缩后的数据, 适用于审计日志或响应缓存。返回 nil 表示该请求不复制。
+	// 写入 Tee 失败只会记录日志并停止复制, 不影响响应本身。
+	Tee func(c *touka.Context) io.Writer
+
+	// MaxPoolMemory 大于 0 时限制对象池持有压缩器的估算内存 (字节, 按编码与级别估算, zstd 以窗口大小为主)。
+	// 超出预算时不再从池中新建或向池中归还压缩器, 改为创建用后即弃的压缩器。
+	// 对象池在进程内共享, 预算与进程内所有对象池的总占用比较。
+	MaxPoolMemory int64
+
+	// MaxBufferedBytes 限制单个响应在内存中缓冲的数据 (字节), 为 0 时为 1 MiB。缓冲的数据包括
+	// 异步压缩排队等待 worker 的写入与 JSON 的编码缓冲区; 达到上限时回退为流式处理:
+	// 异步压缩先等待 worker 处理完已排队的数据, JSON 提前开始响应。
+	// 复制已编码内容 (ServeCompressed、CopyBody 等) 使用的 32 KiB 临时缓冲区边读边写, 不计入。
+	MaxBufferedBytes int64
+	// MaxTotalBufferedBytes 大于 0 时限制进程内所有响应缓冲的数据总量 (字节), 与 MaxPoolMemory 一样按进程计算。
+	// 已达上限时新的响应不再使用异步压缩, 已开始的异步响应在本 goroutine 直接压缩, JSON 不经缓冲直接写出。
+	// 当前用量见 StatsSnapshot.Buffered。
+	MaxTotalBufferedBytes int64
+
+	// MaxConcurrentCompressions 大于 0 时限制同时进行的压缩响应数,
+	// 达到上限后新的响应以 identity 发送 (跳过原因 SkipOverloaded), 避免在过载的机器上继续堆积压缩任务。
+	MaxConcurrentCompressions int
+	// ConcurrencyWait 是达到上限时等待空位的最长时间, 默认 0 即不等待
+	ConcurrencyWait time.Duration
+
+	// ClientRate 大于 0 时限制每个客户端每秒开始的压缩响应数, 超出的响应以 identity 发送 (跳过原因 SkipRateLimited),
+	// 避免单个客户端 (如激进的爬虫) 占满压缩所用的 CPU。最多同时跟踪 65536 个客户端,
+	// 已满且无法清理出空间时, 新客户端的响应按超出限速处理。
+	ClientRate float64
+	// ClientBurst 是每个客户端可以连续开始的压缩响应数, 默认为 ClientRate 向上取整 (至少 1)
+	ClientBurst int
+	// ClientKey 返回限速所用的客户端标识 (如 API 令牌), 默认使用 c.ClientIP()
+	ClientKey func(c *touka.Context) string
+
+	// AdaptiveLevel 非 nil 时在每个压缩响应开始时决定级别, 并在结束后接收写入与压缩耗时等信号,
+	// 例如 NewBackpressurePolicy()。调整后的级别没有对应对象池时, 压缩器不经对象池创建。
+	AdaptiveLevel LevelPolicy
+	// EncodingPolicy 非 nil 时在响应的媒体类型确定后, 从客户端接受的编码中重新选择编码与级别,
+	// 例如 NewHistoryPolicy() 按各内容类型的历史压缩率与耗时选择。AdaptiveLevel 在其选择的级别上继续调整。
+	EncodingPolicy EncodingPolicy
+
+	// AsyncWorkers 大于 0 时启用异步压缩: 处理器的写入经有界队列交给专用的 worker 压缩,
+	// 处理器不必等待压缩完成。压缩器与同步压缩一样在写出头部前取得, 经过对象池、MaxPoolMemory 与池统计。
+	// 所有 worker 都忙时回退为同步压缩。异步写入的错误在 Flush 或请求结束时才会记录。
+	// worker 与处理器并发写出响应体: c.Writer 的 Size、Written 与 BytesOut 与 worker 同步,
+	// 写出状态码之后处理器只应修改 trailer, 不应直接写入底层的 http.ResponseWriter。
+	// 设为 Auto 时每个 P 一个 worker, 最多 64 个。
+	AsyncWorkers int
+	// AsyncQueue 是每个 worker 的写入队列长度, 默认 16。每条消息最多 32 KiB (更大的写入会被拆分),
+	// 因此每个 worker 排队的未压缩数据不超过 AsyncQueue × 32 KiB, 同时受 MaxBufferedBytes 与 MaxTotalBufferedBytes 限制。
+	AsyncQueue int
+
+	// StrictNegotiation 为 true 时, 若客户端既不接受任何已配置的编码也不接受 identity,
+	// 直接以 406 Not Acceptable 响应而不调用处理器 (默认仍以 identity 发送)。
+	StrictNegotiation bool
+	// NotAcceptable 非 nil 时代替默认的 406 响应, supported 为按优先级排列的可用编码
+	NotAcceptable func(c *touka.Context, supported []string)
+	// StripAcceptEncoding 为 true 时, 协商出压缩编码后从 c.Request 中删除 Accept-Encoding 再调用处理器,
+	// 使进程内反向代理转发的请求只取回未压缩的响应, 由中间件统一压缩一次, 而不是原样转发上游的编码。
+	// 未协商出编码 (客户端不接受任何已配置的编码) 时请求保持不变。ServeCompressed 与 EncodingPolicy 仍按原始的头部工作。
+	// 删除只在处理器运行期间有效, 响应结束时恢复原值, 外层中间件与日志看到的仍是客户端发送的头部。
+	StripAcceptEncoding bool
+
+	// StrictValidation 为 true 时, New (及 Compression) 在 Validate 失败时 panic;
+	// 默认只在第一个请求时以 Warn 级别记录一次, 并照常运行。
+	StrictValidation bool
+}
+
+// CompressInfo 描述一次已完成的压缩响应
+type CompressInfo struct {
+	Context    *touka.Context
+	Encoding   string        // 使用的编码
+	Level      int           // 使用的压缩级别
+	StatusCode int           // 响应状态码
+	BytesIn    int64         // 压缩前的字节数
+	BytesOut   int64         // 压缩后的字节数
+	Duration   time.Duration // 在压缩器中花费的累计时间
 }
 
 // compressWriter 接口定义了压缩写入器需要实现的方法。
@@ -94,227 +418,628 @@ type compressWriter interface {
 	Reset(w io.Writer) // 重置写入器以重用，并关联新的底层写入器
 }
 
+// zstdDefaultLevel 是 zstd 的默认数值级别 (与 zstd 命令行一致), 对应 zstd.SpeedDefault。
+// AlgorithmConfig.Level 对 zstd 使用数值级别, 经 zstd.EncoderLevelFromZstd 映射,
+// 因此不能直接使用 int(zstd.SpeedDefault) (其值为 2, 会被映射为 SpeedFastest)。
+const zstdDefaultLevel = 3
+
+// encoderPool 是某一编码与级别的压缩器对象池, 并统计其使用情况。
+// idle 非 nil 时为有界池, 空闲对象保存在 idle 中而不是 sync.Pool。
+type encoderPool struct {
+	sync.Pool
+	encoding string
+	level    int
+	idle     chan compressWriter
+
+	newFn func() compressWriter
+
+	gets     atomic.Uint64 // 从池中获取的次数
+	misses   atomic.Uint64 // 池为空、需要新建压缩器的次数
+	puts     atomic.Uint64 // 归还到池中的次数
+	discards atomic.Uint64 // 取出后未归还而被丢弃的次数 (超出内存预算或处理器异常结束)
+}
+
+func newEncoderPool(encoding string, level int, newFn func() compressWriter) *encoderPool {
+	p := &encoderPool{encoding: encoding, level: level, newFn: newFn}
+	p.New = func() any {
+		p.misses.Add(1)
+		return p.create()
+	}
+	return p
+}
+
+// create 新建一个归池管理的压缩器, 并计入 pooledMemory 直到它被回收
+func (p *encoderPool) create() compressWriter {
+	x := p.newFn()
+	trackPooledMemory(x, encoderFootprint(p.encoding, p.level))
+	return x
+}
+
+// tryGet 从有界池中取出一个空闲压缩器, 没有时不新建
+func (p *encoderPool) tryGet() (compressWriter, bool) {
+	if p == nil || p.idle == nil {
+		return nil, false
+	}
+	select {
+	case x := <-p.idle:
+		p.gets.Add(1)
+		return x, true
+	default:
+		return nil, false
+	}
+}
+
+// drain 丢弃有界池中所有空闲的压缩器, 交由 GC 回收
+func (p *encoderPool) drain() {
+	for {
+		select {
+		case <-p.idle:
+		default:
+			return
+		}
+	}
+}
+
+// get 取出一个压缩器, 池为空时新建。sync.Pool 中只存放本池 newFn 创建的压缩器, 断言不会失败
+func (p *encoderPool) get() compressWriter {
+	p.gets.Add(1)
+	if p.idle != nil {
+		select {
+		case cw := <-p.idle:
+			return cw
+		default:
+			p.misses.Add(1)
+			return p.create()
+		}
+	}
+	return p.Get().(compressWriter)
+}
+
+// put 归还取自本池的压缩器; p 为 nil (压缩器不经对象池) 时不做任何事
+func (p *encoderPool) put(cw compressWriter) {
+	if p == nil {
+		return
+	}
+	p.puts.Add(1)
+	if p.idle != nil {
+		select {
+		case p.idle <- cw:
+		default: // 已达上限, 丢弃
+		}
+		return
+	}
+	p.Put(cw)
+}
+
+// discard 记录一个取自本池的压缩器被丢弃而不再归还
+func (p *encoderPool) discard() {
+	if p != nil {
+		p.discards.Add(1)
+	}
+}
+
+// prewarm 预先创建 n 个压缩器放入池中, 不计入 gets/misses/puts; 有界池最多填满容量
+func (p *encoderPool) prewarm(n int) {
+	for i := 0; i < n; i++ {
+		if p.idle == nil {
+			p.Put(p.create())
+			continue
+		}
+		// 先检查容量: 发送表达式在 select 之前求值, 池已满时会白白新建一个压缩器
+		if len(p.idle) == cap(p.idle) {
+			return
+		}
+		select {
+		case p.idle <- p.create():
+		default: // 并发归还已填满
+			return
+		}
+	}
+}
+
+// unpooledEncoders 统计因池未启用或级别无对应池而直接新建的压缩器数量
+var unpooledEncoders = map[string]*atomic.Uint64{
+	EncodingGzip:    {},
+	EncodingDeflate: {},
+	EncodingZstd:    {},
+}
+
 // --- gzip specific writer and pool ---
 type gzipCompressWriter struct {
 	*gzip.Writer
-	level int // 用于归还到正确的池
 }
 
 func (gzw *gzipCompressWriter) Reset(w io.Writer) { gzw.Writer.Reset(w) }
 func (gzw *gzipCompressWriter) Flush() error      { return gzw.Writer.Flush() } // gzip.Writer.Flush() returns error
 
-var gzipWriterPoolsArray [gzip.BestCompression - gzip.BestSpeed + 3]*sync.Pool // +1 for DefaultCompression, +1 for 0 index
+var gzipWriterPoolsArray [gzip.BestCompression - gzip.BestSpeed + 3]*encoderPool // +1 for DefaultCompression, +1 for 0 index
+
+// gzipSlot 将 gzip 级别映射到 gzipWriterPoolsArray 的下标, 超出范围时返回 -1
+func gzipSlot(level int) int {
+	idx := level - gzip.BestSpeed // Map level to 0-based index for positive levels
+	if level == gzip.DefaultCompression {
+		idx = gzip.BestCompression - gzip.BestSpeed + 1 // Special index for DefaultCompression
+	} else if level == gzip.NoCompression {
+		idx = gzip.BestCompression - gzip.BestSpeed + 2 // Special index for NoCompression
+	}
+	if idx < 0 || idx >= len(gzipWriterPoolsArray) {
+		return -1
+	}
+	return idx
+}
 
 func initGzipPools() {
+	levels := []int{gzip.DefaultCompression, gzip.NoCompression}
 	for i := gzip.BestSpeed; i <= gzip.BestCompression; i++ {
-		level := i
-		idx := level - gzip.BestSpeed // Map level to 0-based index for positive levels
-		if level == gzip.DefaultCompression {
-			idx = gzip.BestCompression - gzip.BestSpeed + 1 // Special index for DefaultCompression
-		} else if level == 0 { // gzip.NoCompression is 0
-			idx = gzip.BestCompression - gzip.BestSpeed + 2 // Special index for NoCompression
-		}
+		levels = append(levels, i)
+	}
+	for _, level := range levels {
+		gzipWriterPoolsArray[gzipSlot(level)] = newEncoderPool(EncodingGzip, level, func() compressWriter {
+			// 初始化时 writer 为 nil
+			w, _ := gzip.NewWriterLevel(nil, level)
+			return &gzipCompressWriter{Writer: w}
+		})
+	}
+}
 
-		gzipWriterPoolsArray[idx] = &sync.Pool{
-			New: func() interface{} {
-				// 初始化时 writer 为 nil
-				w, _ := gzip.NewWriterLevel(nil, level)
-				return &gzipCompressWriter{Writer: w, level: level}
-			},
-		}
+// setGzipHeader 在 gzip 压缩器写入任何数据前设置其头部; h 为 nil 或压缩器不是 gzip 时不做任何事。
+// 压缩器 Reset 时头部恢复默认值, 不会带到之后的响应。
+func setGzipHeader(cw compressWriter, h *gzip.Header) {
+	if gzw, ok := cw.(*gzipCompressWriter); ok && h != nil {
+		gzw.Header = *h
 	}
 }
 
 // --- deflate specific writer and pool ---
 type deflateCompressWriter struct {
 	*flate.Writer
-	level int
 }
 
 func (fw *deflateCompressWriter) Reset(w io.Writer) { fw.Writer.Reset(w) }
 func (fw *deflateCompressWriter) Flush() error      { return fw.Writer.Flush() }
 
-var deflateWriterPoolsArray [flate.BestCompression - flate.BestSpeed + 2]*sync.Pool // +1 for DefaultCompression
+var deflateWriterPoolsArray [flate.BestCompression - flate.BestSpeed + 2]*encoderPool // +1 for DefaultCompression
 
-func initDeflatePools() {
-	for i := flate.BestSpeed; i <= flate.BestCompression; i++ {
-		level := i
-		idx := level - flate.BestSpeed
-		if level == flate.DefaultCompression {
-			idx = flate.BestCompression - flate.BestSpeed + 1
-		}
-		deflateWriterPoolsArray[idx] = &sync.Pool{
-			New: func() interface{} {
-				w, _ := flate.NewWriter(nil, level)
-				return &deflateCompressWriter{Writer: w, level: level}
-			},
-		}
+// deflateSlot 将 deflate 级别映射到 deflateWriterPoolsArray 的下标, 超出范围时返回 -1
+func deflateSlot(level int) int {
+	idx := level - flate.BestSpeed
+	if level == flate.DefaultCompression {
+		idx = flate.BestCompression - flate.BestSpeed + 1
 	}
+	if idx < 0 || idx >= len(deflateWriterPoolsArray) {
+		return -1
+	}
+	return idx
 }
 
-// --- zstd specific writer and pool ---
-// zstd 级别较多，这里简化为只池化默认级别或用户指定的少数级别
-// 为了更通用，我们可以基于 AlgorithmConfig 中的 Level 动态创建池，或者只池化常见的。
-// 这里我们先为默认级别创建一个池。
-type zstdCompressWriter struct {
-	*zstd.Encoder
-	level zstd.EncoderLevel // zstd.EncoderLevel 是一个类型别名
-}
-
-// zstd.Encoder 的 Reset 方法签名是 Reset(dst io.Writer) error
-// 为了适配 compressWriter 接口，我们需要一个包装
-func (zw *zstdCompressWriter) Reset(w io.Writer)                 { zw.Encoder.Reset(w) } // 忽略 Reset 的错误，或记录它
-func (zw *zstdCompressWriter) Flush() error                      { return zw.Encoder.Flush() }
-func (zw *zstdCompressWriter) Close() error                      { return zw.Encoder.Close() }
-func (zw *zstdCompressWriter) Write(p []byte) (n int, err error) { return zw.Encoder.Write(p) }
-
-var zstdWriterPoolDefault *sync.Pool
-
-func initZstdPools() {
-	// 默认池化 zstd.SpeedDefault 级别
-	zstdWriterPoolDefault = &sync.Pool{
-		New: func() interface{} {
-			// zstd.WithWindowSize(1<<20) // 1MB window, example option
-			w, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
-			return &zstdCompressWriter{Encoder: w, level: zstd.SpeedDefault}
-		},
+func initDeflatePools() {
+	levels := []int{flate.DefaultCompression}
+	for i := flate.BestSpeed; i <= flate.BestCompression; i++ {
+		levels = append(levels, i)
+	}
+	for _, level := range levels {
+		deflateWriterPoolsArray[deflateSlot(level)] = newEncoderPool(EncodingDeflate, level, func() compressWriter {
+			w, _ := flate.NewWriter(nil, level)
+			return &deflateCompressWriter{Writer: w}
+		})
 	}
 }
 
+// zstdWriterPoolDefault 是 zstd 默认级别的对象池, 由 initZstdPools 创建; 编译时排除 zstd 时为 nil
+var zstdWriterPoolDefault *encoderPool
+
 func init() {
 	initGzipPools()
 	initDeflatePools()
 	initZstdPools()
 }
 
-// getCompressor 从池中获取或创建一个新的压缩器
-func getCompressor(encoding string, level int, underlyingWriter io.Writer, poolEnabled bool) compressWriter {
+// gzipPoolIndex 返回 gzip 级别对应的池下标, 没有可用的池时返回 -1
+func gzipPoolIndex(level int) int {
+	if idx := gzipSlot(level); idx >= 0 && gzipWriterPoolsArray[idx] != nil {
+		return idx
+	}
+	return -1
+}
+
+// deflatePoolIndex 返回 deflate 级别对应的池下标, 没有可用的池时返回 -1
+func deflatePoolIndex(level int) int {
+	if idx := deflateSlot(level); idx >= 0 && deflateWriterPoolsArray[idx] != nil {
+		return idx
+	}
+	return -1
+}
+
+// poolFor 返回给定编码与级别的对象池, 没有时返回 nil
+func poolFor(encoding string, level int) *encoderPool {
 	switch encoding {
 	case EncodingGzip:
-		idx := level - gzip.BestSpeed
-		if level == gzip.DefaultCompression {
-			idx = gzip.BestCompression - gzip.BestSpeed + 1
-		}
-		if level == 0 {
-			idx = gzip.BestCompression - gzip.BestSpeed + 2
-		}
-		if poolEnabled && idx >= 0 && idx < len(gzipWriterPoolsArray) && gzipWriterPoolsArray[idx] != nil {
-			cw := gzipWriterPoolsArray[idx].Get().(*gzipCompressWriter)
-			cw.Reset(underlyingWriter)
-			return cw
+		if idx := gzipPoolIndex(level); idx >= 0 {
+			return gzipWriterPoolsArray[idx]
 		}
-		// 如果池未启用或级别超出预设池范围，则创建新的
-		w, _ := gzip.NewWriterLevel(underlyingWriter, level)
-		return &gzipCompressWriter{Writer: w, level: level}
 	case EncodingDeflate:
-		idx := level - flate.BestSpeed
-		if level == flate.DefaultCompression {
-			idx = flate.BestCompression - flate.BestSpeed + 1
+		if idx := deflatePoolIndex(level); idx >= 0 {
+			return deflateWriterPoolsArray[idx]
 		}
-		if poolEnabled && idx >= 0 && idx < len(deflateWriterPoolsArray) && deflateWriterPoolsArray[idx] != nil {
-			cw := deflateWriterPoolsArray[idx].Get().(*deflateCompressWriter)
-			cw.Reset(underlyingWriter)
-			return cw
-		}
-		w, _ := flate.NewWriter(underlyingWriter, level)
-		return &deflateCompressWriter{Writer: w, level: level}
 	case EncodingZstd:
-		// 简化：仅当级别为 SpeedDefault 且池启用时才使用池
-		// 生产代码中可以为特定需要的 zstd 级别创建更多池
-		zstdLevel := zstd.EncoderLevelFromZstd(level) // 将 int 转换为 zstd.EncoderLevel
-		if poolEnabled && zstdLevel == zstd.SpeedDefault && zstdWriterPoolDefault != nil {
-			cw := zstdWriterPoolDefault.Get().(*zstdCompressWriter)
-			cw.Reset(underlyingWriter)
-			return cw
-		}
-		w, _ := zstd.NewWriter(underlyingWriter, zstd.WithEncoderLevel(zstdLevel))
-		return &zstdCompressWriter{Encoder: w, level: zstdLevel}
+		return zstdPoolFor(level)
 	}
 	return nil
 }
 
-// putCompressor 将压缩器返还到相应的池中
-func putCompressor(cw compressWriter, encoding string, poolEnabled bool) {
-	if !poolEnabled || cw == nil {
-		return
+// hasPool 报告给定编码与级别是否有可用的对象池
+func hasPool(encoding string, level int) bool {
+	return poolFor(encoding, level) != nil
+}
+
+// getCompressor 从池中获取或创建一个新的压缩器, 同时返回它取自的对象池 (不经对象池时为 nil)。
+// 压缩器用完后交还给返回的池, 即 pool.put(cw), 不必再按编码与级别查找。
+func getCompressor(encoding string, level int, underlyingWriter io.Writer, poolEnabled bool) (compressWriter, *encoderPool) {
+	if pool := poolFor(encoding, level); poolEnabled && pool != nil {
+		cw := pool.get()
+		cw.Reset(underlyingWriter)
+		return cw, pool
 	}
+	// 如果池未启用或级别没有对应的池，则创建新的
 	switch encoding {
 	case EncodingGzip:
-		if gzw, ok := cw.(*gzipCompressWriter); ok {
-			idx := gzw.level - gzip.BestSpeed
-			if gzw.level == gzip.DefaultCompression {
-				idx = gzip.BestCompression - gzip.BestSpeed + 1
-			}
-			if gzw.level == 0 {
-				idx = gzip.BestCompression - gzip.BestSpeed + 2
-			}
-			if idx >= 0 && idx < len(gzipWriterPoolsArray) && gzipWriterPoolsArray[idx] != nil {
-				gzipWriterPoolsArray[idx].Put(gzw)
-			}
+		unpooledEncoders[EncodingGzip].Add(1)
+		w, err := gzip.NewWriterLevel(underlyingWriter, level)
+		if err != nil { // 非法级别
+			return nil, nil
 		}
+		return &gzipCompressWriter{Writer: w}, nil
 	case EncodingDeflate:
-		if fw, ok := cw.(*deflateCompressWriter); ok {
-			idx := fw.level - flate.BestSpeed
-			if fw.level == flate.DefaultCompression {
-				idx = flate.BestCompression - flate.BestSpeed + 1
-			}
-			if idx >= 0 && idx < len(deflateWriterPoolsArray) && deflateWriterPoolsArray[idx] != nil {
-				deflateWriterPoolsArray[idx].Put(fw)
-			}
+		unpooledEncoders[EncodingDeflate].Add(1)
+		w, err := flate.NewWriter(underlyingWriter, level)
+		if err != nil {
+			return nil, nil
 		}
+		return &deflateCompressWriter{Writer: w}, nil
 	case EncodingZstd:
-		if zw, ok := cw.(*zstdCompressWriter); ok {
-			if zw.level == zstd.SpeedDefault && zstdWriterPoolDefault != nil { // 仅返还默认级别的到池
-				zstdWriterPoolDefault.Put(zw)
-			}
+		if cw := newZstdCompressor(level, AlgorithmConfig{}, underlyingWriter); cw != nil {
+			return cw, nil
 		}
 	}
+	return nil, nil
+}
+
+// allEncoderPools 返回所有已创建的压缩器对象池
+func allEncoderPools() []*encoderPool {
+	pools := make([]*encoderPool, 0, len(gzipWriterPoolsArray)+len(deflateWriterPoolsArray)+1)
+	for _, p := range gzipWriterPoolsArray {
+		if p != nil {
+			pools = append(pools, p)
+		}
+	}
+	for _, p := range deflateWriterPoolsArray {
+		if p != nil {
+			pools = append(pools, p)
+		}
+	}
+	if zstdWriterPoolDefault != nil {
+		pools = append(pools, zstdWriterPoolDefault)
+	}
+	boundedPoolsMu.Lock()
+	defer boundedPoolsMu.Unlock()
+	for _, p := range pools {
+		if bp, ok := boundedPools[p]; ok {
+			pools = append(pools, bp)
+		}
+	}
+	return pools
 }
 
 // compressResponseWriter 包装了 touka.ResponseWriter 以提供多种压缩功能
 type compressResponseWriter struct {
 	touka.ResponseWriter                // 底层的 ResponseWriter
 	compressor           compressWriter // 当前使用的压缩器 (gzip, deflate, zstd)
-	options              *CompressOptions
-	chosenEncoding       string // 最终选择的编码
+	mw                   *Middleware
+	cfg                  *config // 请求开始时生效的配置, 整个响应都使用它
+	ctx                  *touka.Context
+	mediaType            string      // 要压缩的响应的媒体类型 (小写, 不含参数), 在 WriteHeader 中解析
+	chosenEncoding       string      // 最终选择的编码
+	acceptEncoding       string      // 请求原始的 Accept-Encoding, StripAcceptEncoding 删除请求头部后仍可使用
+	strippedHeader       http.Header // StripAcceptEncoding 删除了 Accept-Encoding 的请求头部, 释放时放回原值
+	strippedAccept       []string
 	wroteHeader          bool
-	doCompression        bool
 	statusCode           int
+	skip                 SkipReason     // 未压缩时的原因, 用于统计
+	bytesIn              int64          // 写入压缩器的原始字节数
+	out                  countingWriter // 压缩器的输出目标, 统计压缩后的字节数
+	level                int            // 压缩器使用的级别
+	pooled               bool           // 压缩器获取时是否启用了对象池, 决定是否归还
+	aborted              bool           // 处理器未正常返回 (panic 或 runtime.Goexit), 压缩器需丢弃
+	hijacked             bool           // 连接已被 Hijack 接管, 压缩器需丢弃
+	err                  *EncoderError  // 本次响应压缩器的第一个错误
+	headOnly             bool           // HEAD 请求: 已公布编码头部, 但没有响应体需要压缩
+	padding              paddingStyle   // 响应体末尾追加的填充方式
+	pool                 *encoderPool   // 压缩器取自的对象池 (sync 或有界池), 非 nil 时归还到此池
+	codec                *encodingPlan  // 协商选中的编码的执行计划
+	slot                 bool           // 是否占用了 MaxConcurrentCompressions 的名额
+	tee                  io.Writer      // 接收未压缩响应体的副本, 由 CompressOptions.Tee 提供
+	sampled              bool           // 本次请求的决策是否按 LogSampleRate 记录日志
+	timed                bool           // 是否统计压缩耗时
+	encodeTime           time.Duration  // 在压缩器中花费的累计时间
+	sse                  bool           // 路由启用了 SSECompatible
+	uncapped             bool           // 路由启用了 AllowLargeResponses
+	exact                *SpillOptions  // 路由启用了 ExactLength
+	spill                *spillBuffer   // ExactLength 收集压缩输出, 非 nil 时头部推迟到响应结束时写出
+	dirty                bool           // 上次 Flush 之后是否向压缩器写入过数据
+	lengthTrailer        bool           // 结束时以 trailer 发送未压缩的长度, 见 OriginalLengthTrailer
+	rsync                bool           // 在内容边界处同步刷新压缩器, 见 RsyncableTypes
+	rsyncHash            uint64         // 寻找内容边界的滚动哈希
+	rsyncLen             int            // 上一个内容边界之后写入的字节数
+	flushedIn            int64          // 上次刷新压缩器时已写入的原始字节数, 见 FlushSize
+	flushedAt            time.Time      // 上次刷新压缩器的时间, 只在设置了 FlushMaxDelay 时记录
+}
+
+// countingWriter 统计写入底层 writer 的字节数
+type countingWriter struct {
+	w        io.Writer
+	n        int64
+	max      int64         // 大于 0 时允许写出的最大字节数
+	exceeded bool          // 是否因超过 max 拒绝过写入
+	timed    bool          // 是否统计写入耗时
+	dur      time.Duration // 写往 w 的累计耗时
+
+	rc       *http.ResponseController // 非 nil 时每次写出前设置写超时, 见 CompressOptions.WriteTimeout
+	timeout  time.Duration
+	limit    time.Time // 请求 context 的截止时间, 写超时不晚于它
+	deadline bool      // 是否设置过写超时, 响应结束时需要清除
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.max > 0 && cw.n+int64(len(p)) > cw.max {
+		cw.exceeded = true
+		return 0, ErrOutputLimit
+	}
+	if cw.rc != nil {
+		cw.extendDeadline()
+	}
+	if !cw.timed {
+		n, err := cw.w.Write(p)
+		cw.n += int64(n)
+		return n, err
+	}
+	start := time.Now()
+	n, err := cw.w.Write(p)
+	cw.dur += time.Since(start)
+	cw.n += int64(n)
+	return n, err
+}
+
+// extendDeadline 把写超时推迟到 timeout 之后, 不晚于 limit
+func (cw *countingWriter) extendDeadline() {
+	d := time.Now().Add(cw.timeout)
+	if !cw.limit.IsZero() && cw.limit.Before(d) {
+		d = cw.limit
+	}
+	if cw.rc.SetWriteDeadline(d) != nil {
+		cw.rc = nil // 底层 ResponseWriter 不支持写超时
+		return
+	}
+	cw.deadline = true
+}
+
+// clearDeadline 清除设置过的写超时, 同一连接上的后续响应不受影响
+func (cw *countingWriter) clearDeadline() {
+	if cw.deadline {
+		cw.rc.SetWriteDeadline(time.Time{})
+		cw.deadline = false
+	}
 }
 
 var compressResponseWriterPool = sync.Pool{
 	New: func() interface{} { return &compressResponseWriter{} },
 }
 
-func acquireCompressResponseWriter(underlying touka.ResponseWriter, opts *CompressOptions) *compressResponseWriter {
+func acquireCompressResponseWriter(c *touka.Context, m *Middleware, cfg *config) *compressResponseWriter {
 	crw := compressResponseWriterPool.Get().(*compressResponseWriter)
-	crw.ResponseWriter = underlying
-	crw.options = opts
-	crw.chosenEncoding = ""
-	crw.wroteHeader = false
-	crw.doCompression = false
-	crw.statusCode = 0
-	crw.compressor = nil // 确保 compressor 被重置
+	sampled := m.sampled()
+	// 整体重置, 上一次请求的状态不会遗留; 不涉及任何分配
+	*crw = compressResponseWriter{
+		ResponseWriter: c.Writer,
+		mw:             m,
+		cfg:            cfg,
+		ctx:            c,
+		acceptEncoding: c.Request.Header.Get(headerAcceptEncoding),
+		out:            countingWriter{w: c.Writer, max: cfg.opts.MaxOutputBytes, timed: cfg.adaptive()},
+		sampled:        sampled,
+		timed:          cfg.opts.ServerTiming || cfg.opts.OnCompress != nil || sampled || cfg.adaptive(),
+	}
+	if t := cfg.opts.WriteTimeout; t > 0 {
+		crw.out.rc, crw.out.timeout = http.NewResponseController(c.Writer), t
+		crw.out.limit, _ = c.Request.Context().Deadline()
+	}
+	if cfg.opts.StripAcceptEncoding {
+		if v := c.Request.Header[headerAcceptEncoding]; v != nil {
+			crw.strippedHeader, crw.strippedAccept = c.Request.Header, v
+			delete(c.Request.Header, headerAcceptEncoding)
+		}
+	}
 	return crw
 }
 
 func releaseCompressResponseWriter(crw *compressResponseWriter) {
-	if crw.compressor != nil {
-		cfg, exists := crw.options.Algorithms[crw.chosenEncoding]
-		poolEnabled := exists && cfg.PoolEnabled // 如果算法配置存在且启用了池
-
-		_ = crw.compressor.Close()
-		putCompressor(crw.compressor, crw.chosenEncoding, poolEnabled)
+	if crw.compressor != nil && (crw.aborted || crw.hijacked) {
+		crw.abortCompressor()
+		if crw.spill != nil {
+			crw.spill.cleanup()
+		}
+		if !crw.hijacked {
+			crw.out.clearDeadline()
+		}
+	} else if crw.compressor != nil {
+		start := time.Now()
+		if crw.padding != padNone && crw.padding != padHeader && crw.err == nil {
+			crw.writePadding()
+		}
+		if err := crw.compressor.Close(); err != nil {
+			crw.encoderFailed(OpClose, err)
+		}
+		if ac, ok := crw.compressor.(*asyncCompressor); ok {
+			crw.compressor = ac.cw // worker 已写完, 之后按同步压缩器归还
+		}
+		crw.out.clearDeadline()
+		if crw.timed {
+			crw.encodeTime += time.Since(start)
+		}
+		if crw.cfg.opts.ServerTiming {
+			crw.writeServerTiming()
+		}
+		if crw.lengthTrailer {
+			crw.Header().Set(http.TrailerPrefix+crw.cfg.opts.OriginalLengthHeader, strconv.FormatInt(crw.bytesIn, 10))
+		}
+		if crw.cfg.opts.CompressionTrailers {
+			crw.writeCompressionTrailers()
+		}
+		if crw.spill != nil {
+			crw.spill.finish()
+		}
+		if crw.slot {
+			crw.cfg.releaseSlot()
+			crw.slot = false
+		}
+		if crw.pooled && crw.out.exceeded {
+			// 压缩器停在输出被拒绝的状态, 不再复用
+			crw.pooled = false
+			crw.pool.discard()
+		} else if crw.pooled && crw.cfg.overPoolBudget() {
+			// 超出内存预算, 丢弃压缩器而不归还
+			crw.pooled = false
+			crw.pool.discard()
+			budgetDrops.Add(1)
+		}
+		if crw.pooled {
+			crw.pool.put(crw.compressor)
+		}
+		crw.pool = nil
 		crw.compressor = nil
+		crw.ctx.Set(byteCountsKey, ByteCounts{In: crw.bytesIn, Out: crw.out.n})
+		crw.mw.stats.recordCompressed(crw.chosenEncoding, contentTypeFamily(crw.mediaType), crw.bytesIn, crw.out.n)
+		if crw.cfg.opts.OnCompress != nil {
+			crw.cfg.opts.OnCompress(CompressInfo{
+				Context:    crw.ctx,
+				Encoding:   crw.chosenEncoding,
+				Level:      crw.level,
+				StatusCode: crw.statusCode,
+				BytesIn:    crw.bytesIn,
+				BytesOut:   crw.out.n,
+				Duration:   crw.encodeTime,
+			})
+		}
+		if crw.cfg.adaptive() {
+			s := AdaptiveSignals{
+				Level:      crw.level,
+				BytesIn:    crw.bytesIn,
+				BytesOut:   crw.out.n,
+				EncodeTime: crw.encodeTime - crw.out.dur,
+				WriteTime:  crw.out.dur,
+			}
+			if policy := crw.cfg.opts.AdaptiveLevel; policy != nil {
+				policy.Observe(crw.chosenEncoding, s)
+			}
+			if policy := crw.cfg.opts.EncodingPolicy; policy != nil {
+				policy.Observe(crw.mediaType, crw.chosenEncoding, s)
+			}
+		}
+		if crw.sampled {
+			crw.mw.logSample(crw.ctx, "compressed encoding=%s level=%d status=%d in=%d out=%d ratio=%.2f dur=%s",
+				crw.chosenEncoding, crw.level, crw.statusCode, crw.bytesIn, crw.out.n,
+				ratio(uint64(crw.bytesIn), uint64(crw.out.n)), crw.encodeTime)
+		}
+	} else if crw.wroteHeader && !crw.headOnly {
+		crw.mw.stats.recordSkip(crw.skip)
+		if crw.sampled {
+			crw.mw.logSample(crw.ctx, "skipped reason=%s status=%d", crw.skip, crw.statusCode)
+		}
+		if crw.cfg.opts.OnSkip != nil {
+			crw.cfg.opts.OnSkip(crw.skip, crw.ctx)
+		}
+	}
+	if crw.strippedHeader != nil {
+		crw.strippedHeader[headerAcceptEncoding] = crw.strippedAccept
 	}
-	//crw.ResponseWriter = nil
-	crw.options = nil
+	*crw = compressResponseWriter{} // 不在池中保留对请求与中间件的引用
 	compressResponseWriterPool.Put(crw)
 }
 
+// writeCompressionTrailers 在压缩器关闭后设置 CompressionTrailers 声明的 trailer
+func (crw *compressResponseWriter) writeCompressionTrailers() {
+	h := crw.Header()
+	h.Set(headerCompressedLength, strconv.FormatInt(crw.out.n, 10))
+	if crw.out.n > 0 {
+		h.Set(headerCompressionRatio, strconv.FormatFloat(float64(crw.bytesIn)/float64(crw.out.n), 'f', 3, 64))
+	}
+}
+
+// setEncodingHeaders 设置压缩响应的头部: Content-Encoding 与 Vary, 并移除不再成立的长度与摘要
+func (crw *compressResponseWriter) setEncodingHeaders() {
+	h := crw.Header()
+	// 每个响应使用自己的切片: 处理器或下游中间件可能原地修改头部值
+	h[headerContentEncoding] = []string{crw.codec.name}
+	addVaryAcceptEncoding(h)
+	if name := crw.cfg.opts.OriginalLengthHeader; name != "" {
+		if cl := h.Get(headerContentLength); cl != "" {
+			h.Set(name, cl)
+		} else {
+			crw.lengthTrailer = crw.cfg.opts.OriginalLengthTrailer
+		}
+	}
+	delete(h, headerContentLength) // 压缩会改变内容长度
+	// 范围请求针对的是未压缩的表示, 实时压缩后不再成立
+	switch crw.cfg.opts.AcceptRanges {
+	case AcceptRangesRemove:
+		delete(h, headerAcceptRanges)
+	case AcceptRangesNone:
+		h[headerAcceptRanges] = []string{"none"}
+	}
+	// 压缩会使处理器设置的摘要失效
+	for _, k := range integrityHeaders {
+		delete(h, k)
+	}
+}
+
+// abortCompressor 在处理器异常结束 (panic 或 runtime.Goexit) 或连接被 Hijack 时丢弃压缩器。
+// 压缩器可能停在写了一半的状态, 因此既不 Close (不再向已中断的响应或被接管的连接写入尾部), 也不归还到对象池,
+// 避免污染之后的响应; 统计与回调也随之跳过。
+func (crw *compressResponseWriter) abortCompressor() {
+	if ac, ok := crw.compressor.(*asyncCompressor); ok {
+		ac.abort()
+	}
+	if crw.pooled {
+		crw.pool.discard()
+	}
+	if crw.slot {
+		crw.cfg.releaseSlot()
+		crw.slot = false
+	}
+	crw.compressor = nil
+	crw.pool = nil
+	crw.mw.stats.aborted.Add(1)
+	if crw.hijacked {
+		crw.mw.logf(crw.ctx, LogLevelDebug, "connection hijacked, discarding %s encoder", crw.chosenEncoding)
+	} else {
+		crw.mw.logf(crw.ctx, LogLevelWarn, "handler terminated abnormally, discarding %s encoder", crw.chosenEncoding)
+	}
+}
+
+// writeServerTiming 以 trailer 形式追加压缩耗时与压缩比
+func (crw *compressResponseWriter) writeServerTiming() {
+	ms := float64(crw.encodeTime) / float64(time.Millisecond)
+	value := "compress;dur=" + strconv.FormatFloat(ms, 'f', 3, 64) +
+		`;desc="` + crw.chosenEncoding + " l" + strconv.Itoa(crw.level) +
+		" ratio=" + strconv.FormatFloat(ratio(uint64(crw.bytesIn), uint64(crw.out.n)), 'f', 2, 64) + `"`
+	crw.Header().Add(http.TrailerPrefix+headerServerTiming, value)
+}
+
 // --- compressResponseWriter 方法实现 ---
 func (crw *compressResponseWriter) Header() http.Header { return crw.ResponseWriter.Header() }
 
+// Unwrap 返回被包装的 ResponseWriter, 供 http.ResponseController 查找 SetWriteDeadline 等方法
+func (crw *compressResponseWriter) Unwrap() http.ResponseWriter { return crw.ResponseWriter }
+
 func (crw *compressResponseWriter) WriteHeader(statusCode int) {
 	if crw.wroteHeader {
 		return
@@ -322,103 +1047,370 @@ func (crw *compressResponseWriter) WriteHeader(statusCode int) {
 	crw.wroteHeader = true
 	crw.statusCode = statusCode
 
-	// 如果已决定不压缩 (例如，在 negotiateEncoding 中决定) 或者一些特定状态码，则直接写入
-	if !crw.doCompression || statusCode < http.StatusOK || statusCode == http.StatusNoContent || statusCode == http.StatusResetContent || statusCode == http.StatusNotModified || statusCode == http.StatusPartialContent {
-		crw.ResponseWriter.WriteHeader(statusCode)
+	if crw.codec != nil && crw.codec.cfg.PreferAbove > 0 && crw.cfg.opts.EncodingPolicy == nil {
+		crw.preferBySize()
+	}
+	if reason := crw.skipReason(statusCode); reason != SkipNone {
+		crw.skipWith(reason, statusCode)
 		return
 	}
-	// 如果响应已被其他方式编码
-	if crw.Header().Get(headerContentEncoding) != "" {
-		crw.doCompression = false // 修正：确保标记为不压缩
+	chosenLevel, leveled := 0, false
+	if policy := crw.cfg.opts.EncodingPolicy; policy != nil {
+		chosenLevel, leveled = crw.chooseEncoding(policy)
+	}
+
+	if crw.ctx.Request.Method == http.MethodHead {
+		// HEAD 响应没有响应体: 只公布与 GET 相同的编码头部, 不创建压缩器也不占用并发名额
+		crw.headOnly = true
+		crw.setEncodingHeaders()
 		crw.ResponseWriter.WriteHeader(statusCode)
 		return
 	}
 
-	// 检查 Content-Type 是否可压缩
-	contentType := strings.ToLower(strings.TrimSpace(strings.Split(crw.Header().Get(headerContentType), ";")[0]))
-	compressibleTypes := crw.options.CompressibleTypes
-	if len(compressibleTypes) == 0 {
-		compressibleTypes = DefaultCompressibleTypes
+	if !crw.cfg.allowClient(crw.ctx) {
+		crw.skipWith(SkipRateLimited, statusCode)
+		return
+	}
+	if !crw.cfg.acquireSlot() {
+		crw.skipWith(SkipOverloaded, statusCode)
+		return
+	}
+	crw.slot = true
+
+	algoConfig, pooled := crw.codec.cfg, crw.codec.pooled
+	if algoConfig.PoolEnabled && !pooled {
+		crw.mw.warnOnce(crw.mw.warnedPool[crw.chosenEncoding], crw.ctx, "%s level %d has no encoder pool, PoolEnabled has no effect", crw.chosenEncoding, algoConfig.Level)
 	}
-	isCompressible := false
-	for _, t := range compressibleTypes {
-		if strings.HasPrefix(contentType, t) {
-			isCompressible = true
-			break
+
+	if !leveled {
+		if n, ok := crw.declaredLength(); ok {
+			chosenLevel, leveled = algoConfig.tierLevel(n)
+		} else if algoConfig.StreamingLevel != 0 {
+			chosenLevel, leveled = algoConfig.StreamingLevel, true
 		}
 	}
-	if !isCompressible {
-		crw.doCompression = false // 标记为不压缩
-		crw.ResponseWriter.WriteHeader(statusCode)
+	if leveled && chosenLevel != algoConfig.Level {
+		algoConfig.Level = chosenLevel
+		pooled = algoConfig.pooled(crw.chosenEncoding)
+	}
+	if policy := crw.cfg.opts.AdaptiveLevel; policy != nil {
+		if level := policy.Level(crw.chosenEncoding, algoConfig.Level); level != algoConfig.Level {
+			algoConfig.Level = level
+			pooled = algoConfig.pooled(crw.chosenEncoding)
+		}
+	}
+	if pooled && !crw.mw.Pooling() {
+		pooled = false
+	}
+
+	crw.level = algoConfig.Level
+	var gzipHeader *gzip.Header
+	if crw.cfg.opts.GzipHeader != nil && crw.chosenEncoding == EncodingGzip {
+		gzipHeader = &gzip.Header{OS: 255}
+		crw.cfg.opts.GzipHeader(crw.ctx, gzipHeader)
+	}
+	// 压缩器总在写出头部之前于本 goroutine 取得, 异步压缩也一样, 失败时仍能以 identity 发送
+	pooled = crw.acquireCompressor(algoConfig, pooled)
+	setGzipHeader(crw.compressor, gzipHeader)
+	if crw.compressor == nil { // 获取压缩器失败
+		crw.encoderFailed(OpInit, fmt.Errorf("no encoder available for level %d: %w", algoConfig.Level, errEncoderUnavailable))
+		crw.skipWith(SkipEncoderUnavailable, statusCode)
 		return
 	}
+	if crw.mw.idleWorkers != nil {
+		if ac := crw.mw.acquireAsyncCompressor(crw.compressor, crw.cfg); ac != nil {
+			crw.compressor = ac
+		}
+	}
 
-	// 检查最小内容长度
-	if crw.options.MinContentLength > 0 {
-		if clStr := crw.Header().Get(headerContentLength); clStr != "" {
-			if cl, err := strconv.ParseInt(clStr, 10, 64); err == nil && cl < crw.options.MinContentLength {
-				crw.doCompression = false // 标记为不压缩
-				crw.ResponseWriter.WriteHeader(statusCode)
-				return
+	// 所有检查通过，确认进行压缩
+	crw.setEncodingHeaders()
+	if crw.cfg.opts.CompressionTrailers {
+		crw.Header().Add("Trailer", headerCompressedLength+", "+headerCompressionRatio)
+	}
+	if crw.cfg.opts.DebugHeader {
+		crw.Header().Set(headerCompressionInfo, "encoding="+crw.chosenEncoding+"; level="+strconv.Itoa(algoConfig.Level)+"; pooled="+strconv.FormatBool(pooled))
+	}
+	if crw.cfg.opts.Tee != nil {
+		crw.tee = crw.cfg.opts.Tee(crw.ctx)
+	}
+	crw.rsync = crw.chosenEncoding != EncodingZstd && crw.cfg.plan.rsyncable(crw.mediaType)
+	// 压缩后的内容是另一种表示, 处理器设置的强 ETag (如 http.ServeContent 按原文件生成的) 改为弱 ETag,
+	// 避免与同一资源未压缩的 206 响应共用强 ETag, 使 If-Range 续传拼接出错误的内容
+	weakenETag(crw.Header())
+	if crw.padding = paddingStyleFor(crw.cfg.opts.Padding, crw.mediaType); crw.padding == padHeader {
+		crw.Header().Set(headerPadding, string(appendPadding(nil, padHeader, crw.cfg.paddingMax())))
+	}
+	if crw.exact != nil {
+		// 收集压缩输出, 结束时带着 Content-Length 写出头部, 见 ExactLength
+		crw.spill = newSpillBuffer(crw)
+		crw.out.w = crw.spill
+		return
+	}
+
+	crw.ResponseWriter.WriteHeader(statusCode) // 写入实际的状态码
+}
+
+// chooseEncoding 让 policy 在客户端接受的编码中重新选择编码; 返回选择的级别及其是否有效。
+// 声明了长度时, 未达到自身最小压缩长度的编码不作为候选。
+func (crw *compressResponseWriter) chooseEncoding(policy EncodingPolicy) (int, bool) {
+	accept := crw.acceptEncoding
+	accepted := acceptedCodings(accept)
+	n, sized := crw.declaredLength()
+	var candidates []EncodingChoice
+	for i := range crw.cfg.plan.encodings {
+		ep := &crw.cfg.plan.encodings[i]
+		if ep != crw.codec && sized && !ep.admits(n) {
+			continue
+		}
+		if ep == crw.codec || ep.accepted(accept, accepted) {
+			candidates = append(candidates, EncodingChoice{Encoding: ep.name, Level: ep.cfg.Level})
+		}
+	}
+	choice := policy.Choose(crw.mediaType, candidates)
+	if !hasEncoding(candidates, choice.Encoding) {
+		return 0, false
+	}
+	crw.codec = crw.cfg.plan.lookup(choice.Encoding)
+	crw.chosenEncoding = choice.Encoding
+	return choice.Level, validLevel(choice.Encoding, choice.Level)
+}
+
+// acquireCompressor 按配置获取压缩器并设置 crw.compressor, 返回压缩器是否来自对象池
+func (crw *compressResponseWriter) acquireCompressor(algoConfig AlgorithmConfig, pooled bool) bool {
+	bp := crw.codec.bounded
+	if bp != nil && bp.level != algoConfig.Level {
+		bp = nil // 有界池只对应配置的级别
+	}
+	var idle compressWriter
+	if pooled && crw.cfg.overPoolBudget() {
+		// 超出内存预算时只复用有界池中已有的空闲压缩器, 否则创建用后即弃的压缩器
+		if cw, ok := bp.tryGet(); ok {
+			idle = cw
+		} else {
+			pooled = false
+			budgetBypasses.Add(1)
+		}
+	}
+	if pooled && bp != nil {
+		if idle == nil {
+			idle = bp.get()
+		}
+		crw.compressor, crw.pool = idle, bp
+		crw.compressor.Reset(&crw.out)
+	} else if crw.chosenEncoding == EncodingZstd && algoConfig.zstdCustom() {
+		crw.compressor = newZstdCompressor(algoConfig.Level, algoConfig, &crw.out)
+	} else {
+		crw.compressor, crw.pool = getCompressor(crw.chosenEncoding, algoConfig.Level, &crw.out, pooled)
+	}
+	crw.pooled = crw.pool != nil
+	return crw.pooled
+}
+
+// skipReason 依次检查响应是否应跳过压缩, 返回第一个命中的原因; 应当压缩时返回 SkipNone
+func (crw *compressResponseWriter) skipReason(statusCode int) SkipReason {
+	// chosenEncoding 应该已经被设置, 这里处理 identity 的情况
+	if crw.chosenEncoding == "" || crw.chosenEncoding == EncodingIdentity {
+		return SkipNotAccepted
+	}
+	if crw.mw.closed.Load() {
+		return SkipClosed
+	}
+	if crw.cfg.routeDisabled {
+		return SkipRoute
+	}
+	// 1xx 以及不携带 (或不应压缩) 响应体的状态码
+	if statusCode < http.StatusOK || statusCode == http.StatusNoContent || statusCode == http.StatusResetContent || statusCode == http.StatusNotModified || statusCode == http.StatusPartialContent {
+		return SkipStatusCode
+	}
+	if statusCode >= http.StatusBadRequest && crw.cfg.opts.Errors == SkipErrors {
+		return SkipStatusCode
+	}
+	// 如果响应已被其他方式编码
+	if preEncoded(crw.Header()) {
+		return SkipPreEncoded
+	}
+	if crw.cfg.opts.SkipOnVaryWildcard && headerHasToken(crw.Header(), headerVary, "*") {
+		return SkipVaryWildcard
+	}
+	contentType := mediaTypeOf(crw.Header().Get(headerContentType))
+	if strings.EqualFold(contentType, mediaTypeEventStream) {
+		// 事件流只在路由启用了 SSECompatible 时压缩, 不受可压缩类型与最小长度限制
+		if !crw.sse {
+			return SkipEventStream
+		}
+	} else {
+		// 处理器要求不得转换响应体
+		if headerHasToken(crw.Header(), headerCacheControl, "no-transform") {
+			return SkipNoTransform
+		}
+
+		// 检查 Content-Type 是否可压缩
+		if !crw.cfg.plan.compressible(contentType) {
+			return SkipContentType
+		}
+
+		// 检查最小与最大内容长度
+		if cl, ok := crw.declaredLength(); ok {
+			if minLength := crw.codec.minLength; minLength > 0 && cl < minLength {
+				return SkipTooSmall
+			}
+			if maxLength := crw.cfg.opts.MaxContentLength; maxLength > 0 && !crw.uncapped && cl > maxLength {
+				return SkipTooLarge
 			}
 		}
 	}
+	// 只有要压缩的响应才需要小写形式; 已是小写 (常见情况) 时 ToLower 不分配
+	crw.mediaType = strings.ToLower(contentType)
 
-	// 如果到这里，doCompression 仍然为 true，并且 chosenEncoding 应该已经被设置
-	if !crw.doCompression || crw.chosenEncoding == "" || crw.chosenEncoding == EncodingIdentity {
-		crw.doCompression = false // 双重检查或处理 identity 的情况
-		crw.ResponseWriter.WriteHeader(statusCode)
-		return
+	if sensitive := crw.cfg.opts.SensitiveResponse; sensitive != nil && sensitive(crw.ctx, crw.Header()) {
+		return SkipSensitive
 	}
+	return SkipNone
+}
 
-	// 所有检查通过，确认进行压缩
-	crw.Header().Set(headerContentEncoding, crw.chosenEncoding)
-	crw.Header().Add(headerVary, headerAcceptEncoding)
-	crw.Header().Del(headerContentLength) // 压缩会改变内容长度
-
-	algoConfig, ok := crw.options.Algorithms[crw.chosenEncoding]
-	if !ok { // 如果 chosenEncoding 不在配置中，使用默认级别
-		switch crw.chosenEncoding {
-		case EncodingGzip:
-			algoConfig = AlgorithmConfig{Level: gzip.DefaultCompression, PoolEnabled: true}
-		case EncodingDeflate:
-			algoConfig = AlgorithmConfig{Level: flate.DefaultCompression, PoolEnabled: true}
-		case EncodingZstd:
-			algoConfig = AlgorithmConfig{Level: int(zstd.SpeedDefault), PoolEnabled: true} // zstd.SpeedDefault是3
-		default: // 不应该发生
-			crw.doCompression = false
-			crw.ResponseWriter.WriteHeader(statusCode)
+// preferBySize 在声明的 Content-Length 不超过所选编码的 PreferAbove 时, 改用客户端接受且达到其最小压缩长度的下一个编码;
+// 没有更合适的编码时保持原来的选择
+func (crw *compressResponseWriter) preferBySize() {
+	n, ok := crw.declaredLength()
+	if !ok || n > crw.codec.cfg.PreferAbove {
+		return
+	}
+	accept := crw.acceptEncoding
+	accepted := acceptedCodings(accept)
+	for i := range crw.cfg.plan.encodings {
+		ep := &crw.cfg.plan.encodings[i]
+		if ep != crw.codec && (ep.cfg.PreferAbove == 0 || n > ep.cfg.PreferAbove) && ep.admits(n) && ep.accepted(accept, accepted) {
+			crw.codec, crw.chosenEncoding = ep, ep.name
 			return
 		}
 	}
+}
 
-	crw.compressor = getCompressor(crw.chosenEncoding, algoConfig.Level, crw.ResponseWriter, algoConfig.PoolEnabled)
-	if crw.compressor == nil { // 获取压缩器失败
-		crw.doCompression = false
-		crw.Header().Del(headerContentEncoding) // 移除之前设置的编码头
-		crw.Header().Del(headerVary)            // 也移除 Vary
-		crw.ResponseWriter.WriteHeader(statusCode)
-		return
+// declaredLength 返回处理器设置的 Content-Length
+func (crw *compressResponseWriter) declaredLength() (int64, bool) {
+	clStr := crw.Header().Get(headerContentLength)
+	if clStr == "" {
+		return 0, false
 	}
+	cl, err := strconv.ParseInt(clStr, 10, 64)
+	return cl, err == nil
+}
 
-	crw.ResponseWriter.WriteHeader(statusCode) // 写入实际的状态码
+// skipWith 以 reason 放弃压缩, 并原样写入状态码
+func (crw *compressResponseWriter) skipWith(reason SkipReason, statusCode int) {
+	if crw.slot {
+		crw.cfg.releaseSlot()
+		crw.slot = false
+	}
+	crw.skip = reason
+	if crw.mw.logs(LogLevelDebug) {
+		crw.mw.logf(crw.ctx, LogLevelDebug, "skipped compression: %s", reason)
+	}
+	if crw.cfg.opts.DebugHeader {
+		crw.Header().Set(headerCompressionInfo, "skipped="+reason.String())
+	}
+	crw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// headerHasToken 报告头部 key 的逗号分隔值中是否包含 token (不区分大小写)
+func headerHasToken(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for v != "" {
+			var part string
+			part, v, _ = strings.Cut(v, ",")
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// preEncoded 报告处理器设置的 Content-Encoding (可能分多行、逗号分隔) 是否包含 identity 之外的编码。
+// 只有 identity 或空值时视为未编码, 并移除该头部。
+func preEncoded(h http.Header) bool {
+	values, ok := h[headerContentEncoding]
+	if !ok {
+		return false
+	}
+	for _, v := range values {
+		for v != "" {
+			var part string
+			part, v, _ = strings.Cut(v, ",")
+			if part = strings.TrimSpace(part); part != "" && !strings.EqualFold(part, EncodingIdentity) {
+				return true
+			}
+		}
+	}
+	delete(h, headerContentEncoding)
+	return false
 }
 
 func (crw *compressResponseWriter) Write(data []byte) (int, error) {
+	if crw.hijacked {
+		return 0, http.ErrHijacked
+	}
 	if !crw.wroteHeader {
 		crw.WriteHeader(http.StatusOK) // 隐式写入200 OK
 	}
-	if crw.doCompression && crw.compressor != nil {
-		return crw.compressor.Write(data)
+	if crw.compressor != nil {
+		var n int
+		var err error
+		if crw.rsync {
+			n, err = crw.writeRsyncable(data)
+		} else {
+			var start time.Time
+			if crw.timed {
+				start = time.Now()
+			}
+			n, err = crw.compressor.Write(data)
+			if crw.timed {
+				crw.encodeTime += time.Since(start)
+			}
+		}
+		crw.bytesIn += int64(n)
+		crw.dirty = crw.dirty || n > 0
+		if err != nil {
+			crw.encoderFailed(OpWrite, err)
+		}
+		if crw.tee != nil && n > 0 {
+			if _, teeErr := crw.tee.Write(data[:n]); teeErr != nil {
+				crw.mw.logf(crw.ctx, LogLevelError, "writing tee: %v", teeErr)
+				crw.tee = nil
+			}
+		}
+		if crw.sse && err == nil && endsEvent(data) {
+			crw.Flush() // 每个事件写完即送达客户端
+		}
+		return n, err
+	}
+	n, err := crw.ResponseWriter.Write(data)
+	crw.bytesIn += int64(n)
+	return n, err
+}
+
+// ReadFrom 使处理器中的 io.Copy(c.Writer, body) 经池化的缓冲区写入, 不再为每次复制分配 32 KiB 的缓冲区。
+// 已决定不压缩的响应交给底层 ResponseWriter 的 ReadFrom (如有), 以便使用 sendfile 等优化。
+func (crw *compressResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	if crw.wroteHeader && crw.compressor == nil && !crw.hijacked {
+		if rf, ok := crw.ResponseWriter.(io.ReaderFrom); ok {
+			n, err := rf.ReadFrom(r)
+			crw.bytesIn += n
+			return n, err
+		}
 	}
-	return crw.ResponseWriter.Write(data)
+	buf := getBuffer()
+	defer putBuffer(buf)
+	// 隐藏 ReadFrom, 以免 io.CopyBuffer 再次调用自身
+	return io.CopyBuffer(struct{ io.Writer }{crw}, r, *buf)
 }
 
 func (crw *compressResponseWriter) Close() error { // 主要供 defer 调用
 	if crw.compressor != nil {
 		// Close 应该由 releaseCompressResponseWriter 处理，这里仅作为防御
 		// err := crw.compressor.Close()
-		// putCompressor(crw.compressor, crw.chosenEncoding, crw.options.Algorithms[crw.chosenEncoding].PoolEnabled)
+		// crw.pool.put(crw.compressor)
 		// crw.compressor = nil
 		// return err
 	}
@@ -426,17 +1418,63 @@ func (crw *compressResponseWriter) Close() error { // 主要供 defer 调用
 }
 
 func (crw *compressResponseWriter) Flush() {
-	if crw.doCompression && crw.compressor != nil {
-		_ = crw.compressor.Flush() // 忽略刷新错误，或记录
+	if crw.hijacked {
+		return // 连接已被接管
+	}
+	if crw.spill != nil && !crw.spill.streaming.Load() {
+		return // ExactLength 在响应结束时才写出
+	}
+	if crw.compressor != nil && crw.dirty {
+		if crw.deferFlush() {
+			return
+		}
+		// 没有新数据时不刷新压缩器, 以免连续 Flush 产生空的同步块
+		crw.dirty = false
+		var start time.Time
+		if crw.timed {
+			start = time.Now()
+		}
+		if err := crw.compressor.Flush(); err != nil {
+			crw.encoderFailed(OpFlush, err)
+		}
+		if crw.timed {
+			crw.encodeTime += time.Since(start)
+		}
+		crw.flushedIn = crw.bytesIn
+		if crw.cfg.opts.FlushMaxDelay > 0 {
+			crw.flushedAt = time.Now()
+		}
 	}
 	if fl, ok := crw.ResponseWriter.(http.Flusher); ok {
 		fl.Flush()
 	}
 }
 
+// deferFlush 报告是否按 FlushSize 推迟本次 Flush: 以上次刷新前的压缩率估算待刷新数据压缩后的大小。
+// 异步压缩时 worker 仍在写 crw.out, 处理器无法读取已写出的字节数, 因此不推迟, 每次 Flush 都交给 worker。
+func (crw *compressResponseWriter) deferFlush() bool {
+	size := crw.cfg.opts.FlushSize
+	if size <= 0 || crw.flushedIn == 0 {
+		return false
+	}
+	if _, async := crw.compressor.(*asyncCompressor); async {
+		return false
+	}
+	if d := crw.cfg.opts.FlushMaxDelay; d > 0 && time.Since(crw.flushedAt) >= d {
+		return false
+	}
+	// 以浮点数计算, 长时间的流式响应中字节数相乘可能溢出 int64
+	return float64(crw.bytesIn-crw.flushedIn)*float64(crw.out.n)/float64(crw.flushedIn) < float64(size)
+}
+
+// Hijack 接管底层连接。之后压缩器不再向连接写入任何数据 (包括结束时的尾部), 并在请求结束时丢弃
 func (crw *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if hj, ok := crw.ResponseWriter.(http.Hijacker); ok {
-		return hj.Hijack()
+		conn, rw, err := hj.Hijack()
+		if err == nil {
+			crw.hijacked = true
+		}
+		return conn, rw, err
 	}
 	return nil, nil, errors.New("touka.compressResponseWriter: underlying ResponseWriter does not implement http.Hijacker") // 英文错误
 }
@@ -446,16 +1484,124 @@ func (crw *compressResponseWriter) Status() int {
 	}
 	return crw.ResponseWriter.Status()
 }
-func (crw *compressResponseWriter) Size() int     { return crw.ResponseWriter.Size() }
-func (crw *compressResponseWriter) Written() bool { return crw.ResponseWriter.Written() }
+
+// Size 返回底层 writer 统计的字节数; 压缩时即已写出的压缩后字节数, 与 BytesOut 相同。
+// 异步压缩时与 worker 的写出同步, 可以在处理器中安全调用。
+func (crw *compressResponseWriter) Size() int {
+	defer crw.syncAsync()()
+	return crw.ResponseWriter.Size()
+}
+
+// Written 报告是否已写出状态码, 异步压缩时与 worker 同步; ExactLength 推迟写出时也视为已写出
+func (crw *compressResponseWriter) Written() bool {
+	if crw.spill != nil {
+		return true
+	}
+	defer crw.syncAsync()()
+	return crw.ResponseWriter.Written()
+}
+
+// BytesIn 返回处理器写入的未压缩字节数
+func (crw *compressResponseWriter) BytesIn() int64 { return crw.bytesIn }
+
+// BytesOut 返回已写往客户端的字节数。压缩时不含仍在压缩器缓冲中的数据 (异步压缩时也不含仍在队列中的),
+// 请求结束后才是最终值。
+func (crw *compressResponseWriter) BytesOut() int64 {
+	if crw.compressor != nil {
+		defer crw.syncAsync()()
+		return crw.out.n
+	}
+	return crw.bytesIn
+}
+
+// byteCountsKey 是请求结束后保存 ByteCounts 的上下文键
+const byteCountsKey = "compress.byteCounts"
+
+// ByteCounts 是一次响应压缩前后的字节数
+type ByteCounts struct {
+	In  int64 // 处理器写入的未压缩字节数
+	Out int64 // 实际写往客户端的字节数; 未压缩时与 In 相同
+}
+
+// BytesFromContext 返回当前请求经压缩中间件写出的字节数, 供访问日志等中间件同时记录原始与传输大小。
+// 在压缩中间件之内调用时返回截至目前的值 (压缩器缓冲中的数据尚未计入 Out),
+// 在其外层 (c.Next 返回之后) 调用时返回最终值。
+// 响应未被压缩时 In 与 Out 均为 c.Writer.Size(); 尚未写出响应时 ok 为 false。
+func BytesFromContext(c *touka.Context) (counts ByteCounts, ok bool) {
+	if crw, isCRW := c.Writer.(*compressResponseWriter); isCRW {
+		return ByteCounts{In: crw.BytesIn(), Out: crw.BytesOut()}, true
+	}
+	if v, exists := c.Get(byteCountsKey); exists {
+		if counts, ok = v.(ByteCounts); ok {
+			return counts, true
+		}
+	}
+	// 只有压缩的响应才会保存计数, 其余情况下传输的就是未压缩的数据
+	if !c.Writer.Written() {
+		return ByteCounts{}, false
+	}
+	size := int64(c.Writer.Size())
+	return ByteCounts{In: size, Out: size}, true
+}
 
 // --- 压缩中间件 ---
 
-// Compression 返回一个通用的压缩中间件，支持 Gzip, Deflate, Zstd。
-// 它会根据客户端的 Accept-Encoding 头部和服务器配置选择最佳的压缩算法。
-func Compression(opts CompressOptions) touka.HandlerFunc {
+// Middleware 是一个压缩中间件实例, 持有生效的配置与运行时统计。
+// 需要观测或管理中间件时使用 New 创建实例, 否则直接使用 Compression 即可。
+type Middleware struct {
+	cfg   atomic.Pointer[config] // 生效的配置, UpdateOptions 整体替换
+	stats *Stats
+
+	warnedPool map[string]*atomic.Bool // 每种编码的池配置警告只记录一次, 创建后只读
+
+	idleWorkers chan *asyncWorker // 空闲的异步压缩 worker, 未启用 AsyncWorkers 时为 nil
+
+	poolingOff     atomic.Bool // SetPooling(false) 在运行时关闭对象池
+	disabled       atomic.Bool // SetEnabled(false) 使中间件直接放行
+	closed         atomic.Bool // Close 已被调用, 不再开始新的压缩
+	invalid        error       // Validate 的结果, 非 StrictValidation 时在首个请求记录
+	warnedInvalid  atomic.Bool
+	warnedNested   atomic.Bool
+	closeMu        sync.Mutex // 串行化 Close 与 UpdateOptions
+	stoppedWorkers int        // 已由 Close 停止的 worker 数, 由 closeMu 保护
+
+	wrapOnce sync.Once
+	wrapEng  *touka.Engine // Wrap 使用的内部引擎, 见 wrapEngine
+}
+
+// New 根据配置创建压缩中间件实例, 并补全未设置的默认值
+func New(opts CompressOptions) *Middleware {
+	invalid := opts.Validate()
+	if invalid != nil && opts.StrictValidation {
+		panic(invalid)
+	}
+	opts = withDefaults(opts)
+	if opts.AsyncWorkers == Auto {
+		opts.AsyncWorkers = defaultAsyncWorkers()
+	}
+
+	m := &Middleware{invalid: invalid, stats: newStats(), warnedPool: make(map[string]*atomic.Bool, len(codecTable))}
+	for _, c := range codecTable {
+		m.warnedPool[c.Encoding] = &atomic.Bool{}
+	}
+	m.cfg.Store(newConfig(opts, nil))
+	if opts.AsyncWorkers > 0 {
+		m.startAsyncWorkers(opts.AsyncWorkers, opts.AsyncQueue)
+	}
+	if opts.ExpvarName != "" {
+		publishExpvar(opts.ExpvarName, m.stats)
+	}
+	return m
+}
+
+// withDefaults 补全未设置的算法、可压缩类型与编码优先级
+func withDefaults(opts CompressOptions) CompressOptions {
 	if opts.Algorithms == nil && len(opts.CompressibleTypes) == 0 && len(opts.EncodingPriority) == 0 && opts.MinContentLength == 0 {
-		opts = DefaultCompressionConfig()
+		// 只填充压缩策略相关的字段, 保留其他选项 (如 ExpvarName)
+		def := DefaultCompressionConfig()
+		opts.Algorithms = def.Algorithms
+		opts.CompressibleTypes = def.CompressibleTypes
+		opts.EncodingPriority = def.EncodingPriority
 	}
 
 	// 设置默认算法配置 (如果用户没有提供)
@@ -469,7 +1615,7 @@ func Compression(opts CompressOptions) touka.HandlerFunc {
 		opts.Algorithms[EncodingDeflate] = AlgorithmConfig{Level: flate.DefaultCompression, PoolEnabled: true}
 	}
 	// Zstd 默认不启用，除非用户在 opts.Algorithms 中明确配置
-	// 例如：opts.Algorithms[EncodingZstd] = AlgorithmConfig{Level: int(zstd.SpeedDefault), PoolEnabled: true}
+	// 例如：opts.Algorithms[EncodingZstd] = AlgorithmConfig{Level: 3, PoolEnabled: true}
 
 	// 设置默认编码优先级
 	if len(opts.EncodingPriority) == 0 {
@@ -485,44 +1631,110 @@ func Compression(opts CompressOptions) touka.HandlerFunc {
 		}
 		opts.EncodingPriority = defaultPrio
 	}
+	return opts
+}
+
+// Stats 返回该实例的运行时统计
+func (m *Middleware) Stats() *Stats { return m.stats }
 
+// Handler 返回可注册到 touka 的压缩处理函数
+func (m *Middleware) Handler() touka.HandlerFunc {
 	return func(c *touka.Context) {
-		// 1. 解析 Accept-Encoding 头部
-		clientAcceptedEncodings := parseAcceptEncoding(c.Request.Header.Get(headerAcceptEncoding))
+		if m.disabled.Load() {
+			c.Next()
+			return
+		}
+		if m.invalid != nil {
+			m.warnOnce(&m.warnedInvalid, c, "invalid options: %v", m.invalid)
+		}
+		if _, nested := c.Writer.(*compressResponseWriter); nested {
+			// 外层已有压缩中间件 (例如同时注册在引擎与路由组上), 由外层决定是否压缩, 避免重复压缩或争夺头部
+			m.warnOnce(&m.warnedNested, c, "compression middleware applied more than once, inner instance passes through")
+			c.Next()
+			return
+		}
 
-		// 2. 协商选择编码
-		chosenEncoding := negotiateEncoding(clientAcceptedEncodings, opts.Algorithms, opts.EncodingPriority)
+		cfg := m.config()
+		if !cfg.allowMethod(c.Request.Method) {
+			m.stats.recordSkip(SkipMethod)
+			c.Next()
+			if cfg.opts.OnSkip != nil {
+				cfg.opts.OnSkip(SkipMethod, c)
+			}
+			return
+		}
+
+		// 1. 根据 Accept-Encoding 头部协商选择编码
+		codec, chosenEncoding := cfg.plan.negotiate(c.Request.Header.Get(headerAcceptEncoding))
 
 		// 如果选择不压缩 (identity) 或者没有可用的压缩算法，则直接进入下一个处理器
-		if chosenEncoding == "" || chosenEncoding == EncodingIdentity {
+		if codec == nil {
+			if chosenEncoding == "" && cfg.opts.StrictNegotiation && identityRejected(c.Request.Header.Get(headerAcceptEncoding)) {
+				m.stats.recordSkip(SkipNotAccepted)
+				m.notAcceptable(c, cfg)
+				return
+			}
+			if chosenEncoding == "" && m.logs(LogLevelWarn) {
+				// 客户端列出了编码, 却既不接受任何已配置的编码也不接受 identity
+				m.logf(c, LogLevelWarn, "no acceptable encoding for Accept-Encoding %q, serving identity", c.Request.Header.Get(headerAcceptEncoding))
+			}
+			m.stats.recordSkip(SkipNotAccepted)
+			if m.sampled() {
+				m.logSample(c, "skipped reason=%s", SkipNotAccepted)
+			}
 			c.Next()
+			if cfg.opts.OnSkip != nil {
+				cfg.opts.OnSkip(SkipNotAccepted, c)
+			}
 			return
 		}
 
-		// 3. 包装 ResponseWriter
+		// 2. 包装 ResponseWriter
 		originalWriter := c.Writer
-		crw := acquireCompressResponseWriter(originalWriter, &opts)
-		crw.chosenEncoding = chosenEncoding // 告诉 writer 我们选择了什么编码
-		crw.doCompression = true            // 初步标记为需要压缩，WriteHeader 会做最终检查
+		crw := acquireCompressResponseWriter(c, m, cfg)
+		crw.chosenEncoding = chosenEncoding // 告诉 writer 我们选择了什么编码，WriteHeader 会做最终检查
+		crw.codec = codec
+		crw.applyHeaderProfile()
 
 		c.Writer = crw // 替换上下文的 writer
 
+		completed := false
 		defer func() {
 			// 关闭压缩器（如果已创建）并将其返回到池中，然后恢复原始 writer
-			// releaseCompressResponseWriter 会处理 crw.compressor.Close()
+			// releaseCompressResponseWriter 会处理 crw.compressor.Close() 并记录统计;
+			// 处理器 panic 时 completed 仍为 false, 压缩器会被丢弃而不是归还
+			crw.aborted = !completed
 			releaseCompressResponseWriter(crw)
 			c.Writer = originalWriter
 		}()
 
-		// 4. 调用链中的下一个处理函数
+		// 3. 调用链中的下一个处理函数
 		c.Next()
+		completed = true
 
-		// c.Next() 返回后，如果 crw.doCompression 仍然为 true，
-		// 且 crw.compressor 已创建，则响应已被写入压缩器。
+		// c.Next() 返回后，如果 crw.compressor 已创建，则响应已被写入压缩器。
 		// defer 中的 releaseCompressResponseWriter 会负责关闭压缩器并刷新剩余数据。
 	}
 }
 
+// notAcceptable 以 406 拒绝协商失败的请求, 响应体列出可用的编码
+func (m *Middleware) notAcceptable(c *touka.Context, cfg *config) {
+	c.Abort()
+	addVaryAcceptEncoding(c.Writer.Header())
+	if cfg.opts.NotAcceptable != nil {
+		cfg.opts.NotAcceptable(c, cfg.plan.names)
+		return
+	}
+	c.String(http.StatusNotAcceptable, "Not Acceptable: supported content codings are %s\n", strings.Join(cfg.plan.names, ", "))
+}
+
+// Compression 返回一个通用的压缩中间件，支持 Gzip, Deflate, Zstd。
+// 它会根据客户端的 Accept-Encoding 头部和服务器配置选择最佳的压缩算法。
+// 等价于 New(opts).Handler()。
+func Compression(opts CompressOptions) touka.HandlerFunc {
+	return New(opts).Handler()
+}
+
 // DefaultCompressionConfig 获取默认配置
 func DefaultCompressionConfig() CompressOptions {
 	return CompressOptions{
@@ -542,8 +1754,48 @@ type qValue struct {
 	q     float64
 }
 
-// parseAcceptEncoding 解析 Accept-Encoding 头部字符串
+// Accept-Encoding 的解析上限。超出上限的部分按固定规则截断后再解析, 恶意构造的头部不会导致
+// 与其长度成正比的内存分配, 截断的结果也只取决于头部本身。
+const (
+	// MaxAcceptEncodingLength 是解析的最大字节数; 更长的头部在此处截断, 并丢弃被截断的最后一个条目
+	MaxAcceptEncodingLength = 1024
+	// MaxAcceptEncodingCodings 是解析的最大条目数 (包括空条目), 之后的条目被忽略
+	MaxAcceptEncodingCodings = 32
+	// MaxAcceptEncodingParams 是单个条目的最大参数数, 从第一个超出的条目起 (含该条目) 之后的内容被忽略
+	MaxAcceptEncodingParams = 8
+)
+
+// limitAcceptEncoding 按解析上限截断 header, 返回其前缀, 不分配内存
+func limitAcceptEncoding(header string) string {
+	if len(header) > MaxAcceptEncodingLength {
+		cut := header[:MaxAcceptEncodingLength]
+		if header[MaxAcceptEncodingLength] != ',' {
+			// 丢弃被截断的最后一个条目
+			i := strings.LastIndexByte(cut, ',')
+			cut = cut[:max(i, 0)]
+		}
+		header = cut
+	}
+	codings, params, start := 1, 0, 0
+	for i := 0; i < len(header); i++ {
+		switch header[i] {
+		case ',':
+			if codings == MaxAcceptEncodingCodings {
+				return header[:i]
+			}
+			codings, params, start = codings+1, 0, i+1
+		case ';':
+			if params++; params > MaxAcceptEncodingParams {
+				return header[:start]
+			}
+		}
+	}
+	return header
+}
+
+// parseAcceptEncoding 解析 Accept-Encoding 头部字符串, 超出解析上限的部分按 limitAcceptEncoding 截断
 func parseAcceptEncoding(header string) []qValue {
+	header = limitAcceptEncoding(header)
 	if header == "" {
 		return nil
 	}
@@ -671,3 +1923,129 @@ func negotiateEncoding(clientPrefs []qValue, serverAlgos map[string]AlgorithmCon
 
 	return "" // 没有可接受的编码，或只接受 q=0 的编码 (不应发生)
 }
+
+// negotiateHeader 直接扫描 Accept-Encoding 头部, 从按优先级排列且均已配置的 names 中选择编码。
+// 结果与 negotiateEncoding(parseAcceptEncoding(header), ...) 一致, 但不分配内存。
+func negotiateHeader(header string, names []string) string {
+	if !acceptsCoding(header, "") {
+		return EncodingIdentity // 未指定或全部 q=0
+	}
+	for _, name := range names {
+		if acceptsCoding(header, name) {
+			return name
+		}
+	}
+	if acceptsCoding(header, "*") && len(names) > 0 {
+		return names[0]
+	}
+	if acceptsCoding(header, EncodingIdentity) {
+		return EncodingIdentity
+	}
+	return ""
+}
+
+// acceptsCoding 报告 header 中是否有 q>0 的 coding 条目; coding 为空时报告是否有任何 q>0 的条目。
+// 解析规则与 parseAcceptEncoding 相同。
+func acceptsCoding(header, coding string) bool {
+	header = limitAcceptEncoding(header)
+	for header != "" {
+		var part string
+		part, header, _ = strings.Cut(header, ",")
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		val, params, _ := strings.Cut(part, ";")
+		val = strings.TrimSpace(val)
+		if coding != "" && val != coding {
+			continue
+		}
+		if qOf(params) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// identityRejected 报告 Accept-Encoding 是否明确拒绝 identity (RFC 9110 12.5.3):
+// 即 identity;q=0, 或未列出 identity 而 *;q=0。未列出的 identity 默认可接受。
+func identityRejected(header string) bool {
+	header = limitAcceptEncoding(header)
+	rejected := false
+	for header != "" {
+		var part string
+		part, header, _ = strings.Cut(header, ",")
+		val, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		switch strings.TrimSpace(val) {
+		case EncodingIdentity:
+			return qOf(params) <= 0
+		case "*":
+			rejected = qOf(params) <= 0
+		}
+	}
+	return rejected
+}
+
+// qOf 返回参数串 (如 "q=0.5;foo=bar") 中的 q 值, 规则与 parseAcceptEncoding 相同
+func qOf(params string) float64 {
+	for params != "" {
+		var p string
+		p, params, _ = strings.Cut(params, ";")
+		p = strings.TrimSpace(p)
+		if !strings.HasPrefix(p, "q=") {
+			continue
+		}
+		q, err := strconv.ParseFloat(p[2:], 64)
+		switch {
+		case err != nil || q < 0:
+			return 0
+		case q > 1:
+			return 1
+		}
+		return q
+	}
+	return 1
+}
+
+// mediaTypeOf 返回 Content-Type 中去掉参数与首尾空白的媒体类型。结果是 contentType 的子串,
+// 只按 ASCII 扫描一遍, 不分配内存; 每个响应 (包括不压缩的) 都会调用。
+func mediaTypeOf(contentType string) string {
+	end := strings.IndexByte(contentType, ';')
+	if end < 0 {
+		end = len(contentType)
+	}
+	start := 0
+	for start < end && (contentType[start] == ' ' || contentType[start] == '\t') {
+		start++
+	}
+	for end > start && (contentType[end-1] == ' ' || contentType[end-1] == '\t') {
+		end--
+	}
+	return contentType[start:end]
+}
+
+// hasPrefixFold 报告 s 是否以 prefix 开头 (ASCII 不区分大小写), 不分配内存
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// addVaryAcceptEncoding 将 Accept-Encoding 合并进 Vary。
+// 已有的 Vary (可能分多行、逗号分隔) 若已包含 Accept-Encoding (不区分大小写) 或为 "*", 则保持不变。
+func addVaryAcceptEncoding(h http.Header) {
+	values := h[headerVary]
+	if len(values) == 0 {
+		h[headerVary] = []string{headerAcceptEncoding}
+		return
+	}
+	for _, v := range values {
+		for v != "" {
+			var token string
+			token, v, _ = strings.Cut(v, ",")
+			token = strings.TrimSpace(token)
+			if token == "*" || strings.EqualFold(token, headerAcceptEncoding) {
+				return
+			}
+		}
+	}
+	h[headerVary] = append(values, headerAcceptEncoding)
+}
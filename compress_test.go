@@ -1,13 +1,18 @@
 package compress
 
 import (
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/infinite-iroha/touka"
 	"github.com/klauspost/compress/flate"
@@ -137,6 +142,7 @@ func TestCompressionMiddleware(t *testing.T) {
 	})
 
 	t.Run("Zstd Compression", func(t *testing.T) {
+		skipWithoutZstd(t)
 		req := httptest.NewRequest("GET", "/test", nil)
 		req.Header.Set("Accept-Encoding", "zstd, gzip")
 		w := httptest.NewRecorder()
@@ -342,6 +348,7 @@ func TestCompressionNoContentLength(t *testing.T) {
 }
 
 func TestPoolsAndReset(t *testing.T) {
+	skipWithoutZstd(t)
     serverAlgos := map[string]AlgorithmConfig{
         EncodingGzip:    {Level: gzip.DefaultCompression, PoolEnabled: true},
         EncodingDeflate: {Level: flate.DefaultCompression, PoolEnabled: true},
@@ -349,16 +356,16 @@ func TestPoolsAndReset(t *testing.T) {
     }
 
     for enc, _ := range serverAlgos {
-        w1 := getCompressor(enc, serverAlgos[enc].Level, io.Discard, true)
+        w1, pool := getCompressor(enc, serverAlgos[enc].Level, io.Discard, true)
         if w1 == nil {
             t.Errorf("Failed to get compressor for %s", enc)
             continue
         }
         w1.Write([]byte("test"))
         w1.Flush()
-        putCompressor(w1, enc, true)
+        pool.put(w1)
 
-        w2 := getCompressor(enc, serverAlgos[enc].Level, io.Discard, true)
+        w2, _ := getCompressor(enc, serverAlgos[enc].Level, io.Discard, true)
         if w2 == nil {
             t.Errorf("Failed to get compressor for %s second time", enc)
             continue
@@ -371,18 +378,25 @@ func TestPoolsAndReset(t *testing.T) {
 func TestCompressionSpecialStatuses(t *testing.T) {
     tests := []struct {
         status         int
+        errors         ErrorPolicy
         expectedEncode bool
     }{
-        {http.StatusOK, true},
-        {http.StatusNoContent, false},
-        {http.StatusNotModified, false},
-        {http.StatusPartialContent, false}, // RFC 7231
+        {http.StatusOK, CompressErrors, true},
+        {http.StatusNoContent, CompressErrors, false},
+        {http.StatusNotModified, CompressErrors, false},
+        {http.StatusPartialContent, CompressErrors, false}, // RFC 7231
+        {http.StatusNotFound, CompressErrors, true},
+        {http.StatusNotFound, SkipErrors, false},
+        {http.StatusInternalServerError, SkipErrors, false},
+        {http.StatusOK, SkipErrors, true},
     }
 
     for _, tt := range tests {
-        t.Run(fmt.Sprintf("Status%d", tt.status), func(t *testing.T) {
+        t.Run(fmt.Sprintf("Status%d/%s", tt.status, tt.errors), func(t *testing.T) {
+            opts := DefaultCompressionConfig()
+            opts.Errors = tt.errors
             r := touka.New()
-            r.Use(Compression(DefaultCompressionConfig()))
+            r.Use(Compression(opts))
             r.GET("/", func(c *touka.Context) {
                 c.Header("Content-Type", "text/plain")
                 c.Writer.WriteHeader(tt.status)
@@ -535,15 +549,1169 @@ func TestCompressionInvalidMinContentLengthHeader(t *testing.T) {
 }
 
 func TestDeflatePool(t *testing.T) {
-    w := getCompressor(EncodingDeflate, flate.BestSpeed, io.Discard, true)
+    w, pool := getCompressor(EncodingDeflate, flate.BestSpeed, io.Discard, true)
     if w == nil {
         t.Fatal("Failed to get deflate compressor")
     }
     w.Reset(io.Discard)
-    putCompressor(w, EncodingDeflate, true)
+    pool.put(w)
 
-    w2 := getCompressor(EncodingDeflate, flate.BestSpeed, io.Discard, true)
+    w2, _ := getCompressor(EncodingDeflate, flate.BestSpeed, io.Discard, true)
     if w2 == nil {
         t.Fatal("Failed to get deflate compressor second time")
     }
 }
+
+func TestCompressionStripsIntegrityHeaders(t *testing.T) {
+	r := touka.New()
+	r.Use(Compression(DefaultCompressionConfig()))
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.Header("Content-MD5", "Q2hlY2sgSW50ZWdyaXR5IQ==")
+		c.Header("Digest", "sha-256=X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=")
+		c.Header("Repr-Digest", "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:")
+		c.String(http.StatusOK, "content whose digest changes after compression")
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected gzip, got %q", w.Header().Get("Content-Encoding"))
+	}
+	for _, h := range []string{"Content-MD5", "Digest", "Repr-Digest"} {
+		if v := w.Header().Get(h); v != "" {
+			t.Errorf("Expected %s to be removed, got %q", h, v)
+		}
+	}
+}
+
+func TestCompressionServerTimingTrailer(t *testing.T) {
+	opts := DefaultCompressionConfig()
+	opts.ServerTiming = true
+	r := touka.New()
+	r.Use(Compression(opts))
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "server timing should describe this compressed body")
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	res := w.Result()
+	io.Copy(io.Discard, res.Body)
+	got := res.Trailer.Get("Server-Timing")
+	if !strings.HasPrefix(got, "compress;dur=") || !strings.Contains(got, `desc="gzip l-1 ratio=`) {
+		t.Errorf("Unexpected Server-Timing trailer %q", got)
+	}
+}
+
+func TestOriginalLengthHeader(t *testing.T) {
+	body := strings.Repeat("original length ", 64)
+	opts := DefaultCompressionConfig()
+	opts.OriginalLengthHeader = "X-Original-Content-Length"
+	opts.OriginalLengthTrailer = true
+	r := touka.New()
+	r.Use(Compression(opts))
+	r.GET("/known", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.Header("Content-Length", strconv.Itoa(len(body)))
+		c.String(http.StatusOK, "%s", body)
+	})
+	r.GET("/stream", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", body)
+	})
+
+	serve := func(path string) *http.Response {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		res := w.Result()
+		io.Copy(io.Discard, res.Body)
+		return res
+	}
+
+	want := strconv.Itoa(len(body))
+	res := serve("/known")
+	if res.Header.Get("Content-Encoding") != EncodingGzip || res.Header.Get("Content-Length") != "" {
+		t.Fatalf("Expected compressed response without Content-Length, got %v", res.Header)
+	}
+	if got := res.Header.Get("X-Original-Content-Length"); got != want {
+		t.Errorf("Expected original length header %s, got %q", want, got)
+	}
+	if got := res.Trailer.Get("X-Original-Content-Length"); got != "" {
+		t.Errorf("Expected no trailer when the length was known, got %q", got)
+	}
+
+	res = serve("/stream")
+	if got := res.Header.Get("X-Original-Content-Length"); got != "" {
+		t.Errorf("Expected no header without Content-Length, got %q", got)
+	}
+	if got := res.Trailer.Get("X-Original-Content-Length"); got != want {
+		t.Errorf("Expected original length trailer %s, got %q", want, got)
+	}
+}
+
+func TestCompressionMethods(t *testing.T) {
+	var skips []SkipReason
+	opts := DefaultCompressionConfig()
+	opts.Methods = []string{"get", "HEAD", "PATCH"}
+	opts.MethodFilter = func(method string) bool { return method != http.MethodPatch }
+	opts.OnSkip = func(reason SkipReason, c *touka.Context) { skips = append(skips, reason) }
+	m := New(opts)
+	r := touka.New()
+	r.Use(m.Handler())
+	handler := func(c *touka.Context) {
+		if _, wrapped := c.Writer.(*compressResponseWriter); wrapped != (c.Request.Method == http.MethodGet) {
+			t.Errorf("%s: unexpected wrapping %v", c.Request.Method, wrapped)
+		}
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "method policy content")
+	}
+	r.GET("/", handler)
+	r.POST("/", handler)
+	r.PATCH("/", handler)
+
+	for method, want := range map[string]string{"GET": EncodingGzip, "POST": "", "PATCH": ""} {
+		req := httptest.NewRequest(method, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if got := w.Header().Get("Content-Encoding"); got != want {
+			t.Errorf("%s: expected Content-Encoding %q, got %q", method, want, got)
+		}
+	}
+	if len(skips) != 2 || skips[0] != SkipMethod || skips[1] != SkipMethod {
+		t.Errorf("Expected two method skips, got %v", skips)
+	}
+	if n := m.Stats().Snapshot().Skipped["method"]; n != 2 {
+		t.Errorf("Expected 2 method skips in stats, got %d", n)
+	}
+}
+
+func TestCompressionDebugHeader(t *testing.T) {
+	r := touka.New()
+	r.Use(Compression(CompressOptions{
+		Algorithms: map[string]AlgorithmConfig{
+			EncodingGzip: {Level: gzip.BestSpeed, PoolEnabled: true},
+		},
+		DebugHeader: true,
+	}))
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "debug header content")
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	want := "encoding=gzip; level=1; pooled=true"
+	if got := w.Header().Get("X-Compression-Info"); got != want {
+		t.Errorf("Expected X-Compression-Info %q, got %q", want, got)
+	}
+}
+
+func TestCompressionCallbacks(t *testing.T) {
+	var infos []CompressInfo
+	var skips []SkipReason
+	opts := DefaultCompressionConfig()
+	opts.OnCompress = func(info CompressInfo) { infos = append(infos, info) }
+	opts.OnSkip = func(reason SkipReason, c *touka.Context) { skips = append(skips, reason) }
+
+	r := touka.New()
+	r.Use(Compression(opts))
+	r.GET("/text", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "callback observed content")
+	})
+	r.GET("/bin", func(c *touka.Context) {
+		c.Header("Content-Type", "application/octet-stream")
+		c.String(http.StatusOK, "binary")
+	})
+
+	for _, tc := range []struct{ path, ae string }{
+		{"/text", "gzip"},
+		{"/bin", "gzip"},
+		{"/text", ""},
+	} {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		if tc.ae != "" {
+			req.Header.Set("Accept-Encoding", tc.ae)
+		}
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if len(infos) != 1 {
+		t.Fatalf("Expected 1 OnCompress call, got %d", len(infos))
+	}
+	info := infos[0]
+	if info.Encoding != EncodingGzip || info.StatusCode != http.StatusOK || info.BytesIn != int64(len("callback observed content")) || info.BytesOut == 0 || info.Context == nil {
+		t.Errorf("Unexpected CompressInfo %+v", info)
+	}
+	if !reflect.DeepEqual(skips, []SkipReason{SkipContentType, SkipNotAccepted}) {
+		t.Errorf("Unexpected skip reasons %v", skips)
+	}
+}
+
+func TestCompressionSkipReasons(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler touka.HandlerFunc
+		reason  SkipReason
+	}{
+		{"NoTransform", func(c *touka.Context) {
+			c.Header("Content-Type", "text/plain")
+			c.Header("Cache-Control", "public, No-Transform")
+			c.String(http.StatusOK, "must not be transformed")
+		}, SkipNoTransform},
+		{"PreEncoded", func(c *touka.Context) {
+			c.Header("Content-Type", "text/plain")
+			c.Header("Content-Encoding", "br")
+			c.String(http.StatusOK, "already encoded")
+		}, SkipPreEncoded},
+		{"StatusCode", func(c *touka.Context) {
+			c.Status(http.StatusNoContent)
+		}, SkipStatusCode},
+		{"TooSmall", func(c *touka.Context) {
+			c.Header("Content-Type", "text/plain")
+			c.Header("Content-Length", "2")
+			c.String(http.StatusOK, "hi")
+		}, SkipTooSmall},
+		{"Sensitive", func(c *touka.Context) {
+			c.Header("Content-Type", "text/html")
+			c.Header("Set-Cookie", "session=secret")
+			c.String(http.StatusOK, "<input name=csrf value=secret>")
+		}, SkipSensitive},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got SkipReason
+			opts := DefaultCompressionConfig()
+			opts.MinContentLength = 10
+			opts.DebugHeader = true
+			opts.OnSkip = func(reason SkipReason, c *touka.Context) { got = reason }
+			opts.SensitiveResponse = func(c *touka.Context, h http.Header) bool { return h.Get("Set-Cookie") != "" }
+			r := touka.New()
+			r.Use(Compression(opts))
+			r.GET("/", tt.handler)
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if got != tt.reason {
+				t.Errorf("Expected skip reason %v, got %v", tt.reason, got)
+			}
+			if info := w.Header().Get("X-Compression-Info"); info != "skipped="+tt.reason.String() {
+				t.Errorf("Unexpected X-Compression-Info %q", info)
+			}
+			if tt.reason != SkipPreEncoded && w.Header().Get("Content-Encoding") != "" {
+				t.Errorf("Expected no Content-Encoding, got %q", w.Header().Get("Content-Encoding"))
+			}
+		})
+	}
+}
+
+func TestBytesFromContext(t *testing.T) {
+	body := strings.Repeat("access log ", 200)
+	var got ByteCounts
+	var ok bool
+	r := touka.New()
+	r.Use(func(c *touka.Context) {
+		c.Next()
+		got, ok = BytesFromContext(c)
+	})
+	r.Use(Compression(DefaultCompressionConfig()))
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", body)
+		if in, ok := BytesFromContext(c); ok && in.In != int64(len(body)) {
+			t.Errorf("Expected %d bytes in during handler, got %d", len(body), in.In)
+		}
+	})
+
+	for _, ae := range []string{"gzip", "br"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", ae)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if !ok {
+			t.Fatalf("%s: expected byte counts in context", ae)
+		}
+		if got.In != int64(len(body)) {
+			t.Errorf("%s: expected %d bytes in, got %d", ae, len(body), got.In)
+		}
+		if got.Out != int64(w.Body.Len()) {
+			t.Errorf("%s: expected %d bytes out, got %d", ae, w.Body.Len(), got.Out)
+		}
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func TestTee(t *testing.T) {
+	body := strings.Repeat("audited body ", 100)
+	var captured strings.Builder
+	fail := false
+	r := touka.New()
+	r.Use(Compression(CompressOptions{
+		Algorithms: map[string]AlgorithmConfig{EncodingGzip: {Level: gzip.BestSpeed, PoolEnabled: true}},
+		Tee: func(c *touka.Context) io.Writer {
+			if fail {
+				return failingWriter{}
+			}
+			return &captured
+		},
+	}))
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.Writer.Write([]byte(body[:100]))
+		c.Writer.Flush()
+		c.Writer.Write([]byte(body[100:]))
+	})
+
+	for _, fail = range []bool{false, true} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(gr)
+		if string(got) != body {
+			t.Errorf("fail=%v: client body mismatch", fail)
+		}
+	}
+	if captured.String() != body {
+		t.Errorf("Expected tee to capture %d bytes, got %d", len(body), captured.Len())
+	}
+}
+
+func TestZstdEncoderOptions(t *testing.T) {
+	skipWithoutZstd(t)
+	body := strings.Repeat("zstd encoder options ", 500)
+	tests := []struct {
+		name   string
+		cfg    AlgorithmConfig
+		pooled bool
+	}{
+		{"default", AlgorithmConfig{Level: zstdDefaultLevel, PoolEnabled: true}, true},
+		{"concurrency", AlgorithmConfig{Level: zstdDefaultLevel, PoolEnabled: true, Concurrency: 4}, false},
+		{"low memory", AlgorithmConfig{Level: zstdDefaultLevel, PoolEnabled: true, LowMemory: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := touka.New()
+			r.Use(Compression(CompressOptions{
+				Algorithms:  map[string]AlgorithmConfig{EncodingZstd: tt.cfg},
+				DebugHeader: true,
+			}))
+			r.GET("/", func(c *touka.Context) {
+				c.Header("Content-Type", "text/plain")
+				c.String(http.StatusOK, "%s", body)
+			})
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", "zstd")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			wantPooled := "pooled=" + fmt.Sprint(tt.pooled)
+			if info := w.Header().Get("X-Compression-Info"); !strings.Contains(info, wantPooled) {
+				t.Errorf("Expected %s in %q", wantPooled, info)
+			}
+			zr, err := zstd.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer zr.Close()
+			got, _ := io.ReadAll(zr)
+			if string(got) != body {
+				t.Error("Body mismatch")
+			}
+		})
+	}
+}
+
+func TestNegotiateHeaderMatchesParse(t *testing.T) {
+	serverAlgos := map[string]AlgorithmConfig{
+		EncodingGzip:    {Level: gzip.DefaultCompression},
+		EncodingDeflate: {Level: flate.DefaultCompression},
+		EncodingZstd:    {Level: zstdDefaultLevel},
+	}
+	serverPrio := []string{EncodingZstd, EncodingGzip, EncodingDeflate}
+	for _, header := range []string{
+		"", "gzip", "br", "br;q=0", "gzip;q=0", " gzip ; q=0.5 , deflate", "deflate;q=2",
+		"gzip;q=bad, deflate", "*", "*;q=0", "br, *;q=0.1", "identity", "identity, br",
+		"identity;q=0, br", ",,gzip,,", "zstd;level=3;q=0.2", "GZIP", "gzip;Q=0",
+	} {
+		want := negotiateEncoding(parseAcceptEncoding(header), serverAlgos, serverPrio)
+		if got := negotiateHeader(header, serverPrio); got != want {
+			t.Errorf("negotiateHeader(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestLimitAcceptEncoding(t *testing.T) {
+	entry := "x-" + strings.Repeat("a", 100) + ", "
+	long := strings.Repeat(entry, 11) // 1144 字节, 第 10 个条目被截断
+	many := strings.Repeat("br, ", 40) + "gzip"
+	params := "gzip;q=1, deflate" + strings.Repeat(";a=1", 9) + ", zstd"
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"gzip, deflate", "gzip, deflate"},
+		{long, strings.TrimSuffix(strings.Repeat(entry, 9), ", ")},
+		{strings.Repeat("a", MaxAcceptEncodingLength) + ",gzip", strings.Repeat("a", MaxAcceptEncodingLength)},
+		{strings.Repeat("a", MaxAcceptEncodingLength+1), ""},
+		{many, strings.TrimSuffix(strings.Repeat("br, ", MaxAcceptEncodingCodings), ", ")},
+		{params, "gzip;q=1,"},
+		{"gzip" + strings.Repeat(";a", MaxAcceptEncodingParams), "gzip" + strings.Repeat(";a", MaxAcceptEncodingParams)},
+	}
+	for _, tt := range tests {
+		if got := limitAcceptEncoding(tt.header); got != tt.want {
+			t.Errorf("limitAcceptEncoding(%.40q...) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+	// 超出条目上限的 gzip 不被接受
+	if negotiateHeader(many, []string{EncodingGzip}) == EncodingGzip {
+		t.Error("Expected codings past the limit to be ignored")
+	}
+	if n := len(parseAcceptEncoding(strings.Repeat("gzip,", 10000))); n != MaxAcceptEncodingCodings {
+		t.Errorf("Expected %d parsed codings, got %d", MaxAcceptEncodingCodings, n)
+	}
+}
+
+// FuzzParseAcceptEncoding 检查任意头部的解析不会 panic, 结果遵守解析上限且与不分配内存的扫描一致
+func FuzzParseAcceptEncoding(f *testing.F) {
+	for _, h := range []string{
+		"", "gzip", "gzip;q=0.5, deflate", "*;q=0", "identity;q=0, br", ",,gzip,,", "zstd;level=3;q=0.2",
+		"gzip" + strings.Repeat(";a", 9), strings.Repeat("br,", 40),
+	} {
+		f.Add(h)
+	}
+	serverAlgos := map[string]AlgorithmConfig{
+		EncodingGzip:    {Level: gzip.DefaultCompression},
+		EncodingDeflate: {Level: flate.DefaultCompression},
+		EncodingZstd:    {Level: zstdDefaultLevel},
+	}
+	serverPrio := []string{EncodingZstd, EncodingGzip, EncodingDeflate}
+	f.Fuzz(func(t *testing.T, header string) {
+		limited := limitAcceptEncoding(header)
+		if !strings.HasPrefix(header, limited) || len(limited) > MaxAcceptEncodingLength {
+			t.Fatalf("limitAcceptEncoding(%q) = %q is not a bounded prefix", header, limited)
+		}
+		if limitAcceptEncoding(limited) != limited {
+			t.Fatalf("limitAcceptEncoding(%q) is not idempotent", header)
+		}
+		prefs := parseAcceptEncoding(header)
+		if len(prefs) > MaxAcceptEncodingCodings {
+			t.Fatalf("parseAcceptEncoding(%q) returned %d codings", header, len(prefs))
+		}
+		for _, p := range prefs {
+			if p.q <= 0 || p.q > 1 {
+				t.Fatalf("parseAcceptEncoding(%q) returned q=%v", header, p.q)
+			}
+		}
+		want := negotiateEncoding(prefs, serverAlgos, serverPrio)
+		if got := negotiateHeader(header, serverPrio); got != want {
+			t.Fatalf("negotiateHeader(%q) = %q, want %q", header, got, want)
+		}
+	})
+}
+
+func TestSkippedRequestAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocation counts are unreliable under the race detector")
+	}
+	handler := func(c *touka.Context) {
+		c.Writer.Header().Set("Content-Type", "Image/PNG")
+		c.Writer.WriteHeader(http.StatusOK)
+		c.Writer.Write([]byte("png"))
+	}
+	base := touka.New()
+	base.GET("/", handler)
+	with := touka.New()
+	with.Use(Compression(DefaultCompressionConfig()))
+	with.GET("/", handler)
+
+	for _, ae := range []string{"", "br", "gzip, deflate, br"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", ae)
+		w := httptest.NewRecorder()
+		want := testing.AllocsPerRun(100, func() { w.Body.Reset(); base.ServeHTTP(w, req) })
+		got := testing.AllocsPerRun(100, func() { w.Body.Reset(); with.ServeHTTP(w, req) })
+		if got > want {
+			t.Errorf("Accept-Encoding %q: middleware added %v allocations per skipped request", ae, got-want)
+		}
+	}
+}
+
+func TestCompressedRequestAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocation counts are unreliable under the race detector")
+	}
+	body := []byte(strings.Repeat("pooled encoder ", 100))
+	handler := func(c *touka.Context) {
+		c.Writer.Header().Set("Content-Type", "text/plain")
+		c.Writer.Header().Set("Content-MD5", "stale")
+		c.Writer.WriteHeader(http.StatusOK)
+		c.Writer.Write(body)
+	}
+	base := touka.New()
+	base.GET("/", handler)
+	with := touka.New()
+	with.Use(Compression(DefaultCompressionConfig()))
+	with.GET("/", handler)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	want := testing.AllocsPerRun(100, func() { w.Body.Reset(); clear(w.Header()); base.ServeHTTP(w, req) })
+	got := testing.AllocsPerRun(100, func() { w.Body.Reset(); clear(w.Header()); with.ServeHTTP(w, req) })
+	// 只允许保存 ByteCounts 到上下文的两次分配 (Keys 的桶与值的装箱)
+	// 以及 Content-Encoding 与 Vary 各自的切片, 压缩器来自对象池
+	if got-want > 4 {
+		t.Errorf("middleware added %v allocations per compressed request", got-want)
+	}
+	if w.Header().Get("Content-Encoding") != EncodingGzip || w.Header().Get("Content-MD5") != "" {
+		t.Errorf("Unexpected headers %v", w.Header())
+	}
+}
+
+func TestCompressionTrailers(t *testing.T) {
+	body := strings.Repeat("compression trailers ", 200)
+	opts := DefaultCompressionConfig()
+	opts.CompressionTrailers = true
+	r := touka.New()
+	r.Use(Compression(opts))
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", body)
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	res := w.Result()
+	compressed, _ := io.ReadAll(res.Body)
+	if got := res.Header.Get("Trailer"); got != "Compressed-Length, Compression-Ratio" {
+		t.Errorf("Expected declared trailers, got %q", got)
+	}
+	if got, want := res.Trailer.Get("Compressed-Length"), strconv.Itoa(len(compressed)); got != want {
+		t.Errorf("Expected Compressed-Length %s, got %q", want, got)
+	}
+	ratio, err := strconv.ParseFloat(res.Trailer.Get("Compression-Ratio"), 64)
+	if want := float64(len(body)) / float64(len(compressed)); err != nil || ratio < want-0.001 || ratio > want+0.001 {
+		t.Errorf("Expected Compression-Ratio %.3f, got %q", want, res.Trailer.Get("Compression-Ratio"))
+	}
+}
+
+func TestMediaTypeOf(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                                   "",
+		"text/html":                          "text/html",
+		"Text/HTML; charset=utf-8":           "Text/HTML",
+		" \tapplication/json ;charset=utf-8": "application/json",
+		"text/plain  ":                       "text/plain",
+		";charset=utf-8":                     "",
+	} {
+		if got := mediaTypeOf(in); got != want {
+			t.Errorf("mediaTypeOf(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if raceEnabled {
+		return
+	}
+	if n := testing.AllocsPerRun(100, func() { mediaTypeOf("Application/JSON; charset=utf-8") }); n != 0 {
+		t.Errorf("mediaTypeOf allocated %v times", n)
+	}
+}
+
+func TestAddVaryAcceptEncoding(t *testing.T) {
+	for _, tt := range []struct {
+		existing []string
+		want     []string
+	}{
+		{nil, []string{"Accept-Encoding"}},
+		{[]string{"Origin"}, []string{"Origin", "Accept-Encoding"}},
+		{[]string{"Accept-Encoding"}, []string{"Accept-Encoding"}},
+		{[]string{"origin, accept-encoding"}, []string{"origin, accept-encoding"}},
+		{[]string{"Origin", " Accept-Encoding ,Cookie"}, []string{"Origin", " Accept-Encoding ,Cookie"}},
+		{[]string{"*"}, []string{"*"}},
+		{[]string{"Origin,*"}, []string{"Origin,*"}},
+		{[]string{"Accept-Encoding-Extra"}, []string{"Accept-Encoding-Extra", "Accept-Encoding"}},
+	} {
+		h := http.Header{}
+		if tt.existing != nil {
+			h["Vary"] = tt.existing
+		}
+		addVaryAcceptEncoding(h)
+		if got := h["Vary"]; strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("Vary %q: got %q, want %q", tt.existing, got, tt.want)
+		}
+	}
+}
+
+func TestVaryWildcard(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		opts := DefaultCompressionConfig()
+		opts.SkipOnVaryWildcard = skip
+		opts.DebugHeader = true
+		r := touka.New()
+		r.Use(Compression(opts))
+		r.GET("/", func(c *touka.Context) {
+			c.Header("Content-Type", "text/plain")
+			c.Header("Vary", "*")
+			c.String(http.StatusOK, "response varies on everything")
+		})
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if got := w.Header().Values("Vary"); len(got) != 1 || got[0] != "*" {
+			t.Errorf("skip=%v: expected Vary: * to be left as-is, got %q", skip, got)
+		}
+		compressed := w.Header().Get("Content-Encoding") == EncodingGzip
+		if compressed == skip {
+			t.Errorf("skip=%v: unexpected compression %v (%s)", skip, compressed, w.Header().Get("X-Compression-Info"))
+		}
+		if skip && w.Header().Get("X-Compression-Info") != "skipped=vary_wildcard" {
+			t.Errorf("Expected vary_wildcard skip reason, got %q", w.Header().Get("X-Compression-Info"))
+		}
+	}
+}
+
+func TestHeaderValuesNotShared(t *testing.T) {
+	opts := DefaultCompressionConfig()
+	opts.AcceptRanges = AcceptRangesNone
+	r := touka.New()
+	r.Use(Compression(opts))
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", strings.Repeat("shared header values ", 100))
+	})
+
+	serve := func() http.Header {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Header()
+	}
+	first := serve()
+	// 原地修改一个响应的头部值不应影响后续响应
+	for _, k := range []string{"Content-Encoding", "Vary", "Accept-Ranges"} {
+		if len(first[k]) != 1 {
+			t.Fatalf("Expected one %s value, got %q", k, first[k])
+		}
+		first[k][0] = "mutated"
+	}
+	second := serve()
+	if second.Get("Content-Encoding") != EncodingGzip || second.Get("Vary") != "Accept-Encoding" || second.Get("Accept-Ranges") != "none" {
+		t.Errorf("Header values leaked between responses: %v", second)
+	}
+}
+
+func TestGzipHeader(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, workers := range []int{0, 1} {
+		opts := CompressOptions{
+			Algorithms:   map[string]AlgorithmConfig{EncodingGzip: {Level: 6, PoolEnabled: true}},
+			AsyncWorkers: workers,
+			GzipHeader: func(c *touka.Context, h *gzip.Header) {
+				if name := c.Query("name"); name != "" {
+					h.Name = name
+					h.ModTime = modTime
+					h.Comment = "exported"
+				}
+			},
+		}
+		r := touka.New()
+		r.Use(Compression(opts))
+		r.GET("/", func(c *touka.Context) {
+			c.Header("Content-Type", "text/plain")
+			c.String(http.StatusOK, "%s", strings.Repeat("a,b,c\n", 100))
+		})
+
+		for _, name := range []string{"report.csv", "", "other.csv"} {
+			req := httptest.NewRequest("GET", "/?name="+name, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			gr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gr.Name != name || gr.OS != 255 {
+				t.Errorf("workers=%d: expected gzip name %q with OS 255, got %q (OS %d)", workers, name, gr.Name, gr.OS)
+			}
+			if name != "" && (!gr.ModTime.Equal(modTime) || gr.Comment != "exported") {
+				t.Errorf("workers=%d: unexpected ModTime %v or Comment %q", workers, gr.ModTime, gr.Comment)
+			}
+		}
+	}
+}
+
+func TestPerEncodingMinContentLength(t *testing.T) {
+	skipWithoutZstd(t)
+	body := strings.Repeat("x", 100)
+	r := touka.New()
+	r.Use(Compression(CompressOptions{
+		Algorithms: map[string]AlgorithmConfig{
+			EncodingGzip: {Level: 9},
+			EncodingZstd: {Level: zstdDefaultLevel, MinContentLength: 50},
+		},
+		MinContentLength: 200,
+		DebugHeader:      true,
+	}))
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.Header("Content-Length", strconv.Itoa(len(body)))
+		c.String(http.StatusOK, "%s", body)
+	})
+
+	for accept, want := range map[string]string{EncodingZstd: EncodingZstd, EncodingGzip: ""} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", accept)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if got := w.Header().Get("Content-Encoding"); got != want {
+			t.Errorf("%s: expected Content-Encoding %q, got %q (%s)", accept, want, got, w.Header().Get("X-Compression-Info"))
+		}
+	}
+}
+
+func TestSizeTiers(t *testing.T) {
+	r := touka.New()
+	r.Use(Compression(CompressOptions{
+		Algorithms: map[string]AlgorithmConfig{EncodingGzip: {
+			Level:       6,
+			PoolEnabled: true,
+			SizeTiers:   []SizeTier{{MaxLength: 100, Level: 1}, {MaxLength: 1000, Level: 5}, {Level: 9}},
+		}},
+		DebugHeader: true,
+	}))
+	r.GET("/", func(c *touka.Context) {
+		n, _ := strconv.Atoi(c.Query("n"))
+		body := strings.Repeat("t", n)
+		c.Header("Content-Type", "text/plain")
+		if c.Query("length") != "" {
+			c.Header("Content-Length", strconv.Itoa(n))
+		}
+		c.String(http.StatusOK, "%s", body)
+	})
+
+	for target, want := range map[string]string{
+		"/?n=50&length=1":   "level=1",
+		"/?n=100&length=1":  "level=1",
+		"/?n=500&length=1":  "level=5",
+		"/?n=5000&length=1": "level=9",
+		"/?n=5000":          "level=6", // 未声明长度时使用配置的级别
+	} {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if got := w.Header().Get("X-Compression-Info"); !strings.Contains(got, want) {
+			t.Errorf("%s: expected %q in %q", target, want, got)
+		}
+	}
+}
+
+func TestStreamingLevel(t *testing.T) {
+	r := touka.New()
+	r.Use(Compression(CompressOptions{
+		Algorithms:  map[string]AlgorithmConfig{EncodingGzip: {Level: 9, PoolEnabled: true, StreamingLevel: 1}},
+		DebugHeader: true,
+	}))
+	r.GET("/", func(c *touka.Context) {
+		body := strings.Repeat("streaming ", 500)
+		c.Header("Content-Type", "text/plain")
+		if c.Query("length") != "" {
+			c.Header("Content-Length", strconv.Itoa(len(body)))
+		}
+		c.String(http.StatusOK, "%s", body)
+	})
+
+	for target, want := range map[string]string{
+		"/?length=1": "level=9",
+		"/":          "level=1",
+	} {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if got := w.Header().Get("X-Compression-Info"); !strings.Contains(got, want) {
+			t.Errorf("%s: expected %q in %q", target, want, got)
+		}
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		if body, _ := io.ReadAll(gr); string(body) != strings.Repeat("streaming ", 500) {
+			t.Errorf("%s: body mismatch", target)
+		}
+	}
+}
+
+func TestPreferAbove(t *testing.T) {
+	skipWithoutZstd(t)
+	r := touka.New()
+	r.Use(Compression(CompressOptions{
+		Algorithms: map[string]AlgorithmConfig{
+			EncodingZstd:    {Level: 3, PreferAbove: 32 << 10},
+			EncodingGzip:    {Level: 6},
+			EncodingDeflate: {Level: 6, MinContentLength: 2000},
+		},
+		EncodingPriority: []string{EncodingZstd, EncodingGzip, EncodingDeflate},
+	}))
+	r.GET("/", func(c *touka.Context) {
+		n, _ := strconv.Atoi(c.Query("n"))
+		body := strings.Repeat("x", n)
+		c.Header("Content-Type", "text/plain")
+		if c.Query("length") != "" {
+			c.Header("Content-Length", strconv.Itoa(len(body)))
+		}
+		c.String(http.StatusOK, "%s", body)
+	})
+
+	tests := []struct {
+		target string
+		accept string
+		want   string
+	}{
+		{"/?n=1000&length=1", "zstd, gzip", EncodingGzip},
+		{"/?n=100000&length=1", "zstd, gzip", EncodingZstd},
+		{"/?n=1000", "zstd, gzip", EncodingZstd},    // 未声明长度时按优先级
+		{"/?n=1000&length=1", "zstd", EncodingZstd}, // 没有其他可用的编码
+		{"/?n=1000&length=1", "gzip;q=0, zstd", EncodingZstd},
+		{"/?n=1000&length=1", "zstd, deflate", EncodingZstd}, // deflate 未达到自身的最小长度
+		{"/?n=3000&length=1", "zstd, deflate", EncodingDeflate},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.target, nil)
+		req.Header.Set("Accept-Encoding", tt.accept)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if got := w.Header().Get("Content-Encoding"); got != tt.want {
+			t.Errorf("%s (%s): expected %q, got %q", tt.target, tt.accept, tt.want, got)
+		}
+	}
+}
+
+func TestMaxContentLength(t *testing.T) {
+	body := strings.Repeat("large response ", 100)
+	r := touka.New()
+	r.Use(Compression(CompressOptions{
+		Algorithms:       map[string]AlgorithmConfig{EncodingGzip: {Level: 1}},
+		MaxContentLength: 1000,
+		DebugHeader:      true,
+	}))
+	handler := func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		if c.Query("length") != "" {
+			c.Header("Content-Length", strconv.Itoa(len(body)))
+		}
+		c.String(http.StatusOK, "%s", body)
+	}
+	r.GET("/", handler)
+	r.GET("/forced", AllowLargeResponses(), handler)
+
+	tests := []struct {
+		target string
+		want   string
+		info   string
+	}{
+		{"/?length=1", "", "skipped=too_large"},
+		{"/", EncodingGzip, ""},
+		{"/forced?length=1", EncodingGzip, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.target, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if got := w.Header().Get("Content-Encoding"); got != tt.want {
+			t.Errorf("%s: expected Content-Encoding %q, got %q", tt.target, tt.want, got)
+		}
+		if tt.info != "" && w.Header().Get("X-Compression-Info") != tt.info {
+			t.Errorf("%s: expected %q, got %q", tt.target, tt.info, w.Header().Get("X-Compression-Info"))
+		}
+		if tt.want == "" && w.Body.String() != body {
+			t.Errorf("%s: expected body to pass through unchanged", tt.target)
+		}
+	}
+}
+
+func TestHeadRequest(t *testing.T) {
+	m := New(CompressOptions{Algorithms: map[string]AlgorithmConfig{EncodingGzip: {Level: 2, PoolEnabled: true}}})
+	body := strings.Repeat("head matches get ", 100)
+	handler := func(c *touka.Context) {
+		c.Header("Content-Type", c.Query("type"))
+		c.Header("Content-Length", strconv.Itoa(len(body)))
+		if c.Request.Method != http.MethodHead {
+			io.WriteString(c.Writer, body)
+			return
+		}
+		c.Writer.WriteHeader(http.StatusOK)
+	}
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", handler)
+	r.HEAD("/", handler)
+
+	serve := func(method, contentType string) http.Header {
+		req := httptest.NewRequest(method, "/?type="+contentType, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Header()
+	}
+
+	pool := poolFor(EncodingGzip, 2)
+	gets := pool.gets.Load()
+	head := serve(http.MethodHead, "text/plain")
+	if n := pool.gets.Load() - gets; n != 0 {
+		t.Errorf("Expected HEAD not to acquire an encoder, got %d gets", n)
+	}
+	get := serve(http.MethodGet, "text/plain")
+	for _, k := range []string{"Content-Encoding", "Vary", "Content-Length"} {
+		if head.Get(k) != get.Get(k) {
+			t.Errorf("%s: HEAD %q, GET %q", k, head.Get(k), get.Get(k))
+		}
+	}
+	if head.Get("Content-Encoding") != EncodingGzip {
+		t.Errorf("Expected HEAD to advertise gzip, got %q", head.Get("Content-Encoding"))
+	}
+
+	// 不压缩的类型保留 Content-Length
+	if head := serve(http.MethodHead, "application/octet-stream"); head.Get("Content-Length") != strconv.Itoa(len(body)) || head.Get("Content-Encoding") != "" {
+		t.Errorf("Expected skipped HEAD to keep identity headers, got %v", head)
+	}
+}
+
+func TestPreEncoded(t *testing.T) {
+	for _, tt := range []struct {
+		values  []string
+		want    bool
+		removed bool
+	}{
+		{nil, false, false},
+		{[]string{"gzip"}, true, false},
+		{[]string{"identity"}, false, true},
+		{[]string{"IDENTITY", ""}, false, true},
+		{[]string{" identity , "}, false, true},
+		{[]string{"identity, br"}, true, false},
+		{[]string{"identity", "zstd"}, true, false},
+	} {
+		h := http.Header{}
+		if tt.values != nil {
+			h["Content-Encoding"] = tt.values
+		}
+		if got := preEncoded(h); got != tt.want {
+			t.Errorf("preEncoded(%q) = %v, want %v", tt.values, got, tt.want)
+		}
+		if _, ok := h["Content-Encoding"]; tt.values != nil && ok == tt.removed {
+			t.Errorf("preEncoded(%q): header removed = %v, want %v", tt.values, !ok, tt.removed)
+		}
+	}
+}
+
+func TestAcceptRanges(t *testing.T) {
+	for _, tt := range []struct {
+		policy AcceptRangesPolicy
+		want   string
+	}{
+		{AcceptRangesRemove, ""},
+		{AcceptRangesNone, "none"},
+		{AcceptRangesKeep, "bytes"},
+	} {
+		r := touka.New()
+		r.Use(Compression(CompressOptions{AcceptRanges: tt.policy}))
+		r.GET("/", func(c *touka.Context) {
+			c.Header("Accept-Ranges", "bytes")
+			c.Header("Content-Type", c.Query("type"))
+			c.String(http.StatusOK, "ranges %s", tt.policy)
+		})
+		for _, contentType := range []string{"text/plain", "application/octet-stream"} {
+			req := httptest.NewRequest("GET", "/?type="+contentType, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			want := tt.want
+			if w.Header().Get("Content-Encoding") == "" {
+				want = "bytes" // 未压缩的响应不受影响
+			}
+			if got := w.Header().Get("Accept-Ranges"); got != want {
+				t.Errorf("%s %s: Accept-Ranges %q, want %q", tt.policy, contentType, got, want)
+			}
+		}
+	}
+}
+
+func TestStrictNegotiation(t *testing.T) {
+	for _, custom := range []bool{false, true} {
+		opts := DefaultCompressionConfig()
+		opts.StrictNegotiation = true
+		if custom {
+			opts.NotAcceptable = func(c *touka.Context, supported []string) {
+				c.JSON(http.StatusNotAcceptable, map[string][]string{"supported": supported})
+			}
+		}
+		called := false
+		r := touka.New()
+		r.Use(Compression(opts))
+		r.GET("/", func(c *touka.Context) {
+			called = true
+			c.String(http.StatusOK, "ok")
+		})
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "br, identity;q=0")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusNotAcceptable || called {
+			t.Errorf("custom=%v: expected 406 without calling the handler, got %d (called=%v)", custom, w.Code, called)
+		}
+		if !strings.Contains(w.Body.String(), "gzip") || !strings.Contains(w.Body.String(), "deflate") {
+			t.Errorf("custom=%v: expected supported encodings in body, got %q", custom, w.Body.String())
+		}
+
+		// identity 仍可接受时照常处理
+		req.Header.Set("Accept-Encoding", "br")
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || !called {
+			t.Errorf("custom=%v: expected identity response, got %d", custom, w.Code)
+		}
+
+		called = false
+		req.Header.Set("Accept-Encoding", "br, *;q=0")
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusNotAcceptable || called {
+			t.Errorf("custom=%v: expected 406 for *;q=0, got %d (called=%v)", custom, w.Code, called)
+		}
+	}
+}
+
+func TestIdentityRejected(t *testing.T) {
+	for header, want := range map[string]bool{
+		"":                           false,
+		"br":                         false,
+		"br, identity;q=0":           true,
+		"identity;q=0.5":             false,
+		"*;q=0":                      true,
+		"*;q=0, identity":            false,
+		"identity;q=0, *":            true,
+		" br ; q=1 , * ; q=0 ":       true,
+		"gzip;q=0, deflate;q=0, br":  false,
+	} {
+		if got := identityRejected(header); got != want {
+			t.Errorf("identityRejected(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestStripAcceptEncoding(t *testing.T) {
+	body := strings.Repeat("upstream body ", 100)
+	for _, strip := range []bool{false, true} {
+		m := New(CompressOptions{
+			Algorithms:          map[string]AlgorithmConfig{EncodingGzip: {Level: 6}},
+			StripAcceptEncoding: strip,
+		})
+		var seen []string
+		r := touka.New()
+		r.Use(m.Handler())
+		r.GET("/", func(c *touka.Context) {
+			// 模拟进程内代理: 上游在请求接受 gzip 时返回自己压缩的响应
+			accept := c.Request.Header.Get("Accept-Encoding")
+			seen = append(seen, accept)
+			c.Header("Content-Type", "text/plain")
+			if strings.Contains(accept, "gzip") {
+				var buf bytes.Buffer
+				gw := gzip.NewWriter(&buf)
+				gw.Write([]byte(body))
+				gw.Close()
+				c.Header("Content-Encoding", "gzip")
+				c.Writer.Write(buf.Bytes())
+				return
+			}
+			c.String(http.StatusOK, "%s", body)
+		})
+
+		for _, accept := range []string{"gzip", "br"} {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", accept)
+			r.ServeHTTP(httptest.NewRecorder(), req)
+			if got := req.Header.Get("Accept-Encoding"); got != accept {
+				t.Errorf("strip=%v: Accept-Encoding not restored after the response, got %q want %q", strip, got, accept)
+			}
+		}
+		want := []string{"gzip", "br"}
+		if strip {
+			want[0] = "" // 只有协商出编码的请求被修改
+		}
+		if !slices.Equal(seen, want) {
+			t.Errorf("strip=%v: handler saw Accept-Encoding %q, want %q", strip, seen, want)
+		}
+		pre := m.Stats().Snapshot().Skipped["pre_encoded"]
+		if strip && pre != 0 || !strip && pre != 1 {
+			t.Errorf("strip=%v: unexpected pre_encoded skips %d", strip, pre)
+		}
+	}
+}
+
+func TestNestedMiddleware(t *testing.T) {
+	outer := New(CompressOptions{})
+	inner := New(CompressOptions{
+		Algorithms:       map[string]AlgorithmConfig{EncodingZstd: {Level: zstdDefaultLevel}},
+		EncodingPriority: []string{EncodingZstd},
+	})
+	r := touka.New()
+	r.Use(outer.Handler())
+	g := r.Group("/api")
+	g.Use(inner.Handler())
+	g.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", strings.Repeat("nested ", 100))
+	})
+
+	req := httptest.NewRequest("GET", "/api/", nil)
+	req.Header.Set("Accept-Encoding", "gzip, zstd")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if got := w.Header().Values("Content-Encoding"); len(got) != 1 || got[0] != EncodingGzip {
+		t.Fatalf("Expected a single gzip encoding from the outer instance, got %v", got)
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(gr); string(body) != strings.Repeat("nested ", 100) {
+		t.Errorf("Expected body to be compressed once, got %q", body)
+	}
+	if n := inner.Stats().Snapshot().Total.Responses; n != 0 {
+		t.Errorf("Expected inner instance to pass through, got %d compressed", n)
+	}
+}
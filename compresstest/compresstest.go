@@ -0,0 +1,155 @@
+// Package compresstest 提供测试压缩行为的辅助函数, 应用无需自行编写解码代码:
+//
+//	c := compresstest.NewClient(engine).WithAcceptEncoding("zstd")
+//	resp := c.Get("/api/items")
+//	compresstest.AssertEncoded(t, resp, "zstd")
+//	var items []Item
+//	json.Unmarshal(compresstest.DecodeBody(resp), &items)
+//
+// 函数接受 *http.Response; 使用 httptest.ResponseRecorder 时先调用其 Result 方法。
+package compresstest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fenthope/compress"
+	"github.com/klauspost/compress/flate"
+)
+
+// AcceptEncodingVariants 是常见客户端发送的 Accept-Encoding, 便于编写表驱动测试
+var AcceptEncodingVariants = map[string]string{
+	"none":     "",                          // 不带 Accept-Encoding 的客户端 (如 curl 默认)
+	"identity": "identity",                  // 明确只接受未编码的响应
+	"gzip":     "gzip",                      // 只支持 gzip 的旧客户端
+	"zstd":     "zstd",                      // 只支持 zstd
+	"browser":  "gzip, deflate, br, zstd",   // 现代浏览器
+	"weighted": "gzip;q=1.0, zstd;q=0.5",    // 带权重的偏好
+	"reject":   "identity;q=0, *;q=0",       // 拒绝所有编码, 包括 identity
+	"wildcard": "*",                         // 接受任意编码
+	"unknown":  "br, compress, x-gzip-fake", // 只有未配置的编码
+}
+
+// Client 直接调用 http.Handler 的测试客户端, 为每个请求设置 Accept-Encoding
+type Client struct {
+	Handler        http.Handler
+	AcceptEncoding string      // 为空时不发送 Accept-Encoding
+	Header         http.Header // 附加到每个请求的头部
+}
+
+// NewClient 创建调用 h 的客户端, 默认发送 "gzip, deflate, zstd"
+func NewClient(h http.Handler) *Client {
+	return &Client{Handler: h, AcceptEncoding: "gzip, deflate, zstd"}
+}
+
+// WithAcceptEncoding 返回 Accept-Encoding 为 v 的客户端副本
+func (c *Client) WithAcceptEncoding(v string) *Client {
+	cp := *c
+	cp.AcceptEncoding = v
+	return &cp
+}
+
+// Get 发送 GET 请求
+func (c *Client) Get(target string) *http.Response {
+	return c.Do(httptest.NewRequest(http.MethodGet, target, nil))
+}
+
+// Do 按客户端的设置补充请求头部后调用 Handler, 返回记录的响应
+func (c *Client) Do(req *http.Request) *http.Response {
+	for k, v := range c.Header {
+		req.Header[k] = v
+	}
+	if c.AcceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", c.AcceptEncoding)
+	} else {
+		req.Header.Del("Accept-Encoding")
+	}
+	w := httptest.NewRecorder()
+	c.Handler.ServeHTTP(w, req)
+	return w.Result()
+}
+
+// AssertEncoded 检查响应的 Content-Encoding 为 encoding, 且响应体可以按该编码完整解码。
+// encoding 为 "" 或 "identity" 时检查响应未被编码。
+func AssertEncoded(t testing.TB, resp *http.Response, encoding string) {
+	t.Helper()
+	got := resp.Header.Get("Content-Encoding")
+	if encoding == compress.EncodingIdentity {
+		encoding = ""
+	}
+	if got != encoding {
+		t.Fatalf("compresstest: Content-Encoding = %q, want %q", got, encoding)
+	}
+	if encoding != "" && !varies(resp.Header) {
+		t.Errorf("compresstest: encoded response is missing Vary: Accept-Encoding")
+	}
+	if _, err := decode(resp); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// DecodeBody 读取响应体并按 Content-Encoding 解码。可以重复调用, 每次返回相同的内容。
+// 响应体无法解码时 panic, 需要检查错误的测试应使用 AssertEncoded。
+func DecodeBody(resp *http.Response) []byte {
+	body, err := decode(resp)
+	if err != nil {
+		panic(err)
+	}
+	return body
+}
+
+// decode 读取并解码响应体, 之后把原始字节放回 resp.Body 以便再次读取
+func decode(resp *http.Response) ([]byte, error) {
+	raw, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("compresstest: reading body: %w", err)
+	}
+
+	var r io.Reader
+	switch encoding := strings.TrimSpace(resp.Header.Get("Content-Encoding")); encoding {
+	case "", compress.EncodingIdentity:
+		return raw, nil
+	case compress.EncodingGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("compresstest: invalid gzip body: %w", err)
+		}
+		r = gr
+	case compress.EncodingDeflate:
+		r = flate.NewReader(bytes.NewReader(raw))
+	case compress.EncodingZstd:
+		zr, err := newZstdReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, fmt.Errorf("compresstest: unsupported Content-Encoding %q", encoding)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("compresstest: decoding %s body: %w", resp.Header.Get("Content-Encoding"), err)
+	}
+	return body, nil
+}
+
+// varies 报告头部是否包含 Vary: Accept-Encoding
+func varies(h http.Header) bool {
+	for _, v := range h.Values("Vary") {
+		for _, tok := range strings.Split(v, ",") {
+			if tok = strings.TrimSpace(tok); tok == "*" || strings.EqualFold(tok, "Accept-Encoding") {
+				return true
+			}
+		}
+	}
+	return false
+}
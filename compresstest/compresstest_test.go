@@ -0,0 +1,78 @@
+package compresstest
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/fenthope/compress"
+	"github.com/infinite-iroha/touka"
+)
+
+func TestClient(t *testing.T) {
+	payload := strings.Repeat("compresstest payload ", 100)
+	r := touka.New()
+	r.Use(compress.Compression(compress.CompressOptions{
+		Algorithms: map[string]compress.AlgorithmConfig{
+			compress.EncodingGzip:    {Level: 6},
+			compress.EncodingDeflate: {Level: 6},
+			compress.EncodingZstd:    {Level: 3},
+		},
+		EncodingPriority: []string{compress.EncodingZstd, compress.EncodingGzip, compress.EncodingDeflate},
+	}))
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", payload)
+	})
+
+	// 以 compress_no_zstd 构建时 zstd 不参与协商, 由 gzip 接替
+	best, onlyZstd := compress.EncodingZstd, compress.EncodingZstd
+	if _, ok := compress.LookupCodec(compress.EncodingZstd); !ok {
+		best, onlyZstd = compress.EncodingGzip, ""
+	}
+	c := NewClient(r)
+	for variant, want := range map[string]string{
+		"none":     "",
+		"identity": "",
+		"gzip":     compress.EncodingGzip,
+		"zstd":     onlyZstd,
+		"browser":  best,
+		"weighted": best, // 服务器优先级优先于客户端权重
+		"wildcard": best,
+		"unknown":  "",
+	} {
+		resp := c.WithAcceptEncoding(AcceptEncodingVariants[variant]).Get("/")
+		AssertEncoded(t, resp, want)
+		if got := string(DecodeBody(resp)); got != payload {
+			t.Errorf("%s: decoded body mismatch (%d bytes)", variant, len(got))
+		}
+		if got := string(DecodeBody(resp)); got != payload {
+			t.Errorf("%s: second decode mismatch", variant)
+		}
+	}
+
+	resp := c.WithAcceptEncoding("deflate").Get("/")
+	AssertEncoded(t, resp, compress.EncodingDeflate)
+	if c.AcceptEncoding != "gzip, deflate, zstd" {
+		t.Errorf("WithAcceptEncoding modified the original client: %q", c.AcceptEncoding)
+	}
+}
+
+func TestDecodeBodyInvalid(t *testing.T) {
+	r := touka.New()
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Encoding", "gzip")
+		c.String(http.StatusOK, "not gzip")
+	})
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected DecodeBody to panic on a corrupt body")
+		}
+	}()
+	DecodeBody(NewClient(r).Get("/"))
+}
+
+func TestNegotiationConformance(t *testing.T) {
+	t.Run("Negotiator", func(t *testing.T) { RunNegotiationConformance(t, NegotiatorNegotiate) })
+	t.Run("Middleware", func(t *testing.T) { RunNegotiationConformance(t, MiddlewareNegotiate) })
+}
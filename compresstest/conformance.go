@@ -0,0 +1,123 @@
+package compresstest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fenthope/compress"
+	"github.com/infinite-iroha/touka"
+)
+
+// NegotiationCase 是协商一致性测试表中的一项: 服务器按 Priority 配置编码时, Accept-Encoding 为 Accept 的请求应选择 Want
+type NegotiationCase struct {
+	Name     string
+	Priority []string // 服务器按优先级配置的编码
+	Accept   string   // 为空时不发送 Accept-Encoding
+	Want     string   // 期望选择的编码, "" 表示以 identity 发送
+}
+
+var (
+	allCodings  = []string{compress.EncodingZstd, compress.EncodingGzip, compress.EncodingDeflate}
+	flateCoding = []string{compress.EncodingGzip, compress.EncodingDeflate}
+)
+
+// NegotiationCases 是 Accept-Encoding 协商的一致性测试表, 覆盖通配符、q 值、identity 的排除与未知编码。
+// 表中的规则: q=0、负数或无法解析的 q 值视为不接受; 客户端接受的编码中按服务器的优先级选择, q 值不参与排序;
+// "*" 匹配服务器优先级最高的编码; 没有可接受的编码时以 identity 发送 (未启用 StrictNegotiation)。
+var NegotiationCases = []NegotiationCase{
+	{"no header", allCodings, "", ""},
+	{"single coding", allCodings, "gzip", compress.EncodingGzip},
+	{"server priority", allCodings, "gzip, deflate, br, zstd", compress.EncodingZstd},
+	{"browser without zstd", allCodings, "gzip, deflate, br", compress.EncodingGzip},
+	{"unconfigured coding", flateCoding, "zstd", ""},
+
+	{"q does not reorder", allCodings, "gzip;q=1.0, zstd;q=0.5", compress.EncodingZstd},
+	{"q=0 excludes", allCodings, "zstd;q=0, gzip", compress.EncodingGzip},
+	{"all q=0", allCodings, "gzip;q=0, deflate;q=0", ""},
+	{"low q accepted", allCodings, "deflate;q=0.001", compress.EncodingDeflate},
+	{"q above 1", flateCoding, "deflate;q=2", compress.EncodingDeflate},
+	{"negative q", flateCoding, "gzip;q=-1", ""},
+	{"invalid q", flateCoding, "gzip;q=abc, deflate", compress.EncodingDeflate},
+	{"other parameters", allCodings, "zstd;level=3;q=0.2", compress.EncodingZstd},
+	{"whitespace and empty entries", flateCoding, " ,, deflate ; q=0.5 ,", compress.EncodingDeflate},
+
+	{"wildcard", allCodings, "*", compress.EncodingZstd},
+	{"wildcard follows priority", flateCoding, "*", compress.EncodingGzip},
+	{"wildcard q=0", allCodings, "*;q=0", ""},
+	{"wildcard after unknown", allCodings, "br, *;q=0.1", compress.EncodingZstd},
+	{"explicit coding before wildcard", flateCoding, "deflate, *;q=0.1", compress.EncodingDeflate},
+
+	{"identity only", allCodings, "identity", ""},
+	{"identity preferred", allCodings, "identity, gzip;q=0.5", compress.EncodingGzip},
+	{"identity excluded", allCodings, "identity;q=0, gzip", compress.EncodingGzip},
+	{"identity excluded, nothing else", allCodings, "identity;q=0", ""},
+	{"everything excluded", allCodings, "identity;q=0, *;q=0", ""},
+
+	{"unknown only", allCodings, "br, compress", ""},
+	{"lookalike tokens", allCodings, "gzip2, zstd-x, deflate64", ""},
+	{"parameters without coding", allCodings, ";q=1", ""},
+}
+
+// NegotiateFunc 在按 priority 配置编码 (均使用默认级别) 时对 accept 协商, 返回选择的编码, 不压缩时返回 ""
+type NegotiateFunc func(priority []string, accept string) string
+
+// RunNegotiationConformance 以 negotiate 逐项运行 NegotiationCases, 每项为一个子测试。
+// 用于验证自定义的协商实现, 或在重构后确认协商行为不变:
+//
+//	func TestNegotiation(t *testing.T) {
+//		compresstest.RunNegotiationConformance(t, compresstest.MiddlewareNegotiate)
+//	}
+//
+// 配置了未编译进二进制的编码 (如以 compress_no_zstd 构建时的 zstd) 的项会被跳过。
+func RunNegotiationConformance(t *testing.T, negotiate NegotiateFunc) {
+	for _, tc := range NegotiationCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			for _, name := range tc.Priority {
+				if _, ok := compress.LookupCodec(name); !ok {
+					t.Skipf("%s is not available in this build", name)
+				}
+			}
+			if got := negotiate(tc.Priority, tc.Accept); got != tc.Want {
+				t.Errorf("Accept-Encoding %q with %v: got %q, want %q", tc.Accept, tc.Priority, got, tc.Want)
+			}
+		})
+	}
+}
+
+// NegotiatorNegotiate 以 compress.Negotiator 实现 NegotiateFunc
+func NegotiatorNegotiate(priority []string, accept string) string {
+	n, err := compress.NewNegotiator(conformanceOptions(priority))
+	if err != nil {
+		panic(err)
+	}
+	return n.Negotiate(accept)
+}
+
+// MiddlewareNegotiate 以压缩中间件处理一个可压缩的响应, 返回其 Content-Encoding, 实现 NegotiateFunc
+func MiddlewareNegotiate(priority []string, accept string) string {
+	r := touka.New()
+	r.Use(compress.Compression(conformanceOptions(priority)))
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", strings.Repeat("conformance ", 100))
+	})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if accept != "" {
+		req.Header.Set("Accept-Encoding", accept)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Header().Get("Content-Encoding")
+}
+
+// conformanceOptions 返回按 priority 配置编码的选项
+func conformanceOptions(priority []string) compress.CompressOptions {
+	algorithms := make(map[string]compress.AlgorithmConfig, len(priority))
+	for _, name := range priority {
+		c, _ := compress.LookupCodec(name)
+		algorithms[name] = compress.AlgorithmConfig{Level: c.DefaultLevel}
+	}
+	return compress.CompressOptions{Algorithms: algorithms, EncodingPriority: priority}
+}
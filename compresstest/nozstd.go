@@ -0,0 +1,13 @@
+//go:build compress_no_zstd
+
+package compresstest
+
+import (
+	"errors"
+	"io"
+)
+
+// newZstdReader 在以 compress_no_zstd 构建时不可用, 避免引入 zstd 解码器
+func newZstdReader(io.Reader) (io.ReadCloser, error) {
+	return nil, errors.New("compresstest: zstd is excluded by the compress_no_zstd build tag")
+}
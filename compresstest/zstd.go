@@ -0,0 +1,19 @@
+//go:build !compress_no_zstd
+
+package compresstest
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// newZstdReader 创建 zstd 解码器
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("compresstest: invalid zstd body: %w", err)
+	}
+	return zr.IOReadCloser(), nil
+}
@@ -0,0 +1,183 @@
+package compress
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/infinite-iroha/touka"
+)
+
+// DebugInfo 是 DebugHandler 输出的内容
+type DebugInfo struct {
+	Config DebugConfig   `json:"config"` // 生效的配置
+	Stats  StatsSnapshot `json:"stats"`  // 统计快照, 其中 Pools 给出各对象池当前取出的压缩器数
+}
+
+// DebugConfig 是生效配置中可序列化的部分, 回调只以是否设置表示
+type DebugConfig struct {
+	Algorithms        map[string]DebugAlgorithm `json:"algorithms"`
+	MinContentLength  int64                     `json:"min_content_length"`
+	MaxContentLength  int64                     `json:"max_content_length,omitempty"`
+	CompressibleTypes []string                  `json:"compressible_types"`
+	RsyncableTypes    []string                  `json:"rsyncable_types,omitempty"`
+	EncodingPriority  []string                  `json:"encoding_priority"`
+	EncodingWeights   map[string]int            `json:"encoding_weights,omitempty"`
+	Profiles          []string                  `json:"profiles,omitempty"`
+	ProfileHeader     string                    `json:"profile_header,omitempty"`
+	TrustedProxies    []string                  `json:"trusted_proxies,omitempty"`
+	Methods           []string                  `json:"methods,omitempty"`
+	ExpvarName        string                    `json:"expvar_name,omitempty"`
+	ServerTiming      bool                      `json:"server_timing"`
+	DebugHeader       bool                      `json:"debug_header"`
+	OriginalLength    string                    `json:"original_length_header,omitempty"`
+	OriginalTrailer   bool                      `json:"original_length_trailer,omitempty"`
+	CompressTrailers  bool                      `json:"compression_trailers,omitempty"`
+	LogLevel          LogLevel                  `json:"log_level"`
+	LogSampleRate     float64                   `json:"log_sample_rate"`
+	MaxPoolMemory     int64                     `json:"max_pool_memory,omitempty"`
+	MaxBuffered       int64                     `json:"max_buffered_bytes"`
+	MaxTotalBuffered  int64                     `json:"max_total_buffered_bytes,omitempty"`
+	MaxConcurrent     int                       `json:"max_concurrent_compressions,omitempty"`
+	MaxOutputBytes    int64                     `json:"max_output_bytes,omitempty"`
+	WriteTimeout      string                    `json:"write_timeout,omitempty"`
+	FlushSize         int                       `json:"flush_size,omitempty"`
+	FlushMaxDelay     string                    `json:"flush_max_delay,omitempty"`
+	ClientRate        float64                   `json:"client_rate,omitempty"`
+	ClientBurst       int                       `json:"client_burst,omitempty"`
+	ConcurrencyWait   string                    `json:"concurrency_wait,omitempty"`
+	AcceptRanges      string                    `json:"accept_ranges"`
+	Errors            string                    `json:"errors"`
+	SkipVaryWildcard  bool                      `json:"skip_on_vary_wildcard,omitempty"`
+	StripAccept       bool                      `json:"strip_accept_encoding,omitempty"`
+	Padding           string                    `json:"padding"`
+	PaddingMax        int                       `json:"padding_max,omitempty"`
+	Enabled           bool                      `json:"enabled"`         // 中间件的运行时开关, 见 SetEnabled
+	Pooling           bool                      `json:"pooling"`         // 对象池的运行时开关, 见 SetPooling
+	Hooks             []string                  `json:"hooks,omitempty"` // 已设置的回调, 如 OnCompress
+}
+
+// DebugAlgorithm 是单个编码的生效配置
+type DebugAlgorithm struct {
+	Level       int        `json:"level"`
+	PoolEnabled bool       `json:"pool_enabled"`
+	Pooled      bool       `json:"pooled"` // 该配置是否确实经过对象池
+	Concurrency int        `json:"concurrency,omitempty"`
+	LowMemory   bool       `json:"low_memory,omitempty"`
+	Prewarm     int        `json:"prewarm_pool_size,omitempty"`
+	PoolType    string     `json:"pool_type"`
+	MaxIdle     int        `json:"max_idle,omitempty"`
+	MinLength   int64      `json:"min_content_length,omitempty"`
+	SizeTiers   []SizeTier `json:"size_tiers,omitempty"`
+	Streaming   int        `json:"streaming_level,omitempty"`
+	PreferAbove int64      `json:"prefer_above,omitempty"`
+}
+
+// DebugInfo 返回当前的配置与统计, 供排查问题使用
+func (m *Middleware) DebugInfo() DebugInfo {
+	c := m.config()
+	o := &c.opts
+	cfg := DebugConfig{
+		Algorithms:        make(map[string]DebugAlgorithm, len(o.Algorithms)),
+		MinContentLength:  o.MinContentLength,
+		MaxContentLength:  o.MaxContentLength,
+		CompressibleTypes: o.CompressibleTypes,
+		RsyncableTypes:    o.RsyncableTypes,
+		EncodingPriority:  o.EncodingPriority,
+		EncodingWeights:   o.EncodingWeights,
+		ProfileHeader:     o.ProfileHeader,
+		TrustedProxies:    o.TrustedProxies,
+		Methods:           o.Methods,
+		ExpvarName:        o.ExpvarName,
+		ServerTiming:      o.ServerTiming,
+		DebugHeader:       o.DebugHeader,
+		OriginalLength:    o.OriginalLengthHeader,
+		OriginalTrailer:   o.OriginalLengthTrailer,
+		CompressTrailers:  o.CompressionTrailers,
+		LogLevel:          o.LogLevel,
+		LogSampleRate:     o.LogSampleRate,
+		MaxPoolMemory:     o.MaxPoolMemory,
+		MaxBuffered:       c.bufferLimit(),
+		MaxTotalBuffered:  o.MaxTotalBufferedBytes,
+		MaxConcurrent:     o.MaxConcurrentCompressions,
+		MaxOutputBytes:    o.MaxOutputBytes,
+		FlushSize:         o.FlushSize,
+		ClientRate:        o.ClientRate,
+		AcceptRanges:      o.AcceptRanges.String(),
+		Errors:            o.Errors.String(),
+		SkipVaryWildcard:  o.SkipOnVaryWildcard,
+		StripAccept:       o.StripAcceptEncoding,
+		Padding:           o.Padding.String(),
+		Enabled:           m.Enabled(),
+		Pooling:           m.Pooling(),
+	}
+	if c.clients != nil {
+		cfg.ClientBurst = int(c.clients.burst)
+	}
+	if o.Padding != PaddingOff {
+		cfg.PaddingMax = c.paddingMax()
+	}
+	if o.ConcurrencyWait > 0 {
+		cfg.ConcurrencyWait = o.ConcurrencyWait.String()
+	}
+	if len(o.Profiles) > 0 {
+		cfg.Profiles = slices.Sorted(maps.Keys(o.Profiles))
+	}
+	if o.WriteTimeout > 0 {
+		cfg.WriteTimeout = o.WriteTimeout.String()
+	}
+	if o.FlushMaxDelay > 0 {
+		cfg.FlushMaxDelay = o.FlushMaxDelay.String()
+	}
+	if len(cfg.CompressibleTypes) == 0 {
+		cfg.CompressibleTypes = DefaultCompressibleTypes
+	}
+	for name, ac := range o.Algorithms {
+		cfg.Algorithms[name] = DebugAlgorithm{
+			Level:       ac.Level,
+			PoolEnabled: ac.PoolEnabled,
+			Pooled:      ac.pooled(name),
+			Concurrency: ac.Concurrency,
+			LowMemory:   ac.LowMemory,
+			Prewarm:     ac.PrewarmPoolSize,
+			PoolType:    ac.PoolType.String(),
+			MaxIdle:     ac.MaxIdle,
+			MinLength:   ac.MinContentLength,
+			SizeTiers:   ac.SizeTiers,
+			Streaming:   ac.StreamingLevel,
+			PreferAbove: ac.PreferAbove,
+		}
+	}
+	for _, h := range []struct {
+		name string
+		set  bool
+	}{
+		{"OnCompress", o.OnCompress != nil},
+		{"OnSkip", o.OnSkip != nil},
+		{"ErrorHandler", o.ErrorHandler != nil},
+		{"SensitiveResponse", o.SensitiveResponse != nil},
+		{"GzipHeader", o.GzipHeader != nil},
+		{"MethodFilter", o.MethodFilter != nil},
+		{"ClientKey", o.ClientKey != nil},
+		{"Tee", o.Tee != nil},
+		{"AdaptiveLevel", o.AdaptiveLevel != nil},
+		{"EncodingPolicy", o.EncodingPolicy != nil},
+	} {
+		if h.set {
+			cfg.Hooks = append(cfg.Hooks, h.name)
+		}
+	}
+	return DebugInfo{Config: cfg, Stats: m.stats.Snapshot()}
+}
+
+// DebugHandler 返回以 JSON 输出 DebugInfo 的处理函数, 可挂载到管理路由下, 例如:
+//
+//	admin.GET("/debug/compress", m.DebugHandler())
+//
+// 输出包含配置细节, 不应对公网开放。
+func (m *Middleware) DebugHandler() touka.HandlerFunc {
+	return func(c *touka.Context) {
+		c.Header(headerCacheControl, "no-store")
+		c.JSON(http.StatusOK, m.DebugInfo())
+	}
+}
@@ -0,0 +1,53 @@
+package compress
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestDebugHandler(t *testing.T) {
+	m := New(CompressOptions{
+		Algorithms: map[string]AlgorithmConfig{
+			EncodingGzip: {Level: gzip.BestSpeed, PoolEnabled: true},
+		},
+		OnSkip: func(SkipReason, *touka.Context) {},
+	})
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "debug handler renders stats")
+	})
+	r.GET("/debug/compress", m.DebugHandler())
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/debug/compress", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	var info DebugInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if gz := info.Config.Algorithms[EncodingGzip]; gz.Level != gzip.BestSpeed || !gz.Pooled {
+		t.Errorf("Unexpected gzip config %+v", gz)
+	}
+	if len(info.Config.Hooks) != 1 || info.Config.Hooks[0] != "OnSkip" {
+		t.Errorf("Expected hooks [OnSkip], got %v", info.Config.Hooks)
+	}
+	if info.Stats.Encodings[EncodingGzip].Responses != 1 {
+		t.Errorf("Expected 1 gzip response, got %d", info.Stats.Encodings[EncodingGzip].Responses)
+	}
+	if len(info.Stats.Pools) == 0 {
+		t.Error("Expected pool stats")
+	}
+}
@@ -0,0 +1,64 @@
+package compress
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrOutputLimit 表示压缩后的输出超过了 MaxOutputBytes, 通过 ErrorHandler 收到的 *EncoderError 包装此错误
+var ErrOutputLimit = errors.New("compress: output exceeds MaxOutputBytes")
+
+// EncoderOp 表示压缩器出错时所处的操作
+type EncoderOp uint8
+
+const (
+	OpInit  EncoderOp = iota // 创建或获取压缩器
+	OpWrite                  // 写入数据
+	OpFlush                  // 刷新
+	OpClose                  // 结束压缩流
+	numEncoderOps
+)
+
+var encoderOpNames = [numEncoderOps]string{
+	OpInit:  "init",
+	OpWrite: "write",
+	OpFlush: "flush",
+	OpClose: "close",
+}
+
+// String 返回操作的名称, 与统计快照中的键一致
+func (op EncoderOp) String() string {
+	if op < numEncoderOps {
+		return encoderOpNames[op]
+	}
+	return "unknown"
+}
+
+// EncoderError 描述一次压缩器操作失败。
+// 写入、刷新或结束失败意味着客户端收到的压缩流可能被截断。
+type EncoderError struct {
+	Encoding string
+	Op       EncoderOp
+	Err      error
+}
+
+func (e *EncoderError) Error() string {
+	return fmt.Sprintf("compress: %s %s: %v", e.Encoding, e.Op, e.Err)
+}
+
+func (e *EncoderError) Unwrap() error { return e.Err }
+
+// encoderFailed 处理压缩器的错误: 计入统计并交给 ErrorHandler, 未设置时记录日志。
+// 同一响应只处理第一个错误, 之后的错误通常是它的后果 (如连接断开后的每次写入)。
+func (crw *compressResponseWriter) encoderFailed(op EncoderOp, err error) {
+	if crw.err != nil {
+		return
+	}
+	crw.err = &EncoderError{Encoding: crw.chosenEncoding, Op: op, Err: err}
+	crw.mw.stats.recordError(op)
+	if h := crw.cfg.opts.ErrorHandler; h != nil {
+		h(crw.ctx, crw.err)
+		return
+	}
+	crw.mw.logf(crw.ctx, LogLevelError, "%v", crw.err)
+}
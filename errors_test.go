@@ -0,0 +1,173 @@
+package compress
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/infinite-iroha/touka"
+)
+
+var errBrokenPipe = errors.New("broken pipe")
+
+// brokenRecorder 模拟写入客户端失败的连接
+type brokenRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (b brokenRecorder) Write([]byte) (int, error) { return 0, errBrokenPipe }
+
+func TestEncoderErrors(t *testing.T) {
+	m := New(CompressOptions{})
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		for i := 0; i < 3; i++ {
+			io.WriteString(c.Writer, strings.Repeat("truncated ", 10000))
+			c.Writer.Flush()
+		}
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	r.ServeHTTP(brokenRecorder{httptest.NewRecorder()}, req)
+
+	var total uint64
+	for _, n := range m.Stats().Snapshot().Errors {
+		total += n
+	}
+	if total != 1 {
+		t.Errorf("Expected exactly 1 recorded encoder error per response, got %v", m.Stats().Snapshot().Errors)
+	}
+
+	err := &EncoderError{Encoding: EncodingGzip, Op: OpClose, Err: errBrokenPipe}
+	if !errors.Is(err, errBrokenPipe) || err.Error() != "compress: gzip close: broken pipe" {
+		t.Errorf("Unexpected EncoderError %q", err)
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	skipWithoutZstd(t)
+	var got []error
+	m := New(CompressOptions{
+		Algorithms: map[string]AlgorithmConfig{
+			EncodingGzip: {Level: 42}, // 非法级别, 无法创建压缩器
+			EncodingZstd: {Level: zstdDefaultLevel, PoolEnabled: true},
+		},
+		EncodingPriority: []string{EncodingZstd, EncodingGzip},
+		ErrorHandler:     func(c *touka.Context, err error) { got = append(got, err) },
+	})
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		io.WriteString(c.Writer, strings.Repeat("fallback ", 100))
+	})
+
+	// 创建失败时回退为 identity
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != strings.Repeat("fallback ", 100) {
+		t.Errorf("Expected identity fallback, got encoding %q", w.Header().Get("Content-Encoding"))
+	}
+	var ee *EncoderError
+	if len(got) != 1 || !errors.As(got[0], &ee) || ee.Op != OpInit || ee.Encoding != EncodingGzip {
+		t.Fatalf("Expected one init EncoderError, got %v", got)
+	}
+
+	// 写往客户端失败
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "zstd")
+	r.ServeHTTP(brokenRecorder{httptest.NewRecorder()}, req)
+	if len(got) != 2 || !errors.Is(got[1], errBrokenPipe) {
+		t.Errorf("Expected broken pipe to reach ErrorHandler, got %v", got)
+	}
+}
+
+func TestMaxOutputBytes(t *testing.T) {
+	const limit = 4 << 10
+	noise := make([]byte, 64<<10)
+	rand.NewChaCha8([32]byte{}).Read(noise) // 不可压缩, 输出很快超过上限
+	for _, workers := range []int{0, 1} {
+		var got []error
+		m := New(CompressOptions{
+			MaxOutputBytes: limit,
+			AsyncWorkers:   workers,
+			ErrorHandler:   func(c *touka.Context, err error) { got = append(got, err) },
+		})
+		r := touka.New()
+		r.Use(m.Handler())
+		r.GET("/", func(c *touka.Context) {
+			c.Header("Content-Type", "text/plain")
+			c.Writer.Write(noise)
+		})
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Body.Len() > limit {
+			t.Errorf("workers=%d: wrote %d bytes, limit %d", workers, w.Body.Len(), limit)
+		}
+		if len(got) != 1 || !errors.Is(got[0], ErrOutputLimit) {
+			t.Errorf("workers=%d: expected one ErrOutputLimit, got %v", workers, got)
+		}
+		m.Close(context.Background())
+	}
+}
+
+// deadlineWriter 记录经 http.ResponseController 设置的写超时
+type deadlineWriter struct {
+	touka.ResponseWriter
+	deadlines []time.Time
+}
+
+func (w *deadlineWriter) SetWriteDeadline(d time.Time) error {
+	w.deadlines = append(w.deadlines, d)
+	return nil
+}
+
+func TestWriteTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	limit, _ := ctx.Deadline()
+	for _, timeout := range []time.Duration{time.Minute, 2 * time.Hour} {
+		dw := &deadlineWriter{}
+		r := touka.New()
+		r.Use(func(c *touka.Context) {
+			dw.ResponseWriter = c.Writer
+			c.Writer = dw
+			c.Next()
+		})
+		r.Use(Compression(CompressOptions{WriteTimeout: timeout}))
+		r.GET("/", func(c *touka.Context) {
+			c.Header("Content-Type", "text/plain")
+			c.String(200, "%s", strings.Repeat("deadline ", 1000))
+		})
+
+		start := time.Now()
+		req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("timeout=%s: expected gzip response", timeout)
+		}
+		n := len(dw.deadlines)
+		if n < 2 || !dw.deadlines[n-1].IsZero() {
+			t.Fatalf("timeout=%s: expected deadlines followed by a reset, got %v", timeout, dw.deadlines)
+		}
+		for _, d := range dw.deadlines[:n-1] {
+			if d.After(limit) || d.Before(start.Add(min(timeout, time.Hour)-time.Second)) {
+				t.Errorf("timeout=%s: deadline %v outside the expected window", timeout, d)
+			}
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package compress
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/infinite-iroha/touka"
+)
+
+// defaultExportTTL 是 ExportOptions.TTL 的默认值
+const defaultExportTTL = 10 * time.Minute
+
+// ExportOptions 控制 ExportStore 的临时文件与压缩
+type ExportOptions struct {
+	// Dir 是临时文件所在的目录, 为空时使用 os.TempDir()
+	Dir string
+	// TTL 是导出文件生成后保留的时长, 期间重试的下载直接续传而不重新生成; 为 0 时为 10 分钟
+	TTL time.Duration
+	// Encoding 是压缩使用的编码, 为空时使用 gzip
+	Encoding string
+	// Level 是压缩级别, 为 0 时使用该编码的默认级别
+	Level int
+}
+
+// ExportStore 先把生成较慢的大型导出 (如报表、数据转储) 完整压缩到临时文件, 再以精确的 Content-Length、
+// ETag 与 Range 支持发送, 中断后重试的下载可以从断点续传, 而不必重新生成与压缩。
+// 同一 key 同时只生成一次, 并发的请求等待同一个文件; 文件在 TTL 过后的下一次 Serve 时删除。
+// 可以并发使用; 不再使用时调用 Close 删除所有临时文件。
+type ExportStore struct {
+	opts  ExportOptions
+	mu    sync.Mutex
+	files map[string]*exportFile
+}
+
+// exportFile 是一个已生成或正在生成的导出文件
+type exportFile struct {
+	ready   chan struct{} // 生成结束后关闭, 之后其余字段不再改变
+	err     error
+	path    string
+	etag    string
+	modTime time.Time
+	expires time.Time // 为零值时仍在生成, 由 mu 保护
+	pending int       // 已取得但尚未打开该文件的请求数, 不为 0 时 sweep 不删除它; 由 mu 保护
+}
+
+// NewExportStore 按 opts 创建 ExportStore, 编码或级别无效时返回错误
+func NewExportStore(opts ExportOptions) (*ExportStore, error) {
+	if opts.Encoding == "" {
+		opts.Encoding = EncodingGzip
+	}
+	codec, ok := LookupCodec(opts.Encoding)
+	if !ok {
+		return nil, fmt.Errorf("compress: unknown encoding %q", opts.Encoding)
+	}
+	if opts.Level == 0 {
+		opts.Level = codec.DefaultLevel
+	}
+	if !validLevel(opts.Encoding, opts.Level) {
+		return nil, fmt.Errorf("compress: %s level %d out of range", opts.Encoding, opts.Level)
+	}
+	if opts.TTL < 0 {
+		return nil, fmt.Errorf("compress: export TTL %s is negative", opts.TTL)
+	} else if opts.TTL == 0 {
+		opts.TTL = defaultExportTTL
+	}
+	return &ExportStore{opts: opts, files: make(map[string]*exportFile)}, nil
+}
+
+// Serve 发送 key 对应的导出, 没有未过期的文件时先调用 generate 写出未压缩的内容并压缩到临时文件, 例如:
+//
+//	r.GET("/export", func(c *touka.Context) {
+//		key := c.Query("from") + "/" + c.Query("to")
+//		err := exports.Serve(c, key, "text/csv", func(w io.Writer) error {
+//			return writeReport(w, c.Query("from"), c.Query("to"))
+//		})
+//		if err != nil {
+//			c.ErrorUseHandle(http.StatusInternalServerError, err)
+//		}
+//	})
+//
+// key 标识导出的内容, 不同用户或参数的导出必须使用不同的 key。响应以 http.ServeContent 发送,
+// 支持 Range、If-Range 与条件请求; 已带 Content-Encoding, 外层的压缩中间件不会再次压缩 (跳过原因 SkipPreEncoded)。
+// 客户端不接受该编码时解码后发送, 此时不支持 Range。generate 出错或请求在等待时被取消时返回错误, 且不写入任何响应。
+func (s *ExportStore) Serve(c *touka.Context, key, contentType string, generate func(w io.Writer) error) error {
+	f, r, err := s.open(c, key, generate)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	h := c.Writer.Header()
+	h.Set(headerContentType, contentType)
+	addVaryAcceptEncoding(h)
+	if negotiateHeader(c.Request.Header.Get(headerAcceptEncoding), []string{s.opts.Encoding}) != s.opts.Encoding {
+		serveDecoded(c, http.StatusOK, r, s.opts.Encoding)
+		return nil
+	}
+	h.Set("ETag", f.etag)
+	http.ServeContent(exportWriter{c.Writer, s.opts.Encoding}, c.Request, "", f.modTime, r)
+	return nil
+}
+
+// exportWriter 在写出状态码时才设置 Content-Encoding:
+// 已有 Content-Encoding 时 http.ServeContent 不设置 Content-Length
+type exportWriter struct {
+	http.ResponseWriter
+	encoding string
+}
+
+func (w exportWriter) WriteHeader(code int) {
+	if code == http.StatusOK || code == http.StatusPartialContent {
+		w.Header()[headerContentEncoding] = []string{w.encoding}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// maxExportAttempts 是 open 在文件打开前被 Close 或 Invalidate 删除时最多尝试生成的次数
+const maxExportAttempts = 3
+
+// open 返回 key 的导出文件并打开它。取得文件到打开之间 sweep 不会删除它 (见 exportFile.pending),
+// 打开也在持有 mu 时进行; 文件在此期间被 Close 或 Invalidate 删除时重新生成。
+func (s *ExportStore) open(c *touka.Context, key string, generate func(w io.Writer) error) (*exportFile, *os.File, error) {
+	for range maxExportAttempts {
+		f, err := s.file(c, key, generate)
+		s.mu.Lock()
+		f.pending--
+		if err != nil {
+			s.mu.Unlock()
+			return nil, nil, err
+		}
+		if s.files[key] != f {
+			s.mu.Unlock()
+			continue
+		}
+		r, err := os.Open(f.path)
+		s.mu.Unlock()
+		if err != nil {
+			return nil, nil, fmt.Errorf("compress: opening export: %w", err)
+		}
+		return f, r, nil
+	}
+	return nil, nil, errors.New("compress: export removed before it could be opened")
+}
+
+// file 返回 key 未过期的导出文件, 不存在时生成; 同一 key 的并发请求等待同一次生成。
+// 返回的文件总是非 nil 且 pending 已加一, 调用方须在持有 mu 时减一。
+func (s *ExportStore) file(c *touka.Context, key string, generate func(w io.Writer) error) (*exportFile, error) {
+	s.mu.Lock()
+	s.sweep(time.Now())
+	f, ok := s.files[key]
+	if !ok {
+		f = &exportFile{ready: make(chan struct{})}
+		s.files[key] = f
+	}
+	f.pending++
+	s.mu.Unlock()
+
+	if !ok {
+		path, etag, err := s.generate(generate)
+		s.mu.Lock()
+		if err != nil {
+			f.err = err
+			delete(s.files, key) // 之后的请求重新生成
+		} else {
+			f.path, f.etag, f.modTime = path, etag, time.Now()
+			f.expires = f.modTime.Add(s.opts.TTL)
+			if s.files[key] != f {
+				os.Remove(path) // 生成期间被 Invalidate, 不会再被打开; 等待它的请求重新生成
+			}
+		}
+		s.mu.Unlock()
+		close(f.ready)
+		return f, f.err
+	}
+	select {
+	case <-f.ready:
+		return f, f.err
+	case <-c.Request.Context().Done():
+		return f, c.Request.Context().Err()
+	}
+}
+
+// generate 把 fn 写出的内容压缩到新的临时文件, 返回文件路径与按压缩后内容计算的 ETag
+func (s *ExportStore) generate(fn func(w io.Writer) error) (path, etag string, err error) {
+	tmp, err := os.CreateTemp(s.opts.Dir, "compress-export-*")
+	if err != nil {
+		return "", "", fmt.Errorf("compress: creating export file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	sum := sha256.New()
+	bw := bufio.NewWriterSize(io.MultiWriter(tmp, sum), bufferSize)
+	enc, err := NewEncoder(s.opts.Encoding, s.opts.Level, bw)
+	if err != nil {
+		tmp.Close()
+		return "", "", err
+	}
+	err = fn(enc)
+	if closeErr := enc.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("compress: generating export: %w", err)
+	}
+	return tmp.Name(), `"` + hex.EncodeToString(sum.Sum(nil)[:16]) + `"`, nil
+}
+
+// sweep 删除已过期且没有请求等待打开的导出文件, 调用时需持有 mu。
+// 正在发送的文件已被打开, 删除不影响其发送
+func (s *ExportStore) sweep(now time.Time) {
+	for key, f := range s.files {
+		if !f.expires.IsZero() && now.After(f.expires) && f.pending == 0 {
+			s.remove(key, f)
+		}
+	}
+}
+
+// Invalidate 删除 key 的导出文件, 之后的请求重新生成; 正在发送的文件已被打开, 删除不影响其发送。
+// 正在生成时, 生成结束后删除该文件, 等待它的请求也重新生成。导出所依据的数据变化时调用。
+func (s *ExportStore) Invalidate(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.files[key]; ok {
+		s.remove(key, f)
+	}
+}
+
+// InvalidateAll 对所有 key 调用 Invalidate
+func (s *ExportStore) InvalidateAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, f := range s.files {
+		s.remove(key, f)
+	}
+}
+
+// remove 删除 key 的条目及其已生成的文件, 调用时需持有 mu
+func (s *ExportStore) remove(key string, f *exportFile) {
+	if !f.expires.IsZero() {
+		os.Remove(f.path)
+	}
+	delete(s.files, key)
+}
+
+// Close 删除所有已生成的临时文件; 正在生成的文件在生成结束后仍会保留到过期
+func (s *ExportStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for key, f := range s.files {
+		if f.expires.IsZero() {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+		delete(s.files, key)
+	}
+	return errors.Join(errs...)
+}
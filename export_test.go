@@ -0,0 +1,230 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestExportStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewExportStore(ExportOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := strings.Repeat("id,name,value\n1,export,42\n", 2000)
+	generated := 0
+	r := touka.New()
+	r.Use(Compression(DefaultCompressionConfig()))
+	r.GET("/export", func(c *touka.Context) {
+		err := store.Serve(c, c.Query("key"), "text/csv", func(w io.Writer) error {
+			generated++
+			if c.Query("fail") != "" {
+				return errors.New("report failed")
+			}
+			_, err := io.WriteString(w, body)
+			return err
+		})
+		if err != nil {
+			c.String(http.StatusInternalServerError, "%v", err)
+		}
+	})
+	serve := func(target string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/export?key=a", http.Header{"Accept-Encoding": {"gzip"}})
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != EncodingGzip {
+		t.Fatalf("Expected gzip export, got %d %v", w.Code, w.Header())
+	}
+	full := w.Body.Bytes()
+	if got := w.Header().Get("Content-Length"); got != strconv.Itoa(len(full)) {
+		t.Errorf("Expected exact Content-Length %d, got %q", len(full), got)
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(full))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(gr); string(got) != body {
+		t.Error("Export body mismatch")
+	}
+	etag := w.Header().Get("ETag")
+
+	// 重试的下载从断点续传, 不重新生成
+	w = serve("/export?key=a", http.Header{"Accept-Encoding": {"gzip"}, "Range": {"bytes=100-"}, "If-Range": {etag}})
+	if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), full[100:]) {
+		t.Errorf("Expected resumed download, got %d with %d bytes", w.Code, w.Body.Len())
+	}
+	if generated != 1 {
+		t.Errorf("Expected the export to be generated once, got %d", generated)
+	}
+
+	// 不接受 gzip 的客户端收到解码后的内容
+	w = serve("/export?key=a", http.Header{"Accept-Encoding": {"identity"}})
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != body {
+		t.Errorf("Expected identity export, got %v", w.Header())
+	}
+
+	w = serve("/export?key=b&fail=1", nil)
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "report failed") {
+		t.Errorf("Expected generation error, got %d %q", w.Code, w.Body.String())
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected Close to remove export files, %d left", len(entries))
+	}
+}
+
+func TestExportStoreTTL(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewExportStore(ExportOptions{Dir: dir, TTL: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	generated := 0
+	r := touka.New()
+	r.GET("/", func(c *touka.Context) {
+		store.Serve(c, "report", "text/plain", func(w io.Writer) error {
+			generated++
+			_, err := io.WriteString(w, "report")
+			return err
+		})
+	})
+	for range 2 {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+		time.Sleep(time.Millisecond)
+	}
+	if generated != 2 {
+		t.Errorf("Expected the expired export to be regenerated, got %d generations", generated)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected the expired file to be removed, %d files left", len(entries))
+	}
+
+	if _, err := NewExportStore(ExportOptions{Encoding: "br"}); err == nil {
+		t.Error("Expected an error for an unsupported encoding")
+	}
+}
+
+func TestExportStoreInvalidate(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewExportStore(ExportOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	generated := 0
+	r := touka.New()
+	r.GET("/:key", func(c *touka.Context) {
+		store.Serve(c, c.Param("key"), "text/plain", func(w io.Writer) error {
+			generated++
+			_, err := io.WriteString(w, "report")
+			return err
+		})
+	})
+	serve := func(key string) {
+		req := httptest.NewRequest("GET", "/"+key, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve("a")
+	serve("b")
+	store.Invalidate("a")
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected Invalidate to remove the file, %d files left", len(entries))
+	}
+	serve("a")
+	serve("b")
+	if generated != 3 {
+		t.Errorf("Expected only the invalidated export to be regenerated, got %d generations", generated)
+	}
+	store.InvalidateAll()
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected InvalidateAll to remove every file, %d files left", len(entries))
+	}
+	store.Invalidate("missing")
+
+	// 生成期间被 Invalidate: 生成的文件被删除, 等待的请求重新生成
+	generated = 0
+	invalidate := true
+	r.GET("/racing/:key", func(c *touka.Context) {
+		err := store.Serve(c, c.Param("key"), "text/plain", func(w io.Writer) error {
+			generated++
+			if invalidate {
+				invalidate = false
+				store.Invalidate(c.Param("key"))
+			}
+			_, err := io.WriteString(w, "report")
+			return err
+		})
+		if err != nil {
+			c.String(http.StatusInternalServerError, "%v", err)
+		}
+	})
+	serve("racing/c")
+	if generated != 2 {
+		t.Errorf("Expected the export invalidated while generating to be regenerated, got %d generations", generated)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected the stale file to be removed, %d files left", len(entries))
+	}
+}
+
+func TestExportStoreConcurrentSweep(t *testing.T) {
+	// 极短的 TTL 使其他请求的 sweep 随时可能删除刚生成的文件
+	store, err := NewExportStore(ExportOptions{Dir: t.TempDir(), TTL: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	r := touka.New()
+	r.GET("/:key", func(c *touka.Context) {
+		err := store.Serve(c, c.Param("key"), "text/plain", func(w io.Writer) error {
+			_, err := io.WriteString(w, "report")
+			return err
+		})
+		if err != nil {
+			c.String(http.StatusInternalServerError, "%v", err)
+		}
+	})
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Go(func() {
+			for i := range 50 {
+				req := httptest.NewRequest("GET", "/"+strconv.Itoa((g+i)%3), nil)
+				req.Header.Set("Accept-Encoding", "gzip")
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				if w.Code != http.StatusOK {
+					t.Errorf("Expected 200, got %d %q", w.Code, w.Body.String())
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
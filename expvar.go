@@ -0,0 +1,31 @@
+package compress
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+)
+
+// expvarBindings 记录已发布的 expvar 名称与其当前绑定的统计。
+// expvar 不允许重复发布同名变量, 因此同名的新实例只会替换绑定, 而不会再次发布。
+var (
+	expvarMu       sync.Mutex
+	expvarBindings = map[string]*atomic.Pointer[Stats]{}
+)
+
+// publishExpvar 将统计快照以 name 发布到 expvar, /debug/vars 读取时实时生成快照
+func publishExpvar(name string, s *Stats) {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+
+	if binding, ok := expvarBindings[name]; ok {
+		binding.Store(s)
+		return
+	}
+	binding := &atomic.Pointer[Stats]{}
+	binding.Store(s)
+	expvarBindings[name] = binding
+	expvar.Publish(name, expvar.Func(func() any {
+		return binding.Load().Snapshot()
+	}))
+}
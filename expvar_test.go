@@ -0,0 +1,40 @@
+package compress
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestExpvarPublishing(t *testing.T) {
+	opts := DefaultCompressionConfig()
+	opts.ExpvarName = "compress_test_stats"
+	New(opts)
+	m := New(opts) // 同名重复创建不应 panic, 且绑定到最新实例
+
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "expvar published compression counters")
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	v := expvar.Get("compress_test_stats")
+	if v == nil {
+		t.Fatal("Expected expvar to be published")
+	}
+	var snap StatsSnapshot
+	if err := json.Unmarshal([]byte(v.String()), &snap); err != nil {
+		t.Fatal(err)
+	}
+	if snap.Encodings[EncodingGzip].Responses != 1 {
+		t.Errorf("Expected 1 gzip response via expvar, got %d", snap.Encodings[EncodingGzip].Responses)
+	}
+}
@@ -3,16 +3,26 @@ module github.com/fenthope/compress
 go 1.26
 
 require (
+	github.com/go-json-experiment/json v0.0.0-20251027170946-4849db3c2f7e
 	github.com/infinite-iroha/touka v0.4.2
 	github.com/klauspost/compress v1.18.5
+	github.com/prometheus/client_golang v1.23.2
 )
 
 require (
 	github.com/WJQSERVER-STUDIO/go-utils/iox v0.0.2 // indirect
 	github.com/WJQSERVER-STUDIO/httpc v0.8.2 // indirect
 	github.com/WJQSERVER/wanf v0.0.6 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/fenthope/reco v0.0.4 // indirect
-	github.com/go-json-experiment/json v0.0.0-20251027170946-4849db3c2f7e // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/prometheus/client_model v0.6.2 // indirect
+	github.com/prometheus/common v0.66.1 // indirect
+	github.com/prometheus/procfs v0.16.1 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	go.yaml.in/yaml/v2 v2.4.2 // indirect
 	golang.org/x/net v0.49.0 // indirect
+	golang.org/x/sys v0.40.0 // indirect
+	google.golang.org/protobuf v1.36.8 // indirect
 )
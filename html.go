@@ -0,0 +1,70 @@
+package compress
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"github.com/infinite-iroha/touka"
+)
+
+// HTML 依次执行 tpl 中名为 blocks 的模板并写入响应, 每个模板执行完后刷新压缩器与连接,
+// 浏览器可以在其余部分渲染时就开始解析已发送的部分 (如 <head> 中的样式表), 处理器无需自行调用 Flush, 例如:
+//
+//	compress.HTML(c, http.StatusOK, tpl, page, "head", "header", "content", "footer")
+//
+// blocks 为空时执行 tpl 本身, 结束时刷新一次。每次刷新都会结束当前的压缩块, 块过小会降低压缩比,
+// 应按页面的主要区域划分; 配合 FlushSize 时过小的块会合并发送。
+// 在写出任何数据前失败时与 c.HTML 一样交给引擎的错误处理以 500 响应; 响应开始后的失败只记录在 c.Errors 中。
+// 前面没有压缩中间件时同样逐块写入并刷新。
+func HTML(c *touka.Context, code int, tpl *template.Template, data any, blocks ...string) {
+	c.Writer.Header().Set(headerContentType, "text/html; charset=utf-8")
+	w := &htmlWriter{rw: c.Writer, code: code}
+
+	var err error
+	if len(blocks) == 0 {
+		err = tpl.Execute(w, data)
+		w.flush()
+	}
+	for _, name := range blocks {
+		if err = tpl.ExecuteTemplate(w, name, data); err != nil {
+			err = fmt.Errorf("failed to render HTML template '%s': %w", name, err)
+			break
+		}
+		w.flush()
+	}
+
+	switch {
+	case err == nil:
+		if !w.started {
+			c.Writer.WriteHeader(code) // 所有模板都没有输出
+		}
+	case !w.started:
+		c.AddError(err)
+		c.ErrorUseHandle(http.StatusInternalServerError, err)
+	default: // 响应已经开始, 无法再改变状态码
+		c.AddError(err)
+	}
+}
+
+// htmlWriter 在第一次写入时才写出状态码, 使第一个模板在输出前失败时仍能以 500 响应
+type htmlWriter struct {
+	rw      touka.ResponseWriter
+	code    int
+	started bool
+}
+
+func (w *htmlWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		w.rw.WriteHeader(w.code)
+	}
+	return w.rw.Write(p)
+}
+
+// flush 在已有输出时刷新压缩器与连接
+func (w *htmlWriter) flush() {
+	if w.started {
+		w.rw.Flush()
+	}
+}
@@ -0,0 +1,87 @@
+package compress
+
+import (
+	"compress/gzip"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestHTML(t *testing.T) {
+	tpl := template.Must(template.New("page").Parse(`
+{{define "head"}}<!doctype html><head><link rel="stylesheet" href="/app.css"></head>{{end}}
+{{define "content"}}<body>{{range .}}<p>{{.}}</p>{{end}}{{end}}
+{{define "footer"}}</body>{{end}}
+{{define "broken"}}{{.Missing}}{{end}}`))
+	items := make([]string, 500)
+	for i := range items {
+		items[i] = "server-side rendered item"
+	}
+
+	r := touka.New()
+	r.Use(Compression(DefaultCompressionConfig()))
+	r.GET("/", func(c *touka.Context) { HTML(c, http.StatusOK, tpl, items, "head", "content", "footer") })
+	r.GET("/broken", func(c *touka.Context) { HTML(c, http.StatusOK, tpl, items, "broken") })
+	r.GET("/late", func(c *touka.Context) { HTML(c, http.StatusOK, tpl, items, "head", "broken") })
+
+	var firstFlush []byte
+	w := &flushRecorder{ResponseRecorder: httptest.NewRecorder(), onFlush: func(body []byte) {
+		if firstFlush == nil {
+			firstFlush = append([]byte(nil), body...)
+		}
+	}}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != EncodingGzip {
+		t.Fatalf("Expected compressed page, got %d %v", w.Code, w.Header())
+	}
+	if w.flushes != 3 {
+		t.Errorf("Expected a flush per block, got %d", w.flushes)
+	}
+	// 第一次刷新时客户端已能解码出完整的 head
+	gr, err := gzip.NewReader(strings.NewReader(string(firstFlush)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, _ := io.ReadAll(gr)
+	if !strings.HasSuffix(string(head), "</head>") {
+		t.Errorf("Expected the head block after the first flush, got %q", head)
+	}
+	gr, _ = gzip.NewReader(w.Body)
+	if body, _ := io.ReadAll(gr); !strings.HasSuffix(string(body), "</p></body>") {
+		t.Errorf("Unexpected page %q", body)
+	}
+
+	req = httptest.NewRequest("GET", "/broken", nil)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 for a template failing before output, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/late", nil)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "</head>") {
+		t.Errorf("Expected the started response to be kept, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+// flushRecorder 在每次 Flush 时回调当时已写出的响应体
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes int
+	onFlush func(body []byte)
+}
+
+func (f *flushRecorder) Flush() {
+	f.flushes++
+	f.onFlush(f.Body.Bytes())
+	f.ResponseRecorder.Flush()
+}
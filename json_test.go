@@ -0,0 +1,158 @@
+package compress
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestJSON(t *testing.T) {
+	type item struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	large := make([]item, 5000) // 编码后远大于缓冲区
+	for i := range large {
+		large[i] = item{ID: i, Name: "item"}
+	}
+
+	r := touka.New()
+	r.Use(Compression(CompressOptions{
+		Algorithms:       map[string]AlgorithmConfig{EncodingGzip: {Level: 6}},
+		MinContentLength: 64,
+		DebugHeader:      true,
+	}))
+	r.GET("/small", func(c *touka.Context) { JSON(c, http.StatusOK, item{1, "a"}) })
+	r.GET("/large", func(c *touka.Context) { JSON(c, http.StatusCreated, large) })
+	r.GET("/invalid", func(c *touka.Context) { JSON(c, http.StatusOK, make(chan int)) })
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/small")
+	if got := w.Header().Get("X-Compression-Info"); got != "skipped=too_small" {
+		t.Errorf("Expected small document to be skipped by its length, got %q", got)
+	}
+	if got, want := w.Header().Get("Content-Length"), "19"; got != want {
+		t.Errorf("Expected Content-Length %s, got %q", want, got)
+	}
+	if got := w.Body.String(); got != `{"id":1,"name":"a"}` {
+		t.Errorf("Unexpected body %q", got)
+	}
+
+	w = serve("/large")
+	if w.Code != http.StatusCreated || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected compressed 201, got %d %q", w.Code, w.Header().Get("Content-Encoding"))
+	}
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+		t.Errorf("Expected JSON content type, got %q", got)
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(gr)
+	var decoded []item
+	if err := json.Unmarshal(body, &decoded); err != nil || len(decoded) != len(large) || decoded[4999] != large[4999] {
+		t.Errorf("Decoded %d items, err %v", len(decoded), err)
+	}
+
+	w = serve("/invalid")
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 for unencodable value, got %d", w.Code)
+	}
+}
+
+func TestJSONMarshalErrorReportedOnce(t *testing.T) {
+	r := touka.New()
+	var calls int
+	var errs []error
+	r.SetErrorHandler(func(c *touka.Context, code int, err error) {
+		calls++
+		errs = append(errs, c.Errors...)
+		c.String(code, "%s", err)
+	})
+	r.Use(Compression(CompressOptions{Algorithms: map[string]AlgorithmConfig{EncodingGzip: {Level: 6}}}))
+	r.GET("/", func(c *touka.Context) { JSON(c, http.StatusOK, make(chan int)) })
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %d", w.Code)
+	}
+	if calls != 1 || len(errs) != 0 {
+		t.Errorf("Expected one error handler call and no collected errors, got %d calls and %v", calls, errs)
+	}
+	if !strings.Contains(w.Body.String(), "failed to marshal JSON") {
+		t.Errorf("Expected wrapped marshal error, got %q", w.Body.String())
+	}
+}
+
+func TestJSONWithoutMiddleware(t *testing.T) {
+	r := touka.New()
+	r.GET("/", func(c *touka.Context) { JSON(c, http.StatusOK, map[string]int{"a": 1}) })
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK || w.Body.String() != `{"a":1}` {
+		t.Errorf("Expected plain JSON response, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestJSONBufferLimit(t *testing.T) {
+	doc := map[string]string{"data": strings.Repeat("x", 2000)}
+	serve := func(opts CompressOptions) *httptest.ResponseRecorder {
+		opts.Algorithms = map[string]AlgorithmConfig{EncodingGzip: {Level: 1}}
+		r := touka.New()
+		r.Use(Compression(opts))
+		r.GET("/", func(c *touka.Context) { JSON(c, http.StatusOK, doc) })
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	decode := func(t *testing.T, w *httptest.ResponseRecorder) {
+		t.Helper()
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]string
+		if err := json.NewDecoder(gr).Decode(&got); err != nil || got["data"] != doc["data"] {
+			t.Errorf("Unexpected body, err %v", err)
+		}
+	}
+
+	// 文档放得进缓冲区时按长度设置 Content-Length
+	w := serve(CompressOptions{})
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected gzip, got %v", w.Header())
+	}
+	decode(t, w)
+
+	// 超过 MaxBufferedBytes 或进程内预算耗尽时流式写出, 内容不变
+	before := bufferedBytes.Load()
+	w = serve(CompressOptions{MaxBufferedBytes: 512})
+	decode(t, w)
+
+	bufferedBytes.Add(1 << 20)
+	w = serve(CompressOptions{MaxTotalBufferedBytes: 1 << 20})
+	bufferedBytes.Add(-1 << 20)
+	decode(t, w)
+	if got := bufferedBytes.Load(); got != before {
+		t.Errorf("Expected buffered bytes to return to %d, got %d", before, got)
+	}
+}
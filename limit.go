@@ -0,0 +1,127 @@
+package compress
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/infinite-iroha/touka"
+)
+
+// acquireSlot 占用一个并发压缩名额; 名额已满时最多等待 ConcurrencyWait, 仍未取得则返回 false
+func (cfg *config) acquireSlot() bool {
+	if cfg.slots == nil {
+		return true
+	}
+	select {
+	case cfg.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if cfg.opts.ConcurrencyWait <= 0 {
+		return false
+	}
+	t := time.NewTimer(cfg.opts.ConcurrencyWait)
+	defer t.Stop()
+	select {
+	case cfg.slots <- struct{}{}:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+// releaseSlot 归还 acquireSlot 占用的名额
+func (cfg *config) releaseSlot() {
+	if cfg.slots != nil {
+		<-cfg.slots
+	}
+}
+
+// ActiveCompressions 返回当前正在进行的压缩响应数, 未设置 MaxConcurrentCompressions 时为 0
+func (m *Middleware) ActiveCompressions() int {
+	return len(m.config().slots)
+}
+
+// maxClientBuckets 是 clientLimiter 最多同时跟踪的客户端数, 防止伪造大量客户端标识耗尽内存
+const maxClientBuckets = 1 << 16
+
+// clientLimiter 以令牌桶限制每个客户端开始压缩响应的速率
+type clientLimiter struct {
+	rate  float64 // 每秒补充的令牌数
+	burst float64 // 桶容量
+	max   int     // 桶数上限
+
+	mu        sync.Mutex
+	buckets   map[string]clientBucket
+	lastSweep time.Time
+}
+
+type clientBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newClientLimiter(rate float64, burst int) *clientLimiter {
+	if burst <= 0 {
+		burst = max(int(math.Ceil(rate)), 1)
+	}
+	return &clientLimiter{rate: rate, burst: float64(burst), max: maxClientBuckets, buckets: make(map[string]clientBucket)}
+}
+
+// allow 在 key 还有令牌时消耗一个并返回 true。
+// 桶数已达上限时先提前清理; 仍没有空间则拒绝新的 key, 已跟踪的客户端不受影响。
+func (l *clientLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if ok {
+		b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	} else {
+		if len(l.buckets) >= l.max {
+			// 提前清理最多每秒一次, 避免持续的新 key 每次都遍历整个映射
+			l.sweep(now, time.Second)
+			if len(l.buckets) >= l.max {
+				return false
+			}
+		}
+		b.tokens = l.burst
+	}
+	b.last = now
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	l.buckets[key] = b
+	l.sweep(now, time.Minute)
+	return allowed
+}
+
+// sweep 移除已经补满的桶, 它们与新建的桶没有区别, 避免客户端标识无限增长。
+// 距上次清理不足 interval (且不足补满所需的时间) 时不做任何事。
+func (l *clientLimiter) sweep(now time.Time, interval time.Duration) {
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	if now.Sub(l.lastSweep) < max(refill, interval) {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// allowClient 报告该请求的客户端是否仍可开始压缩, 未设置 ClientRate 时总是 true
+func (cfg *config) allowClient(c *touka.Context) bool {
+	if cfg.clients == nil {
+		return true
+	}
+	var key string
+	if cfg.opts.ClientKey != nil {
+		key = cfg.opts.ClientKey(c)
+	} else {
+		key = c.ClientIP()
+	}
+	return cfg.clients.allow(key, time.Now())
+}
@@ -0,0 +1,149 @@
+package compress
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestMaxConcurrentCompressions(t *testing.T) {
+	m := New(CompressOptions{MaxConcurrentCompressions: 1, ConcurrencyWait: time.Millisecond})
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/slow", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.Writer.WriteHeader(http.StatusOK)
+		close(started)
+		<-unblock
+		c.Writer.Write([]byte(strings.Repeat("slow ", 100)))
+	})
+	r.GET("/fast", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", strings.Repeat("fast ", 100))
+	})
+
+	do := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	var wg sync.WaitGroup
+	var slow *httptest.ResponseRecorder
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		slow = do("/slow")
+	}()
+	<-started
+
+	if got := m.ActiveCompressions(); got != 1 {
+		t.Errorf("Expected 1 active compression, got %d", got)
+	}
+	if w := do("/fast"); w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Expected identity while at the limit, got %q", w.Header().Get("Content-Encoding"))
+	}
+	close(unblock)
+	wg.Wait()
+
+	if slow.Header().Get("Content-Encoding") != EncodingGzip {
+		t.Errorf("Expected slow response to be compressed, got %q", slow.Header().Get("Content-Encoding"))
+	}
+	if w := do("/fast"); w.Header().Get("Content-Encoding") != EncodingGzip {
+		t.Errorf("Expected gzip after the slot was released, got %q", w.Header().Get("Content-Encoding"))
+	}
+	if n := m.Stats().Snapshot().Skipped["overloaded"]; n != 1 {
+		t.Errorf("Expected 1 overloaded skip, got %d", n)
+	}
+	if got := m.ActiveCompressions(); got != 0 {
+		t.Errorf("Expected no active compressions, got %d", got)
+	}
+}
+
+func TestClientLimiter(t *testing.T) {
+	l := newClientLimiter(2, 0)
+	now := time.Unix(1000, 0)
+	if !l.allow("a", now) || !l.allow("a", now) {
+		t.Fatal("Expected burst of 2 to be allowed")
+	}
+	if l.allow("a", now) {
+		t.Error("Expected third request in the same instant to be limited")
+	}
+	if !l.allow("b", now) {
+		t.Error("Expected other clients to be unaffected")
+	}
+	if !l.allow("a", now.Add(500*time.Millisecond)) {
+		t.Error("Expected a token to be refilled after 500ms at rate 2")
+	}
+
+	// 已补满的桶在定期清理时移除
+	l.allow("c", now.Add(2*time.Minute))
+	if len(l.buckets) != 1 {
+		t.Errorf("Expected idle buckets to be swept, got %d", len(l.buckets))
+	}
+}
+
+func TestClientLimiterCap(t *testing.T) {
+	l := newClientLimiter(1, 0)
+	l.max = 3
+	now := time.Unix(1000, 0)
+	for _, key := range []string{"a", "b", "c"} {
+		if !l.allow(key, now) {
+			t.Fatalf("Expected %s to be allowed", key)
+		}
+	}
+	// 已满且没有可清理的桶: 新 key 按超限处理, 不再增长
+	if l.allow("d", now) || len(l.buckets) != 3 {
+		t.Errorf("Expected new key to be limited at the cap, have %d buckets", len(l.buckets))
+	}
+	if !l.allow("a", now.Add(time.Second)) {
+		t.Error("Expected tracked clients to keep their buckets")
+	}
+	// 其他桶补满后提前清理, 新 key 得到空间
+	if !l.allow("e", now.Add(1500*time.Millisecond)) || len(l.buckets) > 3 {
+		t.Errorf("Expected refilled buckets to be swept for a new key, have %d buckets", len(l.buckets))
+	}
+}
+
+func TestClientRate(t *testing.T) {
+	m := New(CompressOptions{
+		ClientRate:  0.001, // 测试期间不会补充
+		ClientBurst: 2,
+		ClientKey:   func(c *touka.Context) string { return c.GetReqHeader("X-Api-Token") },
+	})
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", strings.Repeat("crawl ", 100))
+	})
+
+	do := func(token string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("X-Api-Token", token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Header().Get("Content-Encoding")
+	}
+	for i, want := range []string{EncodingGzip, EncodingGzip, ""} {
+		if got := do("crawler"); got != want {
+			t.Errorf("request %d: Content-Encoding %q, want %q", i, got, want)
+		}
+	}
+	if got := do("browser"); got != EncodingGzip {
+		t.Errorf("Expected other client to be compressed, got %q", got)
+	}
+	if n := m.Stats().Snapshot().Skipped[SkipRateLimited.String()]; n != 1 {
+		t.Errorf("Expected 1 rate limited skip, got %d", n)
+	}
+}
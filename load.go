@@ -0,0 +1,367 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/flate"
+)
+
+// LoadOptions 从部署配置文件读取压缩策略, format 为 "json" 或经 RegisterOptionsFormat 注册的格式 (如 "yaml")。
+// 编码级别既可以写数字, 也可以写名称, 例如:
+//
+//	{
+//	  "preset": "balanced",
+//	  "algorithms": {"zstd": "fastest", "gzip": {"level": 6, "pool": true}},
+//	  "encoding_priority": ["zstd", "gzip"],
+//	  "min_content_length": 1024,
+//	  "concurrency_wait": "50ms"
+//	}
+//
+// 级别名称: default、fastest、best, gzip/deflate 另有 none 与 huffman, zstd 另有 better。
+// preset (speed、balanced、best) 给出基础配置, 其余字段在其上覆盖; 未知字段视为错误。
+// 回调类选项 (OnCompress、Tee 等) 无法写在文件中, 需在加载后设置。解析出的配置会经过 Validate, 有问题时一并返回。
+func LoadOptions(r io.Reader, format string) (CompressOptions, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return CompressOptions{}, fmt.Errorf("compress: reading options: %w", err)
+	}
+	format = strings.ToLower(format)
+	if format != "json" {
+		optionsFormatsMu.RLock()
+		unmarshal, ok := optionsFormats[format]
+		optionsFormatsMu.RUnlock()
+		if !ok {
+			return CompressOptions{}, fmt.Errorf("compress: unsupported options format %q", format)
+		}
+		// 先解码为通用结构, 再转为 JSON 按同一套规则解析
+		var v any
+		if err := unmarshal(data, &v); err != nil {
+			return CompressOptions{}, fmt.Errorf("compress: parsing %s options: %w", format, err)
+		}
+		if data, err = json.Marshal(v); err != nil {
+			return CompressOptions{}, fmt.Errorf("compress: converting %s options: %w", format, err)
+		}
+	}
+
+	var f optionsFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return CompressOptions{}, fmt.Errorf("compress: parsing %s options: %w", format, err)
+	}
+	opts, err := f.options()
+	if err != nil {
+		return CompressOptions{}, err
+	}
+	return opts, opts.Validate()
+}
+
+var (
+	optionsFormatsMu sync.RWMutex
+	optionsFormats   = map[string]func(data []byte, v any) error{}
+)
+
+// RegisterOptionsFormat 为 LoadOptions 注册一种配置格式, unmarshal 需能把数据解码到 *any
+// (得到 map[string]any 形式的通用结构), 例如:
+//
+//	compress.RegisterOptionsFormat("yaml", yaml.Unmarshal) // gopkg.in/yaml.v3
+//	compress.RegisterOptionsFormat("toml", toml.Unmarshal) // github.com/BurntSushi/toml
+//
+// 包本身只内置 JSON, 以免为配置文件引入额外依赖。
+func RegisterOptionsFormat(format string, unmarshal func(data []byte, v any) error) {
+	optionsFormatsMu.Lock()
+	defer optionsFormatsMu.Unlock()
+	optionsFormats[strings.ToLower(format)] = unmarshal
+}
+
+// optionsFile 是配置文件的结构, 指针字段为 nil 表示未设置
+type optionsFile struct {
+	Preset                    string                     `json:"preset"`
+	Algorithms                map[string]json.RawMessage `json:"algorithms"`
+	MinContentLength          *int64                     `json:"min_content_length"`
+	MaxContentLength          *int64                     `json:"max_content_length"`
+	CompressibleTypes         []string                   `json:"compressible_types"`
+	RsyncableTypes            []string                   `json:"rsyncable_types"`
+	EncodingPriority          []string                   `json:"encoding_priority"`
+	EncodingWeights           map[string]int             `json:"encoding_weights"`
+	ExpvarName                *string                    `json:"expvar_name"`
+	ServerTiming              *bool                      `json:"server_timing"`
+	DebugHeader               *bool                      `json:"debug_header"`
+	CompressionTrailers       *bool                      `json:"compression_trailers"`
+	AcceptRanges              *string                    `json:"accept_ranges"`
+	LogLevel                  *string                    `json:"log_level"`
+	LogSampleRate             *float64                   `json:"log_sample_rate"`
+	MaxPoolMemory             *int64                     `json:"max_pool_memory"`
+	MaxBufferedBytes          *int64                     `json:"max_buffered_bytes"`
+	MaxTotalBufferedBytes     *int64                     `json:"max_total_buffered_bytes"`
+	MaxConcurrentCompressions *int                       `json:"max_concurrent_compressions"`
+	ConcurrencyWait           *string                    `json:"concurrency_wait"`
+	ClientRate                *float64                   `json:"client_rate"`
+	ClientBurst               *int                       `json:"client_burst"`
+	AsyncWorkers              json.RawMessage            `json:"async_workers"` // 数字或 "auto"
+	AsyncQueue                *int                       `json:"async_queue"`
+	MaxOutputBytes            *int64                     `json:"max_output_bytes"`
+	WriteTimeout              *string                    `json:"write_timeout"`
+	FlushSize                 *int                       `json:"flush_size"`
+	FlushMaxDelay             *string                    `json:"flush_max_delay"`
+	Padding                   *string                    `json:"padding"`
+	PaddingMax                *int                       `json:"padding_max"`
+	StrictNegotiation         *bool                      `json:"strict_negotiation"`
+	StripAcceptEncoding       *bool                      `json:"strip_accept_encoding"`
+	StrictValidation          *bool                      `json:"strict_validation"`
+}
+
+// algorithmFile 是 algorithms 中单个编码的完整写法
+type algorithmFile struct {
+	Level       json.RawMessage `json:"level"`
+	Pool        *bool           `json:"pool"`
+	PoolType    string          `json:"pool_type"`
+	MaxIdle     int             `json:"max_idle"`
+	Prewarm     json.RawMessage `json:"prewarm_pool_size"` // 数字或 "auto"
+	Concurrency int             `json:"concurrency"`
+	LowMemory   bool            `json:"low_memory"`
+	MinLength   int64           `json:"min_content_length"`
+	SizeTiers   []sizeTierFile  `json:"size_tiers"`
+	Streaming   json.RawMessage `json:"streaming_level"`
+	PreferAbove int64           `json:"prefer_above"`
+}
+
+// sizeTierFile 是配置文件中的档位, 级别同样可以写名称
+type sizeTierFile struct {
+	MaxLength int64           `json:"max_length"`
+	Level     json.RawMessage `json:"level"`
+}
+
+func (f *optionsFile) options() (CompressOptions, error) {
+	var o CompressOptions
+	switch strings.ToLower(f.Preset) {
+	case "":
+	case "speed":
+		o = PresetSpeed()
+	case "balanced":
+		o = PresetBalanced()
+	case "best", "best_compression":
+		o = PresetBestCompression()
+	default:
+		return o, fmt.Errorf("compress: unknown preset %q", f.Preset)
+	}
+
+	if f.Algorithms != nil {
+		o.Algorithms = make(map[string]AlgorithmConfig, len(f.Algorithms))
+		for name, raw := range f.Algorithms {
+			ac, err := parseAlgorithm(name, raw)
+			if err != nil {
+				return o, err
+			}
+			o.Algorithms[name] = ac
+		}
+	}
+	if f.CompressibleTypes != nil {
+		o.CompressibleTypes = f.CompressibleTypes
+	}
+	if f.RsyncableTypes != nil {
+		o.RsyncableTypes = f.RsyncableTypes
+	}
+	if f.EncodingPriority != nil {
+		o.EncodingPriority = f.EncodingPriority
+	}
+	if f.EncodingWeights != nil {
+		o.EncodingWeights = f.EncodingWeights
+	}
+	set(&o.MinContentLength, f.MinContentLength)
+	set(&o.MaxContentLength, f.MaxContentLength)
+	set(&o.ExpvarName, f.ExpvarName)
+	set(&o.ServerTiming, f.ServerTiming)
+	set(&o.DebugHeader, f.DebugHeader)
+	set(&o.CompressionTrailers, f.CompressionTrailers)
+	set(&o.LogSampleRate, f.LogSampleRate)
+	set(&o.MaxPoolMemory, f.MaxPoolMemory)
+	set(&o.MaxBufferedBytes, f.MaxBufferedBytes)
+	set(&o.MaxTotalBufferedBytes, f.MaxTotalBufferedBytes)
+	set(&o.MaxConcurrentCompressions, f.MaxConcurrentCompressions)
+	set(&o.ClientRate, f.ClientRate)
+	set(&o.ClientBurst, f.ClientBurst)
+	set(&o.AsyncQueue, f.AsyncQueue)
+	set(&o.MaxOutputBytes, f.MaxOutputBytes)
+	set(&o.FlushSize, f.FlushSize)
+	set(&o.PaddingMax, f.PaddingMax)
+	set(&o.StrictNegotiation, f.StrictNegotiation)
+	set(&o.StripAcceptEncoding, f.StripAcceptEncoding)
+	set(&o.StrictValidation, f.StrictValidation)
+
+	var err error
+	if f.AcceptRanges != nil {
+		if o.AcceptRanges, err = parseName[AcceptRangesPolicy](*f.AcceptRanges, "accept_ranges", acceptRangesPolicyNames[:]); err != nil {
+			return o, err
+		}
+	}
+	if f.Padding != nil {
+		if o.Padding, err = parseName[PaddingMode](*f.Padding, "padding", []string{"off", "auto", "header"}); err != nil {
+			return o, err
+		}
+	}
+	if f.LogLevel != nil {
+		switch strings.ToLower(*f.LogLevel) {
+		case "error":
+			o.LogLevel = LogLevelError
+		case "warn":
+			o.LogLevel = LogLevelWarn
+		case "debug":
+			o.LogLevel = LogLevelDebug
+		case "off":
+			o.LogLevel = LogLevelOff
+		default:
+			return o, fmt.Errorf("compress: unknown log_level %q", *f.LogLevel)
+		}
+	}
+	if f.ConcurrencyWait != nil {
+		if o.ConcurrencyWait, err = time.ParseDuration(*f.ConcurrencyWait); err != nil {
+			return o, fmt.Errorf("compress: concurrency_wait: %w", err)
+		}
+	}
+	if f.WriteTimeout != nil {
+		if o.WriteTimeout, err = time.ParseDuration(*f.WriteTimeout); err != nil {
+			return o, fmt.Errorf("compress: write_timeout: %w", err)
+		}
+	}
+	if f.FlushMaxDelay != nil {
+		if o.FlushMaxDelay, err = time.ParseDuration(*f.FlushMaxDelay); err != nil {
+			return o, fmt.Errorf("compress: flush_max_delay: %w", err)
+		}
+	}
+	if f.AsyncWorkers != nil {
+		if o.AsyncWorkers, err = parseCount(f.AsyncWorkers); err != nil {
+			return o, fmt.Errorf("compress: async_workers: %w", err)
+		}
+	}
+	return o, nil
+}
+
+// set 在 v 非 nil 时把它的值写入 dst
+func set[T any](dst *T, v *T) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+// parseName 把名称解析为其在 names 中的下标, 用于各类枚举选项
+func parseName[T ~uint8 | ~int](s, field string, names []string) (T, error) {
+	for i, name := range names {
+		if strings.EqualFold(s, name) {
+			return T(i), nil
+		}
+	}
+	return 0, fmt.Errorf("compress: unknown %s %q", field, s)
+}
+
+// parseCount 解析数字或 "auto"
+func parseCount(raw json.RawMessage) (int, error) {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		if strings.EqualFold(s, "auto") {
+			return Auto, nil
+		}
+		return strconv.Atoi(s)
+	}
+	var n int
+	err := json.Unmarshal(raw, &n)
+	return n, err
+}
+
+// parseAlgorithm 解析单个编码的配置: 可以只写级别, 也可以写完整的对象。
+// 只写级别时, 该级别有对象池即启用对象池。
+func parseAlgorithm(name string, raw json.RawMessage) (AlgorithmConfig, error) {
+	if _, ok := knownCodec(name); !ok {
+		return AlgorithmConfig{}, fmt.Errorf("compress: unknown encoding %q in algorithms", name)
+	}
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) == 0 || trimmed[0] != '{' {
+		level, err := parseLevel(name, raw)
+		if err != nil {
+			return AlgorithmConfig{}, err
+		}
+		return AlgorithmConfig{Level: level, PoolEnabled: hasPool(name, level)}, nil
+	}
+
+	var af algorithmFile
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&af); err != nil {
+		return AlgorithmConfig{}, fmt.Errorf("compress: %s: %w", name, err)
+	}
+	ac := AlgorithmConfig{MaxIdle: af.MaxIdle, Concurrency: af.Concurrency, LowMemory: af.LowMemory, MinContentLength: af.MinLength, PreferAbove: af.PreferAbove}
+	var err error
+	if af.Level == nil {
+		c, _ := knownCodec(name)
+		ac.Level = c.DefaultLevel
+	} else if ac.Level, err = parseLevel(name, af.Level); err != nil {
+		return ac, err
+	}
+	if af.Streaming != nil {
+		if ac.StreamingLevel, err = parseLevel(name, af.Streaming); err != nil {
+			return ac, err
+		}
+	}
+	for _, t := range af.SizeTiers {
+		level, err := parseLevel(name, t.Level)
+		if err != nil {
+			return ac, err
+		}
+		ac.SizeTiers = append(ac.SizeTiers, SizeTier{MaxLength: t.MaxLength, Level: level})
+	}
+	ac.PoolEnabled = hasPool(name, ac.Level)
+	set(&ac.PoolEnabled, af.Pool)
+	if af.PoolType != "" {
+		if ac.PoolType, err = parseName[PoolType](af.PoolType, name+" pool_type", []string{"sync", "bounded"}); err != nil {
+			return ac, err
+		}
+	}
+	if af.Prewarm != nil {
+		if ac.PrewarmPoolSize, err = parseCount(af.Prewarm); err != nil {
+			return ac, fmt.Errorf("compress: %s prewarm_pool_size: %w", name, err)
+		}
+	}
+	return ac, nil
+}
+
+// levelNames 是各编码可用的级别名称
+var levelNames = map[string]map[string]int{
+	EncodingGzip: {
+		"default": flate.DefaultCompression, "fastest": flate.BestSpeed, "best": flate.BestCompression,
+		"none": flate.NoCompression, "huffman": flate.HuffmanOnly,
+	},
+	EncodingDeflate: {
+		"default": flate.DefaultCompression, "fastest": flate.BestSpeed, "best": flate.BestCompression,
+		"none": flate.NoCompression, "huffman": flate.HuffmanOnly,
+	},
+	// zstd 的名称对应 zstd.EncoderLevelFromZstd 的各档, best 与 PresetBestCompression 一致
+	EncodingZstd: {"default": zstdDefaultLevel, "fastest": 1, "better": 7, "best": 19},
+}
+
+var errLevelSyntax = errors.New("level must be a number or a name")
+
+// parseLevel 解析数字或名称形式的级别
+func parseLevel(encoding string, raw json.RawMessage) (int, error) {
+	var level int
+	if json.Unmarshal(raw, &level) == nil {
+		return level, nil
+	}
+	var s string
+	if json.Unmarshal(raw, &s) != nil {
+		return 0, fmt.Errorf("compress: %s: %w", encoding, errLevelSyntax)
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, nil
+	}
+	if level, ok := levelNames[encoding][strings.ToLower(s)]; ok {
+		return level, nil
+	}
+	return 0, fmt.Errorf("compress: unknown %s level %q", encoding, s)
+}
@@ -0,0 +1,84 @@
+package compress
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadOptions(t *testing.T) {
+	opts, err := LoadOptions(strings.NewReader(`{
+		"preset": "speed",
+		"algorithms": {
+			"zstd": "fastest",
+			"gzip": {"level": "best", "pool_type": "bounded", "max_idle": 8, "size_tiers": [{"max_length": 8192, "level": "fastest"}]},
+			"deflate": 5
+		},
+		"encoding_priority": ["zstd", "gzip", "deflate"],
+		"concurrency_wait": "50ms",
+		"max_concurrent_compressions": 32,
+		"async_workers": "auto",
+		"accept_ranges": "keep",
+		"log_level": "warn"
+	}`), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := opts.Algorithms[EncodingZstd]; got.Level != 1 || got.PoolEnabled {
+		t.Errorf("zstd = %+v, want level 1 without pool", got)
+	}
+	if got := opts.Algorithms[EncodingGzip]; got.Level != 9 || !got.PoolEnabled || got.PoolType != PoolBounded || got.MaxIdle != 8 ||
+		len(got.SizeTiers) != 1 || got.SizeTiers[0] != (SizeTier{MaxLength: 8192, Level: 1}) {
+		t.Errorf("gzip = %+v", got)
+	}
+	if got := opts.Algorithms[EncodingDeflate]; got.Level != 5 || !got.PoolEnabled {
+		t.Errorf("deflate = %+v", got)
+	}
+	if opts.MinContentLength != 1024 {
+		t.Errorf("Expected preset MinContentLength to be kept, got %d", opts.MinContentLength)
+	}
+	if opts.ConcurrencyWait != 50*time.Millisecond || opts.AsyncWorkers != Auto || opts.AcceptRanges != AcceptRangesKeep || opts.LogLevel != LogLevelWarn {
+		t.Errorf("Unexpected options %+v", opts)
+	}
+
+	for _, tt := range []struct{ doc, want string }{
+		{`{"algorithms": {"zstd": "turbo"}}`, `unknown zstd level "turbo"`},
+		{`{"algorithms": {"br": 4}}`, `unknown encoding "br"`},
+		{`{"min_content_lenght": 10}`, "unknown field"},
+		{`{"preset": "fast"}`, `unknown preset "fast"`},
+		{`{"algorithms": {"gzip": 12}}`, "gzip level 12 out of range"},
+	} {
+		if _, err := LoadOptions(strings.NewReader(tt.doc), "json"); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("LoadOptions(%s) = %v, want error containing %q", tt.doc, err, tt.want)
+		}
+	}
+
+	if _, err := LoadOptions(strings.NewReader(""), "ini"); err == nil {
+		t.Error("Expected unregistered format to fail")
+	}
+}
+
+func TestRegisterOptionsFormat(t *testing.T) {
+	// 以 "key=value" 行模拟第三方格式, 解码为通用结构
+	RegisterOptionsFormat("KV", func(data []byte, v any) error {
+		m := map[string]any{}
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			k, val, _ := strings.Cut(line, "=")
+			var decoded any
+			if json.Unmarshal([]byte(val), &decoded) != nil {
+				decoded = val
+			}
+			m[strings.TrimSpace(k)] = decoded
+		}
+		*(v.(*any)) = m
+		return nil
+	})
+	opts, err := LoadOptions(strings.NewReader("preset=best\nmin_content_length=2048\npadding=auto"), "kv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.MinContentLength != 2048 || opts.Padding != PaddingAuto || opts.Algorithms[EncodingGzip].Level != 9 {
+		t.Errorf("Unexpected options %+v", opts)
+	}
+}
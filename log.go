@@ -0,0 +1,74 @@
+package compress
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+
+	"github.com/infinite-iroha/touka"
+)
+
+// LogLevel 控制中间件通过 touka 日志记录的详细程度
+type LogLevel int
+
+const (
+	// LogLevelError 只记录压缩器的错误 (默认)
+	LogLevelError LogLevel = iota
+	// LogLevelWarn 额外记录配置问题与协商异常
+	LogLevelWarn
+	// LogLevelDebug 额外记录每一次压缩决策
+	LogLevelDebug
+
+	// LogLevelOff 关闭中间件的日志
+	LogLevelOff LogLevel = -1
+)
+
+// logs 报告配置的 LogLevel 是否记录 level 级别的日志。
+// 热路径上应先检查, 以免构造参数时产生分配。
+func (m *Middleware) logs(level LogLevel) bool {
+	o := &m.config().opts
+	return o.LogLevel != LogLevelOff && level <= o.LogLevel
+}
+
+// logf 以请求上下文记录一条日志; 级别高于配置的 LogLevel 或引擎未配置日志时忽略
+func (m *Middleware) logf(c *touka.Context, level LogLevel, format string, args ...any) {
+	if !m.logs(level) || c == nil || c.GetLogger() == nil {
+		return
+	}
+	args = append([]any{c.Request.Method, c.Request.URL.Path}, args...)
+	format = "compress: %s %s: " + format
+	switch level {
+	case LogLevelError:
+		c.Errorf(format, args...)
+	case LogLevelWarn:
+		c.Warnf(format, args...)
+	default:
+		c.Debugf(format, args...)
+	}
+}
+
+// warnOnce 对每个 flag 只记录一次警告, 避免配置问题在每个请求上刷屏
+func (m *Middleware) warnOnce(flag *atomic.Bool, c *touka.Context, format string, args ...any) {
+	if flag == nil || flag.Load() || !flag.CompareAndSwap(false, true) {
+		return
+	}
+	m.logf(c, LogLevelWarn, format, args...)
+}
+
+// sampled 按 LogSampleRate 决定本次请求的压缩决策是否记录日志
+func (m *Middleware) sampled() bool {
+	o := &m.config().opts
+	rate := o.LogSampleRate
+	if rate <= 0 || o.LogLevel == LogLevelOff {
+		return false
+	}
+	return rate >= 1 || rand.Float64() < rate
+}
+
+// logSample 记录一条抽样的压缩决策日志, 以 Info 级别输出, 不受 LogLevel 限制 (LogLevelOff 除外)
+func (m *Middleware) logSample(c *touka.Context, format string, args ...any) {
+	if c == nil || c.GetLogger() == nil {
+		return
+	}
+	args = append([]any{c.Request.Method, c.Request.URL.Path}, args...)
+	c.Infof("compress: %s %s: "+format, args...)
+}
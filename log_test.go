@@ -0,0 +1,78 @@
+package compress
+
+import (
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestLoggingLevels(t *testing.T) {
+	for _, level := range []LogLevel{LogLevelOff, LogLevelError, LogLevelWarn, LogLevelDebug} {
+		m := New(CompressOptions{
+			Algorithms: map[string]AlgorithmConfig{
+				// HuffmanOnly 级别没有对象池, 这里会触发一次池配置警告
+				EncodingGzip: {Level: gzip.HuffmanOnly, PoolEnabled: true},
+			},
+			LogLevel: level,
+		})
+		r := touka.New()
+		r.Use(m.Handler())
+		r.GET("/", func(c *touka.Context) {
+			c.Header("Content-Type", "text/plain")
+			c.String(http.StatusOK, "logged compression decisions")
+		})
+
+		for _, ae := range []string{"gzip", "gzip", "br, identity;q=0", "deflate"} {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", ae)
+			r.ServeHTTP(httptest.NewRecorder(), req)
+		}
+
+		if !m.warnedPool[EncodingGzip].Load() && level >= LogLevelWarn {
+			t.Errorf("Expected gzip pool warning to be recorded at level %d", level)
+		}
+	}
+}
+
+func TestLogSampleRate(t *testing.T) {
+	for _, tt := range []struct {
+		rate  float64
+		level LogLevel
+		want  bool
+	}{
+		{0, LogLevelError, false},
+		{1, LogLevelError, true},
+		{2, LogLevelDebug, true},
+		{1, LogLevelOff, false},
+	} {
+		m := New(CompressOptions{LogSampleRate: tt.rate, LogLevel: tt.level})
+		if got := m.sampled(); got != tt.want {
+			t.Errorf("sampled() with rate %v level %d = %v, want %v", tt.rate, tt.level, got, tt.want)
+		}
+	}
+
+	// 抽样记录覆盖压缩、跳过与协商失败三条路径
+	m := New(CompressOptions{LogSampleRate: 1})
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "sampled decision")
+	})
+	r.GET("/bin", func(c *touka.Context) {
+		c.Header("Content-Type", "application/octet-stream")
+		c.String(http.StatusOK, "sampled skip")
+	})
+	for _, tc := range []struct{ path, ae string }{{"/", "gzip"}, {"/bin", "gzip"}, {"/", ""}} {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		req.Header.Set("Accept-Encoding", tc.ae)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected 200 for %s, got %d", tc.path, w.Code)
+		}
+	}
+}
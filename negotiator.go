@@ -0,0 +1,137 @@
+package compress
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+var errWriterClosed = errors.New("compress: write to closed Writer")
+
+// Negotiator 把中间件的编码协商与压缩器对象池用于 HTTP 之外的场景,
+// 如消息队列、由 gRPC 元数据决定编码的负载或文件导出:
+//
+//	n, _ := compress.NewNegotiator(opts)
+//	w, _ := n.NewWriter(n.Negotiate(peerAccept), dst)
+//	w.Write(payload)
+//	w.Close()
+//
+// 协商规则、级别与对象池 (包括有界池与预热) 与使用相同 opts 的中间件一致, 压缩器与中间件共享进程内的对象池。
+// 响应相关的选项 (可压缩类型、最小长度、并发与限速等) 对 Negotiator 不生效。Negotiator 可以并发使用。
+type Negotiator struct {
+	plan *plan
+}
+
+// NewNegotiator 按 opts 创建 Negotiator, opts 未通过 Validate 时返回该错误
+func NewNegotiator(opts CompressOptions) (*Negotiator, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	opts = withDefaults(opts)
+	p := compilePlan(&opts)
+	p.preparePools()
+	return &Negotiator{plan: p}, nil
+}
+
+// Encodings 按优先级返回已配置的编码
+func (n *Negotiator) Encodings() []string { return slices.Clone(n.plan.names) }
+
+// Negotiate 按与 Accept-Encoding 格式相同的 accept 选择编码, 不压缩时返回 ""
+func (n *Negotiator) Negotiate(accept string) string {
+	if ep, _ := n.plan.negotiate(accept); ep != nil {
+		return ep.name
+	}
+	return ""
+}
+
+// NewWriter 返回以 encoding 压缩后写入 w 的 Writer; encoding 为 "" 或 identity 时原样写入。
+// encoding 未配置时返回错误。
+func (n *Negotiator) NewWriter(encoding string, w io.Writer) (*Writer, error) {
+	if encoding == "" || encoding == EncodingIdentity {
+		return &Writer{w: w}, nil
+	}
+	ep := n.plan.lookup(encoding)
+	if ep == nil {
+		return nil, fmt.Errorf("compress: encoding %q is not configured", encoding)
+	}
+	nw := &Writer{w: w, codec: ep}
+	switch {
+	case ep.bounded != nil:
+		nw.cw, nw.pool = ep.bounded.get(), ep.bounded
+		nw.cw.Reset(w)
+	case encoding == EncodingZstd && ep.cfg.zstdCustom():
+		nw.cw = newZstdCompressor(ep.cfg.Level, ep.cfg, w)
+	default:
+		nw.cw, nw.pool = getCompressor(encoding, ep.cfg.Level, w, ep.pooled)
+	}
+	if nw.cw == nil {
+		return nil, fmt.Errorf("compress: %s level %d: %w", encoding, ep.cfg.Level, errEncoderUnavailable)
+	}
+	return nw, nil
+}
+
+// Writer 是 Negotiator 创建的压缩写入器, 不可并发使用。
+// 必须调用 Close 结束压缩流并归还压缩器; Close 不会关闭底层的 io.Writer。
+type Writer struct {
+	w      io.Writer
+	cw     compressWriter // 为 nil 时原样写入 w
+	codec  *encodingPlan
+	pool   *encoderPool // 压缩器取自的对象池, 不经对象池时为 nil
+	failed bool         // 压缩器出过错, 不再归还对象池
+	closed bool
+}
+
+// Encoding 返回写入器使用的编码, 原样写入时返回 ""
+func (w *Writer) Encoding() string {
+	if w.codec == nil {
+		return ""
+	}
+	return w.codec.name
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errWriterClosed
+	}
+	if w.cw == nil {
+		return w.w.Write(p)
+	}
+	n, err := w.cw.Write(p)
+	if err != nil {
+		w.failed = true
+	}
+	return n, err
+}
+
+// Flush 把已写入的数据压缩后全部写出, 接收方无需等到 Close 即可解码
+func (w *Writer) Flush() error {
+	if w.closed || w.cw == nil {
+		return nil
+	}
+	err := w.cw.Flush()
+	if err != nil {
+		w.failed = true
+	}
+	return err
+}
+
+// Close 结束压缩流并归还压缩器, 重复调用返回 nil
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.cw == nil {
+		return nil
+	}
+	err := w.cw.Close()
+	if err != nil || w.failed {
+		// 压缩器可能停在出错的状态, 不再复用
+		w.pool.discard()
+	} else {
+		w.pool.put(w.cw)
+	}
+	w.cw = nil
+	return err
+}
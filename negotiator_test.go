@@ -0,0 +1,86 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiator(t *testing.T) {
+	skipWithoutZstd(t)
+	n, err := NewNegotiator(CompressOptions{
+		Algorithms: map[string]AlgorithmConfig{
+			EncodingZstd:    {Level: zstdDefaultLevel, PoolEnabled: true},
+			EncodingGzip:    {Level: 6, PoolEnabled: true, PoolType: PoolBounded, MaxIdle: 2},
+			EncodingDeflate: {Level: 1},
+		},
+		EncodingPriority: []string{EncodingZstd, EncodingGzip, EncodingDeflate},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := n.Encodings(); strings.Join(got, ",") != "zstd,gzip,deflate" {
+		t.Errorf("Unexpected encodings %v", got)
+	}
+	for accept, want := range map[string]string{
+		"gzip, zstd": EncodingZstd,
+		"gzip":       EncodingGzip,
+		"br":         "",
+		"":           "",
+	} {
+		if got := n.Negotiate(accept); got != want {
+			t.Errorf("Negotiate(%q) = %q, want %q", accept, got, want)
+		}
+	}
+
+	payload := []byte(strings.Repeat("negotiated payload ", 200))
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"":              func(r io.Reader) (io.Reader, error) { return r, nil },
+		EncodingGzip:    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		EncodingDeflate: func(r io.Reader) (io.Reader, error) { return flate.NewReader(r), nil },
+		EncodingZstd:    func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+	}
+	for encoding, decode := range decoders {
+		for range 2 { // 第二次使用归还的压缩器
+			var buf bytes.Buffer
+			w, err := n.NewWriter(encoding, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w.Encoding() != encoding {
+				t.Errorf("Encoding() = %q, want %q", w.Encoding(), encoding)
+			}
+			w.Write(payload[:100])
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			w.Write(payload[100:])
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(payload); !errors.Is(err, errWriterClosed) {
+				t.Errorf("%q: expected error writing after Close, got %v", encoding, err)
+			}
+			r, err := decode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := io.ReadAll(r); !bytes.Equal(got, payload) {
+				t.Errorf("%q: round trip mismatch (%d bytes)", encoding, len(got))
+			}
+		}
+	}
+
+	if _, err := n.NewWriter("br", io.Discard); err == nil {
+		t.Error("Expected error for unconfigured encoding")
+	}
+	if _, err := NewNegotiator(CompressOptions{Algorithms: map[string]AlgorithmConfig{EncodingGzip: {Level: 42}}}); err == nil {
+		t.Error("Expected invalid options to be rejected")
+	}
+}
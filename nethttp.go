@@ -0,0 +1,110 @@
+package compress
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/infinite-iroha/touka"
+)
+
+// Handler 返回标准 net/http 中间件, 与 Compression 使用相同的协商、对象池与响应包装, 例如:
+//
+//	mux.Handle("/metrics", compress.Handler(opts)(promhttp.Handler()))
+//
+// 等价于 New(opts).HTTPHandler。
+func Handler(opts CompressOptions) func(next http.Handler) http.Handler {
+	return New(opts).HTTPHandler
+}
+
+// HTTPHandler 以该实例包装标准的 http.Handler, 适用于与 touka 混用的 net/http 服务 (如 pprof、metrics)。
+// 每个被包装的 handler 持有一个只含本中间件的内部 touka 引擎, 回调中的 *touka.Context 由它创建;
+// 按客户端限速等依赖 c.ClientIP() 的功能使用 touka 的默认设置。
+func (m *Middleware) HTTPHandler(next http.Handler) http.Handler {
+	e := touka.New()
+	e.SetHandleMethodNotAllowed(false)
+	e.Use(m.Handler())
+	// 内部引擎没有路由, 所有请求都经中间件后落到 NoRoute
+	e.NoRoute(func(c *touka.Context) {
+		next.ServeHTTP(c.Writer, c.Request)
+		c.Abort() // 不再进入默认的 404 处理
+	})
+	return e
+}
+
+// Wrap 在中间件之外用本实例的压缩响应包装 w, 写入路径与中间件处理的响应完全相同,
+// 便于在单元测试中直接检查 httptest.ResponseRecorder, 或在非 touka 代码中手动包装。
+// 编码按 r 的 Accept-Encoding 协商; 不压缩时返回的 writer 原样写入 w。
+//
+// 写完响应后必须调用 finish, 它关闭压缩器、写出剩余数据 (包括压缩流的结尾)、记录统计并把压缩器归还到对象池;
+// 不调用 finish 时响应不完整, 压缩器与它占用的池内存预算也不会归还。重复调用 finish 无效果,
+// 之后不应再使用返回的 writer。StrictNegotiation 对 Wrap 不生效, 无法协商时总是以 identity 发送。
+//
+// Wrap 不启动 goroutine: 回调中的 *touka.Context 在调用方的 goroutine 中取自本实例的内部 touka 引擎
+// (所有 Wrap 调用共用, 没有路由与中间件), 不再归还给引擎, 随返回的 writer 一起被回收。
+func (m *Middleware) Wrap(w http.ResponseWriter, r *http.Request) (writer http.ResponseWriter, finish func()) {
+	c := m.leaseContext(w, r)
+	var once sync.Once
+	release := func(f func()) func() {
+		return func() { once.Do(f) }
+	}
+
+	if m.disabled.Load() {
+		return c.Writer, release(func() {})
+	}
+	cfg := m.config()
+	reason := SkipNone
+	codec, chosenEncoding := cfg.plan.negotiate(r.Header.Get(headerAcceptEncoding))
+	if !cfg.allowMethod(r.Method) {
+		reason = SkipMethod
+	} else if codec == nil {
+		reason = SkipNotAccepted
+	}
+	if reason != SkipNone {
+		m.stats.recordSkip(reason)
+		return c.Writer, release(func() {
+			if cfg.opts.OnSkip != nil {
+				cfg.opts.OnSkip(reason, c)
+			}
+		})
+	}
+
+	crw := acquireCompressResponseWriter(c, m, cfg)
+	crw.chosenEncoding = chosenEncoding
+	crw.codec = codec
+	crw.applyHeaderProfile()
+	c.Writer = crw
+	return crw, release(func() { releaseCompressResponseWriter(crw) })
+}
+
+// wrapLease 是内部引擎的处理函数交出 *touka.Context 时使用的 panic 值。
+// touka 不导出从引擎的池中取得 Context 的方法, 只能经 ServeHTTP 取得;
+// 处理函数以 panic 离开 ServeHTTP, 使引擎不会把仍在使用的 Context 放回池中。
+type wrapLease struct{ c *touka.Context }
+
+// leaseContext 在调用方的 goroutine 中从内部引擎取得一个以 w 与 r 初始化的 *touka.Context
+func (m *Middleware) leaseContext(w http.ResponseWriter, r *http.Request) (c *touka.Context) {
+	defer func() {
+		v := recover()
+		lease, ok := v.(wrapLease)
+		if !ok {
+			panic(v)
+		}
+		c = lease.c
+	}()
+	m.wrapEngine().ServeHTTP(w, r)
+	panic("compress: internal engine returned without a context")
+}
+
+// wrapEngine 返回 Wrap 使用的内部 touka 引擎, 首次调用时创建
+func (m *Middleware) wrapEngine() *touka.Engine {
+	m.wrapOnce.Do(func() {
+		e := touka.New()
+		e.SetHandleMethodNotAllowed(false)
+		e.RedirectTrailingSlash = false
+		e.RedirectFixedPath = false
+		// 内部引擎没有路由, 所有请求都落到 NoRoute
+		e.NoRoute(func(c *touka.Context) { panic(wrapLease{c}) })
+		m.wrapEng = e
+	})
+	return m.wrapEng
+}
@@ -0,0 +1,162 @@
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	body := strings.Repeat("net/http ", 100)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/text", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, body)
+		if f, ok := w.(http.Flusher); !ok {
+			t.Error("Expected wrapped writer to implement http.Flusher")
+		} else {
+			f.Flush()
+		}
+	})
+	h := Handler(CompressOptions{})(mux)
+
+	req := httptest.NewRequest("POST", "/text", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != EncodingGzip {
+		t.Fatalf("Expected gzip response, got %d %q", w.Code, w.Header().Get("Content-Encoding"))
+	}
+	if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("Expected Vary: Accept-Encoding, got %q", got)
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(gr); string(got) != body {
+		t.Errorf("Unexpected body %q", got)
+	}
+
+	// 未协商压缩时原样透传
+	req = httptest.NewRequest("GET", "/text", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != body {
+		t.Errorf("Expected identity response, got %q", w.Header().Get("Content-Encoding"))
+	}
+
+	// 下游的 404 不被内部引擎替换
+	req = httptest.NewRequest("GET", "/missing", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 from the mux, got %d", w.Code)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	m := New(CompressOptions{})
+	body := strings.Repeat("wrapped ", 100)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	w, finish := m.Wrap(rec, req)
+	w.Header().Set("Content-Type", "text/plain")
+	io.WriteString(w, body)
+	finish()
+	finish() // 重复调用无效果
+
+	if rec.Header().Get("Content-Encoding") != EncodingGzip {
+		t.Fatalf("Expected gzip, got %q", rec.Header().Get("Content-Encoding"))
+	}
+	gr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(gr); string(got) != body {
+		t.Errorf("Unexpected body %q", got)
+	}
+	if n := m.Stats().Snapshot().Total.Responses; n != 1 {
+		t.Errorf("Expected 1 compressed response in stats, got %d", n)
+	}
+
+	rec = httptest.NewRecorder()
+	w, finish = m.Wrap(rec, httptest.NewRequest("GET", "/", nil))
+	w.Header().Set("Content-Type", "text/plain")
+	io.WriteString(w, body)
+	finish()
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != body {
+		t.Errorf("Expected identity response without Accept-Encoding, got %q", rec.Header().Get("Content-Encoding"))
+	}
+
+	// 所有调用共用一个内部引擎, 并发调用各自取得独立的 Context
+	e := m.wrapEng
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+			w, finish := m.Wrap(rec, req)
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, body)
+			finish()
+			if rec.Header().Get("Content-Encoding") != EncodingGzip {
+				t.Errorf("Expected gzip, got %q", rec.Header().Get("Content-Encoding"))
+			}
+		}()
+	}
+	wg.Wait()
+	if e == nil || m.wrapEng != e {
+		t.Error("Expected Wrap to reuse one internal engine")
+	}
+}
+
+func TestWrapNoGoroutines(t *testing.T) {
+	m := New(CompressOptions{})
+	m.wrapEngine() // 内部引擎 (及其日志器) 在首次调用时创建
+	before := runtime.NumGoroutine()
+
+	// Wrap 不为调用启动 goroutine, 尚未 finish 的调用也不占用 goroutine
+	finishes := make([]func(), 100)
+	recs := make([]*httptest.ResponseRecorder, len(finishes))
+	for i := range finishes {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		recs[i] = httptest.NewRecorder()
+		var w http.ResponseWriter
+		w, finishes[i] = m.Wrap(recs[i], req)
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "lease")
+	}
+	if n := runtime.NumGoroutine(); n > before+2 {
+		t.Errorf("Expected no goroutines per Wrap call, got %d before and %d after", before, n)
+	}
+	for i, finish := range finishes {
+		finish()
+		if recs[i].Header().Get("Content-Encoding") != EncodingGzip {
+			t.Fatalf("Expected gzip, got %v", recs[i].Header())
+		}
+	}
+	if n := runtime.NumGoroutine(); n > before+2 {
+		t.Errorf("Expected no goroutines left after finish, got %d before and %d after", before, n)
+	}
+
+	// 处理函数之外的 panic 照常传出
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a nil request to panic")
+		}
+	}()
+	m.Wrap(httptest.NewRecorder(), nil)
+}
@@ -0,0 +1,29 @@
+//go:build compress_no_zstd
+
+package compress
+
+import (
+	"errors"
+	"io"
+)
+
+const zstdAvailable = false
+
+var errZstdExcluded = errors.New("compress: zstd is excluded by the compress_no_zstd build tag")
+
+// zstdCompressWriter 不会被创建, 只为满足按类型区分压缩器的代码
+type zstdCompressWriter struct{ compressWriter }
+
+func initZstdPools() {}
+
+func zstdPoolFor(level int) *encoderPool { return nil }
+
+func newZstdCompressor(level int, cfg AlgorithmConfig, w io.Writer) compressWriter { return nil }
+
+func newZstdEncoder(level int, w io.Writer) (io.WriteCloser, error) { return nil, errZstdExcluded }
+
+func newZstdDecoder(r io.Reader) (io.Reader, func(), error) { return nil, nil, errZstdExcluded }
+
+func newZstdRequestDecoder(r io.Reader) (io.Reader, func(), error) { return nil, nil, errZstdExcluded }
+
+func zstdChecksumError(err error) bool { return false }
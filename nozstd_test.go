@@ -0,0 +1,63 @@
+//go:build compress_no_zstd
+
+package compress
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNoZstd(t *testing.T) {
+	if _, ok := LookupCodec(EncodingZstd); ok {
+		t.Error("Expected zstd to be missing from the codec table")
+	}
+	if _, err := NewEncoder(EncodingZstd, zstdDefaultLevel, io.Discard); err == nil {
+		t.Error("Expected NewEncoder to fail for zstd")
+	}
+	if err := PresetBalanced().Validate(); err != nil {
+		t.Errorf("Expected presets to drop zstd, got %v", err)
+	}
+	n, err := NewNegotiator(PresetBalanced())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := n.Negotiate("zstd, gzip"); got != EncodingGzip {
+		t.Errorf("Expected gzip, got %q", got)
+	}
+	if zstdWriterPoolDefault != nil || hasPool(EncodingZstd, zstdDefaultLevel) {
+		t.Error("Expected no zstd pool")
+	}
+}
+
+func TestNoZstdConfig(t *testing.T) {
+	// 同一份配置适用于两种构建: zstd 的配置照常校验, 运行时被忽略
+	opts, err := LoadOptions(strings.NewReader(`{
+		"algorithms": {"zstd": "fastest", "gzip": 6},
+		"encoding_priority": ["zstd", "gzip"]
+	}`), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.Validate(); err != nil {
+		t.Errorf("Expected zstd config to be accepted, got %v", err)
+	}
+	if err := (PartialOptions{Levels: map[string]int{EncodingZstd: 19}}).Validate(); err != nil {
+		t.Errorf("Expected zstd level override to be accepted, got %v", err)
+	}
+	if err := (PartialOptions{Levels: map[string]int{EncodingZstd: 23}}).Validate(); err == nil {
+		t.Error("Expected out of range zstd level to be rejected")
+	}
+	if _, err := LoadOptions(strings.NewReader(`{"algorithms": {"br": 5}}`), "json"); err == nil {
+		t.Error("Expected unknown encoding to be rejected")
+	}
+	Override(PartialOptions{Levels: map[string]int{EncodingZstd: 19}})
+
+	n, err := NewNegotiator(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := n.Negotiate("zstd, gzip"); got != EncodingGzip {
+		t.Errorf("Expected gzip, got %q", got)
+	}
+}
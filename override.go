@@ -0,0 +1,192 @@
+package compress
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/infinite-iroha/touka"
+)
+
+// PartialOptions 是 Override 可以按路由调整的选项, 零值字段沿用主中间件的配置
+type PartialOptions struct {
+	// Levels 按编码覆盖压缩级别, 只影响主中间件已配置的编码
+	Levels map[string]int
+	// CompressibleTypes 非空时替换可压缩的 MIME 类型列表
+	CompressibleTypes []string
+	// MinContentLength 大于 0 时替换最小压缩长度, 包括各编码单独设置的最小长度
+	MinContentLength int64
+	// Disable 为 true 时这些路由的响应不压缩 (跳过原因 SkipRoute)
+	Disable bool
+}
+
+// Validate 检查 PartialOptions 中的编码与级别
+func (p PartialOptions) Validate() error {
+	var errs []error
+	for name, level := range p.Levels {
+		if _, ok := knownCodec(name); !ok {
+			errs = append(errs, fmt.Errorf("compress: unknown encoding %q in Levels", name))
+		} else if !validLevel(name, level) {
+			errs = append(errs, fmt.Errorf("compress: %s level %d out of range", name, level))
+		}
+	}
+	if p.MinContentLength < 0 {
+		errs = append(errs, fmt.Errorf("compress: MinContentLength %d is negative", p.MinContentLength))
+	}
+	for _, t := range p.CompressibleTypes {
+		if t == "" {
+			errs = append(errs, errors.New("compress: empty entry in CompressibleTypes would match every content type"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Override 返回按路由调整压缩配置的中间件, 需注册在主压缩中间件之后 (如路由组上), 例如:
+//
+//	r.Use(compress.Compression(opts))
+//	static := r.Group("/assets", compress.Override(compress.PartialOptions{
+//		Levels: map[string]int{compress.EncodingGzip: gzip.BestCompression},
+//	}))
+//
+// 调整后的配置与主中间件共享对象池、并发名额与统计, 不必为这些路由再创建一个实例。
+// 前面没有压缩中间件, 或响应头部已经写出时不起作用。PartialOptions 无效时 panic。
+func Override(p PartialOptions) touka.HandlerFunc {
+	if err := p.Validate(); err != nil {
+		panic(err)
+	}
+	key := &p // 区分各个 Override, 派生的配置按此缓存在基础配置上
+	return func(c *touka.Context) {
+		if crw, ok := c.Writer.(*compressResponseWriter); ok && !crw.wroteHeader {
+			derived := crw.cfg.overlay(key, p)
+			crw.cfg = derived
+			crw.codec = derived.plan.lookup(crw.chosenEncoding)
+		}
+		c.Next()
+	}
+}
+
+// overlay 返回由 cfg 按 p 派生的配置。每个 Override 在每份基础配置 (主配置、Profiles 派生的配置、
+// 其他中间件实例的配置) 上只派生一次, 基础配置被 UpdateOptions 替换后随之释放。
+func (cfg *config) overlay(key *PartialOptions, p PartialOptions) *config {
+	if derived, ok := cfg.overlays.Load(key); ok {
+		return derived.(*config)
+	}
+	derived, _ := cfg.overlays.LoadOrStore(key, p.apply(cfg))
+	return derived.(*config)
+}
+
+// Profile 返回让路由使用具名配置 Profiles[name] 的中间件, 需注册在 m 之后, 例如:
+//
+//	api := r.Group("/api", m.Profile("api"))
+//
+// 与 Override 不同, 配置随 m 编译一次, 多个路由组共用同一份; 注册在 Override 之后时切换到具名配置,
+// 此前 Override 的调整不再生效。name 在创建中间件时检查, 不在 m 当前的 Profiles 中时 panic;
+// 请求路径上从不 panic, 请求开始时的配置中没有该名称 (如 UpdateOptions 移除了它) 时不起作用。
+func (m *Middleware) Profile(name string) touka.HandlerFunc {
+	if _, ok := m.config().opts.Profiles[name]; !ok {
+		panic(fmt.Sprintf("compress: unknown profile %q", name))
+	}
+	return func(c *touka.Context) {
+		if crw, ok := c.Writer.(*compressResponseWriter); ok && !crw.wroteHeader {
+			if derived := crw.cfg.profiles[name]; derived != nil {
+				crw.cfg = derived
+				crw.codec = derived.plan.lookup(crw.chosenEncoding)
+			}
+		}
+		c.Next()
+	}
+}
+
+// applyHeaderProfile 切换到受信任的对端以 ProfileHeader 选择的配置
+func (crw *compressResponseWriter) applyHeaderProfile() {
+	if crw.cfg.opts.ProfileHeader == "" {
+		return
+	}
+	if derived := crw.cfg.headerProfile(crw.ctx.Request); derived != nil {
+		crw.cfg = derived
+		crw.codec = derived.plan.lookup(crw.chosenEncoding)
+	}
+}
+
+// headerProfile 返回受信任的对端以 ProfileHeader 选择的配置, 没有时返回 nil
+func (cfg *config) headerProfile(r *http.Request) *config {
+	name := r.Header.Get(cfg.opts.ProfileHeader)
+	derived := cfg.profiles[name]
+	if derived == nil {
+		return nil
+	}
+	peer, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	addr := peer.Addr().Unmap()
+	for _, p := range cfg.proxies {
+		if p.Contains(addr) {
+			return derived
+		}
+	}
+	return nil
+}
+
+// parseProxy 解析 TrustedProxies 中的一项, 单个 IP 视为只含该地址的前缀
+func parseProxy(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// apply 在 base 的基础上派生新的配置, 并发名额、限速状态、具名配置与受信任的代理沿用 base 的,
+// 因此 Override 之后的 Profile 仍能找到主配置的 Profiles。
+// 派生的配置不创建或预热对象池, 只使用主配置已准备好的, 见 plan.preparePools。
+func (p PartialOptions) apply(base *config) *config {
+	opts := base.opts
+	if len(p.Levels) > 0 || p.MinContentLength > 0 {
+		opts.Algorithms = maps.Clone(opts.Algorithms)
+		for name, level := range p.Levels {
+			if ac, ok := opts.Algorithms[name]; ok {
+				ac.Level = level
+				opts.Algorithms[name] = ac
+			}
+		}
+	}
+	if len(p.CompressibleTypes) > 0 {
+		opts.CompressibleTypes = p.CompressibleTypes
+	}
+	if p.MinContentLength > 0 {
+		opts.MinContentLength = p.MinContentLength
+		for name, ac := range opts.Algorithms {
+			ac.MinContentLength = 0
+			opts.Algorithms[name] = ac
+		}
+	}
+	cfg := &config{
+		opts:          opts,
+		slots:         base.slots,
+		clients:       base.clients,
+		routeDisabled: p.Disable,
+		profiles:      base.profiles,
+		proxies:       base.proxies,
+	}
+	cfg.plan = compilePlan(&cfg.opts)
+	return cfg
+}
+
+// AllowLargeResponses 返回让路由上的响应不受 MaxContentLength 限制的中间件, 需注册在主压缩中间件之后,
+// 用于确实需要压缩的大响应 (如导出的大型 CSV)
+func AllowLargeResponses() touka.HandlerFunc {
+	return func(c *touka.Context) {
+		if crw, ok := c.Writer.(*compressResponseWriter); ok && !crw.wroteHeader {
+			crw.uncapped = true
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,221 @@
+package compress
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestOverride(t *testing.T) {
+	m := New(CompressOptions{
+		Algorithms:  map[string]AlgorithmConfig{EncodingGzip: {Level: 1, PoolEnabled: true}},
+		DebugHeader: true,
+	})
+	handler := func(c *touka.Context) {
+		c.Header("Content-Type", c.DefaultQuery("type", "text/plain"))
+		c.String(http.StatusOK, "%s", strings.Repeat("overlay ", 100))
+	}
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", handler)
+	r.Group("/best", Override(PartialOptions{
+		Levels:            map[string]int{EncodingGzip: 9, EncodingZstd: 19}, // zstd 未配置, 忽略
+		CompressibleTypes: []string{"application/octet-stream"},
+	})).GET("/", handler)
+	r.Group("/off", Override(PartialOptions{Disable: true})).GET("/", handler)
+
+	info := func(path string) string {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Header().Get("X-Compression-Info")
+	}
+
+	if got := info("/"); !strings.Contains(got, "level=1") {
+		t.Errorf("Expected main level on other routes, got %q", got)
+	}
+	if got := info("/best/?type=application/octet-stream"); !strings.Contains(got, "encoding=gzip; level=9") {
+		t.Errorf("Expected overridden level and compressible types, got %q", got)
+	}
+	if got := info("/best/?type=text/plain"); got != "skipped=content_type" {
+		t.Errorf("Expected text/plain to be skipped by the override, got %q", got)
+	}
+	if got := info("/off/"); got != "skipped=route" {
+		t.Errorf("Expected disabled route to be skipped, got %q", got)
+	}
+
+	// 主配置更新后, 覆盖在新配置上重新派生
+	if err := m.UpdateOptions(CompressOptions{
+		Algorithms:  map[string]AlgorithmConfig{EncodingGzip: {Level: 2, PoolEnabled: true}},
+		DebugHeader: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := info("/best/?type=application/octet-stream"); !strings.Contains(got, "level=9") {
+		t.Errorf("Expected override to apply after UpdateOptions, got %q", got)
+	}
+	if got := info("/"); !strings.Contains(got, "level=2") {
+		t.Errorf("Expected updated main level, got %q", got)
+	}
+}
+
+func TestOverrideCachedPerBaseConfig(t *testing.T) {
+	m := New(CompressOptions{
+		Algorithms:     map[string]AlgorithmConfig{EncodingGzip: {Level: 1, PoolEnabled: true}},
+		Profiles:       map[string]PartialOptions{"edge": {Levels: map[string]int{EncodingGzip: 5}}},
+		ProfileHeader:  "X-Compress-Profile",
+		TrustedProxies: []string{"10.0.0.0/8"},
+		DebugHeader:    true,
+	})
+	r := touka.New()
+	r.Use(m.Handler())
+	r.Group("/best", Override(PartialOptions{CompressibleTypes: []string{"text/"}})).GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", strings.Repeat("overlay ", 100))
+	})
+
+	// 请求交替使用主配置与 ProfileHeader 选择的配置, 每份基础配置只派生一次
+	var derived []*config
+	for i := 0; i < 6; i++ {
+		req := httptest.NewRequest("GET", "/best/", nil)
+		req.RemoteAddr = "10.1.2.3:4000"
+		req.Header.Set("Accept-Encoding", "gzip")
+		if i%2 == 1 {
+			req.Header.Set("X-Compress-Profile", "edge")
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if want := []string{"level=1", "level=5"}[i%2]; !strings.Contains(w.Header().Get("X-Compression-Info"), want) {
+			t.Errorf("request %d: expected %q in %q", i, want, w.Header().Get("X-Compression-Info"))
+		}
+	}
+	for _, base := range []*config{m.config(), m.config().profiles["edge"]} {
+		var n int
+		base.overlays.Range(func(_, v any) bool {
+			n++
+			derived = append(derived, v.(*config))
+			return true
+		})
+		if n != 1 {
+			t.Errorf("Expected 1 derived config per base config, got %d", n)
+		}
+	}
+	if len(derived) == 2 && derived[0] == derived[1] {
+		t.Error("Expected separate derived configs for separate base configs")
+	}
+}
+
+func TestOverrideInvalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected Override to panic on an invalid level")
+		}
+	}()
+	Override(PartialOptions{Levels: map[string]int{EncodingGzip: 42}})
+}
+
+func TestProfiles(t *testing.T) {
+	opts := CompressOptions{
+		Algorithms: map[string]AlgorithmConfig{EncodingGzip: {Level: 1, PoolEnabled: true}},
+		Profiles: map[string]PartialOptions{
+			"assets":    {Levels: map[string]int{EncodingGzip: 9}},
+			"streaming": {Disable: true},
+		},
+		DebugHeader: true,
+	}
+	m := New(opts)
+	r := touka.New()
+	r.Use(m.Handler())
+	handler := func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", strings.Repeat("profile ", 100))
+	}
+	r.GET("/", handler)
+	r.Group("/assets", m.Profile("assets")).GET("/", handler)
+	r.Group("/static", m.Profile("assets")).GET("/", handler)
+	r.Group("/events", m.Profile("streaming")).GET("/", handler)
+	r.Group("/mixed", Override(PartialOptions{Levels: map[string]int{EncodingGzip: 5}}), m.Profile("assets")).GET("/", handler)
+
+	info := func(path string) string {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Header().Get("X-Compression-Info")
+	}
+	for path, want := range map[string]string{
+		"/":        "level=1",
+		"/assets/": "level=9",
+		"/static/": "level=9",
+		"/events/": "skipped=route",
+		"/mixed/":  "level=9", // Override 派生的配置仍带有主配置的 Profiles
+	} {
+		if got := info(path); !strings.Contains(got, want) {
+			t.Errorf("%s: expected %q in %q", path, want, got)
+		}
+	}
+	// 各路由组共用 m 编译的同一份配置
+	if cfg := m.config(); cfg.profiles["assets"] == nil || cfg.profiles["assets"].slots != cfg.slots {
+		t.Error("Expected profiles to be compiled with the main config")
+	}
+
+	// UpdateOptions 移除配置后, 路由沿用主配置 (Override 之后的也一样), 请求路径上不会 panic
+	opts.Profiles = nil
+	if err := m.UpdateOptions(opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := info("/assets/"); !strings.Contains(got, "level=1") {
+		t.Errorf("Expected main config once the profile is removed, got %q", got)
+	}
+	if got := info("/mixed/"); !strings.Contains(got, "level=5") {
+		t.Errorf("Expected the Override config once the profile is removed, got %q", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected Profile to panic on an unknown name")
+		}
+	}()
+	m.Profile("api")
+}
+
+func TestProfileHeader(t *testing.T) {
+	r := touka.New()
+	r.Use(Compression(CompressOptions{
+		Algorithms:     map[string]AlgorithmConfig{EncodingGzip: {Level: 1}},
+		Profiles:       map[string]PartialOptions{"assets": {Levels: map[string]int{EncodingGzip: 9}}},
+		ProfileHeader:  "X-Compress-Profile",
+		TrustedProxies: []string{"10.0.0.0/8", "::1"},
+		DebugHeader:    true,
+	}))
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", strings.Repeat("edge ", 100))
+	})
+
+	tests := []struct {
+		remote, profile, want string
+	}{
+		{"10.1.2.3:4000", "assets", "level=9"},
+		{"[::1]:4000", "assets", "level=9"},
+		{"[::ffff:10.0.0.1]:4000", "assets", "level=9"},
+		{"192.0.2.1:4000", "assets", "level=1"}, // 不受信任的对端
+		{"10.1.2.3:4000", "unknown", "level=1"},
+		{"10.1.2.3:4000", "", "level=1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remote
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("X-Compress-Profile", tt.profile)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if got := w.Header().Get("X-Compression-Info"); !strings.Contains(got, tt.want) {
+			t.Errorf("%s %q: expected %q in %q", tt.remote, tt.profile, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package compress
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strings"
+)
+
+// PaddingMode 决定是否在压缩响应中加入随机长度的填充, 使压缩后的长度无法被用作 BREACH 一类攻击的判断依据。
+// 填充只能增加噪声, 不能代替在响应中分离机密与用户输入等根本措施。
+type PaddingMode uint8
+
+const (
+	// PaddingOff 不加入填充 (默认)
+	PaddingOff PaddingMode = iota
+	// PaddingAuto 按内容类型在响应体末尾追加不改变语义的填充:
+	// HTML/XML 追加 <!-- --> 注释, CSS/JavaScript 追加 /* */ 注释, JSON 追加空白;
+	// 其他类型无法安全追加, 改为设置 X-Compression-Padding 头部。
+	// 追加了填充的响应体每次不同, 强 ETag 会被改为弱 ETag (W/)。
+	PaddingAuto
+	// PaddingHeader 只设置随机长度的 X-Compression-Padding 头部, 不改动响应体
+	PaddingHeader
+)
+
+// String 返回填充模式的名称
+func (p PaddingMode) String() string {
+	switch p {
+	case PaddingOff:
+		return "off"
+	case PaddingAuto:
+		return "auto"
+	case PaddingHeader:
+		return "header"
+	}
+	return "unknown"
+}
+
+// defaultPaddingMax 是 PaddingMax 未设置时填充内容的最大长度
+const defaultPaddingMax = 32
+
+// maxPadding 是 PaddingMax 允许的上限, 填充在共享缓冲区中生成
+const maxPadding = 4096
+
+// paddingStyle 是单个响应实际使用的填充方式
+type paddingStyle uint8
+
+const (
+	padNone       paddingStyle = iota
+	padMarkup                  // <!-- ... -->
+	padBlock                   // /* ... */
+	padWhitespace              // 空白字符
+	padHeader                  // X-Compression-Padding 头部
+)
+
+// paddingStyleFor 返回 mode 下媒体类型 mediaType 应使用的填充方式
+func paddingStyleFor(mode PaddingMode, mediaType string) paddingStyle {
+	switch mode {
+	case PaddingAuto:
+	case PaddingHeader:
+		return padHeader
+	default:
+		return padNone
+	}
+	mediaType = strings.ToLower(mediaType)
+	switch mediaType {
+	case "text/html", "application/xhtml+xml", "text/xml", "application/xml", "image/svg+xml":
+		return padMarkup
+	case "text/css", "text/javascript", "application/javascript", "application/x-javascript", "application/ecmascript":
+		return padBlock
+	case "application/json":
+		return padWhitespace
+	}
+	switch {
+	case strings.HasSuffix(mediaType, "+xml"):
+		return padMarkup
+	case strings.HasSuffix(mediaType, "+json"):
+		return padWhitespace
+	}
+	return padHeader
+}
+
+// paddingAlphabet 中的字符可以安全地出现在各类注释与头部值中
+const paddingAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+const paddingWhitespace = " \t\r\n"
+
+// appendPadding 向 dst 追加 style 对应的填充, 内容为 0 到 max 个随机字符
+func appendPadding(dst []byte, style paddingStyle, max int) []byte {
+	n := rand.IntN(max + 1)
+	switch style {
+	case padMarkup:
+		dst = append(dst, "<!--"...)
+	case padBlock:
+		dst = append(dst, "/*"...)
+	case padHeader:
+		n++ // 头部值不能为空
+	}
+	for range n {
+		if style == padWhitespace {
+			dst = append(dst, paddingWhitespace[rand.IntN(len(paddingWhitespace))])
+		} else {
+			dst = append(dst, paddingAlphabet[rand.IntN(len(paddingAlphabet))])
+		}
+	}
+	switch style {
+	case padMarkup:
+		dst = append(dst, "-->"...)
+	case padBlock:
+		dst = append(dst, "*/"...)
+	}
+	return dst
+}
+
+// paddingMax 返回生效的填充最大长度
+func (cfg *config) paddingMax() int {
+	if cfg.opts.PaddingMax > 0 {
+		return cfg.opts.PaddingMax
+	}
+	return defaultPaddingMax
+}
+
+// weakenETag 把强 ETag 改为弱 ETag: 压缩 (以及每次不同的填充) 使响应不再与处理器生成 ETag 时的内容逐字节相同,
+// 强 ETag 会让缓存与范围请求拼接出错误的内容
+func weakenETag(h http.Header) {
+	if etag := h.Get(headerETag); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set(headerETag, "W/"+etag)
+	}
+}
+
+// writePadding 在压缩器关闭前把填充写入压缩流; 填充不计入 BytesIn, 也不复制给 Tee
+func (crw *compressResponseWriter) writePadding() {
+	buf := getBuffer()
+	p := appendPadding((*buf)[:0], crw.padding, crw.cfg.paddingMax())
+	if _, err := crw.compressor.Write(p); err != nil {
+		crw.encoderFailed(OpWrite, err)
+	}
+	putBuffer(buf)
+}
@@ -0,0 +1,117 @@
+package compress
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestPaddingStyleFor(t *testing.T) {
+	for _, tt := range []struct {
+		mode      PaddingMode
+		mediaType string
+		want      paddingStyle
+	}{
+		{PaddingOff, "text/html", padNone},
+		{PaddingHeader, "text/html", padHeader},
+		{PaddingAuto, "text/HTML", padMarkup},
+		{PaddingAuto, "application/atom+xml", padMarkup},
+		{PaddingAuto, "application/javascript", padBlock},
+		{PaddingAuto, "text/css", padBlock},
+		{PaddingAuto, "application/json", padWhitespace},
+		{PaddingAuto, "application/problem+json", padWhitespace},
+		{PaddingAuto, "text/plain", padHeader},
+		{PaddingAuto, "text/event-stream", padHeader},
+	} {
+		if got := paddingStyleFor(tt.mode, tt.mediaType); got != tt.want {
+			t.Errorf("paddingStyleFor(%s, %q) = %d, want %d", tt.mode, tt.mediaType, got, tt.want)
+		}
+	}
+}
+
+func TestAppendPadding(t *testing.T) {
+	for range 100 {
+		if p := string(appendPadding(nil, padMarkup, 8)); !strings.HasPrefix(p, "<!--") || !strings.HasSuffix(p, "-->") || len(p) > 15 {
+			t.Fatalf("bad markup padding %q", p)
+		}
+		if p := string(appendPadding(nil, padBlock, 8)); !strings.HasPrefix(p, "/*") || !strings.HasSuffix(p, "*/") || len(p) > 12 {
+			t.Fatalf("bad block padding %q", p)
+		}
+		if p := string(appendPadding(nil, padWhitespace, 8)); strings.TrimSpace(p) != "" || len(p) > 8 {
+			t.Fatalf("bad whitespace padding %q", p)
+		}
+		if p := string(appendPadding(nil, padHeader, 8)); len(p) < 1 || len(p) > 9 {
+			t.Fatalf("bad header padding %q", p)
+		}
+	}
+}
+
+func TestPadding(t *testing.T) {
+	const body = `{"token":"secret"}`
+	r := touka.New()
+	r.Use(Compression(CompressOptions{Padding: PaddingAuto, PaddingMax: 64}))
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", c.Query("type"))
+		c.Header("ETag", `"v1"`)
+		c.String(http.StatusOK, body)
+	})
+
+	do := func(contentType string) (*httptest.ResponseRecorder, int, string) {
+		req := httptest.NewRequest("GET", "/?type="+contentType, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		n := w.Body.Len()
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatalf("%s: gzip.NewReader: %v", contentType, err)
+		}
+		plain, err := io.ReadAll(gr)
+		if err != nil {
+			t.Fatalf("%s: reading body: %v", contentType, err)
+		}
+		return w, n, string(plain)
+	}
+
+	// JSON 只追加空白, 仍是同一个合法的 JSON 值
+	lengths := make(map[int]bool)
+	for range 20 {
+		w, n, plain := do("application/json")
+		if !strings.HasPrefix(plain, body) || !json.Valid([]byte(plain)) {
+			t.Fatalf("Expected valid padded JSON, got %q", plain)
+		}
+		if w.Header().Get("X-Compression-Padding") != "" {
+			t.Error("Expected no padding header for JSON")
+		}
+		if got := w.Header().Get("ETag"); got != `W/"v1"` {
+			t.Errorf("Expected padded body to carry a weak ETag, got %q", got)
+		}
+		lengths[n] = true
+	}
+	if len(lengths) < 2 {
+		t.Errorf("Expected compressed length to vary, got %v", lengths)
+	}
+
+	if _, _, plain := do("text/html"); !strings.HasPrefix(plain, body+"<!--") || !strings.HasSuffix(plain, "-->") {
+		t.Errorf("Expected HTML comment padding, got %q", plain)
+	}
+
+	// 无法安全追加的类型只设置头部, 响应体不变
+	w, _, plain := do("text/plain")
+	if plain != body {
+		t.Errorf("Expected unchanged text/plain body, got %q", plain)
+	}
+	if w.Header().Get("X-Compression-Padding") == "" {
+		t.Error("Expected padding header for text/plain")
+	}
+	// 压缩后的响应总是带弱 ETag, 与是否填充响应体无关
+	if got := w.Header().Get("ETag"); got != `W/"v1"` {
+		t.Errorf("Expected compressed response to carry a weak ETag, got %q", got)
+	}
+}
@@ -0,0 +1,250 @@
+package compress
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strings"
+)
+
+// encodingPlan 是单个编码在创建中间件时解析好的配置
+type encodingPlan struct {
+	name      string
+	cfg       AlgorithmConfig
+	pooled    bool         // 按配置级别获取的压缩器是否经过对象池
+	bounded   *encoderPool // PoolBounded 时使用的有界池
+	minLength int64        // 生效的最小压缩长度
+	weight    uint         // EncodingWeights 中的权重, 为 0 时不参与随机选择
+	bit       codingSet    // 编码在 codingSet 中的位, 不在已知编码中时为 0
+}
+
+// plan 是由 CompressOptions 编译得到的只读执行计划, 请求路径上不再查询 Algorithms 等映射
+type plan struct {
+	encodings  []encodingPlan // 按优先级排列, 只包含已配置的编码
+	names      []string       // 与 encodings 一一对应的编码名称
+	types      []string       // 小写的可压缩 MIME 类型前缀
+	rsyncTypes []string       // 小写的 RsyncableTypes
+	methods    []string       // 大写的请求方法, 为空时不限制
+	weighted   bool           // 是否有编码设置了权重
+}
+
+// compilePlan 编译已补全默认值的配置。有界池只取已创建的, 需要创建并预热对象池时调用 preparePools
+func compilePlan(opts *CompressOptions) *plan {
+	p := &plan{}
+	for _, name := range opts.EncodingPriority {
+		ac, ok := opts.Algorithms[name]
+		if !ok || p.lookup(name) != nil {
+			continue
+		}
+		if _, ok := LookupCodec(name); !ok {
+			continue // 未编译进二进制的编码 (如以 compress_no_zstd 构建时的 zstd)
+		}
+		ep := encodingPlan{name: name, cfg: ac, pooled: ac.pooled(name), minLength: opts.MinContentLength, bit: codingBit(name)}
+		if ac.MinContentLength > 0 {
+			ep.minLength = ac.MinContentLength
+		}
+		if w := opts.EncodingWeights[name]; w > 0 {
+			ep.weight = uint(w)
+			p.weighted = true
+		}
+		if ep.pooled && ac.PoolType == PoolBounded {
+			ep.bounded = existingBoundedPool(poolFor(name, ac.Level))
+		}
+		p.encodings = append(p.encodings, ep)
+		p.names = append(p.names, name)
+	}
+
+	types := opts.CompressibleTypes
+	if len(types) == 0 {
+		types = DefaultCompressibleTypes
+	}
+	p.types = make([]string, len(types))
+	for i, t := range types {
+		p.types[i] = strings.ToLower(t)
+	}
+	for _, t := range opts.RsyncableTypes {
+		p.rsyncTypes = append(p.rsyncTypes, strings.ToLower(t))
+	}
+	for _, m := range opts.Methods {
+		p.methods = append(p.methods, strings.ToUpper(m))
+	}
+	return p
+}
+
+// preparePools 创建计划所需的有界池并按 PrewarmPoolSize 预热对象池。
+// 只在创建中间件的配置 (及 Negotiator) 时调用; Override 与 Profiles 派生的配置不调用,
+// 它们的级别没有已创建的有界池时改用 sync 池。
+func (p *plan) preparePools() {
+	for i := range p.encodings {
+		ep := &p.encodings[i]
+		if !ep.pooled {
+			continue
+		}
+		pool := poolFor(ep.name, ep.cfg.Level)
+		if ep.cfg.PoolType == PoolBounded {
+			pool = boundedPoolFor(pool, ep.cfg.MaxIdle)
+			ep.bounded = pool
+		}
+		n := ep.cfg.PrewarmPoolSize
+		if n == Auto {
+			n = defaultPrewarmSize()
+		}
+		if n > 0 {
+			pool.prewarm(n)
+		}
+	}
+}
+
+// lookup 返回编码的计划, 未配置时返回 nil
+func (p *plan) lookup(name string) *encodingPlan {
+	for i := range p.encodings {
+		if p.encodings[i].name == name {
+			return &p.encodings[i]
+		}
+	}
+	return nil
+}
+
+// codingSet 是已知 content-coding 的位集合, 协商时代替逐个编码重新扫描 Accept-Encoding
+type codingSet uint8
+
+const (
+	codingAny      codingSet = 1 << iota // 存在任意 q>0 的条目
+	codingIdentity                       // identity
+	codingWildcard                       // *
+	codingGzip
+	codingDeflate
+	codingZstd
+)
+
+// codingBit 返回 coding 对应的位, 未知的编码返回 0
+func codingBit(coding string) codingSet {
+	switch coding {
+	case EncodingIdentity:
+		return codingIdentity
+	case "*":
+		return codingWildcard
+	case EncodingGzip:
+		return codingGzip
+	case EncodingDeflate:
+		return codingDeflate
+	case EncodingZstd:
+		return codingZstd
+	}
+	return 0
+}
+
+// acceptedCodings 返回 Accept-Encoding 中 q>0 的已知编码的集合。
+// 浏览器与常见客户端发送的几种固定取值直接返回预先算好的结果, 不解析头部。
+func acceptedCodings(header string) codingSet {
+	switch header {
+	case "gzip, deflate, br, zstd":
+		return codingAny | codingGzip | codingDeflate | codingZstd
+	case "gzip, deflate, br", "gzip, deflate":
+		return codingAny | codingGzip | codingDeflate
+	case "gzip":
+		return codingAny | codingGzip
+	case "":
+		return 0
+	}
+	return scanCodings(header)
+}
+
+// scanCodings 扫描一遍 Accept-Encoding 得到 acceptedCodings 的结果。
+// 结果中某位置位当且仅当 acceptsCoding 对该编码返回 true, 解析规则与之相同。
+func scanCodings(header string) codingSet {
+	header = limitAcceptEncoding(header)
+	var set codingSet
+	for header != "" {
+		var part string
+		part, header, _ = strings.Cut(header, ",")
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		val, params, _ := strings.Cut(part, ";")
+		if bit := codingBit(strings.TrimSpace(val)) | codingAny; set&bit != bit && qOf(params) > 0 {
+			set |= bit
+		}
+	}
+	return set
+}
+
+// accepted 报告客户端是否接受该编码; 已知编码只需查 accepted, 其余编码仍逐项扫描 header
+func (ep *encodingPlan) accepted(header string, accepted codingSet) bool {
+	if ep.bit != 0 {
+		return accepted&ep.bit != 0
+	}
+	return acceptsCoding(header, ep.name)
+}
+
+// admits 报告声明长度为 n 的响应是否达到该编码的最小压缩长度
+func (ep *encodingPlan) admits(n int64) bool {
+	return ep.minLength <= 0 || n >= ep.minLength
+}
+
+// negotiate 根据 Accept-Encoding 选择编码; 不压缩时返回 nil 与 identity 或 ""。
+// 规则与 negotiateHeader 相同, 但头部只扫描一遍, 之后的判断都是位运算。
+func (p *plan) negotiate(header string) (*encodingPlan, string) {
+	accepted := acceptedCodings(header)
+	if p.weighted {
+		if ep := p.pickWeighted(header, accepted); ep != nil {
+			return ep, ep.name
+		}
+	}
+	if accepted&codingAny == 0 {
+		return nil, EncodingIdentity // 未指定或全部 q=0
+	}
+	for i := range p.encodings {
+		if ep := &p.encodings[i]; ep.accepted(header, accepted) {
+			return ep, ep.name
+		}
+	}
+	if accepted&codingWildcard != 0 && len(p.encodings) > 0 {
+		return &p.encodings[0], p.encodings[0].name
+	}
+	if accepted&codingIdentity != 0 {
+		return nil, EncodingIdentity
+	}
+	return nil, ""
+}
+
+// pickWeighted 在客户端接受的带权重编码中按权重随机选择, 没有这样的编码时返回 nil
+func (p *plan) pickWeighted(header string, accepted codingSet) *encodingPlan {
+	var picked *encodingPlan
+	var total uint
+	for i := range p.encodings {
+		ep := &p.encodings[i]
+		if ep.weight == 0 || !ep.accepted(header, accepted) {
+			continue
+		}
+		// 单次遍历的加权抽样: 第 k 个候选以 weight/total 的概率替换已选中的编码
+		total += ep.weight
+		if rand.UintN(total) < ep.weight {
+			picked = ep
+		}
+	}
+	return picked
+}
+
+// allowMethod 报告请求方法是否在 Methods 中且通过 MethodFilter
+func (cfg *config) allowMethod(method string) bool {
+	if len(cfg.plan.methods) > 0 && !slices.Contains(cfg.plan.methods, method) {
+		return false
+	}
+	return cfg.opts.MethodFilter == nil || cfg.opts.MethodFilter(method)
+}
+
+// adaptive 报告是否有策略需要每个压缩响应的耗时信号
+func (cfg *config) adaptive() bool {
+	return cfg.opts.AdaptiveLevel != nil || cfg.opts.EncodingPolicy != nil
+}
+
+// compressible 报告媒体类型 (不含参数) 是否匹配可压缩类型前缀
+func (p *plan) compressible(mediaType string) bool {
+	for _, t := range p.types {
+		if hasPrefixFold(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
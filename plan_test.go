@@ -0,0 +1,166 @@
+package compress
+
+import "testing"
+
+func TestCompilePlan(t *testing.T) {
+	skipWithoutZstd(t)
+	p := compilePlan(&CompressOptions{
+		Algorithms: map[string]AlgorithmConfig{
+			EncodingGzip: {Level: 5, PoolEnabled: true},
+			EncodingZstd: {Level: zstdDefaultLevel, Concurrency: 2, PoolEnabled: true, MinContentLength: 4},
+		},
+		EncodingPriority:  []string{EncodingDeflate, EncodingZstd, EncodingGzip, EncodingZstd},
+		CompressibleTypes: []string{"Text/", "application/JSON"},
+		MinContentLength:  10,
+	})
+
+	if len(p.names) != 2 || p.names[0] != EncodingZstd || p.names[1] != EncodingGzip {
+		t.Fatalf("Expected [zstd gzip], got %v", p.names)
+	}
+	if p.lookup(EncodingDeflate) != nil {
+		t.Error("Expected no plan for unconfigured deflate")
+	}
+	if gz := p.lookup(EncodingGzip); gz == nil || !gz.pooled || gz.bounded != nil {
+		t.Errorf("Expected pooled sync gzip plan, got %+v", gz)
+	}
+	if zs := p.lookup(EncodingZstd); zs == nil || zs.pooled {
+		t.Errorf("Expected unpooled zstd plan for custom concurrency, got %+v", zs)
+	}
+	if !p.compressible("TEXT/html") || !p.compressible("application/json") || p.compressible("image/png") {
+		t.Errorf("Unexpected compressible matching with types %v", p.types)
+	}
+	if gz, zs := p.lookup(EncodingGzip), p.lookup(EncodingZstd); gz.minLength != 10 || zs.minLength != 4 {
+		t.Errorf("Expected minLength 10 for gzip and 4 for zstd, got %d and %d", gz.minLength, zs.minLength)
+	}
+
+	if codec, name := p.negotiate("deflate, gzip"); codec == nil || name != EncodingGzip {
+		t.Errorf("Expected gzip, got %q", name)
+	}
+	if codec, name := p.negotiate("deflate"); codec != nil || name != "" {
+		t.Errorf("Expected no encoding, got %q", name)
+	}
+}
+
+func TestPreparePools(t *testing.T) {
+	opts := CompressOptions{
+		Algorithms:       map[string]AlgorithmConfig{EncodingDeflate: {Level: 2, PoolEnabled: true, PoolType: PoolBounded, MaxIdle: 3, PrewarmPoolSize: 2}},
+		EncodingPriority: []string{EncodingDeflate},
+	}
+	// 仅编译 (如 Override 派生的配置) 时不创建有界池; 有界池在进程内共享, 重复运行时可能已存在
+	existed := existingBoundedPool(poolFor(EncodingDeflate, 2)) != nil
+	p := compilePlan(&opts)
+	if !existed && (p.lookup(EncodingDeflate).bounded != nil || existingBoundedPool(poolFor(EncodingDeflate, 2)) != nil) {
+		t.Fatal("Expected compilePlan not to create a bounded pool")
+	}
+	p.preparePools()
+	bp := p.lookup(EncodingDeflate).bounded
+	if bp == nil || len(bp.idle) < 2 {
+		t.Fatalf("Expected preparePools to create and prewarm the bounded pool, got %v", bp)
+	}
+	// 之后编译的计划复用已创建的有界池
+	if got := compilePlan(&opts).lookup(EncodingDeflate).bounded; got != bp {
+		t.Error("Expected compilePlan to reuse the existing bounded pool")
+	}
+}
+
+func TestNegotiateMatchesHeaderScan(t *testing.T) {
+	headers := []string{
+		"", "gzip", "deflate, gzip", "zstd;q=0, gzip;q=0.1", "br", "br, identity;q=0", "*", "*;q=0",
+		"identity", "gzip;q=0, *", "GZIP", " zstd ; q=1 ,, deflate", "gzip;q=abc", ";q=1", "x-gzip, identity;q=0.5",
+		"gzip, deflate, br", "gzip, deflate, br, zstd", "gzip, deflate",
+	}
+	for _, priority := range [][]string{
+		{EncodingZstd, EncodingGzip, EncodingDeflate},
+		{EncodingDeflate},
+		{},
+	} {
+		algorithms := make(map[string]AlgorithmConfig)
+		for _, name := range priority {
+			algorithms[name] = AlgorithmConfig{Level: 1}
+		}
+		p := compilePlan(&CompressOptions{Algorithms: algorithms, EncodingPriority: priority})
+		for _, h := range headers {
+			want := negotiateHeader(h, p.names)
+			ep, got := p.negotiate(h)
+			if got != want || (ep != nil) != (got != "" && got != EncodingIdentity) {
+				t.Errorf("%v: negotiate(%q) = %q, negotiateHeader = %q", priority, h, got, want)
+			}
+			for _, name := range []string{EncodingGzip, EncodingDeflate, EncodingZstd, EncodingIdentity, "*"} {
+				if acceptedCodings(h)&codingBit(name) != 0 != acceptsCoding(h, name) {
+					t.Errorf("acceptedCodings(%q) disagrees with acceptsCoding for %q", h, name)
+				}
+			}
+			if acceptedCodings(h)&codingAny != 0 != acceptsCoding(h, "") {
+				t.Errorf("acceptedCodings(%q) disagrees with acceptsCoding for any entry", h)
+			}
+			if fast, scanned := acceptedCodings(h), scanCodings(h); fast != scanned {
+				t.Errorf("acceptedCodings(%q) = %b, scanning gives %b", h, fast, scanned)
+			}
+		}
+	}
+}
+
+// FuzzNegotiate 检查按位集合的协商与逐项扫描头部的结果一致
+func FuzzNegotiate(f *testing.F) {
+	for _, h := range []string{
+		"", "gzip, deflate, br, zstd", "zstd;q=0, gzip;q=0.1", "br, identity;q=0", "gzip;q=0, *", " zstd ; q=1 ,, deflate",
+	} {
+		f.Add(h)
+	}
+	priority := []string{EncodingZstd, EncodingGzip, EncodingDeflate}
+	algorithms := make(map[string]AlgorithmConfig)
+	for _, name := range priority {
+		algorithms[name] = AlgorithmConfig{Level: 1}
+	}
+	p := compilePlan(&CompressOptions{Algorithms: algorithms, EncodingPriority: priority})
+	f.Fuzz(func(t *testing.T, h string) {
+		want := negotiateHeader(h, p.names)
+		if _, got := p.negotiate(h); got != want {
+			t.Fatalf("negotiate(%q) = %q, negotiateHeader = %q", h, got, want)
+		}
+		if fast, scanned := acceptedCodings(h), scanCodings(h); fast != scanned {
+			t.Fatalf("acceptedCodings(%q) = %b, scanning gives %b", h, fast, scanned)
+		}
+		for _, name := range []string{EncodingGzip, EncodingDeflate, EncodingZstd, EncodingIdentity, "*"} {
+			if acceptedCodings(h)&codingBit(name) != 0 != acceptsCoding(h, name) {
+				t.Fatalf("acceptedCodings(%q) disagrees with acceptsCoding for %q", h, name)
+			}
+		}
+	})
+}
+
+func TestWeightedNegotiation(t *testing.T) {
+	skipWithoutZstd(t)
+	opts := withDefaults(CompressOptions{
+		Algorithms: map[string]AlgorithmConfig{
+			EncodingZstd:    {Level: zstdDefaultLevel},
+			EncodingGzip:    {Level: 6},
+			EncodingDeflate: {Level: 6},
+		},
+		EncodingPriority: []string{EncodingDeflate, EncodingGzip, EncodingZstd},
+		EncodingWeights:  map[string]int{EncodingZstd: 80, EncodingGzip: 20},
+	})
+	p := compilePlan(&opts)
+
+	const n = 10000
+	counts := map[string]int{}
+	for range n {
+		_, name := p.negotiate("gzip, deflate, zstd")
+		counts[name]++
+	}
+	if counts[EncodingDeflate] != 0 || counts[EncodingZstd] < n*75/100 || counts[EncodingZstd] > n*85/100 {
+		t.Errorf("Expected about 80%% zstd and no deflate, got %v", counts)
+	}
+
+	for accept, want := range map[string]string{
+		"gzip":           EncodingGzip,
+		"zstd;q=0, gzip": EncodingGzip,
+		"deflate":        EncodingDeflate, // 没有带权重的编码, 按优先级
+		"*":              EncodingDeflate,
+		"":               EncodingIdentity,
+	} {
+		if _, got := p.negotiate(accept); got != want {
+			t.Errorf("negotiate(%q) = %q, want %q", accept, got, want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package compress
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/flate"
+)
+
+// PoolType 选择压缩器对象池的实现
+type PoolType int
+
+const (
+	// PoolSync 使用 sync.Pool: 无容量上限, 空闲对象会在 GC 时被丢弃 (默认)
+	PoolSync PoolType = iota
+	// PoolBounded 使用有容量上限的池: 最多保留 MaxIdle 个空闲压缩器, 跨 GC 保留
+	PoolBounded
+)
+
+func (t PoolType) String() string {
+	if t == PoolBounded {
+		return "bounded"
+	}
+	return "sync"
+}
+
+// boundedPools 按 sync 池索引有界池; 同一编码与级别的有界池在进程内共享,
+// 容量取首次创建时的 MaxIdle。
+var (
+	boundedPoolsMu sync.Mutex
+	boundedPools   = map[*encoderPool]*encoderPool{}
+)
+
+// boundedPoolFor 返回与 syncPool 编码、级别相同的有界池, 不存在时创建
+func boundedPoolFor(syncPool *encoderPool, maxIdle int) *encoderPool {
+	if maxIdle <= 0 {
+		maxIdle = defaultMaxIdle()
+	}
+	boundedPoolsMu.Lock()
+	defer boundedPoolsMu.Unlock()
+	if p, ok := boundedPools[syncPool]; ok {
+		return p
+	}
+	p := &encoderPool{
+		encoding: syncPool.encoding,
+		level:    syncPool.level,
+		newFn:    syncPool.newFn,
+		idle:     make(chan compressWriter, maxIdle),
+	}
+	boundedPools[syncPool] = p
+	return p
+}
+
+// existingBoundedPool 返回已为 syncPool 创建的有界池, 没有时返回 nil
+func existingBoundedPool(syncPool *encoderPool) *encoderPool {
+	boundedPoolsMu.Lock()
+	defer boundedPoolsMu.Unlock()
+	return boundedPools[syncPool]
+}
+
+// 对象池内存预算相关的计数, 均为包级别
+var (
+	pooledMemory   atomic.Int64  // 归池管理且尚未被回收的压缩器的估算内存
+	budgetBypasses atomic.Uint64 // 因超出预算而改用用后即弃压缩器的次数
+	budgetDrops    atomic.Uint64 // 因超出预算而未归还到池的次数
+)
+
+// encoderFootprint 估算单个压缩器的内存占用 (字节)
+func encoderFootprint(encoding string, level int) int64 {
+	switch encoding {
+	case EncodingGzip, EncodingDeflate:
+		switch {
+		case level == flate.NoCompression:
+			return 64 << 10
+		case level == flate.BestSpeed:
+			return 256 << 10
+		case level >= 7:
+			return 1 << 20
+		}
+		return 640 << 10
+	case EncodingZstd:
+		// 默认级别的窗口为 8 MiB, 另有约 1 MiB 的块缓冲与哈希表
+		return 9 << 20
+	}
+	return 0
+}
+
+// trackPooledMemory 将 x 的估算内存计入 pooledMemory, 并在 x 被回收时扣除
+func trackPooledMemory(x interface{}, size int64) {
+	pooledMemory.Add(size)
+	release := func(size int64) { pooledMemory.Add(-size) }
+	switch w := x.(type) {
+	case *gzipCompressWriter:
+		runtime.AddCleanup(w, release, size)
+	case *deflateCompressWriter:
+		runtime.AddCleanup(w, release, size)
+	case *zstdCompressWriter:
+		runtime.AddCleanup(w, release, size)
+	}
+}
+
+// SetPooling 在运行时开启或关闭本实例的对象池, 默认开启。
+// 关闭后每个响应都创建用后即弃的压缩器, 用于排查疑似的压缩器复用问题而无需修改配置重新部署;
+// 已取出的压缩器仍按取出时的方式归还。
+func (m *Middleware) SetPooling(enabled bool) { m.poolingOff.Store(!enabled) }
+
+// Pooling 报告本实例的对象池当前是否开启
+func (m *Middleware) Pooling() bool { return !m.poolingOff.Load() }
+
+// SetEnabled 在运行时开启或关闭本实例, 默认开启。
+// 关闭后中间件直接调用后续处理器, 不协商、不修改任何头部, 也不计入统计, 可作为怀疑压缩引发故障时的紧急开关;
+// 已开始的压缩响应不受影响。
+func (m *Middleware) SetEnabled(enabled bool) { m.disabled.Store(!enabled) }
+
+// Enabled 报告本实例当前是否开启
+func (m *Middleware) Enabled() bool { return !m.disabled.Load() }
+
+// overPoolBudget 报告对象池的估算内存是否已达到 MaxPoolMemory
+func (cfg *config) overPoolBudget() bool {
+	return cfg.opts.MaxPoolMemory > 0 && pooledMemory.Load() >= cfg.opts.MaxPoolMemory
+}
@@ -0,0 +1,340 @@
+package compress
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestBoundedPool(t *testing.T) {
+	m := New(CompressOptions{
+		Algorithms: map[string]AlgorithmConfig{
+			EncodingGzip: {Level: 4, PoolEnabled: true, PoolType: PoolBounded, MaxIdle: 2, PrewarmPoolSize: 5},
+		},
+	})
+	bp := m.config().plan.lookup(EncodingGzip).bounded
+	if bp == nil {
+		t.Fatal("Expected a bounded gzip pool")
+	}
+	if len(bp.idle) != 2 {
+		t.Errorf("Expected prewarm to fill 2 idle encoders, got %d", len(bp.idle))
+	}
+	// 有界池在进程内共享, 以增量比较统计以便重复运行
+	gets, misses := bp.gets.Load(), bp.misses.Load()
+
+	body := strings.Repeat("bounded pool ", 100)
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", body)
+	})
+	for i := 0; i < 3; i++ {
+		runtime.GC() // 有界池的空闲对象不受 GC 影响
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(gr)
+		if string(got) != body {
+			t.Fatal("Body mismatch")
+		}
+	}
+	if n := bp.misses.Load() - misses; n != 0 {
+		t.Errorf("Expected no misses on a warmed bounded pool, got %d", n)
+	}
+
+	var found bool
+	for _, ps := range m.Stats().Snapshot().Pools {
+		if ps.Encoding == EncodingGzip && ps.Level == 4 && ps.Type == "bounded" {
+			found = true
+			if ps.Gets-gets != 3 || ps.Idle != 2 {
+				t.Errorf("Unexpected bounded pool stats %+v", ps)
+			}
+		}
+	}
+	if !found {
+		t.Error("Expected bounded pool in stats snapshot")
+	}
+}
+
+func TestPrewarmFullBoundedPool(t *testing.T) {
+	var created int
+	p := &encoderPool{
+		encoding: EncodingGzip,
+		level:    1,
+		newFn: func() compressWriter {
+			created++
+			w, _ := gzip.NewWriterLevel(nil, 1)
+			return &gzipCompressWriter{Writer: w}
+		},
+		idle: make(chan compressWriter, 2),
+	}
+	p.prewarm(5)
+	if created != 2 || len(p.idle) != 2 {
+		t.Fatalf("Expected 2 encoders created and idle, got %d/%d", created, len(p.idle))
+	}
+	p.prewarm(3)
+	if created != 2 {
+		t.Errorf("Expected prewarm of a full pool to create nothing, created %d", created-2)
+	}
+}
+
+func TestGetCompressorPool(t *testing.T) {
+	skipWithoutZstd(t)
+	for _, tt := range []struct {
+		encoding string
+		level    int
+		pooled   bool
+	}{
+		{EncodingGzip, 6, true},
+		{EncodingDeflate, 1, true},
+		{EncodingZstd, zstdDefaultLevel, true},
+		{EncodingGzip, gzip.HuffmanOnly, false}, // 没有对应级别的池
+		{EncodingZstd, 19, false},
+	} {
+		cw, pool := getCompressor(tt.encoding, tt.level, io.Discard, true)
+		if cw == nil {
+			t.Fatalf("%s level %d: no compressor", tt.encoding, tt.level)
+		}
+		if want := poolFor(tt.encoding, tt.level); pool != want || (pool != nil) != tt.pooled {
+			t.Errorf("%s level %d: got pool %p, want %p", tt.encoding, tt.level, pool, want)
+			continue
+		}
+		if pool == nil {
+			cw.Close()
+			continue
+		}
+		puts := pool.puts.Load()
+		cw.Close()
+		pool.put(cw)
+		if pool.puts.Load() != puts+1 {
+			t.Errorf("%s level %d: compressor was not returned to its pool", tt.encoding, tt.level)
+		}
+	}
+}
+
+func TestMaxPoolMemory(t *testing.T) {
+	m := New(CompressOptions{
+		Algorithms:    map[string]AlgorithmConfig{EncodingGzip: {Level: 5, PoolEnabled: true}},
+		MaxPoolMemory: 1, // 任何压缩器都会超出预算
+		DebugHeader:   true,
+	})
+	// 确保至少有一个归池管理的压缩器存活, 使预算处于超出状态
+	keep := poolFor(EncodingGzip, 5).get()
+	defer poolFor(EncodingGzip, 5).put(keep)
+
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", strings.Repeat("over budget ", 100))
+	})
+	before := m.Stats().Snapshot()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	after := m.Stats().Snapshot()
+
+	if w.Header().Get("Content-Encoding") != EncodingGzip {
+		t.Fatalf("Expected gzip response, got %q", w.Header().Get("Content-Encoding"))
+	}
+	if info := w.Header().Get("X-Compression-Info"); !strings.Contains(info, "pooled=false") {
+		t.Errorf("Expected throwaway encoder, got %q", info)
+	}
+	if after.Budget.Bypasses-before.Budget.Bypasses != 1 {
+		t.Errorf("Expected 1 budget bypass, got %d", after.Budget.Bypasses-before.Budget.Bypasses)
+	}
+	if after.Budget.Memory <= 0 {
+		t.Errorf("Expected positive pooled memory, got %d", after.Budget.Memory)
+	}
+}
+
+func TestSetPooling(t *testing.T) {
+	m := New(CompressOptions{
+		Algorithms:  map[string]AlgorithmConfig{EncodingGzip: {Level: 6, PoolEnabled: true}},
+		DebugHeader: true,
+	})
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", strings.Repeat("toggle ", 100))
+	})
+	serve := func() string {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Header().Get("X-Compression-Info")
+	}
+
+	if !m.Pooling() || !strings.Contains(serve(), "pooled=true") {
+		t.Fatal("Expected pooling to be enabled by default")
+	}
+	m.SetPooling(false)
+	before := m.Stats().Snapshot().Unpooled[EncodingGzip]
+	if info := serve(); !strings.Contains(info, "pooled=false") {
+		t.Errorf("Expected throwaway encoder with pooling disabled, got %q", info)
+	}
+	if n := m.Stats().Snapshot().Unpooled[EncodingGzip] - before; n != 1 {
+		t.Errorf("Expected 1 unpooled gzip encoder, got %d", n)
+	}
+	if m.DebugInfo().Config.Pooling {
+		t.Error("Expected debug config to report pooling disabled")
+	}
+	m.SetPooling(true)
+	if info := serve(); !strings.Contains(info, "pooled=true") {
+		t.Errorf("Expected pooled encoder after re-enabling, got %q", info)
+	}
+}
+
+func TestPanicDiscardsEncoder(t *testing.T) {
+	for _, async := range []int{0, 1} {
+		m := New(CompressOptions{
+			Algorithms:   map[string]AlgorithmConfig{EncodingGzip: {Level: 7, PoolEnabled: true}},
+			AsyncWorkers: async,
+		})
+		body := strings.Repeat("half written ", 100)
+		r := touka.New()
+		r.Use(touka.Recovery(), m.Handler())
+		r.GET("/panic", func(c *touka.Context) {
+			c.Header("Content-Type", "text/plain")
+			io.WriteString(c.Writer, body)
+			panic("handler failed mid-write")
+		})
+		r.GET("/", func(c *touka.Context) {
+			c.Header("Content-Type", "text/plain")
+			io.WriteString(c.Writer, body)
+		})
+		pool := poolFor(EncodingGzip, 7)
+		before := pool.discards.Load()
+
+		req := httptest.NewRequest("GET", "/panic", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+
+		if n := m.Stats().Snapshot().Aborted; n != 1 {
+			t.Errorf("async=%d: expected 1 aborted response, got %d", async, n)
+		}
+		// 异步压缩的压缩器同样取自对象池, 也被丢弃
+		if n := pool.discards.Load() - before; n != 1 {
+			t.Errorf("async=%d: expected 1 pool discard, got %d", async, n)
+		}
+
+		// 之后的响应不受影响
+		req = httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := io.ReadAll(gr); string(got) != body {
+			t.Errorf("async=%d: body mismatch after aborted response", async)
+		}
+	}
+}
+
+// hijackRecorder 是可被 Hijack 的 ResponseRecorder, 记录 Hijack 时已写出的字节数
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	atHijack int
+}
+
+func (h *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h.atHijack = h.Body.Len()
+	c1, c2 := net.Pipe()
+	c2.Close()
+	return c1, bufio.NewReadWriter(bufio.NewReader(c1), bufio.NewWriter(c1)), nil
+}
+
+func TestHijackDiscardsEncoder(t *testing.T) {
+	m := New(CompressOptions{Algorithms: map[string]AlgorithmConfig{EncodingGzip: {Level: 8, PoolEnabled: true}}})
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		io.WriteString(c.Writer, strings.Repeat("before hijack ", 100))
+		c.Writer.Flush()
+		conn, _, err := c.Writer.Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		if _, err := c.Writer.Write([]byte("after")); err != http.ErrHijacked {
+			t.Errorf("Expected ErrHijacked after Hijack, got %v", err)
+		}
+	})
+	pool := poolFor(EncodingGzip, 8)
+	before := pool.discards.Load()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	r.ServeHTTP(w, req)
+
+	if w.Body.Len() != w.atHijack {
+		t.Errorf("Expected no bytes after Hijack, got %d more", w.Body.Len()-w.atHijack)
+	}
+	if n := pool.discards.Load() - before; n != 1 {
+		t.Errorf("Expected the encoder to be discarded, got %d discards", n)
+	}
+	if n := m.Stats().Snapshot().Aborted; n != 1 {
+		t.Errorf("Expected 1 aborted response, got %d", n)
+	}
+}
+
+func TestSetEnabled(t *testing.T) {
+	m := New(CompressOptions{})
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", strings.Repeat("switch ", 100))
+	})
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	m.SetEnabled(false)
+	w := serve()
+	if w.Header().Get("Content-Encoding") != "" || w.Header().Get("Vary") != "" {
+		t.Errorf("Expected untouched response while disabled, got headers %v", w.Header())
+	}
+	if m.Enabled() || m.DebugInfo().Config.Enabled {
+		t.Error("Expected middleware to report disabled")
+	}
+	s := m.Stats().Snapshot()
+	for _, n := range s.Skipped {
+		s.Total.Responses += n
+	}
+	if s.Total.Responses != 0 {
+		t.Errorf("Expected no stats while disabled, got %d responses", s.Total.Responses)
+	}
+
+	m.SetEnabled(true)
+	if got := serve().Header().Get("Content-Encoding"); got != EncodingGzip {
+		t.Errorf("Expected gzip after re-enabling, got %q", got)
+	}
+}
@@ -0,0 +1,52 @@
+package compress
+
+import (
+	"compress/gzip"
+
+	"github.com/klauspost/compress/flate"
+)
+
+// 预设在 DefaultCompressionConfig 的基础上启用 zstd, 并为各编码选好级别与对象池。
+// 返回的配置可以继续修改, 例如设置 ExpvarName 或回调。
+
+// PresetSpeed 优先降低 CPU 占用: gzip 与 deflate 使用最快的级别, zstd 使用默认级别
+// (zstd 只有默认级别有对象池, 更低的级别逐个创建压缩器反而更慢)。小于 1KB 的响应不压缩。
+func PresetSpeed() CompressOptions {
+	return preset(zstdDefaultLevel, gzip.BestSpeed, flate.BestSpeed, 1024)
+}
+
+// PresetBalanced 在压缩比与 CPU 之间取折中, 各编码使用默认级别, 适合大多数 API 与页面
+func PresetBalanced() CompressOptions {
+	return preset(zstdDefaultLevel, gzip.DefaultCompression, flate.DefaultCompression, 512)
+}
+
+// PresetBestCompression 追求最小的传输体积, 适合带宽昂贵或响应可被缓存的场景。
+// 高级别的 zstd 没有对象池, 每个响应都会新建压缩器, 不适合高并发的动态内容。
+func PresetBestCompression() CompressOptions {
+	return preset(19, gzip.BestCompression, flate.BestCompression, 256)
+}
+
+func preset(zstdLevel, gzipLevel, deflateLevel int, minLength int64) CompressOptions {
+	algorithms := map[string]AlgorithmConfig{
+		EncodingZstd:    {Level: zstdLevel},
+		EncodingGzip:    {Level: gzipLevel},
+		EncodingDeflate: {Level: deflateLevel},
+	}
+	var priority []string
+	for _, name := range []string{EncodingZstd, EncodingGzip, EncodingDeflate} {
+		ac := algorithms[name]
+		if _, ok := LookupCodec(name); !ok {
+			delete(algorithms, name) // 未编译进二进制 (compress_no_zstd)
+			continue
+		}
+		ac.PoolEnabled = hasPool(name, ac.Level)
+		algorithms[name] = ac
+		priority = append(priority, name)
+	}
+	return CompressOptions{
+		Algorithms:        algorithms,
+		MinContentLength:  minLength,
+		CompressibleTypes: DefaultCompressibleTypes,
+		EncodingPriority:  priority,
+	}
+}
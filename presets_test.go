@@ -0,0 +1,50 @@
+package compress
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestPresets(t *testing.T) {
+	for name, opts := range map[string]CompressOptions{
+		"speed":    PresetSpeed(),
+		"balanced": PresetBalanced(),
+		"best":     PresetBestCompression(),
+	} {
+		if err := opts.Validate(); err != nil {
+			t.Errorf("%s: invalid preset: %v", name, err)
+		}
+		// 预设只在确实有对象池时启用, 不会触发 "PoolEnabled has no effect" 警告
+		for enc, ac := range opts.Algorithms {
+			if ac.PoolEnabled != ac.pooled(enc) {
+				t.Errorf("%s: %s level %d PoolEnabled=%v but pooled=%v", name, enc, ac.Level, ac.PoolEnabled, ac.pooled(enc))
+			}
+		}
+
+		r := touka.New()
+		r.Use(Compression(opts))
+		r.GET("/", func(c *touka.Context) {
+			c.Header("Content-Type", "text/html")
+			c.String(http.StatusOK, "%s", strings.Repeat("<p>preset</p>", 200))
+		})
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip, zstd")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		want := EncodingZstd
+		if !zstdAvailable {
+			want = EncodingGzip
+		}
+		if got := w.Header().Get("Content-Encoding"); got != want {
+			t.Errorf("%s: expected %s to be preferred, got %q", name, want, got)
+		}
+	}
+
+	if s, b := PresetSpeed().Algorithms[EncodingGzip].Level, PresetBestCompression().Algorithms[EncodingGzip].Level; s >= b {
+		t.Errorf("Expected speed gzip level %d below best compression level %d", s, b)
+	}
+}
@@ -0,0 +1,24 @@
+package compress
+
+import "runtime"
+
+// Auto 可用于 PrewarmPoolSize 与 AsyncWorkers, 表示按 GOMAXPROCS 自动选择数量
+const Auto = -1
+
+// scaleProcs 返回 GOMAXPROCS*mul/div, 并限制在 [lo, hi] 之间
+func scaleProcs(mul, div, lo, hi int) int {
+	return min(max(runtime.GOMAXPROCS(0)*mul/div, lo), hi)
+}
+
+// defaultZstdConcurrency 是 zstd 压缩器未指定 Concurrency 时使用的 goroutine 数:
+// 每 16 个 P 增加一个, 最多 4 个。小容器上保持单 goroutine, 大机器上单个大响应也能利用多核。
+func defaultZstdConcurrency() int { return scaleProcs(1, 16, 1, 4) }
+
+// defaultPrewarmSize 是 PrewarmPoolSize 为 Auto 时每个对象池预热的压缩器数, 每个 P 一个, 最多 16 个
+func defaultPrewarmSize() int { return scaleProcs(1, 1, 1, 16) }
+
+// defaultMaxIdle 是 PoolBounded 池未指定 MaxIdle 时的容量, 每个 P 四个, 介于 16 与 256 之间
+func defaultMaxIdle() int { return scaleProcs(4, 1, 16, 256) }
+
+// defaultAsyncWorkers 是 AsyncWorkers 为 Auto 时的 worker 数, 每个 P 一个, 最多 64 个
+func defaultAsyncWorkers() int { return scaleProcs(1, 1, 1, 64) }
@@ -0,0 +1,26 @@
+package compress
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestScaleProcs(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	for _, tt := range []struct {
+		procs                           int
+		zstd, prewarm, maxIdle, workers int
+	}{
+		{1, 1, 1, 16, 1},
+		{2, 1, 2, 16, 2},
+		{16, 1, 16, 64, 16},
+		{64, 4, 16, 256, 64},
+		{128, 4, 16, 256, 64},
+	} {
+		runtime.GOMAXPROCS(tt.procs)
+		got := [4]int{defaultZstdConcurrency(), defaultPrewarmSize(), defaultMaxIdle(), defaultAsyncWorkers()}
+		if want := [4]int{tt.zstd, tt.prewarm, tt.maxIdle, tt.workers}; got != want {
+			t.Errorf("GOMAXPROCS=%d: got %v, want %v", tt.procs, got, want)
+		}
+	}
+}
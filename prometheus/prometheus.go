@@ -0,0 +1,186 @@
+// Package prometheus 将 compress 中间件的统计导出为 Prometheus 指标。
+// 它只使用 compress 公开的 Stats API, 不使用 Prometheus 的程序无需引入 client_golang。
+package prometheus
+
+import (
+	"strconv"
+
+	"github.com/fenthope/compress"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Options 配置 Collector 生成的指标
+type Options struct {
+	// Namespace 是指标名的前缀, 为空时为 "touka_compress"
+	Namespace string
+	// ConstLabels 附加在所有指标上。同一 registry 注册多个中间件实例的 Collector 时,
+	// 需以不同的 ConstLabels (例如 {"middleware": "api"}) 或 Namespace 区分, 否则注册时报告重复的指标。
+	ConstLabels prometheus.Labels
+}
+
+// Collector 将中间件实例的统计导出为 Prometheus 指标。
+// 指标在每次采集时从 Stats 快照生成, 不会在请求路径上引入额外开销。
+// 对象池与池内存预算在进程内共享, 多个 Collector 导出的这部分指标相同。
+type Collector struct {
+	m *compress.Middleware
+
+	responses *prometheus.Desc
+	bytesIn   *prometheus.Desc
+	bytesOut  *prometheus.Desc
+	saved     *prometheus.Desc
+	skipped   *prometheus.Desc
+	aborted   *prometheus.Desc
+	errors    *prometheus.Desc
+	ratio     *prometheus.Desc
+
+	requests           *prometheus.Desc
+	requestBytesIn     *prometheus.Desc
+	requestBytesOut    *prometheus.Desc
+	requestUnsupported *prometheus.Desc
+	requestErrors      *prometheus.Desc
+
+	poolGets   *prometheus.Desc
+	poolMisses *prometheus.Desc
+	poolPuts   *prometheus.Desc
+	poolDrops  *prometheus.Desc
+	poolLive   *prometheus.Desc
+	poolIdle   *prometheus.Desc
+	unpooled   *prometheus.Desc
+	poolMemory *prometheus.Desc
+	budget     *prometheus.Desc
+	buffered   *prometheus.Desc
+	active     *prometheus.Desc
+}
+
+// NewCollector 为中间件实例创建一个 Prometheus collector,
+// 可通过 registry.MustRegister 注册到已有的 registry。
+func NewCollector(m *compress.Middleware, opts Options) *Collector {
+	ns := opts.Namespace
+	if ns == "" {
+		ns = "touka_compress"
+	}
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(ns+"_"+name, help, labels, opts.ConstLabels)
+	}
+	enc := []string{"encoding"}
+	pool := []string{"encoding", "level", "type"}
+	return &Collector{
+		m:         m,
+		responses: desc("responses_total", "Number of responses compressed.", enc...),
+		bytesIn:   desc("bytes_in_total", "Uncompressed bytes written to encoders.", enc...),
+		bytesOut:  desc("bytes_out_total", "Compressed bytes emitted by encoders.", enc...),
+		saved:     desc("bytes_saved_total", "Bytes saved by compression (in - out).", enc...),
+		skipped:   desc("skipped_total", "Number of responses not compressed, by reason.", "reason"),
+		aborted:   desc("aborted_total", "Compressed responses whose handler terminated abnormally; their encoders were discarded."),
+		errors:    desc("encoder_errors_total", "Encoder failures, by operation; write/flush/close failures may truncate responses.", "op"),
+		ratio:     desc("ratio", "Per-response compression ratio (uncompressed / compressed).", "encoding", "content_type"),
+
+		requests:           desc("request_bodies_total", "Compressed request bodies read to the end and validated."),
+		requestBytesIn:     desc("request_bytes_in_total", "Compressed request body bytes read."),
+		requestBytesOut:    desc("request_bytes_out_total", "Decompressed request body bytes."),
+		requestUnsupported: desc("request_unsupported_total", "Requests rejected with 415 for an unsupported Content-Encoding."),
+		requestErrors:      desc("request_errors_total", "Request body decompression failures, by kind.", "kind"),
+
+		poolGets:   desc("pool_gets_total", "Encoders taken from the pool.", pool...),
+		poolMisses: desc("pool_misses_total", "Pool gets that had to allocate a new encoder.", pool...),
+		poolPuts:   desc("pool_puts_total", "Encoders returned to the pool.", pool...),
+		poolDrops:  desc("pool_discards_total", "Pooled encoders discarded instead of returned.", pool...),
+		poolLive:   desc("pool_live", "Pooled encoders currently checked out.", pool...),
+		poolIdle:   desc("pool_idle", "Idle encoders held by bounded pools.", pool...),
+		unpooled:   desc("unpooled_encoders_total", "Encoders created without a pool.", enc...),
+		poolMemory: desc("pool_memory_bytes", "Estimated memory held by pool-managed encoders."),
+		budget:     desc("pool_budget_total", "Pool operations refused by MaxPoolMemory, by action.", "action"),
+		buffered:   desc("buffered_bytes", "Response data currently buffered in memory, process-wide."),
+		active:     desc("active", "Compressions in progress (tracked only with MaxConcurrentCompressions)."),
+	}
+}
+
+// Describe 实现 prometheus.Collector
+func (pc *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pc.responses
+	ch <- pc.bytesIn
+	ch <- pc.bytesOut
+	ch <- pc.saved
+	ch <- pc.skipped
+	ch <- pc.aborted
+	ch <- pc.errors
+	ch <- pc.ratio
+	ch <- pc.requests
+	ch <- pc.requestBytesIn
+	ch <- pc.requestBytesOut
+	ch <- pc.requestUnsupported
+	ch <- pc.requestErrors
+	ch <- pc.poolGets
+	ch <- pc.poolMisses
+	ch <- pc.poolPuts
+	ch <- pc.poolDrops
+	ch <- pc.poolLive
+	ch <- pc.poolIdle
+	ch <- pc.unpooled
+	ch <- pc.poolMemory
+	ch <- pc.budget
+	ch <- pc.buffered
+	ch <- pc.active
+}
+
+// Collect 实现 prometheus.Collector
+func (pc *Collector) Collect(ch chan<- prometheus.Metric) {
+	snap := pc.m.Stats().Snapshot()
+	for name, es := range snap.Encodings {
+		ch <- prometheus.MustNewConstMetric(pc.responses, prometheus.CounterValue, float64(es.Responses), name)
+		ch <- prometheus.MustNewConstMetric(pc.bytesIn, prometheus.CounterValue, float64(es.BytesIn), name)
+		ch <- prometheus.MustNewConstMetric(pc.bytesOut, prometheus.CounterValue, float64(es.BytesOut), name)
+		var saved float64
+		if es.BytesIn > es.BytesOut {
+			saved = float64(es.BytesIn - es.BytesOut)
+		}
+		ch <- prometheus.MustNewConstMetric(pc.saved, prometheus.CounterValue, saved, name)
+		for family, h := range es.RatioByType {
+			ch <- constHistogram(pc.ratio, h, name, family)
+		}
+	}
+	for reason, n := range snap.Skipped {
+		ch <- prometheus.MustNewConstMetric(pc.skipped, prometheus.CounterValue, float64(n), reason)
+	}
+	ch <- prometheus.MustNewConstMetric(pc.aborted, prometheus.CounterValue, float64(snap.Aborted))
+	for op, n := range snap.Errors {
+		ch <- prometheus.MustNewConstMetric(pc.errors, prometheus.CounterValue, float64(n), op)
+	}
+	ch <- prometheus.MustNewConstMetric(pc.requests, prometheus.CounterValue, float64(snap.Requests.Decoded))
+	ch <- prometheus.MustNewConstMetric(pc.requestBytesIn, prometheus.CounterValue, float64(snap.Requests.BytesIn))
+	ch <- prometheus.MustNewConstMetric(pc.requestBytesOut, prometheus.CounterValue, float64(snap.Requests.BytesOut))
+	ch <- prometheus.MustNewConstMetric(pc.requestUnsupported, prometheus.CounterValue, float64(snap.Requests.Unsupported))
+	for kind, n := range snap.Requests.Errors {
+		ch <- prometheus.MustNewConstMetric(pc.requestErrors, prometheus.CounterValue, float64(n), kind)
+	}
+	for _, ps := range snap.Pools {
+		level := strconv.Itoa(ps.Level)
+		ch <- prometheus.MustNewConstMetric(pc.poolGets, prometheus.CounterValue, float64(ps.Gets), ps.Encoding, level, ps.Type)
+		ch <- prometheus.MustNewConstMetric(pc.poolMisses, prometheus.CounterValue, float64(ps.Misses), ps.Encoding, level, ps.Type)
+		ch <- prometheus.MustNewConstMetric(pc.poolPuts, prometheus.CounterValue, float64(ps.Puts), ps.Encoding, level, ps.Type)
+		ch <- prometheus.MustNewConstMetric(pc.poolDrops, prometheus.CounterValue, float64(ps.Discards), ps.Encoding, level, ps.Type)
+		ch <- prometheus.MustNewConstMetric(pc.poolLive, prometheus.GaugeValue, float64(ps.Live), ps.Encoding, level, ps.Type)
+		if ps.Type == compress.PoolBounded.String() {
+			ch <- prometheus.MustNewConstMetric(pc.poolIdle, prometheus.GaugeValue, float64(ps.Idle), ps.Encoding, level, ps.Type)
+		}
+	}
+	for name, n := range snap.Unpooled {
+		ch <- prometheus.MustNewConstMetric(pc.unpooled, prometheus.CounterValue, float64(n), name)
+	}
+	ch <- prometheus.MustNewConstMetric(pc.poolMemory, prometheus.GaugeValue, float64(snap.Budget.Memory))
+	ch <- prometheus.MustNewConstMetric(pc.budget, prometheus.CounterValue, float64(snap.Budget.Bypasses), "bypass")
+	ch <- prometheus.MustNewConstMetric(pc.budget, prometheus.CounterValue, float64(snap.Budget.Drops), "drop")
+	ch <- prometheus.MustNewConstMetric(pc.buffered, prometheus.GaugeValue, float64(snap.Buffered))
+	ch <- prometheus.MustNewConstMetric(pc.active, prometheus.GaugeValue, float64(pc.m.ActiveCompressions()))
+}
+
+// constHistogram 将直方图快照转换为 Prometheus 的累计桶形式
+func constHistogram(desc *prometheus.Desc, h compress.Histogram, labels ...string) prometheus.Metric {
+	buckets := make(map[float64]uint64, len(h.Bounds))
+	var cumulative uint64
+	for i, bound := range h.Bounds {
+		cumulative += h.Counts[i]
+		buckets[bound] = cumulative
+	}
+	return prometheus.MustNewConstHistogram(desc, h.Count, h.Sum, buckets, labels...)
+}
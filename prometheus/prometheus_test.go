@@ -0,0 +1,94 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fenthope/compress"
+	"github.com/infinite-iroha/touka"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollector(t *testing.T) {
+	m := compress.New(compress.DefaultCompressionConfig())
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", strings.Repeat("metrics ", 200))
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(NewCollector(m, Options{})); err != nil {
+		t.Fatal(err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := map[string]bool{}
+	for _, mf := range families {
+		found[mf.GetName()] = true
+		if mf.GetName() != "touka_compress_ratio" {
+			continue
+		}
+		for _, metric := range mf.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range metric.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			want := uint64(0)
+			if labels["encoding"] == compress.EncodingGzip && labels["content_type"] == "text" {
+				want = 1
+			}
+			if got := metric.GetHistogram().GetSampleCount(); got != want {
+				t.Errorf("Expected %d ratio samples for %v, got %d", want, labels, got)
+			}
+		}
+	}
+	for _, name := range []string{
+		"touka_compress_responses_total",
+		"touka_compress_bytes_saved_total",
+		"touka_compress_skipped_total",
+		"touka_compress_ratio",
+		"touka_compress_pool_gets_total",
+		"touka_compress_pool_live",
+		"touka_compress_unpooled_encoders_total",
+		"touka_compress_buffered_bytes",
+	} {
+		if !found[name] {
+			t.Errorf("Expected metric family %s", name)
+		}
+	}
+}
+
+func TestCollectorMultipleMiddlewares(t *testing.T) {
+	api := compress.New(compress.DefaultCompressionConfig())
+	static := compress.New(compress.DefaultCompressionConfig())
+
+	// 以 ConstLabels 区分的两个实例可以注册到同一 registry
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(NewCollector(api, Options{ConstLabels: prometheus.Labels{"middleware": "api"}})); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Register(NewCollector(static, Options{ConstLabels: prometheus.Labels{"middleware": "static"}})); err != nil {
+		t.Fatalf("Expected distinct const labels to register, got %v", err)
+	}
+	if err := reg.Register(NewCollector(static, Options{Namespace: "static_compress"})); err != nil {
+		t.Fatalf("Expected a distinct namespace to register, got %v", err)
+	}
+	if _, err := reg.Gather(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有区分时报告重复
+	if err := reg.Register(NewCollector(static, Options{ConstLabels: prometheus.Labels{"middleware": "api"}})); err == nil {
+		t.Error("Expected registering the same labels twice to fail")
+	}
+}
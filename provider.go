@@ -0,0 +1,174 @@
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/infinite-iroha/touka"
+	"github.com/klauspost/compress/flate"
+)
+
+// CompressedProvider 由能够直接提供已压缩内容的值实现, 如以压缩形式缓存的 API 响应。
+// Compressed 返回 encoding 编码的内容及其长度 (未知时为 -1); 没有该编码的副本时 ok 为 false。
+// 返回的 Reader 实现 io.Closer 时在读完后关闭。
+type CompressedProvider interface {
+	Compressed(encoding string) (r io.Reader, size int64, ok bool)
+}
+
+// MultiProvider 由以多种编码保存了同一资源的 CompressedProvider 实现, 如同时缓存了 br、zstd 与 gzip 副本。
+// Encodings 按服务端的偏好顺序返回已保存的编码, 可以包含中间件不支持的编码 (如 br);
+// 未压缩的副本以 identity 表示, Compressed(identity) 返回原始内容。
+type MultiProvider interface {
+	CompressedProvider
+	Encodings() []string
+}
+
+// SelectEncoding 按 Accept-Encoding 从 stored 中选出最佳的编码, stored 的顺序即服务端偏好, 规则与中间件的协商相同。
+// 只有 stored 包含 identity 时才可能返回 identity; 没有可接受的编码时返回 ""。
+func SelectEncoding(accept string, stored []string) string {
+	name := negotiateHeader(accept, stored)
+	if name == EncodingIdentity && !slices.Contains(stored, EncodingIdentity) {
+		return ""
+	}
+	return name
+}
+
+// ServeCompressed 在协商选中的编码有 p 提供的副本时, 以 code 直接发送这些字节而不重新压缩, 返回 true;
+// 否则不写入任何内容并返回 false, 处理器照常写出未压缩的响应, 由中间件按配置压缩:
+//
+//	c.Header("Content-Type", "application/json")
+//	if !compress.ServeCompressed(c, http.StatusOK, cached) {
+//		c.Writer.Write(cached.Raw)
+//	}
+//
+// p 实现 MultiProvider 时改用 SelectEncoding 在已保存的编码中选择, 不限于中间件配置的编码;
+// 客户端不接受任何已保存的编码时, 发送未压缩的副本或解码一个 gzip、deflate 或 zstd 副本,
+// 再由中间件按协商结果压缩, 只有都无法做到时才返回 false。
+//
+// Content-Type 等头部需在调用前设置。直接发送的响应在统计中计为 SkipPreEncoded;
+// 路由上的 Override 关闭了压缩时返回 false。p 不是 MultiProvider 时, 未经压缩中间件或未协商出编码同样返回 false。
+func ServeCompressed(c *touka.Context, code int, p CompressedProvider) bool {
+	crw, wrapped := c.Writer.(*compressResponseWriter)
+	if c.Writer.Written() || wrapped && (crw.wroteHeader || crw.cfg.routeDisabled) {
+		return false
+	}
+	if mp, ok := p.(MultiProvider); ok {
+		accept := c.Request.Header.Get(headerAcceptEncoding)
+		if wrapped {
+			accept = crw.acceptEncoding
+		}
+		return serveStored(c, code, mp, accept)
+	}
+	if !wrapped || crw.codec == nil {
+		return false
+	}
+	r, size, ok := p.Compressed(crw.chosenEncoding)
+	if !ok {
+		return false
+	}
+	serveEncoded(c, code, crw.codec.name, r, size)
+	return true
+}
+
+// serveStored 从 mp 保存的编码中选择副本发送, 必要时解码后交给中间件重新压缩
+func serveStored(c *touka.Context, code int, mp MultiProvider, accept string) bool {
+	stored := mp.Encodings()
+	if enc := SelectEncoding(accept, stored); enc != "" && enc != EncodingIdentity {
+		if r, size, ok := mp.Compressed(enc); ok {
+			serveEncoded(c, code, enc, r, size)
+			return true
+		}
+	}
+
+	// 没有可直接发送的副本: 优先使用未压缩的副本, 其次解码一个压缩副本
+	if slices.Contains(stored, EncodingIdentity) {
+		if r, _, ok := mp.Compressed(EncodingIdentity); ok {
+			serveDecoded(c, code, r, "")
+			return true
+		}
+	}
+	for _, enc := range stored {
+		if !decodable(enc) {
+			continue
+		}
+		if r, _, ok := mp.Compressed(enc); ok {
+			serveDecoded(c, code, r, enc)
+			return true
+		}
+	}
+	return false
+}
+
+// serveEncoded 以 contentEncoding 原样发送 r 中已编码的内容
+func serveEncoded(c *touka.Context, code int, contentEncoding string, r io.Reader, size int64) {
+	if rc, ok := r.(io.Closer); ok {
+		defer rc.Close()
+	}
+	h := c.Writer.Header()
+	h[headerContentEncoding] = []string{contentEncoding}
+	addVaryAcceptEncoding(h)
+	if size >= 0 {
+		h.Set(headerContentLength, strconv.FormatInt(size, 10))
+	} else {
+		h.Del(headerContentLength)
+	}
+	// 已带 Content-Encoding, 压缩中间件会原样发送 (SkipPreEncoded)
+	c.Writer.WriteHeader(code)
+	if c.Request.Method != http.MethodHead {
+		if err := copyBuffered(c.Writer, r); err != nil {
+			c.AddError(fmt.Errorf("failed to write compressed response: %w", err))
+		}
+	}
+}
+
+// serveDecoded 把以 encoding 编码的 r 解码 (为 "" 时不解码) 后写入响应, 由中间件按协商结果压缩
+func serveDecoded(c *touka.Context, code int, r io.Reader, encoding string) {
+	if rc, ok := r.(io.Closer); ok {
+		defer rc.Close()
+	}
+	h := c.Writer.Header()
+	addVaryAcceptEncoding(h)
+	h.Del(headerContentLength)
+	if encoding != "" {
+		dr, done, err := newDecoder(encoding, r)
+		if err != nil {
+			c.ErrorUseHandle(http.StatusInternalServerError, fmt.Errorf("failed to decode stored %s response: %w", encoding, err))
+			return
+		}
+		defer done()
+		r = dr
+	}
+	c.Writer.WriteHeader(code)
+	if c.Request.Method != http.MethodHead {
+		if err := copyBuffered(c.Writer, r); err != nil {
+			c.AddError(fmt.Errorf("failed to write decoded response: %w", err))
+		}
+	}
+}
+
+// decodable 报告能否解码 encoding 编码的副本
+func decodable(encoding string) bool {
+	return encoding == EncodingGzip || encoding == EncodingDeflate || (encoding == EncodingZstd && zstdAvailable)
+}
+
+// newDecoder 返回解码 r 的 Reader 及用完后释放资源的函数
+func newDecoder(encoding string, r io.Reader) (io.Reader, func(), error) {
+	switch encoding {
+	case EncodingGzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gr, func() { gr.Close() }, nil
+	case EncodingDeflate:
+		fr := flate.NewReader(r)
+		return fr, func() { fr.Close() }, nil
+	case EncodingZstd:
+		return newZstdDecoder(r)
+	}
+	return nil, nil, fmt.Errorf("compress: cannot decode %q", encoding)
+}
@@ -0,0 +1,177 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/infinite-iroha/touka"
+	"github.com/klauspost/compress/zstd"
+)
+
+// cachedResponse 以 gzip 形式缓存的响应
+type cachedResponse struct {
+	raw, gz []byte
+}
+
+func (c *cachedResponse) Compressed(encoding string) (io.Reader, int64, bool) {
+	if encoding != EncodingGzip {
+		return nil, 0, false
+	}
+	return bytes.NewReader(c.gz), int64(len(c.gz)), true
+}
+
+func TestServeCompressed(t *testing.T) {
+	skipWithoutZstd(t)
+	raw := []byte(strings.Repeat(`{"item":"cached"}`, 50))
+	var buf bytes.Buffer
+	enc, _ := NewEncoder(EncodingGzip, 9, &buf)
+	enc.Write(raw)
+	enc.Close()
+	cached := &cachedResponse{raw: raw, gz: buf.Bytes()}
+	m := New(CompressOptions{Algorithms: map[string]AlgorithmConfig{
+		EncodingGzip: {Level: 6},
+		EncodingZstd: {Level: zstdDefaultLevel},
+	}, DebugHeader: true})
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "application/json")
+		if !ServeCompressed(c, http.StatusOK, cached) {
+			c.Writer.Write(cached.raw)
+		}
+	})
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", accept)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("gzip")
+	if w.Header().Get("Content-Encoding") != EncodingGzip || !bytes.Equal(w.Body.Bytes(), cached.gz) {
+		t.Fatalf("Expected the cached gzip bytes to be sent as-is")
+	}
+	if w.Header().Get("Content-Length") != strconv.Itoa(len(cached.gz)) || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Unexpected headers %v", w.Header())
+	}
+	if got := m.Stats().Snapshot().Skipped["pre_encoded"]; got != 1 {
+		t.Errorf("Expected the provided response to count as pre_encoded, got %d", got)
+	}
+
+	// 没有 zstd 副本, 由中间件压缩未压缩的内容
+	w = get("zstd")
+	if w.Header().Get("Content-Encoding") != EncodingZstd {
+		t.Fatalf("Expected fallback response to be compressed by the middleware")
+	}
+	zr, err := zstd.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if got, err := io.ReadAll(zr); err != nil || !bytes.Equal(got, raw) {
+		t.Errorf("Fallback body mismatch: %v", err)
+	}
+	if w = get(""); !bytes.Equal(w.Body.Bytes(), raw) {
+		t.Errorf("Expected identity response to be written unchanged")
+	}
+}
+
+// storedResponse 以多种编码缓存的响应, 不含未压缩的副本
+type storedResponse map[string][]byte
+
+func (s storedResponse) Encodings() []string { return []string{"br", EncodingGzip} }
+
+func (s storedResponse) Compressed(encoding string) (io.Reader, int64, bool) {
+	b, ok := s[encoding]
+	return bytes.NewReader(b), int64(len(b)), ok
+}
+
+func TestSelectEncoding(t *testing.T) {
+	stored := []string{"br", EncodingZstd, EncodingGzip}
+	tests := []struct {
+		accept string
+		stored []string
+		want   string
+	}{
+		{"gzip, br", stored, "br"},
+		{"gzip, zstd;q=0.5", stored, EncodingZstd},
+		{"deflate", stored, ""},
+		{"", stored, ""},
+		{"", append(stored, EncodingIdentity), EncodingIdentity},
+		{"*", stored, "br"},
+		{"br;q=0, gzip", stored, EncodingGzip},
+	}
+	for _, tt := range tests {
+		if got := SelectEncoding(tt.accept, tt.stored); got != tt.want {
+			t.Errorf("SelectEncoding(%q, %v) = %q, want %q", tt.accept, tt.stored, got, tt.want)
+		}
+	}
+}
+
+func TestServeCompressedStored(t *testing.T) {
+	raw := []byte(strings.Repeat("stored representation ", 50))
+	var buf bytes.Buffer
+	enc, _ := NewEncoder(EncodingGzip, 9, &buf)
+	enc.Write(raw)
+	enc.Close()
+	stored := storedResponse{"br": []byte("fake brotli bytes"), EncodingGzip: buf.Bytes()}
+
+	r := touka.New()
+	r.Use(Compression(CompressOptions{Algorithms: map[string]AlgorithmConfig{
+		EncodingGzip: {Level: 6},
+		EncodingZstd: {Level: zstdDefaultLevel},
+	}}))
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		if !ServeCompressed(c, http.StatusOK, stored) {
+			t.Error("Expected a stored representation to be served")
+		}
+	})
+
+	tests := []struct {
+		accept string
+		want   string
+		body   []byte
+	}{
+		{"br, gzip", "br", stored["br"]}, // br 不由中间件压缩, 但已保存
+		{"gzip", EncodingGzip, stored[EncodingGzip]},
+		{"zstd", EncodingZstd, nil}, // 解码 gzip 副本后由中间件以 zstd 压缩
+		{"", "", raw},
+	}
+	for _, tt := range tests {
+		if tt.want == EncodingZstd && !zstdAvailable {
+			continue
+		}
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", tt.accept)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if got := w.Header().Get("Content-Encoding"); got != tt.want {
+			t.Errorf("%q: expected Content-Encoding %q, got %q", tt.accept, tt.want, got)
+			continue
+		}
+		if w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%q: expected Vary: Accept-Encoding, got %q", tt.accept, w.Header().Get("Vary"))
+		}
+		body := w.Body.Bytes()
+		if tt.want == EncodingZstd {
+			zr, err := zstd.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ = io.ReadAll(zr)
+			zr.Close()
+			tt.body = raw
+		}
+		if !bytes.Equal(body, tt.body) {
+			t.Errorf("%q: body mismatch", tt.accept)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package compress
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/infinite-iroha/touka"
+)
+
+// CopyBody 把上游响应 resp 的状态码与响应体写入 c 并关闭 resp.Body, 用于反向代理等转发上游响应的处理器:
+//
+//	resp, err := client.Do(upstreamReq)
+//	if err != nil {
+//		c.ErrorUseHandle(http.StatusBadGateway, err)
+//		return
+//	}
+//	c.Header("Cache-Control", resp.Header.Get("Cache-Control"))
+//	compress.CopyBody(c, resp)
+//
+// 未编码的上游响应保留其 Content-Length (MinContentLength 等按长度的规则照常生效), 由中间件按协商结果压缩,
+// 响应体经池化的缓冲区直接写入压缩器。已编码的上游响应在客户端接受该编码时原样发送 (跳过原因 SkipPreEncoded);
+// 否则解码 (gzip、deflate 或 zstd) 后交给中间件重新压缩, 无法解码的编码仍原样发送。
+// Content-Type 未设置时取自 resp, 其余头部由调用方按需复制。复制失败记录在 c.Errors 中。
+// 处理器自行 io.Copy(c.Writer, resp.Body) 同样经池化的缓冲区写入, 但需自行处理上游的编码与长度。
+func CopyBody(c *touka.Context, resp *http.Response) {
+	h := c.Writer.Header()
+	if ct := resp.Header.Get(headerContentType); ct != "" && h.Get(headerContentType) == "" {
+		h.Set(headerContentType, ct)
+	}
+	if enc := upstreamEncoding(resp.Header); enc != "" {
+		accept := c.Request.Header.Get(headerAcceptEncoding)
+		if crw, ok := c.Writer.(*compressResponseWriter); ok {
+			accept = crw.acceptEncoding
+		}
+		if SelectEncoding(accept, []string{enc}) == enc || !decodable(enc) {
+			serveEncoded(c, resp.StatusCode, enc, resp.Body, resp.ContentLength)
+		} else {
+			serveDecoded(c, resp.StatusCode, resp.Body, enc)
+		}
+		return
+	}
+
+	defer resp.Body.Close()
+	if resp.ContentLength >= 0 {
+		h.Set(headerContentLength, strconv.FormatInt(resp.ContentLength, 10))
+	} else {
+		h.Del(headerContentLength)
+	}
+	c.Writer.WriteHeader(resp.StatusCode)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(c.Writer, resp.Body); err != nil {
+		c.AddError(fmt.Errorf("failed to copy upstream response: %w", err))
+	}
+}
+
+// upstreamEncoding 返回上游响应的内容编码 (小写), 未编码或为 identity 时返回 ""。
+// 多重编码 (如 "deflate, gzip") 原样返回, 不会被视为可解码的编码
+func upstreamEncoding(h http.Header) string {
+	enc := strings.ToLower(strings.TrimSpace(strings.Join(h.Values(headerContentEncoding), ", ")))
+	if enc == EncodingIdentity {
+		return ""
+	}
+	return enc
+}
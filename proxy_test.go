@@ -0,0 +1,97 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestCopyBody(t *testing.T) {
+	body := strings.Repeat("upstream response body ", 200)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(body))
+	zw.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		switch r.URL.Path {
+		case "/gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("Content-Length", strconv.Itoa(gz.Len()))
+			w.Write(gz.Bytes())
+		case "/small":
+			w.Header().Set("Content-Length", "5")
+			io.WriteString(w, "small")
+		default:
+			w.WriteHeader(http.StatusAccepted)
+			io.WriteString(w, body)
+		}
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	r := touka.New()
+	r.Use(Compression(CompressOptions{
+		Algorithms:       map[string]AlgorithmConfig{EncodingGzip: {Level: 6}},
+		MinContentLength: 64,
+		DebugHeader:      true,
+	}))
+	r.GET("/*path", func(c *touka.Context) {
+		resp, err := client.Get(upstream.URL + c.Param("path"))
+		if err != nil {
+			c.ErrorUseHandle(http.StatusBadGateway, err)
+			return
+		}
+		CopyBody(c, resp)
+	})
+	serve := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", accept)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder) string {
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(gr)
+		return string(got)
+	}
+
+	// 未编码的上游响应由中间件压缩
+	w := serve("/plain", "gzip")
+	if w.Code != http.StatusAccepted || w.Header().Get("Content-Encoding") != EncodingGzip {
+		t.Fatalf("Expected compressed 202, got %d %v", w.Code, w.Header())
+	}
+	if got := decode(w); got != body {
+		t.Error("Body mismatch for identity upstream")
+	}
+
+	// 上游的 Content-Length 使最小长度规则生效
+	w = serve("/small", "gzip")
+	if got := w.Header().Get("X-Compression-Info"); got != "skipped=too_small" || w.Body.String() != "small" {
+		t.Errorf("Expected small upstream response to be skipped, got %q %q", got, w.Body.String())
+	}
+
+	// 客户端接受上游的编码时原样发送
+	w = serve("/gzip", "gzip")
+	if !bytes.Equal(w.Body.Bytes(), gz.Bytes()) || w.Header().Get("Content-Length") != strconv.Itoa(gz.Len()) {
+		t.Errorf("Expected the upstream gzip bytes to pass through, got %v", w.Header())
+	}
+
+	// 否则解码后发送
+	w = serve("/gzip", "identity")
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != body {
+		t.Errorf("Expected decoded body, got %v", w.Header())
+	}
+}
@@ -0,0 +1,6 @@
+//go:build race
+
+package compress
+
+// raceEnabled 报告测试是否在竞态检测下运行; 此时 sync.Pool 会随机丢弃对象, 分配计数不可靠
+const raceEnabled = true
@@ -0,0 +1,80 @@
+package compress
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+)
+
+// config 是一份生效的配置及由它派生的运行时对象, 创建后只读。
+// 每个请求在开始时取得当时的 config 并用到响应结束, UpdateOptions 只影响之后的请求。
+type config struct {
+	opts    CompressOptions
+	plan    *plan          // 由 opts 编译的执行计划
+	slots   chan struct{}  // MaxConcurrentCompressions 的名额, 为 nil 时不限制
+	clients *clientLimiter // ClientRate 的按客户端限速, 为 nil 时不限制
+
+	routeDisabled bool               // 由 Override 派生且关闭了压缩
+	profiles      map[string]*config // 由 Profiles 派生的配置, 创建后只读
+	proxies       []netip.Prefix     // 解析后的 TrustedProxies
+	overlays      sync.Map           // 由各 Override 派生的配置 (*PartialOptions -> *config), 见 config.overlay
+}
+
+// newConfig 由已补全默认值的 opts 创建 config。
+// prev 非 nil 时, 限制未变的并发名额与限速状态沿用 prev 的, 使替换前后的请求共享同一上限。
+func newConfig(opts CompressOptions, prev *config) *config {
+	cfg := &config{opts: opts}
+	cfg.plan = compilePlan(&cfg.opts)
+	cfg.plan.preparePools()
+	switch {
+	case prev != nil && cap(prev.slots) == opts.MaxConcurrentCompressions:
+		cfg.slots = prev.slots
+	case opts.MaxConcurrentCompressions > 0:
+		cfg.slots = make(chan struct{}, opts.MaxConcurrentCompressions)
+	}
+	switch {
+	case prev != nil && prev.opts.ClientRate == opts.ClientRate && prev.opts.ClientBurst == opts.ClientBurst:
+		cfg.clients = prev.clients
+	case opts.ClientRate > 0:
+		cfg.clients = newClientLimiter(opts.ClientRate, opts.ClientBurst)
+	}
+	for _, s := range opts.TrustedProxies {
+		if p, err := parseProxy(s); err == nil {
+			cfg.proxies = append(cfg.proxies, p)
+		}
+	}
+	if len(opts.Profiles) > 0 {
+		cfg.profiles = make(map[string]*config, len(opts.Profiles))
+		for name, p := range opts.Profiles {
+			cfg.profiles[name] = p.apply(cfg)
+		}
+	}
+	return cfg
+}
+
+// config 返回当前生效的配置
+func (m *Middleware) config() *config { return m.cfg.Load() }
+
+// Options 返回当前生效的配置 (已补全默认值)。返回值与中间件共享 Algorithms 等映射和切片, 不应修改。
+func (m *Middleware) Options() CompressOptions { return m.config().opts }
+
+// UpdateOptions 在运行时原子地替换配置, 例如在故障期间调整级别或停用 zstd 而无需重启。
+// 已开始的请求继续使用原来的配置直到结束, 之后的请求使用新配置。
+//
+// opts 未通过 Validate 时不做任何更改并返回该错误。
+// AsyncWorkers、AsyncQueue 与 ExpvarName 只在 New 时生效, 更新时沿用原值。
+func (m *Middleware) UpdateOptions(opts CompressOptions) error {
+	if err := opts.Validate(); err != nil {
+		return fmt.Errorf("compress: options not updated: %w", err)
+	}
+	opts = withDefaults(opts)
+
+	m.closeMu.Lock() // 与并发的 UpdateOptions 及 Close 互斥
+	defer m.closeMu.Unlock()
+	prev := m.config()
+	opts.AsyncWorkers = prev.opts.AsyncWorkers
+	opts.AsyncQueue = prev.opts.AsyncQueue
+	opts.ExpvarName = prev.opts.ExpvarName
+	m.cfg.Store(newConfig(opts, prev))
+	return nil
+}
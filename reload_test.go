@@ -0,0 +1,81 @@
+package compress
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestUpdateOptions(t *testing.T) {
+	skipWithoutZstd(t)
+	m := New(CompressOptions{MaxConcurrentCompressions: 4})
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.Writer.WriteHeader(http.StatusOK)
+		if c.Query("block") != "" {
+			close(started)
+			<-unblock
+		}
+		c.Writer.Write([]byte(strings.Repeat("reload ", 100)))
+	})
+	do := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/"+query, nil)
+		req.Header.Set("Accept-Encoding", "gzip, zstd")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	var wg sync.WaitGroup
+	var inflight *httptest.ResponseRecorder
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		inflight = do("?block=1")
+	}()
+	<-started
+
+	slots := m.config().slots
+	err := m.UpdateOptions(CompressOptions{
+		Algorithms:                map[string]AlgorithmConfig{EncodingZstd: {Level: zstdDefaultLevel, PoolEnabled: true}},
+		EncodingPriority:          []string{EncodingZstd},
+		MaxConcurrentCompressions: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.config().slots != slots {
+		t.Error("Expected unchanged concurrency limit to keep its slots")
+	}
+	if got := m.ActiveCompressions(); got != 1 {
+		t.Errorf("Expected in-flight compression to stay counted, got %d", got)
+	}
+	if got := do("").Header().Get("Content-Encoding"); got != EncodingZstd {
+		t.Errorf("Expected new requests to use zstd, got %q", got)
+	}
+
+	close(unblock)
+	wg.Wait()
+	if got := inflight.Header().Get("Content-Encoding"); got != EncodingGzip {
+		t.Errorf("Expected in-flight request to finish with gzip, got %q", got)
+	}
+	if got := m.ActiveCompressions(); got != 0 {
+		t.Errorf("Expected all slots released, got %d", got)
+	}
+
+	// 无效的配置不生效
+	if err := m.UpdateOptions(CompressOptions{EncodingPriority: []string{"br"}}); err == nil {
+		t.Error("Expected invalid options to be rejected")
+	}
+	if got := m.Options().EncodingPriority; len(got) != 1 || got[0] != EncodingZstd {
+		t.Errorf("Expected options to be unchanged, got %v", got)
+	}
+}
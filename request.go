@@ -0,0 +1,246 @@
+package compress
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/infinite-iroha/touka"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zlib"
+)
+
+// defaultMaxRequestBytes 是 RequestOptions.MaxBodyBytes 为 0 时解压后请求体的上限
+const defaultMaxRequestBytes = 10 << 20
+
+// RequestOptions 配置 DecompressRequests
+type RequestOptions struct {
+	// Encodings 是接受的请求 Content-Encoding, 为空时接受 gzip、deflate 与 zstd (以 compress_no_zstd 构建时不含 zstd)
+	Encodings []string
+	// MaxBodyBytes 限制解压后的请求体长度, 0 时为 10 MiB, 小于 0 时不限制。
+	// 压缩的请求体很小也可能解压出大量数据, 未压缩请求体的长度应由服务器或其他中间件另行限制。
+	MaxBodyBytes int64
+}
+
+// RequestErrorKind 表示请求体解压失败的类型
+type RequestErrorKind uint8
+
+const (
+	RequestCorrupt   RequestErrorKind = iota // 压缩数据格式错误
+	RequestChecksum                          // 校验和不匹配 (gzip 的 CRC-32, zlib 的 Adler-32, zstd 的 XXH64)
+	RequestTruncated                         // 压缩流在结束前中断
+	RequestTooLarge                          // 解压后超过 MaxBodyBytes
+	numRequestErrorKinds
+)
+
+var requestErrorKindNames = [numRequestErrorKinds]string{
+	RequestCorrupt:   "corrupt",
+	RequestChecksum:  "checksum",
+	RequestTruncated: "truncated",
+	RequestTooLarge:  "too_large",
+}
+
+// String 返回类型的名称, 与统计快照中的键一致
+func (k RequestErrorKind) String() string {
+	if k < numRequestErrorKinds {
+		return requestErrorKindNames[k]
+	}
+	return "unknown"
+}
+
+// RequestBodyError 是 DecompressRequests 解压请求体失败时读取返回的错误。
+// 读取原请求体本身的错误 (如客户端断开) 不包装, 原样返回。
+type RequestBodyError struct {
+	Encoding string
+	Kind     RequestErrorKind
+	Err      error
+}
+
+func (e *RequestBodyError) Error() string {
+	return fmt.Sprintf("compress: %s request body %s: %v", e.Encoding, e.Kind, e.Err)
+}
+
+func (e *RequestBodyError) Unwrap() error { return e.Err }
+
+// StatusCode 返回应答该错误的状态码: RequestTooLarge 为 413, 其余为 400
+func (e *RequestBodyError) StatusCode() int {
+	if e.Kind == RequestTooLarge {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// DecompressRequests 返回解压请求体的中间件, 计入 m 的统计 (见 StatsSnapshot.Requests), 例如:
+//
+//	r.POST("/ingest", m.DecompressRequests(compress.RequestOptions{}), ingest)
+//
+// 带有 Content-Encoding 的请求体在处理器读取时解压, 并删除请求的 Content-Encoding 与 Content-Length。
+// 不接受的编码以 415 拒绝, 响应的 Accept-Encoding 列出接受的编码。解压失败时读取返回 *RequestBodyError;
+// 处理器没有写出响应时, 中间件以 ErrorUseHandle 按 StatusCode 应答, 已写出时由处理器以 errors.As 自行处理。
+// Encodings 中有未知的编码时 panic。
+func (m *Middleware) DecompressRequests(opts RequestOptions) touka.HandlerFunc {
+	accepted := opts.Encodings
+	if len(accepted) == 0 {
+		accepted = []string{EncodingGzip, EncodingDeflate}
+		if zstdAvailable {
+			accepted = append(accepted, EncodingZstd)
+		}
+	}
+	for _, enc := range accepted {
+		if !decodable(enc) {
+			panic(fmt.Sprintf("compress: cannot decode request encoding %q", enc))
+		}
+	}
+	acceptHeader := strings.Join(accepted, ", ")
+	limit := opts.MaxBodyBytes
+	if limit == 0 {
+		limit = defaultMaxRequestBytes
+	}
+
+	return func(c *touka.Context) {
+		req := c.Request
+		enc := strings.ToLower(strings.TrimSpace(req.Header.Get(headerContentEncoding)))
+		if enc == "" || enc == "identity" || req.Body == nil || req.Body == http.NoBody {
+			c.Next()
+			return
+		}
+		if enc == "x-gzip" {
+			enc = EncodingGzip
+		}
+		if !slices.Contains(accepted, enc) {
+			m.stats.requests.unsupported.Add(1)
+			c.Writer.Header().Set(headerAcceptEncoding, acceptHeader)
+			c.ErrorUseHandle(http.StatusUnsupportedMediaType, fmt.Errorf("compress: unsupported request Content-Encoding %q", enc))
+			return
+		}
+
+		body := &requestBody{src: req.Body, encoding: enc, limit: limit}
+		req.Body = body
+		req.Header.Del(headerContentEncoding)
+		req.Header.Del(headerContentLength)
+		req.ContentLength = -1
+		defer func() {
+			body.release()
+			m.stats.recordRequest(body)
+		}()
+		c.Next()
+		if body.err != nil && !c.Writer.Written() {
+			c.ErrorUseHandle(body.err.StatusCode(), body.err)
+		}
+	}
+}
+
+// requestBody 在读取时解压原请求体, 解码器在第一次读取时创建
+type requestBody struct {
+	src      io.ReadCloser
+	encoding string
+	limit    int64 // 解压后的上限, 小于 0 时不限制
+
+	dec     io.Reader
+	done    func()
+	in, out int64 // 读取的压缩数据与解压后的字节数
+	srcErr  error // 读取 src 的错误 (不含 io.EOF)
+	err     *RequestBodyError
+	eof     bool
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.eof {
+		return 0, io.EOF
+	}
+	if b.dec == nil {
+		dec, done, err := newRequestDecoder(b.encoding, (*requestSource)(b))
+		if err != nil {
+			return 0, b.fail(err)
+		}
+		b.dec, b.done = dec, done
+	}
+	if b.limit >= 0 && int64(len(p)) > b.limit-b.out+1 {
+		p = p[:b.limit-b.out+1] // 多读一个字节以发现超出上限
+	}
+	n, err := b.dec.Read(p)
+	b.out += int64(n)
+	if b.limit >= 0 && b.out > b.limit {
+		n -= int(b.out - b.limit)
+		b.out = b.limit
+		b.err = &RequestBodyError{Encoding: b.encoding, Kind: RequestTooLarge, Err: fmt.Errorf("exceeds %d bytes", b.limit)}
+		return n, b.err
+	}
+	switch {
+	case err == io.EOF:
+		b.eof = true
+	case err != nil:
+		err = b.fail(err)
+	}
+	return n, err
+}
+
+// fail 将解码器的错误分类为 *RequestBodyError; 读取原请求体的错误原样返回
+func (b *requestBody) fail(err error) error {
+	if b.srcErr != nil {
+		return err
+	}
+	kind := RequestCorrupt
+	switch {
+	case err == io.EOF, errors.Is(err, io.ErrUnexpectedEOF):
+		kind = RequestTruncated // io.EOF 只在创建解码器时出现: 请求体为空
+	case errors.Is(err, gzip.ErrChecksum), errors.Is(err, zlib.ErrChecksum), zstdChecksumError(err):
+		kind = RequestChecksum
+	}
+	b.err = &RequestBodyError{Encoding: b.encoding, Kind: kind, Err: err}
+	return b.err
+}
+
+// release 释放解码器, 可以多次调用
+func (b *requestBody) release() {
+	if b.done != nil {
+		b.done()
+		b.done = nil
+	}
+}
+
+func (b *requestBody) Close() error {
+	b.release()
+	return b.src.Close()
+}
+
+// requestSource 读取原请求体, 记录读取的字节数与错误
+type requestSource requestBody
+
+func (s *requestSource) Read(p []byte) (int, error) {
+	n, err := s.src.Read(p)
+	s.in += int64(n)
+	if err != nil && err != io.EOF {
+		s.srcErr = err
+	}
+	return n, err
+}
+
+// newRequestDecoder 返回解压请求体 r 的 Reader 及用完后释放资源的函数。
+// deflate 按 HTTP 的定义是 zlib 格式, 但不少客户端发送裸 deflate 流, 这里按前两个字节是否为 zlib 头区分。
+func newRequestDecoder(encoding string, r io.Reader) (io.Reader, func(), error) {
+	switch encoding {
+	case EncodingDeflate:
+		br := bufio.NewReader(r)
+		if h, err := br.Peek(2); err == nil && h[0]&0x0f == 8 && h[0]>>4 <= 7 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0 {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, nil, err
+			}
+			return zr, func() { zr.Close() }, nil
+		}
+		fr := flate.NewReader(br)
+		return fr, func() { fr.Close() }, nil
+	case EncodingZstd:
+		return newZstdRequestDecoder(r)
+	}
+	return newDecoder(encoding, r)
+}
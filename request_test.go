@@ -0,0 +1,189 @@
+package compress
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/infinite-iroha/touka"
+)
+
+// encodeBytes 以 encoding 的默认级别压缩 p
+func encodeBytes(t testing.TB, encoding string, p []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	codec, _ := LookupCodec(encoding)
+	w, err := NewEncoder(encoding, codec.DefaultLevel, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(p)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// errReader 在读出 data 后返回 err
+type errReader struct {
+	data []byte
+	err  error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestDecompressRequests(t *testing.T) {
+	payload := []byte(strings.Repeat(`{"event":"click","id":42}`+"\n", 400))
+	gz := encodeBytes(t, EncodingGzip, payload)
+	var zl bytes.Buffer
+	zw := zlib.NewWriter(&zl)
+	zw.Write(payload)
+	zw.Close()
+	corrupt := func(p []byte, i int) []byte {
+		p = bytes.Clone(p)
+		p[len(p)+i] ^= 0xff
+		return p
+	}
+
+	m := New(DefaultCompressionConfig())
+	var got []byte
+	var readErr error
+	r := touka.New()
+	r.POST("/", m.DecompressRequests(RequestOptions{MaxBodyBytes: int64(len(payload))}), func(c *touka.Context) {
+		if ce := c.Request.Header.Get("Content-Encoding"); ce != "" {
+			t.Errorf("Expected Content-Encoding to be removed, got %q", ce)
+		}
+		got, readErr = io.ReadAll(c.Request.Body)
+		if readErr == nil {
+			c.String(http.StatusOK, "ok")
+		}
+	})
+	post := func(encoding string, body io.Reader) *httptest.ResponseRecorder {
+		got, readErr = nil, nil
+		req := httptest.NewRequest("POST", "/", body)
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, tc := range []struct {
+		name, encoding string
+		body           []byte
+	}{
+		{"gzip", "gzip", gz},
+		{"x-gzip", "X-Gzip", gz},
+		{"zlib", "deflate", zl.Bytes()},
+		{"raw deflate", "deflate", encodeBytes(t, EncodingDeflate, payload)},
+		{"identity", "", payload},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if w := post(tc.encoding, bytes.NewReader(tc.body)); w.Code != http.StatusOK || !bytes.Equal(got, payload) {
+				t.Errorf("Expected the decoded payload, got %d, %d bytes, %v", w.Code, len(got), readErr)
+			}
+		})
+	}
+
+	failures := []struct {
+		name, encoding string
+		body           []byte
+		kind           RequestErrorKind
+		code           int
+	}{
+		{"gzip crc", "gzip", corrupt(gz, -5), RequestChecksum, http.StatusBadRequest},
+		{"zlib adler", "deflate", corrupt(zl.Bytes(), -1), RequestChecksum, http.StatusBadRequest},
+		{"gzip truncated", "gzip", gz[:len(gz)/2], RequestTruncated, http.StatusBadRequest},
+		{"gzip empty", "gzip", nil, RequestTruncated, http.StatusBadRequest},
+		{"gzip header", "gzip", []byte("not gzip at all"), RequestCorrupt, http.StatusBadRequest},
+		{"too large", "gzip", encodeBytes(t, EncodingGzip, append(payload, '!')), RequestTooLarge, http.StatusRequestEntityTooLarge},
+	}
+	if zstdAvailable {
+		zs := encodeBytes(t, EncodingZstd, payload)
+		failures = append(failures, struct {
+			name, encoding string
+			body           []byte
+			kind           RequestErrorKind
+			code           int
+		}{"zstd checksum", "zstd", corrupt(zs, -1), RequestChecksum, http.StatusBadRequest})
+	}
+	for _, tc := range failures {
+		t.Run(tc.name, func(t *testing.T) {
+			w := post(tc.encoding, bytes.NewReader(tc.body))
+			var rbe *RequestBodyError
+			if !errors.As(readErr, &rbe) || rbe.Kind != tc.kind {
+				t.Fatalf("Expected a %s RequestBodyError, got %v", tc.kind, readErr)
+			}
+			if w.Code != tc.code {
+				t.Errorf("Expected %d, got %d", tc.code, w.Code)
+			}
+		})
+	}
+
+	// 读取原请求体的错误原样返回, 不计为解压失败
+	errDisconnect := errors.New("client went away")
+	post("gzip", &errReader{data: gz[:20], err: errDisconnect})
+	if !errors.Is(readErr, errDisconnect) || errors.As(readErr, new(*RequestBodyError)) {
+		t.Errorf("Expected the transport error unchanged, got %v", readErr)
+	}
+
+	w := post("br", bytes.NewReader(payload))
+	if w.Code != http.StatusUnsupportedMediaType || w.Header().Get("Accept-Encoding") == "" {
+		t.Errorf("Expected 415 with Accept-Encoding, got %d %v", w.Code, w.Header())
+	}
+
+	rs := m.Stats().Snapshot().Requests
+	if rs.Decoded != 4 || rs.Unsupported != 1 || rs.BytesOut < 4*uint64(len(payload)) {
+		t.Errorf("Unexpected request stats %+v", rs)
+	}
+	wantErrors := map[string]uint64{"checksum": 2, "truncated": 2, "corrupt": 1, "too_large": 1}
+	if zstdAvailable {
+		wantErrors["checksum"]++
+	}
+	for kind, n := range wantErrors {
+		if rs.Errors[kind] != n {
+			t.Errorf("Expected %d %s errors, got %d", n, kind, rs.Errors[kind])
+		}
+	}
+}
+
+func TestDecompressRequestsHandlerResponds(t *testing.T) {
+	// 处理器已自行应答时, 中间件不再写出错误
+	m := New(DefaultCompressionConfig())
+	r := touka.New()
+	r.POST("/", m.DecompressRequests(RequestOptions{}), func(c *touka.Context) {
+		if _, err := io.ReadAll(c.Request.Body); err != nil {
+			var rbe *RequestBodyError
+			if errors.As(err, &rbe) {
+				c.String(http.StatusUnprocessableEntity, "%s", rbe.Kind)
+			}
+		}
+	})
+	req := httptest.NewRequest("POST", "/", strings.NewReader("garbage"))
+	req.Header.Set("Content-Encoding", "deflate")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnprocessableEntity || w.Body.String() != "corrupt" {
+		t.Errorf("Expected the handler's response, got %d %q", w.Code, w.Body.String())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic for an unknown encoding")
+		}
+	}()
+	m.DecompressRequests(RequestOptions{Encodings: []string{"br"}})
+}
@@ -0,0 +1,80 @@
+package compress
+
+import (
+	"slices"
+	"time"
+)
+
+// rsyncMask 决定内容边界的平均间隔: 滚动哈希的高 13 位全为 0 时视为边界, 在最短间隔之后平均约 8 KiB 一个。
+// gear 哈希每字节左移一位, 第 k 位只取决于最近 k+1 个字节, 因此检查高位才能让边界取决于最近 64 个字节;
+// 低位只取决于最近十几个字节, 在重复的内容上会产生大量过短的块。
+const rsyncMask = (1<<13 - 1) << 51
+
+// rsyncMinChunk 是两个边界之间的最少字节数, 避免每次同步刷新只输出很少的数据而明显降低压缩比
+const rsyncMinChunk = 2 << 10
+
+// gearTable 是 gear 滚动哈希的字节表, 由固定种子生成, 相同的内容在任何进程中都得到相同的边界
+var gearTable = func() (t [256]uint64) {
+	x := uint64(0x9e3779b97f4a7c15)
+	for i := range t {
+		// splitmix64
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		t[i] = z ^ z>>31
+	}
+	return t
+}()
+
+// rsyncBoundary 以滚动哈希 h 继续扫描 p, 返回更新后的哈希与第一个边界之后的位置; 没有边界时返回 -1。
+// n 是上一个边界之后已扫描的字节数, 不足 rsyncMinChunk 时不视为边界。
+// 哈希只取决于最近 64 个字节, 因此边界只由附近的内容决定, 与之前插入或删除的数据无关。
+func rsyncBoundary(h uint64, n int, p []byte) (uint64, int) {
+	for i, b := range p {
+		h = h<<1 + gearTable[b]
+		if h&rsyncMask == 0 && n+i+1 >= rsyncMinChunk {
+			return h, i + 1
+		}
+	}
+	return h, -1
+}
+
+// rsyncable 报告媒体类型是否匹配 RsyncableTypes
+func (p *plan) rsyncable(mediaType string) bool {
+	return slices.ContainsFunc(p.rsyncTypes, func(t string) bool { return hasPrefixFold(mediaType, t) })
+}
+
+// writeRsyncable 把 data 写入压缩器, 并在每个内容边界处同步刷新压缩器 (不刷新到网络),
+// 使输入中未改变的部分在边界之后重新得到相同的压缩输出。
+func (crw *compressResponseWriter) writeRsyncable(data []byte) (int, error) {
+	var start time.Time
+	if crw.timed {
+		start = time.Now()
+	}
+	written := 0
+	for len(data) > 0 {
+		h, i := rsyncBoundary(crw.rsyncHash, crw.rsyncLen, data)
+		crw.rsyncHash = h
+		chunk := data
+		if i >= 0 {
+			chunk = data[:i]
+			crw.rsyncLen = 0
+		} else {
+			crw.rsyncLen += len(chunk)
+		}
+		n, err := crw.compressor.Write(chunk)
+		written += n
+		if err == nil && i >= 0 {
+			err = crw.compressor.Flush()
+		}
+		if err != nil {
+			return written, err
+		}
+		data = data[len(chunk):]
+	}
+	if crw.timed {
+		crw.encodeTime += time.Since(start)
+	}
+	return written, nil
+}
@@ -0,0 +1,145 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math/rand/v2"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestRsyncableTypes(t *testing.T) {
+	// 不重复的伪随机文本, 使压缩输出依赖于具体内容
+	rng := rand.New(rand.NewPCG(1, 2))
+	words := []string{"alpha", "beta", "gamma", "delta", "epsilon", "zeta", "eta", "theta", "iota", "kappa"}
+	var sb strings.Builder
+	for sb.Len() < 256<<10 {
+		sb.WriteString(words[rng.IntN(len(words))])
+		sb.WriteByte(" \n"[rng.IntN(2)])
+	}
+	original := sb.String()
+	edited := "a small edit at the start\n" + original
+
+	r := touka.New()
+	r.Use(Compression(CompressOptions{
+		Algorithms:        map[string]AlgorithmConfig{EncodingGzip: {Level: 6, PoolEnabled: true}},
+		CompressibleTypes: []string{"application/x-"},
+		RsyncableTypes:    []string{"application/x-rsync"},
+	}))
+	r.GET("/:type", func(c *touka.Context) {
+		body := original
+		if c.Query("edited") != "" {
+			body = edited
+		}
+		c.Header("Content-Type", "application/x-"+c.Param("type"))
+		for i := 0; i < len(body); i += 1000 {
+			c.Writer.Write([]byte(body[i:min(i+1000, len(body))]))
+		}
+	})
+	fetch := func(target, want string) []byte {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		gr, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		if got, _ := io.ReadAll(gr); string(got) != want {
+			t.Fatalf("%s: body mismatch", target)
+		}
+		return w.Body.Bytes()
+	}
+	// 公共后缀的长度, 不含 gzip 尾部的 CRC 与长度
+	commonSuffix := func(a, b []byte) int {
+		a, b = a[:len(a)-8], b[:len(b)-8]
+		n := 0
+		for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+			n++
+		}
+		return n
+	}
+
+	rsync := commonSuffix(fetch("/rsync", original), fetch("/rsync?edited=1", edited))
+	plain := commonSuffix(fetch("/plain", original), fetch("/plain?edited=1", edited))
+	if size := len(fetch("/rsync", original)); rsync < size/2 {
+		t.Errorf("Expected rsyncable outputs to share most of their %d bytes, got %d", size, rsync)
+	}
+	if plain > 1024 {
+		t.Errorf("Expected regular outputs to diverge after an edit, got %d common bytes", plain)
+	}
+}
+
+func TestRsyncBoundary(t *testing.T) {
+	data := make([]byte, 1<<18)
+	for i := range data {
+		data[i] = byte(rand.Uint32())
+	}
+	boundaries := scanBoundaries(data)
+	// 逐字节扫描得到相同的边界
+	var h uint64
+	var single []int
+	n := 0
+	for i := range data {
+		var j int
+		if h, j = rsyncBoundary(h, n, data[i:i+1]); j == 1 {
+			single = append(single, i+1)
+			n = 0
+		} else {
+			n++
+		}
+	}
+	if len(boundaries) < 10 {
+		t.Fatalf("Expected boundaries about every 10 KiB, got %d in %d bytes", len(boundaries), len(data))
+	}
+	if len(boundaries) != len(single) {
+		t.Fatalf("Expected the same boundaries, got %d and %d", len(boundaries), len(single))
+	}
+	for i := range boundaries {
+		if boundaries[i] != single[i] {
+			t.Fatalf("Boundary %d differs: %d vs %d", i, boundaries[i], single[i])
+		}
+	}
+}
+
+// scanBoundaries 返回 data 中所有内容边界的位置
+func scanBoundaries(data []byte) []int {
+	var h uint64
+	var boundaries []int
+	for pos := 0; pos < len(data); {
+		var i int
+		h, i = rsyncBoundary(h, 0, data[pos:])
+		if i < 0 {
+			break
+		}
+		pos += i
+		boundaries = append(boundaries, pos)
+	}
+	return boundaries
+}
+
+func TestRsyncMinChunk(t *testing.T) {
+	random := make([]byte, 1<<18)
+	for i := range random {
+		random[i] = byte(rand.Uint32())
+	}
+	inputs := map[string][]byte{
+		"random": random,
+		"zeros":  make([]byte, 1<<18),
+		"period": bytes.Repeat([]byte("0123456789abc"), 1<<14), // 周期与低位窗口相同的重复内容
+	}
+	for name, data := range inputs {
+		prev := 0
+		for _, b := range scanBoundaries(data) {
+			if b-prev < rsyncMinChunk {
+				t.Errorf("%s: chunk of %d bytes at %d is shorter than %d", name, b-prev, prev, rsyncMinChunk)
+				break
+			}
+			prev = b
+		}
+	}
+}
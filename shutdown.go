@@ -0,0 +1,52 @@
+package compress
+
+import (
+	"context"
+	"time"
+
+	"github.com/infinite-iroha/touka"
+)
+
+// Close 停止中间件接受新的压缩工作, 等待异步 worker 完成手头的响应后退出,
+// 并释放本实例有界池中的空闲压缩器。
+//
+// Close 之后的响应以 identity 发送 (跳过原因 SkipClosed); 已开始的压缩响应不受影响, 会正常结束。
+// ctx 到期时返回 ctx.Err(), 尚未退出的 worker 会在再次调用 Close 时继续回收。
+// 有界池在进程内共享, 释放后仍在使用同一池的其他实例会按需重新创建压缩器。
+func (m *Middleware) Close(ctx context.Context) error {
+	m.closed.Store(true)
+
+	m.closeMu.Lock()
+	defer m.closeMu.Unlock()
+	for m.stoppedWorkers < m.config().opts.AsyncWorkers {
+		select {
+		case wk := <-m.idleWorkers:
+			close(wk.msgs)
+			m.stoppedWorkers++
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	p := m.config().plan
+	for i := range p.encodings {
+		if bp := p.encodings[i].bounded; bp != nil {
+			bp.drain()
+		}
+	}
+	return nil
+}
+
+// Closed 报告 Close 是否已被调用
+func (m *Middleware) Closed() bool { return m.closed.Load() }
+
+// CloseOnShutdown 在 engine 开始优雅关闭 (RunShutdown 等收到信号) 时调用 Close,
+// 最多等待 timeout。需要处理 Close 的错误时应直接调用 Close。
+func (m *Middleware) CloseOnShutdown(engine *touka.Engine, timeout time.Duration) {
+	go func() {
+		<-engine.Context().Done()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		m.Close(ctx)
+	}()
+}
@@ -0,0 +1,87 @@
+package compress
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestClose(t *testing.T) {
+	m := New(CompressOptions{
+		Algorithms: map[string]AlgorithmConfig{
+			EncodingGzip: {Level: 3, PoolEnabled: true, PoolType: PoolBounded, MaxIdle: 2, PrewarmPoolSize: 2},
+		},
+		AsyncWorkers: 1,
+	})
+	body := strings.Repeat("draining ", 100)
+	started, release := make(chan struct{}), make(chan struct{})
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/slow", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		io.WriteString(c.Writer, body)
+		close(started)
+		<-release
+		io.WriteString(c.Writer, body)
+	})
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		io.WriteString(c.Writer, body)
+	})
+
+	// 进行中的异步压缩响应占用唯一的 worker
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest("GET", "/slow", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		r.ServeHTTP(w, req)
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := m.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected Close to time out while a response is in flight, got %v", err)
+	}
+	if !m.Closed() {
+		t.Error("Expected Closed to report true after Close")
+	}
+
+	// 关闭后的新响应不压缩
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w2 := httptest.NewRecorder()
+	r.ServeHTTP(w2, req)
+	if w2.Header().Get("Content-Encoding") != "" || w2.Body.String() != body {
+		t.Errorf("Expected identity response after Close, got encoding %q", w2.Header().Get("Content-Encoding"))
+	}
+	if n := m.Stats().Snapshot().Skipped[SkipClosed.String()]; n != 1 {
+		t.Errorf("Expected 1 closed skip, got %d", n)
+	}
+
+	// 进行中的响应正常结束, 之后 Close 完成
+	close(release)
+	<-done
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(gr); string(got) != body+body {
+		t.Error("In-flight response body mismatch")
+	}
+	if err := m.Close(context.Background()); err != nil {
+		t.Fatalf("Expected Close to succeed after draining, got %v", err)
+	}
+	if n := len(m.config().plan.lookup(EncodingGzip).bounded.idle); n != 0 {
+		t.Errorf("Expected bounded pool to be drained, got %d idle", n)
+	}
+}
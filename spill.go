@@ -0,0 +1,170 @@
+package compress
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/infinite-iroha/touka"
+)
+
+// SpillOptions 配置 ExactLength
+type SpillOptions struct {
+	// Dir 是溢出文件所在的目录, 为空时使用 os.TempDir()
+	Dir string
+	// MaxFileBytes 大于 0 时限制单个响应溢出到文件的压缩数据 (字节), 超过时回退为不带 Content-Length 的流式发送
+	MaxFileBytes int64
+}
+
+// ExactLength 返回让路由上的压缩响应带着确切的 Content-Length 发送的中间件, 需注册在主压缩中间件之后, 例如:
+//
+//	r.GET("/download/:name", compress.ExactLength(compress.SpillOptions{Dir: "/var/tmp"}), download)
+//
+// 用于要求 Content-Length 的客户端 (如部分下载工具显示进度或校验完整性)。压缩输出先缓冲在内存中,
+// 超过 MaxBufferedBytes (或进程内的 MaxTotalBufferedBytes) 后写入 Dir 中的临时文件, 处理器返回后才写出头部与响应体;
+// 期间处理器的 Flush 不送达客户端, 响应不能携带 trailer, 已声明的 trailer (如 ServerTiming) 改为普通头部发送。
+// 临时文件在响应结束、处理器异常结束或客户端断开时删除; 无法创建临时文件或超过 MaxFileBytes 时回退为流式发送。
+// 压缩出错时不发送截断的内容, 改为以 500 应答。未压缩的响应不受影响。
+func ExactLength(opts SpillOptions) touka.HandlerFunc {
+	o := &opts
+	return func(c *touka.Context) {
+		if crw, ok := c.Writer.(*compressResponseWriter); ok && !crw.wroteHeader {
+			crw.exact = o
+		}
+		c.Next()
+	}
+}
+
+// spillBuffer 收集 ExactLength 路由上的压缩输出, 作为 crw.out 的写入目标。
+// 异步压缩时由 worker 在持有 asyncCompressor.mu 时写入, 处理器读取状态前同样取得该锁 (见 syncAsync)。
+type spillBuffer struct {
+	crw      *compressResponseWriter
+	opts     *SpillOptions
+	limit    int64 // 内存中缓冲的上限, 见 MaxBufferedBytes
+	mem      []byte
+	reserved int64 // 计入 bufferedBytes 的字节数
+	file     *os.File
+	fileSize int64
+
+	streaming atomic.Bool // 已回退为流式发送, 之后的写入直接转给底层 ResponseWriter
+}
+
+func newSpillBuffer(crw *compressResponseWriter) *spillBuffer {
+	return &spillBuffer{crw: crw, opts: crw.exact, limit: crw.cfg.bufferLimit()}
+}
+
+func (s *spillBuffer) Write(p []byte) (int, error) {
+	if s.streaming.Load() {
+		return s.crw.ResponseWriter.Write(p)
+	}
+	if err := s.crw.ctx.Request.Context().Err(); err != nil {
+		return 0, err // 客户端已断开, 不再继续收集
+	}
+	if s.file == nil {
+		n := int64(len(p))
+		if int64(len(s.mem))+n <= s.limit && reserveBuffered(s.crw.cfg.opts.MaxTotalBufferedBytes, n) {
+			s.reserved += n
+			s.mem = append(s.mem, p...)
+			return len(p), nil
+		}
+		if err := s.spill(); err != nil {
+			s.crw.mw.logf(s.crw.ctx, LogLevelWarn, "exact length: %v, streaming without Content-Length", err)
+			return s.stream(p)
+		}
+	}
+	if max := s.opts.MaxFileBytes; max > 0 && s.fileSize+int64(len(p)) > max {
+		return s.stream(p)
+	}
+	n, err := s.file.Write(p)
+	s.fileSize += int64(n)
+	return n, err
+}
+
+// spill 把内存中的数据移到临时文件
+func (s *spillBuffer) spill() error {
+	f, err := os.CreateTemp(s.opts.Dir, "compress-spill-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(s.mem); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	s.file, s.fileSize = f, int64(len(s.mem))
+	s.releaseMem()
+	return nil
+}
+
+// stream 回退为流式发送: 写出不带 Content-Length 的头部与已收集的数据, 再写出 p
+func (s *spillBuffer) stream(p []byte) (int, error) {
+	w := s.crw.ResponseWriter
+	w.WriteHeader(s.crw.statusCode)
+	s.streaming.Store(true)
+	err := s.writeCollected(w)
+	s.cleanup()
+	if err != nil {
+		return 0, err
+	}
+	return w.Write(p)
+}
+
+// writeCollected 把已收集的数据写往 w
+func (s *spillBuffer) writeCollected(w io.Writer) error {
+	if s.file == nil {
+		_, err := w.Write(s.mem)
+		return err
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return copyBuffered(w, io.LimitReader(s.file, s.fileSize))
+}
+
+// finish 在压缩器关闭后写出头部与收集的数据, 并删除临时文件
+func (s *spillBuffer) finish() {
+	defer s.cleanup()
+	if s.streaming.Load() {
+		return
+	}
+	crw := s.crw
+	h := crw.Header()
+	if crw.err != nil {
+		// 收集的内容不完整, 不能以确切的长度发送
+		delete(h, headerContentEncoding)
+		http.Error(crw.ResponseWriter, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	// 带 Content-Length 的响应不能携带 trailer, 改为普通头部
+	delete(h, "Trailer")
+	for k, v := range h {
+		if name, ok := strings.CutPrefix(k, http.TrailerPrefix); ok {
+			delete(h, k)
+			h[http.CanonicalHeaderKey(name)] = v
+		}
+	}
+	h.Set(headerContentLength, strconv.FormatInt(crw.out.n, 10))
+	crw.ResponseWriter.WriteHeader(crw.statusCode)
+	if err := s.writeCollected(crw.ResponseWriter); err != nil {
+		crw.mw.logf(crw.ctx, LogLevelDebug, "exact length: writing response: %v", err)
+	}
+}
+
+// cleanup 释放内存缓冲并删除临时文件, 可以多次调用
+func (s *spillBuffer) cleanup() {
+	s.releaseMem()
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+		s.file = nil
+	}
+}
+
+func (s *spillBuffer) releaseMem() {
+	releaseBuffered(s.reserved)
+	s.reserved = 0
+	s.mem = nil
+}
@@ -0,0 +1,126 @@
+package compress
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestExactLength(t *testing.T) {
+	t.Run("sync", func(t *testing.T) { testExactLength(t, 0) })
+	t.Run("async", func(t *testing.T) { testExactLength(t, 2) })
+}
+
+func testExactLength(t *testing.T, workers int) {
+	dir := t.TempDir()
+	opts := DefaultCompressionConfig()
+	opts.MaxBufferedBytes = 4 << 10
+	opts.CompressionTrailers = true
+	opts.AsyncWorkers = workers
+	m := New(opts)
+	r := touka.New()
+	r.Use(m.Handler())
+	lines := func(n int) string {
+		var b strings.Builder
+		for i := range n {
+			fmt.Fprintf(&b, "%d,%x\n", i, uint32(i*2654435761)) // 不易压缩, 使输出超过内存上限
+		}
+		return b.String()
+	}
+	small, large := lines(50), lines(20000)
+	r.GET("/:size", ExactLength(SpillOptions{Dir: dir}), func(c *touka.Context) {
+		body := small
+		if c.Param("size") == "large" {
+			body = large
+		}
+		c.Writer.Header().Set("Content-Type", "text/plain")
+		for len(body) > 0 {
+			k := min(len(body), 8<<10)
+			io.WriteString(c.Writer, body[:k])
+			c.Writer.Flush() // 不应提前写出头部
+			body = body[k:]
+		}
+	})
+	r.GET("/capped", ExactLength(SpillOptions{Dir: dir, MaxFileBytes: 16 << 10}), func(c *touka.Context) {
+		c.Writer.Header().Set("Content-Type", "text/plain")
+		io.WriteString(c.Writer, large)
+	})
+	serve := func(t *testing.T, path string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("Expected spill files to be removed, %d left", len(entries))
+		}
+		return w
+	}
+	decode := func(t *testing.T, w *httptest.ResponseRecorder) string {
+		t.Helper()
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(gr)
+		return string(got)
+	}
+
+	for _, tc := range []struct{ name, body string }{{"small", small}, {"large", large}} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serve(t, "/"+tc.name)
+			if cl := w.Header().Get("Content-Length"); cl != strconv.Itoa(w.Body.Len()) {
+				t.Errorf("Expected Content-Length %d, got %q", w.Body.Len(), cl)
+			}
+			if w.Header().Get(headerCompressedLength) != strconv.Itoa(w.Body.Len()) || w.Header().Get("Trailer") != "" {
+				t.Errorf("Expected trailers to be sent as headers, got %v", w.Header())
+			}
+			if decode(t, w) != tc.body {
+				t.Error("Body mismatch")
+			}
+		})
+	}
+
+	t.Run("capped", func(t *testing.T) {
+		w := serve(t, "/capped")
+		if cl := w.Header().Get("Content-Length"); cl != "" {
+			t.Errorf("Expected streaming without Content-Length over MaxFileBytes, got %q", cl)
+		}
+		if decode(t, w) != large {
+			t.Error("Body mismatch")
+		}
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		r.GET("/disconnect", ExactLength(SpillOptions{Dir: dir}), func(c *touka.Context) {
+			c.Writer.Header().Set("Content-Type", "text/plain")
+			io.WriteString(c.Writer, large[:len(large)/2])
+			cancel()
+			io.WriteString(c.Writer, large[len(large)/2:])
+		})
+		req := httptest.NewRequest("GET", "/disconnect", nil).WithContext(ctx)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("Expected spill files to be removed, %d left", len(entries))
+		}
+		if w.Code != http.StatusInternalServerError || w.Header().Get("Content-Encoding") != "" {
+			t.Errorf("Expected no truncated compressed body, got %d %v", w.Code, w.Header())
+		}
+	})
+
+	if b := bufferedBytes.Load(); b != 0 {
+		t.Errorf("Expected buffered bytes to be released, %d left", b)
+	}
+}
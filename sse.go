@@ -0,0 +1,34 @@
+package compress
+
+import (
+	"bytes"
+
+	"github.com/infinite-iroha/touka"
+)
+
+// mediaTypeEventStream 是服务器发送事件 (SSE) 的媒体类型
+const mediaTypeEventStream = "text/event-stream"
+
+// SSECompatible 返回在路由上启用事件流压缩的中间件, 需注册在主压缩中间件之后, 例如:
+//
+//	r.GET("/events", compress.SSECompatible(), func(c *touka.Context) {
+//		c.EventStream(...)
+//	})
+//
+// 默认情况下 text/event-stream 响应总是以 identity 发送 (跳过原因 SkipEventStream)。
+// 启用后事件流不受 CompressibleTypes 与 MinContentLength 限制, 也忽略 touka 的 SSE 辅助函数
+// 附带的 Cache-Control: no-transform (它针对的是中间代理); 每写完一个事件 (以空行结尾) 即刷新压缩器,
+// 客户端不会因压缩器缓冲而迟迟收不到事件。
+func SSECompatible() touka.HandlerFunc {
+	return func(c *touka.Context) {
+		if crw, ok := c.Writer.(*compressResponseWriter); ok && !crw.wroteHeader {
+			crw.sse = true
+		}
+		c.Next()
+	}
+}
+
+// endsEvent 报告写入的数据是否以结束一个事件的空行结尾
+func endsEvent(p []byte) bool {
+	return bytes.HasSuffix(p, []byte("\n\n")) || bytes.HasSuffix(p, []byte("\r\n\r\n")) || bytes.HasSuffix(p, []byte("\r\r"))
+}
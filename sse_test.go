@@ -0,0 +1,148 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestSSECompatible(t *testing.T) {
+	const event = "event: tick\ndata: hello\n\n"
+	w := httptest.NewRecorder()
+	var midStream string // 第一个事件写完后客户端已能解出的内容
+
+	r := touka.New()
+	r.Use(Compression(CompressOptions{
+		Algorithms:        map[string]AlgorithmConfig{EncodingGzip: {Level: 6}},
+		CompressibleTypes: []string{"text/"},
+		MinContentLength:  1024,
+		DebugHeader:       true,
+	}))
+	r.GET("/plain", func(c *touka.Context) {
+		c.EventStream(func(w io.Writer) bool {
+			io.WriteString(w, event)
+			return false
+		})
+	})
+	r.GET("/compat", SSECompatible(), func(c *touka.Context) {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache, no-transform")
+		io.WriteString(c.Writer, event) // 不显式 Flush
+		gr, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+		if err == nil {
+			buf := make([]byte, len(event))
+			n, _ := io.ReadFull(gr, buf)
+			midStream = string(buf[:n])
+		}
+		io.WriteString(c.Writer, event)
+	})
+
+	req := httptest.NewRequest("GET", "/plain", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	r.ServeHTTP(w, req)
+	if got := w.Header().Get("X-Compression-Info"); got != "skipped=event_stream" {
+		t.Errorf("Expected event stream to be skipped by default, got %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/compat", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected compressed event stream, got %d %q", w.Code, w.Header().Get("Content-Encoding"))
+	}
+	if midStream != event {
+		t.Errorf("Expected first event to be flushed as soon as it was written, got %q", midStream)
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(gr); string(body) != event+event {
+		t.Errorf("Unexpected stream %q", body)
+	}
+}
+
+func TestEndsEvent(t *testing.T) {
+	for in, want := range map[string]bool{
+		"data: a\n\n":     true,
+		"data: a\r\n\r\n": true,
+		"data: a\r\r":     true,
+		"data: a\n":       false,
+		"":                false,
+	} {
+		if got := endsEvent([]byte(in)); got != want {
+			t.Errorf("endsEvent(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+// flushCounter 统计写往客户端的 Flush 次数
+type flushCounter struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (f *flushCounter) Flush() {
+	f.flushes++
+	f.ResponseRecorder.Flush()
+}
+
+func TestFlushSize(t *testing.T) {
+	const events = 200
+	event := func(i int) string {
+		return fmt.Sprintf("id: %d\ndata: {\"seq\":%d,\"value\":%d}\n\n", i, i, i*7919%1000)
+	}
+	var want strings.Builder
+	for i := range events {
+		want.WriteString(event(i))
+	}
+
+	for _, tt := range []struct {
+		size  int
+		delay time.Duration
+		min   int
+		max   int
+	}{
+		{0, 0, events, events},                  // 每个事件都刷新
+		{1400, 0, 2, events / 10},               // 合并为接近 1400 字节的块
+		{1400, time.Nanosecond, events, events}, // 每次 Flush 都已超过最长推迟时间
+	} {
+		r := touka.New()
+		r.Use(Compression(CompressOptions{
+			Algorithms:        map[string]AlgorithmConfig{EncodingGzip: {Level: 6}},
+			CompressibleTypes: []string{"text/"},
+			FlushSize:         tt.size,
+			FlushMaxDelay:     tt.delay,
+		}))
+		r.GET("/", func(c *touka.Context) {
+			c.Header("Content-Type", "text/plain")
+			for i := range events {
+				io.WriteString(c.Writer, event(i))
+				c.Writer.Flush()
+			}
+		})
+		w := &flushCounter{ResponseRecorder: httptest.NewRecorder()}
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		r.ServeHTTP(w, req)
+		if w.flushes < tt.min || w.flushes > tt.max {
+			t.Errorf("FlushSize %d: expected %d to %d flushes, got %d", tt.size, tt.min, tt.max, w.flushes)
+		}
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatalf("FlushSize %d: %v", tt.size, err)
+		}
+		if got, _ := io.ReadAll(gr); string(got) != want.String() {
+			t.Errorf("FlushSize %d: body mismatch", tt.size)
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package compress
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/infinite-iroha/touka"
+)
+
+// StaticOptions 控制 Static 查找与发送预压缩副本
+type StaticOptions struct {
+	// Encodings 是查找副本的编码, 按服务端偏好排序; 副本的文件名为原文件名加上编码的扩展名 (如 app.js.zst)。
+	// 为空时为所有带扩展名的编码 (zstd、gzip), 与 cmd/precompress 的默认输出一致
+	Encodings []string
+	// Index 是请求目录时发送的文件, 为空时为 index.html
+	Index string
+
+	// CacheBytes 大于 0 时, 没有可用副本的文件在首次请求 (或 WarmCache) 时按 Encodings 压缩,
+	// 结果保存在内存中, 总量不超过 CacheBytes, 超出时淘汰最久未使用的条目。
+	// 条目按原文件的修改时间与长度校验, 原文件被覆盖后重新压缩。压缩后不更小的文件只记录结果, 照常发送原文件。
+	CacheBytes int64
+	// CacheLevel 是写入缓存时的压缩级别, 为 0 时使用各编码的默认级别; 不为 0 时须对所有 Encodings 有效
+	CacheLevel int
+}
+
+// Static 从 fs.FS 发送静态文件, 客户端接受时直接发送构建期生成的预压缩副本 (见 cmd/precompress) 而不在请求时压缩。
+// 副本早于原文件 (修改时间更早) 时视为过期而不使用; 设置了 CacheBytes 时, 没有副本的文件压缩后缓存在内存中。
+//
+// 每种表示 (原文件与各编码的副本) 有各自的强 ETag, 以 http.ServeContent 发送,
+// 因此 Range、If-Range 与条件请求作用于所选的表示: 中断的 .zst/.gz 下载可以按压缩后的字节续传。
+// 发送副本时已带 Content-Encoding, 外层的压缩中间件原样发送 (跳过原因 SkipPreEncoded);
+// 没有可用副本时发送原文件, 由中间件按配置压缩。可以并发使用。
+type Static struct {
+	fsys      fs.FS
+	opts      StaticOptions
+	encodings []string
+	ext       map[string]string // 编码 → 副本扩展名
+	cache     *staticCache      // CacheBytes 为 0 时为 nil
+}
+
+// NewStatic 创建从 fsys 发送文件的 Static, 编码未知或没有副本扩展名时返回错误
+func NewStatic(fsys fs.FS, opts StaticOptions) (*Static, error) {
+	s := &Static{fsys: fsys, opts: opts, ext: make(map[string]string)}
+	if len(opts.Encodings) == 0 {
+		for _, codec := range Codecs() {
+			if codec.Extension != "" {
+				s.encodings = append(s.encodings, codec.Encoding)
+				s.ext[codec.Encoding] = codec.Extension
+			}
+		}
+	}
+	for _, enc := range opts.Encodings {
+		codec, ok := LookupCodec(enc)
+		if !ok {
+			return nil, fmt.Errorf("compress: unknown encoding %q", enc)
+		}
+		if codec.Extension == "" {
+			return nil, fmt.Errorf("compress: encoding %q has no precompressed file extension", enc)
+		}
+		s.encodings = append(s.encodings, codec.Encoding)
+		s.ext[codec.Encoding] = codec.Extension
+	}
+	if s.opts.Index == "" {
+		s.opts.Index = "index.html"
+	}
+	if opts.CacheBytes < 0 {
+		return nil, fmt.Errorf("compress: static CacheBytes %d is negative", opts.CacheBytes)
+	}
+	if opts.CacheLevel != 0 {
+		for _, enc := range s.encodings {
+			if !validLevel(enc, opts.CacheLevel) {
+				return nil, fmt.Errorf("compress: %s level %d out of range", enc, opts.CacheLevel)
+			}
+		}
+	}
+	if opts.CacheBytes > 0 {
+		s.cache = newStaticCache(opts.CacheBytes)
+	}
+	return s, nil
+}
+
+// Handler 返回发送路由参数 param 所指文件的处理函数, 例如:
+//
+//	static, _ := compress.NewStatic(os.DirFS("public"), compress.StaticOptions{})
+//	r.GET("/assets/*filepath", static.Handler("filepath"))
+func (s *Static) Handler(param string) touka.HandlerFunc {
+	return func(c *touka.Context) {
+		s.Serve(c, c.Param(param))
+	}
+}
+
+// Serve 发送 fsys 中的 name (可以带前导的 /), name 是目录时发送其中的 Index。
+// 文件不存在时以 404、读取失败时以 500 交给引擎的错误处理。
+func (s *Static) Serve(c *touka.Context, name string) {
+	name, info, err := s.stat(name)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, fs.ErrNotExist) {
+			code = http.StatusNotFound
+		}
+		c.ErrorUseHandle(code, err)
+		return
+	}
+
+	h := c.Writer.Header()
+	addVaryAcceptEncoding(h)
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype != "" {
+		h.Set(headerContentType, ctype)
+	}
+
+	if v := s.variant(c, name, info); v.r != nil {
+		if rc, ok := v.r.(io.Closer); ok {
+			defer rc.Close()
+		}
+		if ctype == "" {
+			h.Set(headerContentType, "application/octet-stream") // 不对压缩后的字节做内容嗅探
+		}
+		h.Set(headerETag, v.etag)
+		serveFile(exportWriter{c.Writer, v.enc}, c.Request, info.ModTime(), v.r, v.size)
+		return
+	}
+
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		c.ErrorUseHandle(http.StatusInternalServerError, err)
+		return
+	}
+	defer f.Close()
+	h.Set(headerETag, staticETag(info, ""))
+	serveFile(c.Writer, c.Request, info.ModTime(), f, info.Size())
+}
+
+// stat 把 name 规范为 fsys 中的路径并返回其信息, 目录解析为其中的 Index
+func (s *Static) stat(name string) (string, fs.FileInfo, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		name = "."
+	}
+	info, err := fs.Stat(s.fsys, name)
+	if err == nil && info.IsDir() {
+		name = path.Join(name, s.opts.Index)
+		info, err = fs.Stat(s.fsys, name)
+	}
+	if err == nil && !info.Mode().IsRegular() {
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("compress: static file %q: %w", name, err)
+	}
+	return name, info, nil
+}
+
+// staticVariant 是选中的压缩表示: 副本文件或缓存中的压缩结果
+type staticVariant struct {
+	enc  string
+	r    io.Reader // 副本文件时需要关闭
+	size int64
+	etag string
+}
+
+// variant 按 Accept-Encoding 选出最佳的可用表示, 依次使用副本与缓存; 客户端更偏好的编码都不可用时尝试其余编码。
+// 没有可用表示 (或路由上的 Override 关闭了压缩) 时 r 为 nil。
+func (s *Static) variant(c *touka.Context, name string, info fs.FileInfo) staticVariant {
+	accept := c.Request.Header.Get(headerAcceptEncoding)
+	if crw, ok := c.Writer.(*compressResponseWriter); ok {
+		if crw.cfg.routeDisabled {
+			return staticVariant{}
+		}
+		accept = crw.acceptEncoding
+	}
+	candidates := s.encodings
+	for len(candidates) > 0 {
+		enc := SelectEncoding(accept, candidates)
+		if enc == "" || enc == EncodingIdentity {
+			break
+		}
+		if f, vinfo := s.openVariant(name+s.ext[enc], info); f != nil {
+			return staticVariant{enc, f, vinfo.Size(), staticETag(vinfo, enc)}
+		}
+		if e, _ := s.cache.load(s, name, enc, info); e != nil {
+			return staticVariant{enc, bytes.NewReader(e.data), int64(len(e.data)), e.etag}
+		}
+		candidates = slices.DeleteFunc(slices.Clone(candidates), func(e string) bool { return e == enc })
+	}
+	return staticVariant{}
+}
+
+// WarmCache 在启动时压缩 names 中没有可用副本的文件并写入内存缓存, 避免部署后的首批请求集中压缩。
+// encodings 为空时使用 StaticOptions.Encodings; 没有启用 CacheBytes 时返回错误。
+// 缓存容量不足时先写入的条目可能被淘汰。返回所有文件的错误 (文件不存在等) 的合并。
+func (s *Static) WarmCache(names, encodings []string) error {
+	if s.cache == nil {
+		return errors.New("compress: static cache is disabled (CacheBytes is 0)")
+	}
+	if len(encodings) == 0 {
+		encodings = s.encodings
+	}
+	for _, enc := range encodings {
+		if _, ok := s.ext[enc]; !ok {
+			return fmt.Errorf("compress: encoding %q is not configured for static files", enc)
+		}
+	}
+	var errs []error
+	for _, name := range names {
+		name, info, err := s.stat(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, enc := range encodings {
+			if f, _ := s.openVariant(name+s.ext[enc], info); f != nil {
+				f.Close() // 已有副本, 不需要缓存
+				continue
+			}
+			if _, err := s.cache.load(s, name, enc, info); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Invalidate 删除内存缓存中 name (fsys 中的文件路径, 可以带前导的 /) 各编码的压缩结果, 之后的请求重新压缩。
+// 缓存按原文件的修改时间与长度校验, 覆盖文件通常不需要调用它; 修改时间不可靠 (如 embed.FS 总为零值)
+// 或覆盖后长度与修改时间都不变时, 部署流程或文件监视 (见 README) 应调用它。副本文件每次请求都重新检查, 不受影响。
+func (s *Static) Invalidate(name string) {
+	if s.cache == nil {
+		return
+	}
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+	for _, enc := range s.encodings {
+		if el, ok := s.cache.entries[staticKey{name, enc}]; ok {
+			s.cache.remove(el)
+		}
+	}
+}
+
+// InvalidateAll 清空内存缓存
+func (s *Static) InvalidateAll() {
+	if s.cache == nil {
+		return
+	}
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+	for el := s.cache.lru.Front(); el != nil; el = s.cache.lru.Front() {
+		s.cache.remove(el)
+	}
+}
+
+// openVariant 打开副本 name, 不存在或早于原文件时返回 nil
+func (s *Static) openVariant(name string, info fs.FileInfo) (fs.File, fs.FileInfo) {
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, nil
+	}
+	vinfo, err := f.Stat()
+	if err != nil || !vinfo.Mode().IsRegular() || vinfo.ModTime().Before(info.ModTime()) {
+		f.Close()
+		return nil, nil
+	}
+	return f, vinfo
+}
+
+// staticETag 按文件的修改时间与长度生成强 ETag, 副本的 ETag 带上编码, 与原文件及其他编码的副本互不相同
+func staticETag(info fs.FileInfo, enc string) string {
+	tag := strconv.FormatInt(info.ModTime().UnixNano(), 16) + "-" + strconv.FormatInt(info.Size(), 16)
+	if enc != "" {
+		tag += "-" + enc
+	}
+	return `"` + tag + `"`
+}
+
+// serveFile 以 http.ServeContent 发送 f; f 不支持 Seek 时整体发送, 不支持 Range 与条件请求
+func serveFile(w http.ResponseWriter, r *http.Request, modTime time.Time, f io.Reader, size int64) {
+	if rs, ok := f.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", modTime, rs)
+		return
+	}
+	w.Header().Set(headerContentLength, strconv.FormatInt(size, 10))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		copyBuffered(w, f)
+	}
+}
+
+// staticCache 是 Static 在内存中保存的压缩结果, 按最近使用淘汰
+type staticCache struct {
+	mu      sync.Mutex
+	max     int64
+	size    int64 // 所有条目 data 的总长度
+	entries map[staticKey]*list.Element
+	lru     list.List // 元素为 *staticEntry, 最近使用的在前
+}
+
+type staticKey struct{ name, enc string }
+
+// staticEntry 是一个文件以一种编码压缩的结果
+type staticEntry struct {
+	key     staticKey
+	modTime time.Time // 原文件的修改时间与长度, 不一致时条目过期
+	size    int64
+	ready   chan struct{} // 压缩结束后关闭, 之后 data、etag 与 err 不再改变
+	data    []byte        // 压缩后不更小时为 nil
+	etag    string
+	err     error
+}
+
+func newStaticCache(max int64) *staticCache {
+	return &staticCache{max: max, entries: make(map[staticKey]*list.Element)}
+}
+
+// load 返回 name 以 enc 压缩的结果, 没有或已过期时压缩并写入缓存; 同一条目的并发请求等待同一次压缩。
+// 缓存未启用、文件大于缓存容量或压缩后不更小时返回 nil。
+func (sc *staticCache) load(s *Static, name, enc string, info fs.FileInfo) (*staticEntry, error) {
+	if sc == nil || info.Size() > sc.max {
+		return nil, nil
+	}
+	key := staticKey{name, enc}
+	sc.mu.Lock()
+	if el, ok := sc.entries[key]; ok {
+		e := el.Value.(*staticEntry)
+		if e.modTime.Equal(info.ModTime()) && e.size == info.Size() {
+			sc.lru.MoveToFront(el)
+			sc.mu.Unlock()
+			<-e.ready
+			return e.result()
+		}
+		sc.remove(el)
+	}
+	e := &staticEntry{key: key, modTime: info.ModTime(), size: info.Size(), ready: make(chan struct{})}
+	sc.entries[key] = sc.lru.PushFront(e)
+	sc.mu.Unlock()
+
+	e.data, e.etag, e.err = s.compressFile(name, enc)
+	if e.err == nil && int64(len(e.data)) >= info.Size() {
+		e.data = nil
+	}
+	sc.mu.Lock()
+	if el, ok := sc.entries[key]; ok && el.Value == e {
+		if e.err != nil {
+			sc.remove(el) // 之后的请求重新压缩
+		} else {
+			sc.size += int64(len(e.data))
+			for sc.size > sc.max {
+				sc.remove(sc.lru.Back())
+			}
+		}
+	}
+	sc.mu.Unlock()
+	close(e.ready)
+	return e.result()
+}
+
+func (e *staticEntry) result() (*staticEntry, error) {
+	if e.err != nil || e.data == nil {
+		return nil, e.err
+	}
+	return e, nil
+}
+
+// remove 删除条目, 调用时需持有 mu
+func (sc *staticCache) remove(el *list.Element) {
+	e := sc.lru.Remove(el).(*staticEntry)
+	delete(sc.entries, e.key)
+	sc.size -= int64(len(e.data))
+}
+
+// compressFile 以 enc 压缩 name, 返回压缩结果与按其内容计算的 ETag
+func (s *Static) compressFile(name, enc string) ([]byte, string, error) {
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	level := s.opts.CacheLevel
+	if level == 0 {
+		codec, _ := LookupCodec(enc)
+		level = codec.DefaultLevel
+	}
+	var buf bytes.Buffer
+	zw, err := NewEncoder(enc, level, &buf)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := copyBuffered(zw, f); err != nil {
+		zw.Close()
+		return nil, "", fmt.Errorf("compress: compressing static file %q: %w", name, err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, "", fmt.Errorf("compress: compressing static file %q: %w", name, err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return buf.Bytes(), `"` + hex.EncodeToString(sum[:16]) + "-" + enc + `"`, nil
+}
@@ -0,0 +1,392 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/infinite-iroha/touka"
+)
+
+// gzipBytes 以 gzip 压缩 p
+func gzipBytes(t testing.TB, p []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(p)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestStatic(t *testing.T) {
+	mod := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	js := bytes.Repeat([]byte("console.log('static');\n"), 200)
+	css := bytes.Repeat([]byte("body { margin: 0 }\n"), 200)
+	gz := gzipBytes(t, js)
+	fsys := fstest.MapFS{
+		"app.js":          {Data: js, ModTime: mod},
+		"app.js.gz":       {Data: gz, ModTime: mod},
+		"site.css":        {Data: css, ModTime: mod},
+		"site.css.gz":     {Data: gzipBytes(t, css), ModTime: mod.Add(-time.Hour)}, // 过期的副本
+		"docs/index.html": {Data: []byte("<p>docs</p>"), ModTime: mod},
+	}
+	static, err := NewStatic(fsys, StaticOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := touka.New()
+	r.GET("/assets/*filepath", static.Handler("filepath"))
+
+	serve := func(path string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/assets/app.js", "Accept-Encoding", "gzip")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != EncodingGzip || !bytes.Equal(w.Body.Bytes(), gz) {
+		t.Fatalf("Expected the gzip sidecar, got %d %v", w.Code, w.Header())
+	}
+	if got := w.Header().Get("Content-Length"); got != strconv.Itoa(len(gz)) {
+		t.Errorf("Expected Content-Length %d, got %q", len(gz), got)
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/javascript") || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Unexpected headers %v", w.Header())
+	}
+	gzETag := w.Header().Get("ETag")
+
+	plain := serve("/assets/app.js")
+	plainETag := plain.Header().Get("ETag")
+	if plain.Header().Get("Content-Encoding") != "" || !bytes.Equal(plain.Body.Bytes(), js) {
+		t.Fatalf("Expected the original file without Accept-Encoding, got %v", plain.Header())
+	}
+	if gzETag == "" || plainETag == "" || gzETag == plainETag || strings.HasPrefix(gzETag, "W/") {
+		t.Errorf("Expected distinct strong ETags per representation, got %q and %q", gzETag, plainETag)
+	}
+
+	// Range 作用于压缩后的表示
+	w = serve("/assets/app.js", "Accept-Encoding", "gzip", "Range", "bytes=10-19")
+	if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), gz[10:20]) {
+		t.Fatalf("Expected 206 with sidecar bytes 10-19, got %d", w.Code)
+	}
+	if got, want := w.Header().Get("Content-Range"), "bytes 10-19/"+strconv.Itoa(len(gz)); got != want {
+		t.Errorf("Expected Content-Range %q, got %q", want, got)
+	}
+	if w.Header().Get("Content-Encoding") != EncodingGzip || w.Header().Get("ETag") != gzETag {
+		t.Errorf("Expected gzip partial content with the sidecar ETag, got %v", w.Header())
+	}
+
+	// If-Range 只在 ETag 属于所选的表示时续传
+	w = serve("/assets/app.js", "Accept-Encoding", "gzip", "Range", "bytes=10-19", "If-Range", gzETag)
+	if w.Code != http.StatusPartialContent {
+		t.Errorf("Expected 206 for matching If-Range, got %d", w.Code)
+	}
+	w = serve("/assets/app.js", "Accept-Encoding", "gzip", "Range", "bytes=10-19", "If-Range", plainETag)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), gz) {
+		t.Errorf("Expected the full sidecar for another representation's If-Range, got %d", w.Code)
+	}
+	w = serve("/assets/app.js", "Accept-Encoding", "gzip", "If-None-Match", gzETag)
+	if w.Code != http.StatusNotModified || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Expected 304 without Content-Encoding, got %d %v", w.Code, w.Header())
+	}
+
+	// 过期的副本不使用
+	w = serve("/assets/site.css", "Accept-Encoding", "gzip")
+	if w.Header().Get("Content-Encoding") != "" || !bytes.Equal(w.Body.Bytes(), css) {
+		t.Errorf("Expected stale sidecar to be ignored, got %v", w.Header())
+	}
+
+	if w = serve("/assets/docs/"); w.Body.String() != "<p>docs</p>" {
+		t.Errorf("Expected directory index, got %d %q", w.Code, w.Body.String())
+	}
+	if w = serve("/assets/missing.js"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for missing file, got %d", w.Code)
+	}
+	if w = serve("/assets/../../etc/passwd"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 outside the file system, got %d", w.Code)
+	}
+}
+
+func TestStaticBehindMiddleware(t *testing.T) {
+	mod := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	js := bytes.Repeat([]byte("console.log('static');\n"), 200)
+	gz := gzipBytes(t, js)
+	fsys := fstest.MapFS{
+		"app.js":    {Data: js, ModTime: mod},
+		"app.js.gz": {Data: gz, ModTime: mod},
+		"lib.js":    {Data: js, ModTime: mod},
+	}
+	static, err := NewStatic(fsys, StaticOptions{Encodings: []string{EncodingGzip}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New(CompressOptions{Algorithms: map[string]AlgorithmConfig{EncodingGzip: {Level: 1}}})
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/*filepath", static.Handler("filepath"))
+
+	serve := func(path, rng string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// 副本原样发送, 中间件不再压缩
+	w := serve("/app.js", "")
+	if !bytes.Equal(w.Body.Bytes(), gz) || w.Header().Get("Content-Length") != strconv.Itoa(len(gz)) {
+		t.Fatalf("Expected the sidecar to pass through, got %v", w.Header())
+	}
+	if n := m.Stats().Snapshot().Skipped[SkipPreEncoded.String()]; n != 1 {
+		t.Errorf("Expected 1 pre-encoded skip, got %d", n)
+	}
+	w = serve("/app.js", "bytes=0-9")
+	if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), gz[:10]) {
+		t.Errorf("Expected 206 over the sidecar, got %d", w.Code)
+	}
+
+	// 没有副本时由中间件压缩; 压缩后的响应带弱 ETag, 与未压缩的 206 响应的强 ETag 区分
+	w = serve("/lib.js", "")
+	etag := w.Header().Get("ETag")
+	if w.Header().Get("Content-Encoding") != EncodingGzip || !strings.HasPrefix(etag, "W/") {
+		t.Fatalf("Expected on-the-fly gzip with a weak ETag, got %v", w.Header())
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(gr); !bytes.Equal(got, js) {
+		t.Error("Body mismatch")
+	}
+	w = serve("/lib.js", "bytes=0-9")
+	if w.Code != http.StatusPartialContent || w.Header().Get("Content-Encoding") != "" || w.Header().Get("ETag") != strings.TrimPrefix(etag, "W/") {
+		t.Errorf("Expected identity 206 with the strong ETag, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestNewStaticErrors(t *testing.T) {
+	if _, err := NewStatic(fstest.MapFS{}, StaticOptions{Encodings: []string{"br"}}); err == nil {
+		t.Error("Expected an error for an unknown encoding")
+	}
+	if _, err := NewStatic(fstest.MapFS{}, StaticOptions{Encodings: []string{EncodingDeflate}}); err == nil {
+		t.Error("Expected an error for an encoding without a sidecar extension")
+	}
+}
+
+func TestStaticCache(t *testing.T) {
+	mod := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	js := bytes.Repeat([]byte("console.log('cached');\n"), 200)
+	noise := make([]byte, 4096)
+	for i := range noise {
+		noise[i] = byte(i*7919 + i>>3*31)
+	}
+	fsys := fstest.MapFS{
+		"app.js":    {Data: js, ModTime: mod},
+		"other.js":  {Data: bytes.Repeat([]byte("other();\n"), 400), ModTime: mod},
+		"noise.bin": {Data: gzipBytes(t, noise), ModTime: mod}, // 压缩后不会更小
+		"pre.js":    {Data: js, ModTime: mod},
+		"pre.js.gz": {Data: gzipBytes(t, js), ModTime: mod},
+	}
+	static, err := NewStatic(fsys, StaticOptions{Encodings: []string{EncodingGzip}, CacheBytes: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := touka.New()
+	r.GET("/*filepath", static.Handler("filepath"))
+	serve := func(path string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	decode := func(t *testing.T, w *httptest.ResponseRecorder) []byte {
+		t.Helper()
+		if w.Header().Get("Content-Encoding") != EncodingGzip || w.Header().Get("Content-Length") != strconv.Itoa(w.Body.Len()) {
+			t.Fatalf("Expected cached gzip with Content-Length, got %v", w.Header())
+		}
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(gr)
+		return got
+	}
+
+	// 并发的首次请求只压缩一次
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve("/app.js")
+		}()
+	}
+	wg.Wait()
+	w := serve("/app.js")
+	etag := w.Header().Get("ETag")
+	compressed := bytes.Clone(w.Body.Bytes())
+	if got := decode(t, w); !bytes.Equal(got, js) {
+		t.Fatal("Body mismatch")
+	}
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		t.Errorf("Expected a strong ETag for the cached representation, got %q", etag)
+	}
+	w = serve("/app.js", "Range", "bytes=5-14", "If-Range", etag)
+	if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), compressed[5:15]) {
+		t.Errorf("Expected 206 over the cached bytes, got %d", w.Code)
+	}
+
+	// 原文件被覆盖后重新压缩
+	fsys["app.js"] = &fstest.MapFile{Data: []byte(strings.Repeat("updated();\n", 300)), ModTime: mod.Add(time.Minute)}
+	w = serve("/app.js")
+	if w.Header().Get("ETag") == etag {
+		t.Error("Expected a new ETag after the file changed")
+	}
+	if got := decode(t, w); string(got) != strings.Repeat("updated();\n", 300) {
+		t.Error("Expected the updated content")
+	}
+
+	// 压缩后不更小的文件照常发送原文件
+	if w = serve("/noise.bin"); w.Header().Get("Content-Encoding") != "" || w.Body.Len() != len(fsys["noise.bin"].Data) {
+		t.Errorf("Expected incompressible file to be sent as is, got %v", w.Header())
+	}
+
+	// 有副本的文件不写入缓存
+	serve("/pre.js")
+	static.cache.mu.Lock()
+	_, cached := static.cache.entries[staticKey{"pre.js", EncodingGzip}]
+	size := static.cache.size
+	static.cache.mu.Unlock()
+	if cached {
+		t.Error("Expected files with sidecars to bypass the cache")
+	}
+	if size <= 0 || size > 1<<20 {
+		t.Errorf("Unexpected cache size %d", size)
+	}
+}
+
+func TestStaticCacheEviction(t *testing.T) {
+	mod := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{}
+	for i := range 4 {
+		var b strings.Builder
+		for j := 0; b.Len() < 8<<10; j++ {
+			fmt.Fprintf(&b, "%d:%x\n", i, uint32(j*2654435761)) // 不易压缩, 使副本与原文件大小相近
+		}
+		fsys[fmt.Sprintf("f%d.txt", i)] = &fstest.MapFile{Data: []byte(b.String()), ModTime: mod}
+	}
+	one := len(gzipBytes(t, fsys["f0.txt"].Data))
+	static, err := NewStatic(fsys, StaticOptions{Encodings: []string{EncodingGzip}, CacheBytes: int64(one*5/2 + 512)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := static.WarmCache([]string{"f0.txt", "f1.txt", "f2.txt", "f3.txt"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	sc := static.cache
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.size > sc.max {
+		t.Errorf("Cache holds %d bytes over its %d limit", sc.size, sc.max)
+	}
+	// 容量只够两个条目, 最久未使用的被淘汰
+	for i, want := range []bool{false, false, true, true} {
+		if _, ok := sc.entries[staticKey{fmt.Sprintf("f%d.txt", i), EncodingGzip}]; ok != want {
+			t.Errorf("f%d.txt cached = %v, want %v", i, ok, want)
+		}
+	}
+}
+
+func TestStaticWarmCache(t *testing.T) {
+	mod := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{"app.js": {Data: bytes.Repeat([]byte("warm();\n"), 300), ModTime: mod}}
+
+	static, _ := NewStatic(fsys, StaticOptions{})
+	if err := static.WarmCache([]string{"app.js"}, nil); err == nil {
+		t.Error("Expected an error without CacheBytes")
+	}
+	static, _ = NewStatic(fsys, StaticOptions{Encodings: []string{EncodingGzip}, CacheBytes: 1 << 20})
+	if err := static.WarmCache([]string{"app.js"}, []string{EncodingDeflate}); err == nil {
+		t.Error("Expected an error for an encoding Static does not use")
+	}
+	if err := static.WarmCache([]string{"/app.js", "missing.js"}, nil); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected the missing file to be reported, got %v", err)
+	}
+	static.cache.mu.Lock()
+	el, ok := static.cache.entries[staticKey{"app.js", EncodingGzip}]
+	static.cache.mu.Unlock()
+	if !ok || el.Value.(*staticEntry).data == nil {
+		t.Fatal("Expected app.js to be warmed")
+	}
+}
+
+func TestStaticInvalidate(t *testing.T) {
+	// 与 embed.FS 一样没有修改时间, 覆盖成等长的内容后缓存无法察觉
+	fsys := fstest.MapFS{
+		"app.js":   {Data: bytes.Repeat([]byte("version(1);\n"), 200)},
+		"other.js": {Data: bytes.Repeat([]byte("other();\n"), 200)},
+	}
+	static, err := NewStatic(fsys, StaticOptions{Encodings: []string{EncodingGzip}, CacheBytes: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := touka.New()
+	r.GET("/*filepath", static.Handler("filepath"))
+	get := func(path string) string {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(gr)
+		return string(got)
+	}
+
+	get("/app.js")
+	get("/other.js")
+	v2 := strings.Repeat("version(2);\n", 200)
+	fsys["app.js"] = &fstest.MapFile{Data: []byte(v2)}
+	if get("/app.js") == v2 {
+		t.Fatal("Expected the cache to serve the stale copy before Invalidate")
+	}
+	static.Invalidate("/app.js")
+	if got := get("/app.js"); got != v2 {
+		t.Errorf("Expected the new content after Invalidate, got %q", got[:min(len(got), 24)])
+	}
+
+	static.InvalidateAll()
+	sc := static.cache
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if len(sc.entries) != 0 || sc.lru.Len() != 0 || sc.size != 0 {
+		t.Errorf("Expected an empty cache after InvalidateAll, got %d entries, %d bytes", len(sc.entries), sc.size)
+	}
+}
@@ -0,0 +1,456 @@
+package compress
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// SkipReason 描述一次响应未被压缩的原因
+type SkipReason uint8
+
+const (
+	SkipNone               SkipReason = iota // 未跳过
+	SkipNotAccepted                          // 客户端不接受任何已配置的编码
+	SkipStatusCode                           // 状态码不允许携带或不适合压缩响应体
+	SkipPreEncoded                           // 响应已被上游编码
+	SkipNoTransform                          // 响应带有 Cache-Control: no-transform
+	SkipContentType                          // Content-Type 不在可压缩列表中
+	SkipTooSmall                             // Content-Length 小于 MinContentLength
+	SkipEncoderUnavailable                   // 无法获取压缩器
+	SkipOverloaded                           // 同时进行的压缩数已达 MaxConcurrentCompressions
+	SkipClosed                               // 中间件已被 Close
+	SkipSensitive                            // SensitiveResponse 判定响应含有机密
+	SkipRateLimited                          // 客户端超出 ClientRate
+	SkipRoute                                // 路由上的 Override 关闭了压缩
+	SkipEventStream                          // 事件流所在的路由未启用 SSECompatible
+	SkipMethod                               // 请求方法不在 Methods 中或未通过 MethodFilter
+	SkipVaryWildcard                         // 响应带有 Vary: * 且设置了 SkipOnVaryWildcard
+	SkipTooLarge                             // Content-Length 大于 MaxContentLength
+	numSkipReasons
+)
+
+var skipReasonNames = [numSkipReasons]string{
+	SkipNone:               "none",
+	SkipNotAccepted:        "not_accepted",
+	SkipStatusCode:         "status_code",
+	SkipPreEncoded:         "pre_encoded",
+	SkipNoTransform:        "no_transform",
+	SkipContentType:        "content_type",
+	SkipTooSmall:           "too_small",
+	SkipEncoderUnavailable: "encoder_unavailable",
+	SkipOverloaded:         "overloaded",
+	SkipClosed:             "closed",
+	SkipSensitive:          "sensitive",
+	SkipRateLimited:        "rate_limited",
+	SkipRoute:              "route",
+	SkipEventStream:        "event_stream",
+	SkipMethod:             "method",
+	SkipVaryWildcard:       "vary_wildcard",
+	SkipTooLarge:           "too_large",
+}
+
+// String 返回原因的 snake_case 名称, 与统计快照中的键一致
+func (r SkipReason) String() string {
+	if r < numSkipReasons {
+		return skipReasonNames[r]
+	}
+	return "unknown"
+}
+
+// ratioBuckets 是压缩比直方图的桶上界 (BytesIn / BytesOut)
+var ratioBuckets = []float64{1, 1.5, 2, 3, 4, 6, 8, 12, 16, 32}
+
+// histogram 是一个固定桶的并发安全直方图
+type histogram struct {
+	counts  []atomic.Uint64 // len(bounds)+1, 最后一个桶为 +Inf
+	sumBits atomic.Uint64   // float64 总和的位表示
+	bounds  []float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{counts: make([]atomic.Uint64, len(bounds)+1), bounds: bounds}
+}
+
+func (h *histogram) observe(v float64) {
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	for {
+		old := h.sumBits.Load()
+		if h.sumBits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (h *histogram) snapshot() Histogram {
+	hs := Histogram{
+		Bounds: h.bounds,
+		Counts: make([]uint64, len(h.counts)),
+		Sum:    math.Float64frombits(h.sumBits.Load()),
+	}
+	for i := range h.counts {
+		hs.Counts[i] = h.counts[i].Load()
+		hs.Count += hs.Counts[i]
+	}
+	return hs
+}
+
+// add 把快照中的计数与总和累加到直方图, 桶的上界不一致时忽略
+func (h *histogram) add(hs Histogram) {
+	if !slices.Equal(hs.Bounds, h.bounds) || len(hs.Counts) != len(h.counts) {
+		return
+	}
+	for i, n := range hs.Counts {
+		h.counts[i].Add(n)
+	}
+	for {
+		old := h.sumBits.Load()
+		if h.sumBits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+hs.Sum)) {
+			return
+		}
+	}
+}
+
+// Histogram 是直方图快照
+type Histogram struct {
+	Bounds []float64 `json:"bounds"` // 各桶上界 (含)
+	Counts []uint64  `json:"counts"` // 各桶计数 (非累计), 比 Bounds 多一个 +Inf 桶
+	Count  uint64    `json:"count"`  // 观测总数
+	Sum    float64   `json:"sum"`    // 观测值总和
+}
+
+// typeFamilies 是统计压缩比分布时使用的内容类型分组
+var typeFamilies = []string{"html", "css", "javascript", "json", "xml", "text", "image", "font", "other"}
+
+// contentTypeFamily 将小写的媒体类型 (不含参数) 归入 typeFamilies 中的一组
+func contentTypeFamily(mediaType string) string {
+	switch {
+	case mediaType == "text/html":
+		return "html"
+	case mediaType == "text/css":
+		return "css"
+	case strings.Contains(mediaType, "javascript"):
+		return "javascript"
+	case strings.Contains(mediaType, "json"):
+		return "json"
+	case strings.Contains(mediaType, "xml"):
+		return "xml"
+	case strings.HasPrefix(mediaType, "text/"):
+		return "text"
+	case strings.HasPrefix(mediaType, "image/"):
+		return "image"
+	case strings.HasPrefix(mediaType, "font/"), strings.Contains(mediaType, "font"):
+		return "font"
+	}
+	return "other"
+}
+
+// encodingCounters 是单个编码的累计计数器
+type encodingCounters struct {
+	responses atomic.Uint64
+	bytesIn   atomic.Uint64
+	bytesOut  atomic.Uint64
+	ratios    *histogram
+	byFamily  map[string]*histogram // 按内容类型分组的压缩比, 创建后只读
+}
+
+// Stats 记录压缩中间件实例的运行时统计, 所有计数器均可并发更新。
+type Stats struct {
+	encodings map[string]*encodingCounters // 在创建时固定, 之后只读
+	skips     [numSkipReasons]atomic.Uint64
+	aborted   atomic.Uint64 // 处理器异常结束或连接被接管而丢弃压缩器的响应数
+	errors    [numEncoderOps]atomic.Uint64
+	requests  requestCounters
+}
+
+// requestCounters 是请求体解压的累计计数器, 见 DecompressRequests
+type requestCounters struct {
+	decoded     atomic.Uint64
+	bytesIn     atomic.Uint64
+	bytesOut    atomic.Uint64
+	unsupported atomic.Uint64
+	errors      [numRequestErrorKinds]atomic.Uint64
+}
+
+func newStats() *Stats {
+	s := &Stats{encodings: make(map[string]*encodingCounters, len(codecTable))}
+	for _, c := range codecTable {
+		ec := &encodingCounters{
+			ratios:   newHistogram(ratioBuckets),
+			byFamily: make(map[string]*histogram, len(typeFamilies)),
+		}
+		for _, f := range typeFamilies {
+			ec.byFamily[f] = newHistogram(ratioBuckets)
+		}
+		s.encodings[c.Encoding] = ec
+	}
+	return s
+}
+
+// recordCompressed 记录一次完成的压缩响应, family 为 contentTypeFamily 的结果
+func (s *Stats) recordCompressed(encoding, family string, in, out int64) {
+	ec, ok := s.encodings[encoding]
+	if !ok {
+		return
+	}
+	ec.responses.Add(1)
+	ec.bytesIn.Add(uint64(in))
+	ec.bytesOut.Add(uint64(out))
+	if in > 0 && out > 0 {
+		r := float64(in) / float64(out)
+		ec.ratios.observe(r)
+		if h, ok := ec.byFamily[family]; ok {
+			h.observe(r)
+		}
+	}
+}
+
+// recordSkip 记录一次未压缩的响应
+func (s *Stats) recordSkip(reason SkipReason) {
+	if reason == SkipNone || reason >= numSkipReasons {
+		return
+	}
+	s.skips[reason].Add(1)
+}
+
+// recordError 记录一次压缩器错误
+func (s *Stats) recordError(op EncoderOp) {
+	if op < numEncoderOps {
+		s.errors[op].Add(1)
+	}
+}
+
+// recordRequest 记录一次解压的请求体: 读完时计入 Decoded, 失败时按类型计入 Errors
+func (s *Stats) recordRequest(b *requestBody) {
+	rc := &s.requests
+	rc.bytesIn.Add(uint64(b.in))
+	rc.bytesOut.Add(uint64(b.out))
+	switch {
+	case b.err != nil:
+		rc.errors[b.err.Kind].Add(1)
+	case b.eof:
+		rc.decoded.Add(1)
+	}
+}
+
+// RequestStats 是请求体解压 (DecompressRequests) 的统计快照
+type RequestStats struct {
+	Decoded     uint64            `json:"decoded"`     // 完整读取并校验通过的请求体数
+	BytesIn     uint64            `json:"bytes_in"`    // 读取的压缩数据字节数
+	BytesOut    uint64            `json:"bytes_out"`   // 解压后的字节数
+	Unsupported uint64            `json:"unsupported"` // 因不接受的 Content-Encoding 以 415 拒绝的请求数
+	Errors      map[string]uint64 `json:"errors"`      // 按类型 (corrupt, checksum, truncated, too_large) 统计的解压失败
+}
+
+// EncodingStats 是单个编码的统计快照
+type EncodingStats struct {
+	Responses uint64  `json:"responses"` // 已压缩的响应数
+	BytesIn   uint64  `json:"bytes_in"`  // 压缩前的字节数
+	BytesOut  uint64  `json:"bytes_out"` // 压缩后的字节数
+	Ratio     float64 `json:"ratio"`     // 平均压缩比 (BytesIn / BytesOut), 无数据时为 0
+	// RatioHistogram 是单个响应压缩比的分布, 仅在按编码分组的快照中填充
+	RatioHistogram Histogram `json:"ratio_histogram"`
+	// RatioByType 是按内容类型分组 (html, json, javascript, …) 的压缩比分布, 仅在按编码分组的快照中填充
+	RatioByType map[string]Histogram `json:"ratio_by_type,omitempty"`
+}
+
+// StatsSnapshot 是某一时刻的统计快照
+type StatsSnapshot struct {
+	Encodings map[string]EncodingStats `json:"encodings"` // 按编码名称分组
+	Skipped   map[string]uint64        `json:"skipped"`   // 按原因统计的未压缩响应数
+	Total     EncodingStats            `json:"total"`     // 所有编码的汇总
+	Aborted   uint64                   `json:"aborted"`   // 处理器异常结束 (如 panic) 或连接被 Hijack 而丢弃压缩器的响应数
+	Errors    map[string]uint64        `json:"errors"`    // 按操作 (init, write, flush, close) 统计的压缩器错误, 每个响应最多计一次
+	Requests  RequestStats             `json:"requests"`  // 请求体解压的统计
+
+	// Pools 与 Unpooled 描述压缩器对象池的使用情况。
+	// 对象池在进程内共享, 因此这两项是包级别的统计, 不区分中间件实例。
+	Pools    []PoolStats       `json:"pools"`    // 按编码与级别区分的对象池统计
+	Unpooled map[string]uint64 `json:"unpooled"` // 按编码统计的未经对象池直接创建的压缩器数
+	Budget   PoolBudgetStats   `json:"budget"`   // 对象池内存预算的使用情况
+
+	// Buffered 是进程内所有响应当前在内存中缓冲的数据 (字节), 包级别, 见 MaxTotalBufferedBytes
+	Buffered int64 `json:"buffered"`
+}
+
+// PoolBudgetStats 描述对象池的估算内存与 MaxPoolMemory 预算的执行情况
+type PoolBudgetStats struct {
+	Memory   int64  `json:"memory"`   // 归池管理且尚未被回收的压缩器的估算内存 (字节)
+	Bypasses uint64 `json:"bypasses"` // 因超出预算而改用用后即弃压缩器的次数
+	Drops    uint64 `json:"drops"`    // 因超出预算而未归还到池的次数
+}
+
+// PoolStats 是单个压缩器对象池的统计快照
+type PoolStats struct {
+	Encoding string  `json:"encoding"`
+	Level    int     `json:"level"`
+	Type     string  `json:"type"`     // 池的实现, "sync" 或 "bounded"
+	Gets     uint64  `json:"gets"`     // 从池中获取的次数
+	Misses   uint64  `json:"misses"`   // 池为空而新建压缩器的次数
+	Puts     uint64  `json:"puts"`     // 归还到池中的次数
+	Discards uint64  `json:"discards"` // 取出后被丢弃而未归还的次数
+	Live     uint64  `json:"live"`     // 已取出尚未归还的压缩器数 (Gets - Puts - Discards)
+	HitRate  float64 `json:"hit_rate"` // 命中率 ((Gets - Misses) / Gets), 无数据时为 0
+	Idle     int     `json:"idle"`     // 空闲的压缩器数, 仅有界池可知, sync 池为 0
+}
+
+// poolSnapshot 返回所有压缩器对象池的统计快照
+func poolSnapshot() []PoolStats {
+	pools := allEncoderPools()
+	out := make([]PoolStats, 0, len(pools))
+	for _, p := range pools {
+		// 先读 puts 与 discards 再读 gets, 避免并发时 Live 下溢
+		puts, discards := p.puts.Load(), p.discards.Load()
+		ps := PoolStats{
+			Encoding: p.encoding,
+			Level:    p.level,
+			Type:     PoolSync.String(),
+			Misses:   p.misses.Load(),
+			Gets:     p.gets.Load(),
+			Puts:     puts,
+			Discards: discards,
+		}
+		if p.idle != nil {
+			ps.Type = PoolBounded.String()
+			ps.Idle = len(p.idle)
+		}
+		if returned := ps.Puts + ps.Discards; ps.Gets > returned {
+			ps.Live = ps.Gets - returned
+		}
+		if ps.Gets > 0 && ps.Gets >= ps.Misses {
+			ps.HitRate = float64(ps.Gets-ps.Misses) / float64(ps.Gets)
+		}
+		out = append(out, ps)
+	}
+	return out
+}
+
+// Snapshot 返回当前统计的快照。
+// 各计数器分别原子读取, 并发更新时快照内的数值之间可能存在细微偏差。
+func (s *Stats) Snapshot() StatsSnapshot {
+	snap := StatsSnapshot{
+		Encodings: make(map[string]EncodingStats, len(s.encodings)),
+		Skipped:   make(map[string]uint64, numSkipReasons),
+	}
+	for name, ec := range s.encodings {
+		es := EncodingStats{
+			Responses: ec.responses.Load(),
+			BytesIn:   ec.bytesIn.Load(),
+			BytesOut:  ec.bytesOut.Load(),
+
+			RatioHistogram: ec.ratios.snapshot(),
+			RatioByType:    make(map[string]Histogram, len(ec.byFamily)),
+		}
+		for f, h := range ec.byFamily {
+			es.RatioByType[f] = h.snapshot()
+		}
+		es.Ratio = ratio(es.BytesIn, es.BytesOut)
+		snap.Encodings[name] = es
+		snap.Total.Responses += es.Responses
+		snap.Total.BytesIn += es.BytesIn
+		snap.Total.BytesOut += es.BytesOut
+	}
+	snap.Total.Ratio = ratio(snap.Total.BytesIn, snap.Total.BytesOut)
+	for r := SkipNone + 1; r < numSkipReasons; r++ {
+		snap.Skipped[r.String()] = s.skips[r].Load()
+	}
+	snap.Aborted = s.aborted.Load()
+	snap.Errors = make(map[string]uint64, numEncoderOps)
+	for op := OpInit; op < numEncoderOps; op++ {
+		snap.Errors[op.String()] = s.errors[op].Load()
+	}
+	rc := &s.requests
+	snap.Requests = RequestStats{
+		Decoded:     rc.decoded.Load(),
+		BytesIn:     rc.bytesIn.Load(),
+		BytesOut:    rc.bytesOut.Load(),
+		Unsupported: rc.unsupported.Load(),
+		Errors:      make(map[string]uint64, numRequestErrorKinds),
+	}
+	for k := RequestCorrupt; k < numRequestErrorKinds; k++ {
+		snap.Requests.Errors[k.String()] = rc.errors[k].Load()
+	}
+	snap.Pools = poolSnapshot()
+	snap.Budget = PoolBudgetStats{
+		Memory:   pooledMemory.Load(),
+		Bypasses: budgetBypasses.Load(),
+		Drops:    budgetDrops.Load(),
+	}
+	snap.Buffered = bufferedBytes.Load()
+	snap.Unpooled = make(map[string]uint64, len(unpooledEncoders))
+	for name, n := range unpooledEncoders {
+		snap.Unpooled[name] = n.Load()
+	}
+	return snap
+}
+
+func ratio(in, out uint64) float64 {
+	if out == 0 {
+		return 0
+	}
+	return float64(in) / float64(out)
+}
+
+// savedStats 是 Stats.MarshalJSON 输出的格式, 字段与 StatsSnapshot 中的同名字段一致
+type savedStats struct {
+	Encodings map[string]EncodingStats `json:"encodings"`
+	Skipped   map[string]uint64        `json:"skipped"`
+	Aborted   uint64                   `json:"aborted"`
+	Errors    map[string]uint64        `json:"errors"`
+	Requests  RequestStats             `json:"requests"`
+}
+
+// MarshalJSON 输出中间件实例的累计计数 (各编码的字节数与压缩比分布、跳过原因、错误、请求体解压), 供应用持久化后以 LoadStats 恢复。
+// 进程级别的对象池统计不包含在内。
+func (s *Stats) MarshalJSON() ([]byte, error) {
+	snap := s.Snapshot()
+	return json.Marshal(savedStats{Encodings: snap.Encodings, Skipped: snap.Skipped, Aborted: snap.Aborted, Errors: snap.Errors, Requests: snap.Requests})
+}
+
+// LoadStats 把 Stats().MarshalJSON 保存的计数累加到中间件的统计中, 使长期计数在重启后延续; 通常在启动时调用一次。
+// 未知的编码、跳过原因与错误类型被忽略, 桶的上界与当前版本不同的压缩比分布也被忽略。
+func (m *Middleware) LoadStats(data []byte) error {
+	var saved savedStats
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("compress: loading stats: %w", err)
+	}
+	s := m.stats
+	for name, es := range saved.Encodings {
+		ec, ok := s.encodings[name]
+		if !ok {
+			continue
+		}
+		ec.responses.Add(es.Responses)
+		ec.bytesIn.Add(es.BytesIn)
+		ec.bytesOut.Add(es.BytesOut)
+		ec.ratios.add(es.RatioHistogram)
+		for f, hs := range es.RatioByType {
+			if h, ok := ec.byFamily[f]; ok {
+				h.add(hs)
+			}
+		}
+	}
+	for r := SkipNone + 1; r < numSkipReasons; r++ {
+		s.skips[r].Add(saved.Skipped[r.String()])
+	}
+	s.aborted.Add(saved.Aborted)
+	for op := OpInit; op < numEncoderOps; op++ {
+		s.errors[op].Add(saved.Errors[op.String()])
+	}
+	rc := &s.requests
+	rc.decoded.Add(saved.Requests.Decoded)
+	rc.bytesIn.Add(saved.Requests.BytesIn)
+	rc.bytesOut.Add(saved.Requests.BytesOut)
+	rc.unsupported.Add(saved.Requests.Unsupported)
+	for k := RequestCorrupt; k < numRequestErrorKinds; k++ {
+		rc.errors[k].Add(saved.Requests.Errors[k.String()])
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package compress
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/infinite-iroha/touka"
+)
+
+func TestStatsSnapshot(t *testing.T) {
+	m := New(DefaultCompressionConfig())
+	r := touka.New()
+	r.Use(m.Handler())
+	body := strings.Repeat("compressible text ", 100)
+	r.GET("/text", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", body)
+	})
+	r.GET("/png", func(c *touka.Context) {
+		c.Header("Content-Type", "image/x-unknown")
+		c.String(http.StatusOK, "binary")
+	})
+
+	do := func(path, ae string) {
+		req := httptest.NewRequest("GET", path, nil)
+		if ae != "" {
+			req.Header.Set("Accept-Encoding", ae)
+		}
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	do("/text", "gzip")
+	do("/text", "gzip")
+	do("/text", "deflate")
+	do("/text", "")
+	do("/png", "gzip")
+
+	snap := m.Stats().Snapshot()
+	gz := snap.Encodings[EncodingGzip]
+	if gz.Responses != 2 {
+		t.Errorf("Expected 2 gzip responses, got %d", gz.Responses)
+	}
+	if gz.BytesIn != uint64(2*len(body)) {
+		t.Errorf("Expected %d gzip bytes in, got %d", 2*len(body), gz.BytesIn)
+	}
+	if gz.BytesOut == 0 || gz.BytesOut >= gz.BytesIn {
+		t.Errorf("Unexpected gzip bytes out %d (in %d)", gz.BytesOut, gz.BytesIn)
+	}
+	if gz.Ratio <= 1 {
+		t.Errorf("Expected ratio > 1, got %f", gz.Ratio)
+	}
+	if snap.Encodings[EncodingDeflate].Responses != 1 {
+		t.Errorf("Expected 1 deflate response, got %d", snap.Encodings[EncodingDeflate].Responses)
+	}
+	if snap.Total.Responses != 3 {
+		t.Errorf("Expected 3 compressed responses in total, got %d", snap.Total.Responses)
+	}
+	if snap.Skipped["not_accepted"] != 1 {
+		t.Errorf("Expected 1 not_accepted skip, got %d", snap.Skipped["not_accepted"])
+	}
+	if snap.Skipped["content_type"] != 1 {
+		t.Errorf("Expected 1 content_type skip, got %d", snap.Skipped["content_type"])
+	}
+}
+
+func TestContentTypeFamily(t *testing.T) {
+	tests := map[string]string{
+		"text/html":              "html",
+		"text/css":               "css",
+		"application/javascript": "javascript",
+		"text/javascript":        "javascript",
+		"application/json":       "json",
+		"application/ld+json":    "json",
+		"image/svg+xml":          "xml",
+		"text/plain":             "text",
+		"image/png":              "image",
+		"application/font-woff2": "font",
+		"application/wasm":       "other",
+	}
+	for mediaType, want := range tests {
+		if got := contentTypeFamily(mediaType); got != want {
+			t.Errorf("contentTypeFamily(%q) = %q, want %q", mediaType, got, want)
+		}
+	}
+}
+
+func TestStatsRatioByType(t *testing.T) {
+	m := New(DefaultCompressionConfig())
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/json", func(c *touka.Context) {
+		c.JSON(http.StatusOK, map[string]string{"payload": strings.Repeat("a", 512)})
+	})
+	req := httptest.NewRequest("GET", "/json", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	gz := m.Stats().Snapshot().Encodings[EncodingGzip]
+	if gz.RatioByType["json"].Count != 1 {
+		t.Errorf("Expected 1 json ratio sample, got %d", gz.RatioByType["json"].Count)
+	}
+	if gz.RatioByType["html"].Count != 0 {
+		t.Errorf("Expected no html ratio samples, got %d", gz.RatioByType["html"].Count)
+	}
+}
+
+func TestStatsPools(t *testing.T) {
+	skipWithoutZstd(t)
+	find := func(snap StatsSnapshot, encoding string, level int) PoolStats {
+		for _, ps := range snap.Pools {
+			if ps.Encoding == encoding && ps.Level == level {
+				return ps
+			}
+		}
+		t.Fatalf("No pool for %s level %d", encoding, level)
+		return PoolStats{}
+	}
+
+	m := New(CompressOptions{
+		Algorithms: map[string]AlgorithmConfig{
+			EncodingGzip: {Level: -1, PoolEnabled: true},
+			EncodingZstd: {Level: 7, PoolEnabled: true},
+		},
+	})
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", strings.Repeat("pooled ", 100))
+	})
+
+	before := m.Stats().Snapshot()
+	for _, ae := range []string{"gzip", "gzip", "gzip", "zstd"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", ae)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	after := m.Stats().Snapshot()
+
+	// 默认级别 (-1) 的 gzip 池应被使用
+	b, a := find(before, EncodingGzip, -1), find(after, EncodingGzip, -1)
+	if a.Gets-b.Gets != 3 || a.Puts-b.Puts != 3 {
+		t.Errorf("Expected 3 gets and puts on gzip default pool, got %d/%d", a.Gets-b.Gets, a.Puts-b.Puts)
+	}
+	if a.Live != b.Live {
+		t.Errorf("Expected live gzip encoders to stay at %d, got %d", b.Live, a.Live)
+	}
+	if a.Misses-b.Misses > 3 {
+		t.Errorf("Expected at most 3 misses, got %d", a.Misses-b.Misses)
+	}
+	// zstd 级别 7 没有对应的池, 应计入 Unpooled
+	if got := after.Unpooled[EncodingZstd] - before.Unpooled[EncodingZstd]; got != 1 {
+		t.Errorf("Expected 1 unpooled zstd encoder, got %d", got)
+	}
+	if !hasPool(EncodingZstd, zstdDefaultLevel) {
+		t.Error("Expected zstd default level to be pooled")
+	}
+}
+
+func TestStatsPoolsReturnAcquired(t *testing.T) {
+	// 压缩器是否归还由获取时的配置决定, 请求期间配置变化不应导致池化的压缩器泄漏
+	algos := map[string]AlgorithmConfig{EncodingGzip: {Level: -1, PoolEnabled: true}}
+	m := New(CompressOptions{Algorithms: algos})
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		delete(algos, EncodingGzip)
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", strings.Repeat("pooled ", 100))
+	})
+
+	pool := func(snap StatsSnapshot) PoolStats {
+		for _, ps := range snap.Pools {
+			if ps.Encoding == EncodingGzip && ps.Level == -1 {
+				return ps
+			}
+		}
+		t.Fatal("No pool for gzip level -1")
+		return PoolStats{}
+	}
+
+	before := pool(m.Stats().Snapshot())
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	after := pool(m.Stats().Snapshot())
+
+	if w.Header().Get("Content-Encoding") != EncodingGzip {
+		t.Fatalf("Expected gzip response, got %q", w.Header().Get("Content-Encoding"))
+	}
+	if gets, puts := after.Gets-before.Gets, after.Puts-before.Puts; gets != 1 || puts != 1 {
+		t.Errorf("Expected 1 get and 1 put on gzip default pool, got %d/%d", gets, puts)
+	}
+}
+
+func TestPrewarmPool(t *testing.T) {
+	pool := poolFor(EncodingDeflate, 3)
+	before := pool.misses.Load()
+	m := New(CompressOptions{
+		Algorithms: map[string]AlgorithmConfig{
+			EncodingDeflate: {Level: 3, PoolEnabled: true, PrewarmPoolSize: 4},
+		},
+	})
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.String(http.StatusOK, "%s", strings.Repeat("prewarmed ", 100))
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "deflate")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	// sync.Pool 可能在 GC 时丢弃预热的对象, 这里只在未发生丢弃时检查
+	if got := pool.misses.Load() - before; got > 1 {
+		t.Errorf("Expected at most 1 miss after prewarm, got %d", got)
+	}
+	if pool.gets.Load() == 0 {
+		t.Error("Expected the prewarmed pool to be used")
+	}
+}
+
+func TestPersistStats(t *testing.T) {
+	m := New(DefaultCompressionConfig())
+	r := touka.New()
+	r.Use(m.Handler())
+	r.GET("/", func(c *touka.Context) {
+		c.Header("Content-Type", "application/json")
+		c.String(http.StatusOK, "%s", strings.Repeat(`{"persist":true}`, 100))
+	})
+	for _, ae := range []string{"gzip", "gzip", ""} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", ae)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	data, err := json.Marshal(m.Stats())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 新实例恢复后再累加一次
+	restored := New(DefaultCompressionConfig())
+	if err := restored.LoadStats(data); err != nil {
+		t.Fatal(err)
+	}
+	restored.stats.recordCompressed(EncodingGzip, "json", 1000, 100)
+	before, after := m.Stats().Snapshot(), restored.Stats().Snapshot()
+	gz, rgz := before.Encodings[EncodingGzip], after.Encodings[EncodingGzip]
+	if rgz.Responses != gz.Responses+1 || rgz.BytesIn != gz.BytesIn+1000 || rgz.BytesOut != gz.BytesOut+100 {
+		t.Errorf("Expected restored counters plus one response, got %+v from %+v", rgz, gz)
+	}
+	if rgz.RatioByType["json"].Count != gz.RatioByType["json"].Count+1 || rgz.RatioHistogram.Sum != gz.RatioHistogram.Sum+10 {
+		t.Errorf("Expected restored ratio histograms, got %+v", rgz.RatioByType["json"])
+	}
+	if after.Skipped["not_accepted"] != 1 {
+		t.Errorf("Expected restored skip counts, got %v", after.Skipped)
+	}
+
+	if err := restored.LoadStats([]byte("{")); err == nil {
+		t.Error("Expected invalid data to be rejected")
+	}
+	// 桶的上界不同的分布被忽略, 计数仍然恢复
+	other := New(DefaultCompressionConfig())
+	if err := other.LoadStats([]byte(`{"encodings":{"gzip":{"responses":2,"ratio_histogram":{"bounds":[1],"counts":[1,1]}},"br":{"responses":5}}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := other.Stats().Snapshot().Encodings[EncodingGzip]; got.Responses != 2 || got.RatioHistogram.Count != 0 {
+		t.Errorf("Unexpected stats after loading mismatched buckets: %+v", got)
+	}
+}
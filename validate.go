@@ -0,0 +1,233 @@
+package compress
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/flate"
+)
+
+// Validate 检查配置中会在运行时被静默忽略或导致异常行为的取值, 返回包含所有问题的错误。
+// 零值字段视为使用默认值, 不会报错。包认识但未编译进二进制的编码 (如以 compress_no_zstd 构建时的 zstd)
+// 照常校验而不视为未知编码, 运行时被忽略, 使同一份配置适用于各种构建。
+func (o CompressOptions) Validate() error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("compress: "+format, args...))
+	}
+
+	for name, ac := range o.Algorithms {
+		if _, ok := knownCodec(name); !ok {
+			add("unknown encoding %q in Algorithms", name)
+			continue
+		}
+		if !validLevel(name, ac.Level) {
+			add("%s level %d out of range", name, ac.Level)
+		}
+		if ac.Concurrency < 0 {
+			add("%s concurrency %d is negative", name, ac.Concurrency)
+		}
+		if ac.PrewarmPoolSize < Auto {
+			add("%s prewarm pool size %d is invalid", name, ac.PrewarmPoolSize)
+		}
+		if ac.PoolType != PoolSync && ac.PoolType != PoolBounded {
+			add("%s pool type %d is unknown", name, ac.PoolType)
+		}
+		if ac.MaxIdle < 0 {
+			add("%s max idle %d is negative", name, ac.MaxIdle)
+		}
+		if ac.MinContentLength < 0 {
+			add("%s MinContentLength %d is negative", name, ac.MinContentLength)
+		}
+		if ac.PreferAbove < 0 {
+			add("%s PreferAbove %d is negative", name, ac.PreferAbove)
+		}
+		if ac.StreamingLevel != 0 && !validLevel(name, ac.StreamingLevel) {
+			add("%s streaming level %d out of range", name, ac.StreamingLevel)
+		}
+		for i, t := range ac.SizeTiers {
+			if !validLevel(name, t.Level) {
+				add("%s size tier %d level %d out of range", name, i, t.Level)
+			}
+			switch {
+			case t.MaxLength < 0:
+				add("%s size tier %d MaxLength %d is negative", name, i, t.MaxLength)
+			case t.MaxLength == 0 && i != len(ac.SizeTiers)-1:
+				add("%s size tier %d is unbounded but not last", name, i)
+			case i > 0 && t.MaxLength != 0 && t.MaxLength <= ac.SizeTiers[i-1].MaxLength:
+				add("%s size tiers are not in ascending order", name)
+			}
+		}
+	}
+
+	seen := make(map[string]bool, len(o.EncodingPriority))
+	for _, name := range o.EncodingPriority {
+		if _, ok := knownCodec(name); !ok {
+			add("unknown encoding %q in EncodingPriority", name)
+		} else if seen[name] {
+			add("duplicate encoding %q in EncodingPriority", name)
+		}
+		seen[name] = true
+	}
+
+	for name, p := range o.Profiles {
+		if err := p.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("profile %q: %w", name, err))
+		}
+	}
+
+	if o.ProfileHeader != "" && !isToken(o.ProfileHeader) {
+		add("ProfileHeader %q is not a valid header name", o.ProfileHeader)
+	} else if o.ProfileHeader != "" && len(o.TrustedProxies) == 0 {
+		add("ProfileHeader is set without TrustedProxies")
+	}
+	for _, s := range o.TrustedProxies {
+		if _, err := parseProxy(s); err != nil {
+			add("invalid address %q in TrustedProxies", s)
+		}
+	}
+
+	for name, w := range o.EncodingWeights {
+		if _, ok := knownCodec(name); !ok {
+			add("unknown encoding %q in EncodingWeights", name)
+		} else if w < 0 {
+			add("%s weight %d is negative", name, w)
+		}
+	}
+
+	for _, t := range o.CompressibleTypes {
+		if t == "" {
+			add("empty entry in CompressibleTypes would match every content type")
+		}
+	}
+
+	for _, t := range o.RsyncableTypes {
+		if t == "" {
+			add("empty entry in RsyncableTypes would match every content type")
+		}
+	}
+
+	for _, m := range o.Methods {
+		if !isToken(m) {
+			add("invalid method %q in Methods", m)
+		}
+	}
+
+	if o.MinContentLength < 0 {
+		add("MinContentLength %d is negative", o.MinContentLength)
+	}
+	if o.MaxContentLength < 0 {
+		add("MaxContentLength %d is negative", o.MaxContentLength)
+	} else if o.MaxContentLength > 0 && o.MaxContentLength < o.MinContentLength {
+		add("MaxContentLength %d is below MinContentLength %d", o.MaxContentLength, o.MinContentLength)
+	}
+	if o.LogSampleRate < 0 || math.IsNaN(o.LogSampleRate) {
+		add("LogSampleRate %v is invalid", o.LogSampleRate)
+	}
+	if o.LogLevel < LogLevelOff || o.LogLevel > LogLevelDebug {
+		add("LogLevel %d is unknown", o.LogLevel)
+	}
+	if o.MaxPoolMemory < 0 {
+		add("MaxPoolMemory %d is negative", o.MaxPoolMemory)
+	}
+	if o.MaxBufferedBytes < 0 {
+		add("MaxBufferedBytes %d is negative", o.MaxBufferedBytes)
+	}
+	if o.MaxTotalBufferedBytes < 0 {
+		add("MaxTotalBufferedBytes %d is negative", o.MaxTotalBufferedBytes)
+	}
+	if o.MaxConcurrentCompressions < 0 {
+		add("MaxConcurrentCompressions %d is negative", o.MaxConcurrentCompressions)
+	}
+	if o.ConcurrencyWait < 0 {
+		add("ConcurrencyWait %s is negative", o.ConcurrencyWait)
+	} else if o.ConcurrencyWait > 0 && o.MaxConcurrentCompressions == 0 {
+		add("ConcurrencyWait is set without MaxConcurrentCompressions")
+	}
+	if o.ClientRate < 0 || math.IsNaN(o.ClientRate) || math.IsInf(o.ClientRate, 0) {
+		add("ClientRate %v is invalid", o.ClientRate)
+	}
+	if o.ClientBurst < 0 {
+		add("ClientBurst %d is negative", o.ClientBurst)
+	} else if (o.ClientBurst > 0 || o.ClientKey != nil) && o.ClientRate == 0 {
+		add("ClientBurst or ClientKey is set without ClientRate")
+	}
+	if o.AsyncWorkers < Auto {
+		add("AsyncWorkers %d is invalid", o.AsyncWorkers)
+	}
+	if o.AsyncQueue < 0 {
+		add("AsyncQueue %d is negative", o.AsyncQueue)
+	} else if o.AsyncQueue > 0 && o.AsyncWorkers == 0 {
+		add("AsyncQueue is set without AsyncWorkers")
+	}
+	if o.AcceptRanges > AcceptRangesKeep {
+		add("AcceptRanges %d is unknown", o.AcceptRanges)
+	}
+	if o.Errors > SkipErrors {
+		add("Errors %d is unknown", o.Errors)
+	}
+	if o.MaxOutputBytes < 0 {
+		add("MaxOutputBytes %d is negative", o.MaxOutputBytes)
+	}
+	if o.WriteTimeout < 0 {
+		add("WriteTimeout %s is negative", o.WriteTimeout)
+	}
+	if o.FlushSize < 0 {
+		add("FlushSize %d is negative", o.FlushSize)
+	}
+	if o.FlushMaxDelay < 0 {
+		add("FlushMaxDelay %s is negative", o.FlushMaxDelay)
+	} else if o.FlushMaxDelay > 0 && o.FlushSize == 0 {
+		add("FlushMaxDelay is set without FlushSize")
+	}
+	if o.Padding > PaddingHeader {
+		add("Padding %d is unknown", o.Padding)
+	}
+	if o.PaddingMax < 0 || o.PaddingMax > maxPadding {
+		add("PaddingMax %d out of range", o.PaddingMax)
+	} else if o.PaddingMax > 0 && o.Padding == PaddingOff {
+		add("PaddingMax is set without Padding")
+	}
+	if o.OriginalLengthHeader != "" && !validHeaderName(o.OriginalLengthHeader) {
+		add("OriginalLengthHeader %q is not a valid header name", o.OriginalLengthHeader)
+	} else if o.OriginalLengthTrailer && o.OriginalLengthHeader == "" {
+		add("OriginalLengthTrailer is set without OriginalLengthHeader")
+	}
+	return errors.Join(errs...)
+}
+
+// isToken 报告 s 是否是非空的 RFC 9110 token, 头部名称与请求方法都属于 token
+func isToken(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0 {
+			continue
+		}
+		return false
+	}
+	return s != ""
+}
+
+// validHeaderName 报告 name 是否是可以使用的头部名称, 且不与压缩会改写的头部冲突
+func validHeaderName(name string) bool {
+	if !isToken(name) {
+		return false
+	}
+	switch http.CanonicalHeaderKey(name) {
+	case headerContentLength, headerContentEncoding, headerVary, headerContentType:
+		return false
+	}
+	return true
+}
+
+// validLevel 报告 level 是否是 encoding 可接受的压缩级别
+func validLevel(encoding string, level int) bool {
+	if encoding == EncodingZstd {
+		return level >= 1 && level <= 22
+	}
+	// gzip 与 deflate 的级别相同: HuffmanOnly (-2) 到 BestCompression (9)
+	return level >= flate.HuffmanOnly && level <= flate.BestCompression
+}
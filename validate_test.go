@@ -0,0 +1,72 @@
+package compress
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	if err := DefaultCompressionConfig().Validate(); err != nil {
+		t.Errorf("Expected default config to be valid, got %v", err)
+	}
+	if err := (CompressOptions{}).Validate(); err != nil {
+		t.Errorf("Expected zero options to be valid, got %v", err)
+	}
+
+	for _, tt := range []struct {
+		opts CompressOptions
+		want string
+	}{
+		{CompressOptions{Algorithms: map[string]AlgorithmConfig{"br": {}}}, `unknown encoding "br" in Algorithms`},
+		{CompressOptions{Algorithms: map[string]AlgorithmConfig{EncodingGzip: {Level: 10}}}, "gzip level 10 out of range"},
+		{CompressOptions{Algorithms: map[string]AlgorithmConfig{EncodingZstd: {Level: 0}}}, "zstd level 0 out of range"},
+		{CompressOptions{Algorithms: map[string]AlgorithmConfig{EncodingGzip: {Level: 6, MaxIdle: -1}}}, "max idle -1 is negative"},
+		{CompressOptions{EncodingPriority: []string{"gzip", "x-gzip"}}, `unknown encoding "x-gzip" in EncodingPriority`},
+		{CompressOptions{EncodingPriority: []string{"gzip", "gzip"}}, `duplicate encoding "gzip"`},
+		{CompressOptions{EncodingWeights: map[string]int{"br": 1}}, `unknown encoding "br" in EncodingWeights`},
+		{CompressOptions{ProfileHeader: "X-Compress-Profile"}, "ProfileHeader is set without TrustedProxies"},
+		{CompressOptions{TrustedProxies: []string{"10.0.0.0/33"}}, `invalid address "10.0.0.0/33" in TrustedProxies`},
+		{CompressOptions{Profiles: map[string]PartialOptions{"api": {Levels: map[string]int{"gzip": 42}}}}, `profile "api": compress: gzip level 42 out of range`},
+		{CompressOptions{EncodingWeights: map[string]int{"zstd": -1}}, "zstd weight -1 is negative"},
+		{CompressOptions{CompressibleTypes: []string{"text/", ""}}, "empty entry in CompressibleTypes"},
+		{CompressOptions{MinContentLength: -1}, "MinContentLength -1 is negative"},
+		{CompressOptions{Algorithms: map[string]AlgorithmConfig{EncodingGzip: {Level: 6, SizeTiers: []SizeTier{{Level: 1}, {MaxLength: 10, Level: 5}}}}}, "gzip size tier 0 is unbounded but not last"},
+		{CompressOptions{Algorithms: map[string]AlgorithmConfig{EncodingZstd: {Level: 3, StreamingLevel: 30}}}, "zstd streaming level 30 out of range"},
+		{CompressOptions{Algorithms: map[string]AlgorithmConfig{EncodingZstd: {Level: 3, PreferAbove: -1}}}, "zstd PreferAbove -1 is negative"},
+		{CompressOptions{RsyncableTypes: []string{"application/x-tar", ""}}, "empty entry in RsyncableTypes"},
+		{CompressOptions{FlushMaxDelay: time.Second}, "FlushMaxDelay is set without FlushSize"},
+		{CompressOptions{Algorithms: map[string]AlgorithmConfig{EncodingGzip: {Level: 6, SizeTiers: []SizeTier{{MaxLength: 10, Level: 1}, {MaxLength: 5, Level: 12}}}}}, "gzip size tier 1 level 12 out of range"},
+		{CompressOptions{MinContentLength: 100, MaxContentLength: 10}, "MaxContentLength 10 is below MinContentLength 100"},
+		{CompressOptions{ConcurrencyWait: time.Second}, "ConcurrencyWait is set without MaxConcurrentCompressions"},
+		{CompressOptions{AsyncQueue: 4}, "AsyncQueue is set without AsyncWorkers"},
+		{CompressOptions{AcceptRanges: 9}, "AcceptRanges 9 is unknown"},
+		{CompressOptions{Errors: 7}, "Errors 7 is unknown"},
+		{CompressOptions{Padding: PaddingAuto, PaddingMax: maxPadding + 1}, "PaddingMax 4097 out of range"},
+		{CompressOptions{PaddingMax: 16}, "PaddingMax is set without Padding"},
+		{CompressOptions{OriginalLengthHeader: "X Original"}, `OriginalLengthHeader "X Original" is not a valid header name`},
+		{CompressOptions{OriginalLengthHeader: "content-length"}, "not a valid header name"},
+		{CompressOptions{Methods: []string{"GET", ""}}, `invalid method "" in Methods`},
+		{CompressOptions{OriginalLengthTrailer: true}, "OriginalLengthTrailer is set without OriginalLengthHeader"},
+	} {
+		err := tt.opts.Validate()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Validate() = %v, want error containing %q", err, tt.want)
+		}
+	}
+
+	// 多个问题一并报告
+	err := CompressOptions{MinContentLength: -1, MaxPoolMemory: -1}.Validate()
+	if err == nil || strings.Count(err.Error(), "\n") != 1 {
+		t.Errorf("Expected two joined errors, got %v", err)
+	}
+}
+
+func TestStrictValidation(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected New to panic on invalid options with StrictValidation")
+		}
+	}()
+	New(CompressOptions{EncodingPriority: []string{"br"}, StrictValidation: true})
+}
@@ -0,0 +1,99 @@
+//go:build !compress_no_zstd
+
+package compress
+
+import (
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/zstd" // Zstandard
+)
+
+// zstdAvailable 报告 zstd 是否编译进了二进制; 以 compress_no_zstd 构建时为 false,
+// 此时 zstd 不出现在编码表中, 配置中的 zstd 不参与协商, 也不创建其对象池
+const zstdAvailable = true
+
+// --- zstd specific writer and pool ---
+// zstd 级别较多，这里简化为只池化默认级别或用户指定的少数级别
+// 为了更通用，我们可以基于 AlgorithmConfig 中的 Level 动态创建池，或者只池化常见的。
+// 这里我们先为默认级别创建一个池。
+type zstdCompressWriter struct {
+	*zstd.Encoder
+}
+
+// zstd.Encoder 的 Reset 方法签名是 Reset(dst io.Writer) error
+// 为了适配 compressWriter 接口，我们需要一个包装
+func (zw *zstdCompressWriter) Reset(w io.Writer)                 { zw.Encoder.Reset(w) } // 忽略 Reset 的错误，或记录它
+func (zw *zstdCompressWriter) Flush() error                      { return zw.Encoder.Flush() }
+func (zw *zstdCompressWriter) Close() error                      { return zw.Encoder.Close() }
+func (zw *zstdCompressWriter) Write(p []byte) (n int, err error) { return zw.Encoder.Write(p) }
+
+// zstdEncoderOptions 返回按配置创建 zstd 压缩器的选项
+func zstdEncoderOptions(level zstd.EncoderLevel, cfg AlgorithmConfig) []zstd.EOption {
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultZstdConcurrency()
+	}
+	return []zstd.EOption{
+		zstd.WithEncoderLevel(level),
+		zstd.WithEncoderConcurrency(concurrency),
+		zstd.WithLowerEncoderMem(cfg.LowMemory),
+	}
+}
+
+// newZstdCompressor 创建一个不经对象池的 zstd 压缩器
+func newZstdCompressor(level int, cfg AlgorithmConfig, w io.Writer) compressWriter {
+	unpooledEncoders[EncodingZstd].Add(1)
+	zw, err := zstd.NewWriter(w, zstdEncoderOptions(zstd.EncoderLevelFromZstd(level), cfg)...)
+	if err != nil {
+		return nil
+	}
+	return &zstdCompressWriter{Encoder: zw}
+}
+
+func initZstdPools() {
+	// 默认池化 zstd.SpeedDefault 级别
+	zstdWriterPoolDefault = newEncoderPool(EncodingZstd, zstdDefaultLevel, func() compressWriter {
+		// zstd.WithWindowSize(1<<20) // 1MB window, example option
+		w, _ := zstd.NewWriter(nil, zstdEncoderOptions(zstd.SpeedDefault, AlgorithmConfig{})...)
+		return &zstdCompressWriter{Encoder: w}
+	})
+}
+
+// zstdPoolFor 返回 zstd 级别对应的对象池, 只有默认级别有池
+func zstdPoolFor(level int) *encoderPool {
+	if zstd.EncoderLevelFromZstd(level) == zstd.SpeedDefault {
+		return zstdWriterPoolDefault
+	}
+	return nil
+}
+
+// newZstdEncoder 创建 NewEncoder 使用的 zstd 压缩写入器
+func newZstdEncoder(level int, w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+}
+
+// newZstdDecoder 返回解码 r 的 Reader 及用完后释放资源的函数
+func newZstdDecoder(r io.Reader) (io.Reader, func(), error) {
+	zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, nil, err
+	}
+	return zr, zr.Close, nil
+}
+
+// maxRequestZstdWindow 限制请求体的 zstd 窗口, 防止很小的请求体让解码器分配大量内存;
+// RFC 8878 要求 HTTP 中的 zstd 窗口不超过 8 MiB
+const maxRequestZstdWindow = 8 << 20
+
+// newZstdRequestDecoder 与 newZstdDecoder 相同, 但拒绝窗口超过 maxRequestZstdWindow 的流
+func newZstdRequestDecoder(r io.Reader) (io.Reader, func(), error) {
+	zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(maxRequestZstdWindow))
+	if err != nil {
+		return nil, nil, err
+	}
+	return zr, zr.Close, nil
+}
+
+// zstdChecksumError 报告 err 是否为 zstd 帧的校验和不匹配
+func zstdChecksumError(err error) bool { return errors.Is(err, zstd.ErrCRCMismatch) }